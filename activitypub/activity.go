@@ -0,0 +1,100 @@
+package activitypub
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// Inbound is an activity which a remote server has delivered
+// to one of our inboxes. Only the common elements are parsed,
+// the rest of the payload is available via Raw.
+type Inbound struct {
+	Id     string          `json:"id"`
+	Type   string          `json:"type"`
+	Actor  string          `json:"actor"`
+	Object json.RawMessage `json:"object"`
+	To     stringList      `json:"to"`
+	CC     stringList      `json:"cc"`
+	Raw    []byte          `json:"-"`
+}
+
+func ParseInbound(data []byte) (*Inbound, error) {
+	var in Inbound
+	err := json.Unmarshal(data, &in)
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid activity")
+	}
+	if in.Id == "" || in.Type == "" || in.Actor == "" {
+		return nil, errors.New("Activity requires id, type and actor")
+	}
+	in.Raw = data
+	return &in, nil
+}
+
+// ObjectId returns the IRI of the activity's object, which may be
+// a bare IRI or an embedded object.
+func (in *Inbound) ObjectId() string {
+	return referenceId(in.Object)
+}
+
+// referenceId returns the IRI of a property which may be a bare
+// IRI or an embedded object.
+func referenceId(data json.RawMessage) string {
+	var iri string
+	if json.Unmarshal(data, &iri) == nil {
+		return iri
+	}
+	var obj struct {
+		Id string `json:"id"`
+	}
+	_ = json.Unmarshal(data, &obj)
+	return obj.Id
+}
+
+// ObjectType returns the type of an embedded object or the empty
+// string if the object is a bare IRI.
+func (in *Inbound) ObjectType() string {
+	var obj struct {
+		Type string `json:"type"`
+	}
+	_ = json.Unmarshal(in.Object, &obj)
+	return obj.Type
+}
+
+// DecodeObject unmarshals an embedded object into v.
+func (in *Inbound) DecodeObject(v any) error {
+	return json.Unmarshal(in.Object, v)
+}
+
+// Embedded parses the object as an activity, e.g. the Follow
+// within an Accept or Undo.
+func (in *Inbound) Embedded() (*Inbound, error) {
+	return ParseInbound(in.Object)
+}
+
+// Addressed returns all recipients in To and CC.
+func (in *Inbound) Addressed() []string {
+	all := make([]string, 0, len(in.To)+len(in.CC))
+	all = append(all, in.To...)
+	return append(all, in.CC...)
+}
+
+// ActivityStreams allows any property to be a single value
+// or an array of values.
+type stringList []string
+
+func (sl *stringList) UnmarshalJSON(data []byte) error {
+	var single string
+	if json.Unmarshal(data, &single) == nil {
+		*sl = []string{single}
+		return nil
+	}
+	var multi []string
+	err := json.Unmarshal(data, &multi)
+	if err != nil {
+		return err
+	}
+	*sl = multi
+	return nil
+}
@@ -0,0 +1,269 @@
+package activitypub
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/sparq"
+	"github.com/contribsys/sparq/activitystreams"
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/util"
+	"github.com/pkg/errors"
+)
+
+const (
+	ActivityJson = "application/activity+json"
+	// Accept header used when fetching remote ActivityPub resources
+	ActivityAccept = `application/activity+json, application/ld+json; profile="https://www.w3.org/ns/activitystreams"`
+
+	maxObjectSize = 1 << 20
+)
+
+var (
+	// Client is used for all outbound ActivityPub requests.
+	Client = &http.Client{Timeout: 10 * time.Second}
+
+	// The remote actor or object has been deleted
+	ErrGone = errors.New("Object is gone")
+
+	// Cached actors older than this are refreshed by the
+	// periodic RefreshActors job.
+	ActorTTL = 24 * time.Hour
+	// The number of actors refreshed by each RefreshActors job.
+	ActorRefreshBatch = 100
+	// How often the RefreshActors job runs, in seconds.
+	ActorRefreshInterval int64 = 3600
+
+	// An actor which fails signature verification is refetched
+	// in case it rotated its key, but not more often than this.
+	KeyRefreshInterval = time.Minute
+)
+
+// InstanceIRI is the IRI of the actor which represents
+// this server rather than any one account.
+func InstanceIRI(svr sparq.Server) string {
+	return "https://" + svr.Hostname() + "/actor"
+}
+
+// InstanceActor returns the instance actor, creating its keypair
+// on first use. It signs any fetches we make which aren't on
+// behalf of a particular account.
+func InstanceActor(ctx context.Context, svr sparq.Server) (*model.Actor, error) {
+	iri := InstanceIRI(svr)
+	var actor model.Actor
+	err := svr.DB().GetContext(ctx, &actor, "select * from actors where Id = ?", iri)
+	if err == nil {
+		return &actor, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.Wrap(err, "actors")
+	}
+
+	pub, priv := util.GenerateKeys()
+	_, err = svr.DB().ExecContext(ctx, `
+		insert into actors (Id, MastodonId, Type, Email, PrivateKey, PublicKey, FetchedAt)
+		values (?, ?, 'Application', '', ?, ?, STRFTIME('%Y-%m-%d %H:%M:%f', 'NOW'))
+		on conflict (Id) do nothing`, iri, int64(model.Snowflakes.NextID()), priv, string(pub))
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to create instance actor")
+	}
+	err = svr.DB().GetContext(ctx, &actor, "select * from actors where Id = ?", iri)
+	if err != nil {
+		return nil, errors.Wrap(err, "actors")
+	}
+	return &actor, nil
+}
+
+// GET /actor
+func InstanceActorHandler(svr sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		actor, err := InstanceActor(r.Context(), svr)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		me := activitystreams.NewPerson(actor.Id)
+		me.Type = actor.Type
+		me.URL = "https://" + svr.Hostname() + "/"
+		me.PreferredUsername = svr.Hostname()
+		me.Inbox = SharedInbox(svr)
+		me.ManuallyApproves = true
+		me.AddPubKey(actor.PublicKey)
+		me.Endpoints.SharedInbox = SharedInbox(svr)
+
+		w.Header().Add("Content-Type", ActivityJson)
+		err = json.NewEncoder(w).Encode(me)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// FetchActor returns the remote actor from our cache, fetching it
+// from the remote server if we haven't seen it before.
+func FetchActor(ctx context.Context, svr sparq.Server, iri string) (*model.Actor, error) {
+	var actor model.Actor
+	err := svr.DB().GetContext(ctx, &actor, "select * from actors where Id = ?", iri)
+	if err == nil {
+		return &actor, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.Wrap(err, "actors")
+	}
+	return RefreshActor(ctx, svr, iri)
+}
+
+// RefreshActor fetches the remote actor and updates our cache.
+func RefreshActor(ctx context.Context, svr sparq.Server, iri string) (*model.Actor, error) {
+	data, err := fetchObject(ctx, svr, iri)
+	if err != nil {
+		return nil, err
+	}
+
+	var obj activitystreams.Object
+	err = json.Unmarshal(data, &obj)
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid actor "+iri)
+	}
+	if obj.ID != iri {
+		return nil, fmt.Errorf("Actor ID mismatch: %s != %s", obj.ID, iri)
+	}
+	pubkey := ""
+	if obj.PublicKey != nil && obj.PublicKey.Owner == iri {
+		pubkey = obj.PublicKey.PublicKeyPEM
+	}
+
+	_, err = svr.DB().ExecContext(ctx, `
+		insert into actors (Id, MastodonId, Type, Email, PublicKey, Properties, FetchedAt)
+		values (?, ?, ?, '', ?, ?, STRFTIME('%Y-%m-%d %H:%M:%f', 'NOW'))
+		on conflict (Id) do update set Type = excluded.Type, PublicKey = excluded.PublicKey,
+		  Properties = excluded.Properties, FetchedAt = excluded.FetchedAt`,
+		iri, int64(model.Snowflakes.NextID()), obj.Type, pubkey, string(data))
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to save actor "+iri)
+	}
+
+	err = SaveEmojis(ctx, svr, iri, obj.Tag)
+	if err != nil {
+		return nil, err
+	}
+
+	var actor model.Actor
+	err = svr.DB().GetContext(ctx, &actor, "select * from actors where Id = ?", iri)
+	if err != nil {
+		return nil, errors.Wrap(err, "actors")
+	}
+	return &actor, nil
+}
+
+// fetchObject fetches the remote ActivityPub object. The fetch is
+// signed by the instance actor since many servers require authorized
+// fetch. Returns ErrGone if the object has been deleted.
+func fetchObject(ctx context.Context, svr sparq.Server, iri string) ([]byte, error) {
+	inst, err := InstanceActor(ctx, svr)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", iri, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", ActivityAccept)
+	req.Header.Set("User-Agent", sparq.ServerHeader)
+	err = SignRequest(req, inst.Id+"#main-key", inst.PrivateKey, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := Client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to fetch "+iri)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusGone || resp.StatusCode == http.StatusNotFound {
+		return nil, ErrGone
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unable to fetch %s: %d", iri, resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxObjectSize))
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to read "+iri)
+	}
+	return data, nil
+}
+
+// VerifyActor checks the request's signature against the signing
+// actor's public key. If the cached key doesn't match, the actor
+// may have rotated its key so we refetch the actor and try again.
+func VerifyActor(ctx context.Context, svr sparq.Server, r *http.Request, sig *Signature, body []byte) (*model.Actor, error) {
+	actor, err := FetchActor(ctx, svr, sig.Owner())
+	if err != nil {
+		return nil, err
+	}
+	err = VerifyRequest(r, sig, []byte(actor.PublicKey), body)
+	if err == nil {
+		return actor, nil
+	}
+	if actor.PublicKey != "" && !errors.Is(err, ErrInvalidSignature) {
+		return nil, err
+	}
+	if time.Since(actor.FetchedAt) < KeyRefreshInterval {
+		return nil, err
+	}
+
+	util.Debugf("Refreshing %s, signature did not match cached key", actor.Id)
+	actor, err = RefreshActor(ctx, svr, actor.Id)
+	if err != nil {
+		return nil, err
+	}
+	err = VerifyRequest(r, sig, []byte(actor.PublicKey), body)
+	if err != nil {
+		return nil, err
+	}
+	return actor, nil
+}
+
+// refreshActors is the periodic job which queues a refresh
+// for each cached remote actor we haven't fetched recently.
+func refreshActors(ctx context.Context, svr sparq.Server) error {
+	iris := []string{}
+	err := svr.DB().SelectContext(ctx, &iris, `
+		select Id from actors
+		where PrivateKey is null and FetchedAt < STRFTIME('%Y-%m-%d %H:%M:%f', 'NOW', ?)
+		order by FetchedAt limit ?`,
+		fmt.Sprintf("-%d seconds", int64(ActorTTL.Seconds())), ActorRefreshBatch)
+	if err != nil {
+		return errors.Wrap(err, "actors")
+	}
+	for _, iri := range iris {
+		err := svr.Jobs().Push(ctx, client.NewJob("RefreshActor", iri))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// refreshActor updates a single cached actor. Actors which
+// have been deleted are removed along with their follows, toots
+// and notifications.
+func refreshActor(ctx context.Context, svr sparq.Server, iri string) error {
+	_, err := RefreshActor(ctx, svr, iri)
+	if !errors.Is(err, ErrGone) {
+		return err
+	}
+	util.Infof("Removing deleted actor %s", iri)
+	err = deleteActor(ctx, svr, iri)
+	if err != nil {
+		return err
+	}
+	_, err = svr.DB().ExecContext(ctx, "delete from actors where Id = ?", iri)
+	return errors.Wrap(err, "actors")
+}
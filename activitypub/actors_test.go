@@ -0,0 +1,130 @@
+package activitypub
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/sparq/util"
+	"github.com/contribsys/sparq/web"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestActors(t *testing.T) {
+	ts, stopper := web.NewTestServer(t, "actors")
+	defer stopper()
+	jobs := ts.Jobs().(*web.TestJobs)
+	Register(ts)
+	ctx := context.Background()
+
+	root := mux.NewRouter()
+	root.HandleFunc("/actor", InstanceActorHandler(ts))
+	root.HandleFunc("/users/{nick:[a-z0-9]{4,20}}/inbox", InboxHandler(ts))
+	bob := newRemoteActor(t)
+
+	// pretend we last fetched the actor long ago
+	age := func(iri string) {
+		_, err := ts.DB().Exec("update actors set FetchedAt = STRFTIME('%Y-%m-%d %H:%M:%f', 'NOW', '-2 days') where Id = ?", iri)
+		assert.NoError(t, err)
+	}
+
+	t.Run("InstanceActor", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "https://localhost.dev/actor", nil)
+		w := httptest.NewRecorder()
+		root.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+
+		doc := map[string]any{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &doc))
+		assert.Equal(t, "Application", doc["type"])
+		assert.Equal(t, "https://localhost.dev/actor", doc["id"])
+		key := doc["publicKey"].(map[string]any)
+		assert.Equal(t, "https://localhost.dev/actor#main-key", key["id"])
+
+		inst, err := InstanceActor(ctx, ts)
+		assert.NoError(t, err)
+		assert.Equal(t, inst.PublicKey, key["publicKeyPem"])
+	})
+
+	t.Run("SignedFetch", func(t *testing.T) {
+		actor, err := FetchActor(ctx, ts, bob.IRI)
+		assert.NoError(t, err)
+		assert.NotZero(t, actor.MastodonId)
+		assert.Equal(t, string(bob.Public), actor.PublicKey)
+		assert.Contains(t, actor.Properties, "Bob Remote")
+
+		assert.Equal(t, 1, len(bob.Fetches))
+		req := bob.Fetches[0]
+		sig, err := ParseSignature(req.Header.Get("Signature"))
+		assert.NoError(t, err)
+		assert.Equal(t, "https://localhost.dev/actor", sig.Owner())
+		inst, err := InstanceActor(ctx, ts)
+		assert.NoError(t, err)
+		assert.NoError(t, VerifyRequest(req, sig, []byte(inst.PublicKey), nil))
+
+		// served from the cache
+		again, err := FetchActor(ctx, ts, bob.IRI)
+		assert.NoError(t, err)
+		assert.Equal(t, actor.MastodonId, again.MastodonId)
+		assert.Equal(t, 1, len(bob.Fetches))
+	})
+
+	t.Run("KeyRotation", func(t *testing.T) {
+		bob.Public, bob.Private = util.GenerateKeys()
+		follow := map[string]any{
+			"id":     bob.IRI + "#follows/rotated",
+			"type":   "Follow",
+			"actor":  bob.IRI,
+			"object": "https://localhost.dev/users/admin",
+		}
+		// fetched too recently to refetch
+		w := bob.post(t, root, "/users/admin/inbox", follow)
+		assert.Equal(t, 401, w.Code)
+
+		age(bob.IRI)
+		w = bob.post(t, root, "/users/admin/inbox", follow)
+		assert.Equal(t, 202, w.Code, w.Body.String())
+
+		actor, err := FetchActor(ctx, ts, bob.IRI)
+		assert.NoError(t, err)
+		assert.Equal(t, string(bob.Public), actor.PublicKey)
+		assert.NoError(t, jobs.Drain(ctx))
+	})
+
+	t.Run("Periodic", func(t *testing.T) {
+		assert.Equal(t, ActorRefreshInterval, jobs.Schedule["RefreshActors"])
+
+		carol := newRemoteActor(t)
+		_, err := FetchActor(ctx, ts, carol.IRI)
+		assert.NoError(t, err)
+		_, err = ts.DB().Exec(`insert into actor_following (Id, ActorId, TargetActorId, TargetActorAccount, State)
+			values (?, ?, 'https://localhost.dev/users/admin', 'admin@localhost.dev', 'accepted')`, carol.IRI+"#follows/1", carol.IRI)
+		assert.NoError(t, err)
+
+		// nothing is stale yet
+		fetches := len(bob.Fetches)
+		assert.NoError(t, jobs.Push(ctx, client.NewJob("RefreshActors")))
+		assert.NoError(t, jobs.Drain(ctx))
+		assert.Equal(t, fetches, len(bob.Fetches))
+
+		age(bob.IRI)
+		age(carol.IRI)
+		bob.Name = "Bob Renamed"
+		carol.Gone = true
+		assert.NoError(t, jobs.Push(ctx, client.NewJob("RefreshActors")))
+		assert.NoError(t, jobs.Drain(ctx))
+
+		actor, err := FetchActor(ctx, ts, bob.IRI)
+		assert.NoError(t, err)
+		assert.Contains(t, actor.Properties, "Bob Renamed")
+
+		var count int
+		assert.NoError(t, ts.DB().QueryRow("select count(*) from actors where Id = ?", carol.IRI).Scan(&count))
+		assert.Equal(t, 0, count)
+		assert.NoError(t, ts.DB().QueryRow("select count(*) from actor_following where ActorId = ?", carol.IRI).Scan(&count))
+		assert.Equal(t, 0, count)
+	})
+}
@@ -0,0 +1,240 @@
+package activitypub
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/contribsys/sparq"
+	"github.com/contribsys/sparq/activitystreams"
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/util"
+	"github.com/pkg/errors"
+)
+
+// Blocking returns the actor's block of the target or nil.
+func Blocking(ctx context.Context, svr sparq.Server, actor, target string) (*model.ActorBlock, error) {
+	var block model.ActorBlock
+	err := svr.DB().GetContext(ctx, &block,
+		"select * from actor_blocks where ActorId = ? and TargetActorId = ?", actor, target)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "actor_blocks")
+	}
+	return &block, nil
+}
+
+// Muting returns the actor's unexpired mute of the target or nil.
+func Muting(ctx context.Context, svr sparq.Server, actor, target string) (*model.ActorMute, error) {
+	var mute model.ActorMute
+	err := svr.DB().GetContext(ctx, &mute,
+		"select * from actor_mutes where ActorId = ? and TargetActorId = ?", actor, target)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "actor_mutes")
+	}
+	if mute.IsExpired() {
+		return nil, nil
+	}
+	return &mute, nil
+}
+
+// DomainBlocked is true if the actor blocks the domain of the target.
+func DomainBlocked(ctx context.Context, svr sparq.Server, actor, target string) (bool, error) {
+	var count int
+	err := svr.DB().GetContext(ctx, &count,
+		"select count(*) from actor_domain_blocks where ActorId = ? and Domain = ?", actor, host(target))
+	return count > 0, errors.Wrap(err, "actor_domain_blocks")
+}
+
+// Rejects is true if the local recipient wants nothing from the
+// actor, having blocked it or its domain.
+func Rejects(ctx context.Context, svr sparq.Server, recipient, actor string) (bool, error) {
+	if _, ok := model.LocalNick(recipient); !ok {
+		return false, nil
+	}
+	block, err := Blocking(ctx, svr, recipient, actor)
+	if err != nil || block != nil {
+		return block != nil, err
+	}
+	return DomainBlocked(ctx, svr, recipient, actor)
+}
+
+// silenced is true if the recipient shouldn't be notified of anything
+// the actor does: either blocks the other or the recipient mutes the
+// actor's notifications or blocks its domain.
+func silenced(ctx context.Context, svr sparq.Server, recipient, actor string) (bool, error) {
+	var count int
+	err := svr.DB().GetContext(ctx, &count, `select
+		(select count(*) from actor_blocks
+			where (ActorId = ? and TargetActorId = ?) or (ActorId = ? and TargetActorId = ?)) +
+		(select count(*) from actor_mutes
+			where ActorId = ? and TargetActorId = ? and HideNotifications
+			and (ExpiresAt is null or ExpiresAt > current_timestamp)) +
+		(select count(*) from actor_domain_blocks where ActorId = ? and Domain = ?)`,
+		recipient, actor, actor, recipient, recipient, actor, recipient, host(actor))
+	return count > 0, errors.Wrap(err, "blocks")
+}
+
+// Block blocks the target actor, severing any follows between the
+// two and telling a remote target.
+func Block(ctx context.Context, svr sparq.Server, acct *model.Account, target string) error {
+	if target == acct.IRI() {
+		return errors.New("You can't block yourself")
+	}
+	existing, err := Blocking(ctx, svr, acct.IRI(), target)
+	if err != nil || existing != nil {
+		return err
+	}
+	block := &model.ActorBlock{
+		Id:            acct.IRI() + "#blocks/" + model.Snowflakes.NextSID(),
+		ActorId:       acct.IRI(),
+		TargetActorId: target,
+	}
+	_, err = svr.DB().ExecContext(ctx, "insert into actor_blocks (Id, ActorId, TargetActorId) values (?, ?, ?)",
+		block.Id, block.ActorId, block.TargetActorId)
+	if err != nil {
+		return errors.Wrap(err, "actor_blocks")
+	}
+	err = severFollows(ctx, svr, acct.IRI(), target)
+	if err != nil {
+		return err
+	}
+	if _, ok := model.LocalNick(target); ok {
+		return nil
+	}
+	// the remote server drops the follows on its side
+	return SendTo(ctx, svr, acct, blockFor(block), target)
+}
+
+// Unblock removes the account's block of the target.
+func Unblock(ctx context.Context, svr sparq.Server, acct *model.Account, target string) error {
+	block, err := Blocking(ctx, svr, acct.IRI(), target)
+	if err != nil || block == nil {
+		return err
+	}
+	_, err = svr.DB().ExecContext(ctx, "delete from actor_blocks where Id = ?", block.Id)
+	if err != nil {
+		return errors.Wrap(err, "actor_blocks")
+	}
+	if _, ok := model.LocalNick(target); ok {
+		return nil
+	}
+	activity := blockFor(block)
+	activity.Context = nil
+	undo := activitystreams.NewUndoActivity(acct.IRI(), activity)
+	undo.ID = block.Id + "/undo"
+	return SendTo(ctx, svr, acct, undo, target)
+}
+
+// Mute hides the target from the account's timelines and, if
+// notifications is set, its notifications. Mutes which are never
+// lifted have no expiry. Muting again replaces the earlier mute.
+func Mute(ctx context.Context, svr sparq.Server, acct *model.Account, target string, notifications bool, expiresAt *time.Time) error {
+	if target == acct.IRI() {
+		return errors.New("You can't mute yourself")
+	}
+	var expires any
+	if expiresAt != nil {
+		expires = expiresAt.UTC().Format("2006-01-02 15:04:05")
+	}
+	_, err := svr.DB().ExecContext(ctx, `
+		insert into actor_mutes (ActorId, TargetActorId, HideNotifications, ExpiresAt) values (?, ?, ?, ?)
+		on conflict (ActorId, TargetActorId) do update set
+		HideNotifications = excluded.HideNotifications, ExpiresAt = excluded.ExpiresAt`,
+		acct.IRI(), target, notifications, expires)
+	return errors.Wrap(err, "actor_mutes")
+}
+
+// Unmute removes the account's mute of the target.
+func Unmute(ctx context.Context, svr sparq.Server, acct *model.Account, target string) error {
+	_, err := svr.DB().ExecContext(ctx, "delete from actor_mutes where ActorId = ? and TargetActorId = ?",
+		acct.IRI(), target)
+	return errors.Wrap(err, "actor_mutes")
+}
+
+// BlockDomain hides everything from the domain and removes the
+// account's followers there.
+func BlockDomain(ctx context.Context, svr sparq.Server, acct *model.Account, domain string) error {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	if domain == "" || strings.ContainsAny(domain, "/@ ") {
+		return errors.New("Invalid domain: " + domain)
+	}
+	if domain == svr.Hostname() {
+		return errors.New("You can't block your own domain")
+	}
+	_, err := svr.DB().ExecContext(ctx, `
+		insert into actor_domain_blocks (ActorId, Domain) values (?, ?) on conflict do nothing`,
+		acct.IRI(), domain)
+	if err != nil {
+		return errors.Wrap(err, "actor_domain_blocks")
+	}
+	followers := []string{}
+	err = svr.DB().SelectContext(ctx, &followers, `
+		select ActorId from actor_following where TargetActorId = ? and ActorId like ?`,
+		acct.IRI(), "%://"+domain+"/%")
+	if err != nil {
+		return errors.Wrap(err, "actor_following")
+	}
+	for _, follower := range followers {
+		err = RejectFollower(ctx, svr, acct, follower)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UnblockDomain removes the account's block of the domain.
+func UnblockDomain(ctx context.Context, svr sparq.Server, acct *model.Account, domain string) error {
+	_, err := svr.DB().ExecContext(ctx, "delete from actor_domain_blocks where ActorId = ? and Domain = ?",
+		acct.IRI(), strings.ToLower(strings.TrimSpace(domain)))
+	return errors.Wrap(err, "actor_domain_blocks")
+}
+
+// severFollows removes any follows or follow requests between the
+// two actors, in either direction.
+func severFollows(ctx context.Context, svr sparq.Server, actor, target string) error {
+	for _, pair := range [][2]string{{actor, target}, {target, actor}} {
+		af, err := Following(ctx, svr, pair[0], pair[1])
+		if err != nil {
+			return err
+		}
+		if af == nil {
+			continue
+		}
+		err = removeFollow(ctx, svr, af)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func blockFor(block *model.ActorBlock) *activitystreams.ReferenceActivity {
+	activity := activitystreams.NewBlockActivity(block.ActorId, block.TargetActorId)
+	activity.ID = block.Id
+	return activity
+}
+
+// A remote actor has blocked one of our accounts.
+func handleBlock(ctx context.Context, svr sparq.Server, recipient string, in *Inbound) error {
+	target := in.ObjectId()
+	if _, ok := model.LocalNick(target); !ok {
+		util.Debugf("Ignoring block of %s", target)
+		return nil
+	}
+	_, err := svr.DB().ExecContext(ctx, `
+		insert into actor_blocks (Id, ActorId, TargetActorId) values (?, ?, ?) on conflict do nothing`,
+		in.Id, in.Actor, target)
+	if err != nil {
+		return errors.Wrap(err, "actor_blocks")
+	}
+	util.Infof("%s blocked %s", in.Actor, target)
+	return severFollows(ctx, svr, in.Actor, target)
+}
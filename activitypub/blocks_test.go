@@ -0,0 +1,105 @@
+package activitypub
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/contribsys/sparq/web"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlocks(t *testing.T) {
+	ts, stopper := web.NewTestServer(t, "blocks")
+	defer stopper()
+	jobs := ts.Jobs().(*web.TestJobs)
+	Register(ts)
+	ctx := context.Background()
+
+	root := mux.NewRouter()
+	root.HandleFunc("/inbox", InboxHandler(ts))
+	root.HandleFunc("/users/{nick:[a-z0-9]{4,20}}/inbox", InboxHandler(ts))
+	bob := newRemoteActor(t)
+
+	admin, err := localAccount(ctx, ts, 1)
+	assert.NoError(t, err)
+	var uri string
+	assert.NoError(t, ts.DB().Get(&uri, "select Uri from toots where Sid = 'AABA'"))
+
+	deliver := func(path string, activity map[string]any) {
+		activity["actor"] = bob.IRI
+		w := bob.post(t, root, path, activity)
+		assert.Equal(t, 202, w.Code, w.Body.String())
+		assert.NoError(t, jobs.Drain(ctx))
+	}
+	// the last activity bob received
+	received := func() map[string]any {
+		assert.NotEmpty(t, bob.Inbox)
+		if len(bob.Inbox) == 0 {
+			return nil
+		}
+		activity := map[string]any{}
+		assert.NoError(t, json.NewDecoder(bob.Inbox[len(bob.Inbox)-1].Body).Decode(&activity))
+		return activity
+	}
+
+	t.Run("Outbound", func(t *testing.T) {
+		deliver("/users/admin/inbox", map[string]any{"id": bob.IRI + "#follows/1", "type": "Follow", "object": admin.IRI()})
+		af, err := Following(ctx, ts, bob.IRI, admin.IRI())
+		assert.NoError(t, err)
+		assert.NotNil(t, af)
+
+		assert.NoError(t, Block(ctx, ts, admin, bob.IRI))
+		assert.NoError(t, jobs.Drain(ctx))
+		block := received()
+		assert.Equal(t, "Block", block["type"])
+		assert.Equal(t, bob.IRI, block["object"])
+		af, err = Following(ctx, ts, bob.IRI, admin.IRI())
+		assert.NoError(t, err)
+		assert.Nil(t, af)
+
+		// bob can't follow again or interact
+		deliver("/users/admin/inbox", map[string]any{"id": bob.IRI + "#follows/2", "type": "Follow", "object": admin.IRI()})
+		assert.Equal(t, "Reject", received()["type"])
+		af, err = Following(ctx, ts, bob.IRI, admin.IRI())
+		assert.NoError(t, err)
+		assert.Nil(t, af)
+		deliver("/users/admin/inbox", map[string]any{"id": bob.IRI + "/likes/1", "type": "Like", "object": uri})
+		var count int
+		assert.NoError(t, ts.DB().Get(&count, "select count(*) from actor_favorites where ObjectId = ?", uri))
+		assert.Equal(t, 0, count)
+		deliver("/inbox", map[string]any{"id": bob.IRI + "/likes/2", "type": "Like", "object": uri})
+		assert.Equal(t, 0, notifications(t, ts, NotifyFavourite))
+
+		assert.NoError(t, Unblock(ctx, ts, admin, bob.IRI))
+		assert.NoError(t, jobs.Drain(ctx))
+		undo := received()
+		assert.Equal(t, "Undo", undo["type"])
+		assert.Equal(t, block["id"], undo["object"].(map[string]any)["id"])
+		b, err := Blocking(ctx, ts, admin.IRI(), bob.IRI)
+		assert.NoError(t, err)
+		assert.Nil(t, b)
+	})
+
+	t.Run("Inbound", func(t *testing.T) {
+		_, err := ts.DB().Exec(`
+			insert into actor_following (Id, ActorId, TargetActorId, TargetActorAccount, State)
+			values ('follow-bob', ?, ?, 'bob@remote', 'accepted')`, admin.IRI(), bob.IRI)
+		assert.NoError(t, err)
+
+		block := map[string]any{"id": bob.IRI + "#blocks/1", "type": "Block", "object": admin.IRI()}
+		deliver("/users/admin/inbox", block)
+		b, err := Blocking(ctx, ts, bob.IRI, admin.IRI())
+		assert.NoError(t, err)
+		assert.NotNil(t, b)
+		af, err := Following(ctx, ts, admin.IRI(), bob.IRI)
+		assert.NoError(t, err)
+		assert.Nil(t, af)
+
+		deliver("/users/admin/inbox", map[string]any{"id": bob.IRI + "#blocks/1/undo", "type": "Undo", "object": block})
+		b, err = Blocking(ctx, ts, bob.IRI, admin.IRI())
+		assert.NoError(t, err)
+		assert.Nil(t, b)
+	})
+}
@@ -0,0 +1,203 @@
+package activitypub
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/contribsys/sparq"
+	"github.com/contribsys/sparq/activitystreams"
+	"github.com/contribsys/sparq/model"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+var (
+	CollectionPageSize = 20
+)
+
+// collection knows how to count and page through the items
+// of one of an actor's collections.
+type collection struct {
+	name  string
+	count func(ctx context.Context, svr sparq.Server, acct *model.Account) (int, error)
+	items func(ctx context.Context, svr sparq.Server, acct *model.Account, limit, offset int) ([]interface{}, error)
+	// hidden collections only reveal their size
+	hidden func(acct *model.Account) bool
+}
+
+// GET /users/{nick}/outbox
+func OutboxHandler(svr sparq.Server) http.HandlerFunc {
+	return collectionHandler(svr, &collection{
+		name:   "outbox",
+		count:  countOutbox,
+		items:  outboxItems,
+		hidden: func(*model.Account) bool { return false },
+	})
+}
+
+// GET /users/{nick}/followers
+func FollowersHandler(svr sparq.Server) http.HandlerFunc {
+	return collectionHandler(svr, &collection{
+		name: "followers",
+		count: func(ctx context.Context, svr sparq.Server, acct *model.Account) (int, error) {
+			return countActors(ctx, svr, `select count(*) from actor_following
+				where TargetActorId = ? and State = 'accepted'`, acct.IRI())
+		},
+		items: func(ctx context.Context, svr sparq.Server, acct *model.Account, limit, offset int) ([]interface{}, error) {
+			return actorItems(ctx, svr, `select ActorId from actor_following
+				where TargetActorId = ? and State = 'accepted'
+				order by CreatedAt desc limit ? offset ?`, acct.IRI(), limit, offset)
+		},
+		hidden: hideNetwork,
+	})
+}
+
+// GET /users/{nick}/following
+func FollowingHandler(svr sparq.Server) http.HandlerFunc {
+	return collectionHandler(svr, &collection{
+		name: "following",
+		count: func(ctx context.Context, svr sparq.Server, acct *model.Account) (int, error) {
+			return countActors(ctx, svr, `select count(*) from actor_following
+				where ActorId = ? and State = 'accepted'`, acct.IRI())
+		},
+		items: func(ctx context.Context, svr sparq.Server, acct *model.Account, limit, offset int) ([]interface{}, error) {
+			return actorItems(ctx, svr, `select TargetActorId from actor_following
+				where ActorId = ? and State = 'accepted'
+				order by CreatedAt desc limit ? offset ?`, acct.IRI(), limit, offset)
+		},
+		hidden: hideNetwork,
+	})
+}
+
+// Protected and Private accounts don't reveal who they follow
+// or who follows them.
+func hideNetwork(acct *model.Account) bool {
+	return acct.Visibility != model.Public
+}
+
+func collectionHandler(svr sparq.Server, coll *collection) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			httpError(w, errors.New("GET only"), http.StatusMethodNotAllowed)
+			return
+		}
+		nick := mux.Vars(r)["nick"]
+		var acct model.Account
+		err := svr.DB().GetContext(r.Context(), &acct, "select * from accounts where Nick = ?", nick)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				httpError(w, errors.New("User not found"), http.StatusNotFound)
+				return
+			}
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		total, err := coll.count(r.Context(), svr, &acct)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		var result any
+		pageParam := r.URL.Query().Get("page")
+		if pageParam == "" || coll.hidden(&acct) {
+			oc := activitystreams.NewOrderedCollection(acct.IRI(), coll.name, total)
+			if coll.hidden(&acct) {
+				oc.First = ""
+			}
+			result = oc
+		} else {
+			page, err := strconv.Atoi(pageParam)
+			if err != nil || page < 1 {
+				httpError(w, errors.New("Invalid page"), http.StatusBadRequest)
+				return
+			}
+			items, err := coll.items(r.Context(), svr, &acct, CollectionPageSize, (page-1)*CollectionPageSize)
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+			ocp := activitystreams.NewOrderedCollectionPage(acct.IRI(), coll.name, total, page)
+			ocp.OrderedItems = items
+			if page*CollectionPageSize >= total {
+				ocp.Next = ""
+			}
+			if page > 1 {
+				ocp.Prev = activitystreams.NewOrderedCollectionPage(acct.IRI(), coll.name, total, page-1).ID
+			}
+			result = ocp
+		}
+
+		w.Header().Add("Content-Type", ActivityJson)
+		err = json.NewEncoder(w).Encode(result)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// The outbox only contains toots visible to the world.
+var outboxVisibility = []model.PostVisibility{model.VisPublic, model.VisUnlisted}
+
+func countOutbox(ctx context.Context, svr sparq.Server, acct *model.Account) (int, error) {
+	var count int
+	err := svr.DB().QueryRowContext(ctx, `
+		select count(*) from toots where AuthorId = ? and BoostOfId is null and DeletedAt is null and Visibility in (?, ?)`,
+		acct.Id, outboxVisibility[0], outboxVisibility[1]).Scan(&count)
+	if err != nil {
+		return 0, errors.Wrap(err, "outbox")
+	}
+	return count, nil
+}
+
+func outboxItems(ctx context.Context, svr sparq.Server, acct *model.Account, limit, offset int) ([]interface{}, error) {
+	toots := []model.Toot{}
+	err := svr.DB().SelectContext(ctx, &toots, `
+		select * from toots where AuthorId = ? and BoostOfId is null and DeletedAt is null and Visibility in (?, ?)
+		order by CreatedAt desc limit ? offset ?`,
+		acct.Id, outboxVisibility[0], outboxVisibility[1], limit, offset)
+	if err != nil {
+		return nil, errors.Wrap(err, "outbox")
+	}
+	items := make([]interface{}, 0, len(toots))
+	for idx := range toots {
+		note, err := NoteFor(ctx, svr, &toots[idx], acct)
+		if err != nil {
+			return nil, err
+		}
+		act := activitystreams.NewCreateActivity(note)
+		act.Context = nil
+		act.ID = toots[idx].Uri + "/activity"
+		act.To = note.To
+		act.CC = note.CC
+		items = append(items, act)
+	}
+	return items, nil
+}
+
+func countActors(ctx context.Context, svr sparq.Server, query string, iri string) (int, error) {
+	var count int
+	err := svr.DB().QueryRowContext(ctx, query, iri).Scan(&count)
+	if err != nil {
+		return 0, errors.Wrap(err, "actor_following")
+	}
+	return count, nil
+}
+
+func actorItems(ctx context.Context, svr sparq.Server, query string, args ...any) ([]interface{}, error) {
+	iris := []string{}
+	err := svr.DB().SelectContext(ctx, &iris, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "actor_following")
+	}
+	items := make([]interface{}, len(iris))
+	for idx := range iris {
+		items[idx] = iris[idx]
+	}
+	return items, nil
+}
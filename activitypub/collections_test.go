@@ -0,0 +1,106 @@
+package activitypub
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/web"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollections(t *testing.T) {
+	ts, stopper := web.NewTestServer(t, "collections")
+	defer stopper()
+
+	root := mux.NewRouter()
+	root.HandleFunc("/users/{nick:[a-z0-9]{4,20}}/outbox", OutboxHandler(ts))
+	root.HandleFunc("/users/{nick:[a-z0-9]{4,20}}/followers", FollowersHandler(ts))
+	root.HandleFunc("/users/{nick:[a-z0-9]{4,20}}/following", FollowingHandler(ts))
+
+	get := func(path string) (int, map[string]any) {
+		req := httptest.NewRequest("GET", "https://localhost.dev"+path, nil)
+		w := httptest.NewRecorder()
+		root.ServeHTTP(w, req)
+		result := map[string]any{}
+		if w.Code == 200 {
+			assert.Equal(t, ActivityJson, w.Header().Get("Content-Type"))
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		}
+		return w.Code, result
+	}
+
+	admin := model.LocalIRI("admin")
+	_, err := ts.DB().Exec(`insert into actor_following (Id, ActorId, TargetActorId, TargetActorAccount, State)
+		values ('1', 'https://example.com/users/bob', ?, 'admin@localhost.dev', 'accepted'),
+		('2', 'https://example.com/users/eve', ?, 'admin@localhost.dev', 'pending'),
+		('3', ?, 'https://example.com/users/bob', 'bob@example.com', 'accepted')`, admin, admin, admin)
+	assert.NoError(t, err)
+
+	t.Run("Outbox", func(t *testing.T) {
+		code, _ := get("/users/nosuch/outbox")
+		assert.Equal(t, 404, code)
+
+		code, coll := get("/users/admin/outbox")
+		assert.Equal(t, 200, code)
+		assert.Equal(t, "OrderedCollection", coll["type"])
+		assert.Equal(t, admin+"/outbox", coll["id"])
+		assert.EqualValues(t, 2, coll["totalItems"])
+		assert.Equal(t, admin+"/outbox?page=1", coll["first"])
+
+		code, page := get("/users/admin/outbox?page=1")
+		assert.Equal(t, 200, code)
+		assert.Equal(t, "OrderedCollectionPage", page["type"])
+		assert.Equal(t, admin+"/outbox", page["partOf"])
+		assert.Nil(t, page["next"])
+		assert.Nil(t, page["prev"])
+		items := page["orderedItems"].([]any)
+		assert.Equal(t, 2, len(items))
+		item := items[0].(map[string]any)
+		assert.Equal(t, "Create", item["type"])
+		assert.Equal(t, admin, item["actor"])
+		note := item["object"].(map[string]any)
+		assert.Equal(t, "Note", note["type"])
+		assert.Equal(t, admin, note["attributedTo"])
+
+		code, _ = get("/users/admin/outbox?page=0")
+		assert.Equal(t, 400, code)
+	})
+
+	t.Run("Network", func(t *testing.T) {
+		code, coll := get("/users/admin/followers")
+		assert.Equal(t, 200, code)
+		assert.EqualValues(t, 1, coll["totalItems"])
+
+		code, page := get("/users/admin/followers?page=1")
+		assert.Equal(t, 200, code)
+		assert.EqualValues(t, []any{"https://example.com/users/bob"}, page["orderedItems"])
+
+		code, page = get("/users/admin/following?page=1")
+		assert.Equal(t, 200, code)
+		assert.EqualValues(t, 1, page["totalItems"])
+		assert.EqualValues(t, []any{"https://example.com/users/bob"}, page["orderedItems"])
+	})
+
+	t.Run("Hidden", func(t *testing.T) {
+		_, err := ts.DB().Exec("update accounts set Visibility = ? where Nick = 'admin'", model.Protected)
+		assert.NoError(t, err)
+		defer func() {
+			_, _ = ts.DB().Exec("update accounts set Visibility = ? where Nick = 'admin'", model.Public)
+		}()
+
+		code, coll := get("/users/admin/followers?page=1")
+		assert.Equal(t, 200, code)
+		assert.Equal(t, "OrderedCollection", coll["type"])
+		assert.EqualValues(t, 1, coll["totalItems"])
+		assert.Nil(t, coll["first"])
+		assert.Nil(t, coll["orderedItems"])
+
+		// the outbox is still available
+		code, page := get("/users/admin/outbox?page=1")
+		assert.Equal(t, 200, code)
+		assert.Equal(t, 2, len(page["orderedItems"].([]any)))
+	})
+}
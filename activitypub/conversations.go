@@ -0,0 +1,137 @@
+package activitypub
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/contribsys/sparq"
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/util"
+	"github.com/pkg/errors"
+)
+
+// MaxConversationDepth limits how far up a thread we look for the
+// conversation a direct reply belongs to.
+const MaxConversationDepth = 40
+
+// ConversationFunc is called for each local participant when a
+// direct toot joins one of their conversations, e.g. to stream it.
+type ConversationFunc func(ctx context.Context, svr sparq.Server, iri string, conversationId int64)
+
+var conversationListeners listeners[ConversationFunc]
+
+// OnConversation registers a listener for conversation activity,
+// returning a func which unregisters it.
+func OnConversation(fn ConversationFunc) func() {
+	return conversationListeners.add(fn)
+}
+
+// JoinConversation adds a direct toot to the conversation of the
+// thread it replies to, starting one if needed. The toot's author
+// and the local accounts it mentions take part; the conversation is
+// unread for everyone but the author and reappears for anyone who
+// removed it.
+func JoinConversation(ctx context.Context, svr sparq.Server, toot *model.Toot) error {
+	if toot.Visibility != model.VisDirect || toot.BoostOfId != nil {
+		return nil
+	}
+	id, err := conversationFor(ctx, svr, toot)
+	if err != nil {
+		return err
+	}
+	_, err = svr.DB().ExecContext(ctx, "update toots set ConversationId = ? where Sid = ?", id, toot.Sid)
+	if err != nil {
+		return errors.Wrap(err, "toots")
+	}
+	author, err := AuthorIRI(ctx, svr, toot)
+	if err != nil {
+		return err
+	}
+	mentioned, err := Mentions(ctx, svr, toot.Sid)
+	if err != nil {
+		return err
+	}
+
+	notify := []string{}
+	for _, iri := range append([]string{author}, mentioned...) {
+		if _, ok := model.LocalNick(iri); !ok {
+			continue
+		}
+		_, err = svr.DB().ExecContext(ctx, `
+			insert into account_conversations (ConversationId, ActorId, LastTootId, Unread)
+			values (?, ?, (select rowid from toots where Sid = ?), ?)
+			on conflict (ConversationId, ActorId) do update set
+				LastTootId = max(LastTootId, excluded.LastTootId), Unread = excluded.Unread,
+				Removed = false, UpdatedAt = current_timestamp`, id, iri, toot.Sid, iri != author)
+		if err != nil {
+			return errors.Wrap(err, "account_conversations")
+		}
+		muted, err := MutedConversation(ctx, svr, iri, toot.Uri)
+		if err != nil {
+			return err
+		}
+		if !muted {
+			notify = append(notify, iri)
+		}
+	}
+
+	listeners := conversationListeners.all()
+	for _, iri := range notify {
+		for _, fn := range listeners {
+			fn(ctx, svr, iri, id)
+		}
+	}
+	return nil
+}
+
+// conversationFor returns the conversation of the nearest ancestor
+// which is in one, otherwise the conversation named by the first
+// toot of the thread.
+func conversationFor(ctx context.Context, svr sparq.Server, toot *model.Toot) (int64, error) {
+	if toot.ConversationId != nil {
+		return *toot.ConversationId, nil
+	}
+	uri := toot.Uri
+	parentUri := toot.InReplyTo
+	for depth := 0; parentUri != nil && depth < MaxConversationDepth; depth++ {
+		var parent model.Toot
+		err := svr.DB().GetContext(ctx, &parent, "select * from toots where Uri = ?", *parentUri)
+		if err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				return 0, errors.Wrap(err, "toots")
+			}
+			// we haven't seen the parent but its other replies
+			// still belong together
+			uri = *parentUri
+			break
+		}
+		if parent.ConversationId != nil {
+			return *parent.ConversationId, nil
+		}
+		uri = parent.Uri
+		parentUri = parent.InReplyTo
+	}
+
+	_, err := svr.DB().ExecContext(ctx, "insert into conversations (Uri) values (?) on conflict do nothing", uri)
+	if err != nil {
+		return 0, errors.Wrap(err, "conversations")
+	}
+	var id int64
+	err = svr.DB().GetContext(ctx, &id, "select Id from conversations where Uri = ?", uri)
+	if err != nil {
+		return 0, errors.Wrap(err, "conversations")
+	}
+	util.Debugf("Toot %s is in conversation %d", toot.Sid, id)
+	return id, nil
+}
+
+// MutedConversation is true if the local account muted the
+// conversation which the toot with the given URI belongs to.
+func MutedConversation(ctx context.Context, svr sparq.Server, iri, uri string) (bool, error) {
+	var count int
+	err := svr.DB().GetContext(ctx, &count, `
+		select count(*) from account_conversations ac
+		join toots t on t.ConversationId = ac.ConversationId
+		where t.Uri = ? and ac.ActorId = ? and ac.Muted`, uri, iri)
+	return count > 0, errors.Wrap(err, "account_conversations")
+}
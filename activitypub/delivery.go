@@ -0,0 +1,304 @@
+package activitypub
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/sparq"
+	"github.com/contribsys/sparq/activitystreams"
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/util"
+	"github.com/pkg/errors"
+)
+
+var (
+	// Mastodon gives up on delivery after 16 attempts, roughly 3 days
+	// with Faktory's exponential backoff.
+	DeliveryRetries = 16
+
+	// The maximum number of concurrent deliveries to any single host
+	// so we don't hammer large instances.
+	MaxHostConcurrency = 2
+
+	hostLimits = &hostLimiter{hosts: map[string]chan struct{}{}}
+)
+
+// DeliverToot queues the federation of a new local toot
+// to the author's followers.
+func DeliverToot(ctx context.Context, svr sparq.Server, sid string) error {
+	return svr.Jobs().Push(ctx, client.NewJob("DeliverToot", sid))
+}
+
+// DeliverUpdate queues the federation of an edited local toot.
+func DeliverUpdate(ctx context.Context, svr sparq.Server, sid string) error {
+	return svr.Jobs().Push(ctx, client.NewJob("DeliverUpdate", sid))
+}
+
+// DeliverDelete queues the federation of a deleted local toot.
+func DeliverDelete(ctx context.Context, svr sparq.Server, sid string) error {
+	return svr.Jobs().Push(ctx, client.NewJob("DeliverDelete", sid))
+}
+
+func deliverToot(ctx context.Context, svr sparq.Server, sid string) error {
+	toot, author, err := localToot(ctx, svr, sid)
+	if err != nil {
+		return err
+	}
+	if toot.DeletedAt != nil {
+		return nil
+	}
+	note, err := NoteFor(ctx, svr, toot, author)
+	if err != nil {
+		return err
+	}
+	act := activitystreams.NewCreateActivity(note)
+	act.ID = toot.Uri + "/activity"
+	act.To = note.To
+	act.CC = note.CC
+	return broadcastToot(ctx, svr, author, toot, act)
+}
+
+func deliverUpdate(ctx context.Context, svr sparq.Server, sid string) error {
+	toot, author, err := localToot(ctx, svr, sid)
+	if err != nil {
+		return err
+	}
+	if toot.DeletedAt != nil {
+		return nil
+	}
+	note, err := NoteFor(ctx, svr, toot, author)
+	if err != nil {
+		return err
+	}
+	// polls are updated when they close without being edited
+	updated := toot.UpdatedAt
+	if toot.LastEditAt != nil {
+		updated = *toot.LastEditAt
+	}
+	act := activitystreams.NewUpdateActivity(note)
+	act.ID = fmt.Sprintf("%s#updates/%d", toot.Uri, updated.Unix())
+	act.Published = updated
+	act.To = note.To
+	act.CC = note.CC
+	return broadcastToot(ctx, svr, author, toot, act)
+}
+
+func deliverDelete(ctx context.Context, svr sparq.Server, sid string) error {
+	toot, author, err := localToot(ctx, svr, sid)
+	if err != nil {
+		return err
+	}
+	if toot.DeletedAt == nil {
+		return nil
+	}
+	act := activitystreams.NewDeleteActivity(activitystreams.NewTombstoneObject(toot.Uri, "Note", *toot.DeletedAt))
+	act.ID = toot.Uri + "#delete"
+	act.Actor = author.IRI()
+	act.To, act.CC = Addressing(author, toot.Visibility)
+	if toot.Visibility == model.VisDirect {
+		act.To, err = Mentions(ctx, svr, toot.Sid)
+		if err != nil {
+			return err
+		}
+	}
+	return broadcastToot(ctx, svr, author, toot, act)
+}
+
+// localToot returns the toot, deleted or not, and its local author.
+func localToot(ctx context.Context, svr sparq.Server, sid string) (*model.Toot, *model.Account, error) {
+	var toot model.Toot
+	err := svr.DB().GetContext(ctx, &toot, "select * from toots where sid = ? and AuthorId is not null", sid)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "toot "+sid)
+	}
+	author, err := localAccount(ctx, svr, *toot.AuthorId)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &toot, author, nil
+}
+
+// broadcastToot sends the activity about the toot to the author's
+// followers and any remote actors mentioned in the toot. Direct
+// toots only go to the mentioned actors.
+func broadcastToot(ctx context.Context, svr sparq.Server, author *model.Account, toot *model.Toot, activity any) error {
+	inboxes := []string{}
+	if toot.Visibility != model.VisDirect {
+		followers, err := FollowerInboxes(ctx, svr, author.IRI())
+		if err != nil {
+			return err
+		}
+		inboxes = append(inboxes, followers...)
+	}
+	mentioned, err := MentionInboxes(ctx, svr, toot.Sid)
+	if err != nil {
+		return err
+	}
+	seen := map[string]bool{}
+	for _, inbox := range inboxes {
+		seen[inbox] = true
+	}
+	for _, inbox := range mentioned {
+		if !seen[inbox] {
+			seen[inbox] = true
+			inboxes = append(inboxes, inbox)
+		}
+	}
+	return Broadcast(ctx, svr, author, activity, inboxes)
+}
+
+// Broadcast queues a separate delivery job for each inbox. Each
+// delivery is retried independently so one dead server doesn't
+// hold up everyone else.
+func Broadcast(ctx context.Context, svr sparq.Server, author *model.Account, activity any, inboxes []string) error {
+	if len(inboxes) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(activity)
+	if err != nil {
+		return errors.Wrap(err, "activity")
+	}
+	aid := strconv.FormatInt(author.Id, 10)
+	for _, inbox := range inboxes {
+		job := client.NewJob("Deliver", aid, inbox, string(data))
+		job.Retry = &DeliveryRetries
+		err := svr.Jobs().Push(ctx, job)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FollowerInboxes returns the unique inboxes for the remote followers
+// of the given local actor, preferring shared inboxes.
+func FollowerInboxes(ctx context.Context, svr sparq.Server, iri string) ([]string, error) {
+	props := []string{}
+	err := svr.DB().SelectContext(ctx, &props, `
+		select a.Properties from actor_following f
+		join actors a on f.ActorId = a.Id
+		where f.TargetActorId = ? and f.State = 'accepted'`, iri)
+	if err != nil {
+		return nil, errors.Wrap(err, "followers")
+	}
+
+	seen := map[string]bool{}
+	inboxes := []string{}
+	for _, prop := range props {
+		inbox := inboxFor(prop)
+		if inbox != "" && !seen[inbox] {
+			seen[inbox] = true
+			inboxes = append(inboxes, inbox)
+		}
+	}
+	return inboxes, nil
+}
+
+func inboxFor(props string) string {
+	var obj activitystreams.Object
+	err := json.Unmarshal([]byte(props), &obj)
+	if err != nil {
+		return ""
+	}
+	if obj.Endpoints != nil && obj.Endpoints.SharedInbox != "" {
+		return obj.Endpoints.SharedInbox
+	}
+	return obj.Inbox
+}
+
+// Deliver POSTs the activity to the remote inbox, signed with the
+// account's private key. Errors which might be temporary are
+// returned so Faktory will retry the delivery.
+func Deliver(ctx context.Context, svr sparq.Server, accountId, inbox, payload string) error {
+	var nick string
+	var key []byte
+	err := svr.DB().QueryRowContext(ctx, `
+		select a.Nick, s.PrivateKey from accounts a
+		join account_securities s on a.Id = s.AccountId
+		where a.Id = ?`, accountId).Scan(&nick, &key)
+	if err != nil {
+		return errors.Wrap(err, "account "+accountId)
+	}
+
+	u, err := url.Parse(inbox)
+	if err != nil {
+		util.Warnf("Invalid inbox %q: %v", inbox, err)
+		return nil
+	}
+	release, err := hostLimits.acquire(ctx, u.Host)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	body := []byte(payload)
+	req, err := http.NewRequestWithContext(ctx, "POST", inbox, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", ActivityJson)
+	req.Header.Set("User-Agent", sparq.ServerHeader)
+	err = SignRequest(req, model.LocalIRI(nick)+"#main-key", key, body)
+	if err != nil {
+		return err
+	}
+
+	resp, err := Client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "Unable to deliver to "+inbox)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	code := resp.StatusCode
+	switch {
+	case code >= 200 && code < 300:
+		util.Debugf("Delivered to %s: %d", inbox, code)
+		return nil
+	case code == http.StatusTooManyRequests || code >= 500:
+		return fmt.Errorf("Delivery to %s failed: %d", inbox, code)
+	}
+	// the remote server rejected the activity, retrying won't help
+	util.Warnf("Delivery to %s rejected: %d", inbox, code)
+	return nil
+}
+
+func localAccount(ctx context.Context, svr sparq.Server, id any) (*model.Account, error) {
+	var acct model.Account
+	err := svr.DB().GetContext(ctx, &acct, "select * from accounts where Id = ?", id)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("account %v", id))
+	}
+	return &acct, nil
+}
+
+// hostLimiter caps the number of concurrent requests to each host.
+type hostLimiter struct {
+	hosts map[string]chan struct{}
+	mu    sync.Mutex
+}
+
+func (hl *hostLimiter) acquire(ctx context.Context, host string) (func(), error) {
+	hl.mu.Lock()
+	sem, ok := hl.hosts[host]
+	if !ok {
+		sem = make(chan struct{}, MaxHostConcurrency)
+		hl.hosts[host] = sem
+	}
+	hl.mu.Unlock()
+
+	select {
+	case sem <- struct{}{}:
+		return func() { <-sem }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,143 @@
+package activitypub
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/web"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDelivery(t *testing.T) {
+	ts, stopper := web.NewTestServer(t, "delivery")
+	defer stopper()
+	jobs := ts.Jobs().(*web.TestJobs)
+	Register(ts)
+	ctx := context.Background()
+
+	bob := newRemoteActor(t)
+	_, err := FetchActor(ctx, ts, bob.IRI)
+	assert.NoError(t, err)
+
+	t.Run("NoFollowers", func(t *testing.T) {
+		assert.NoError(t, DeliverToot(ctx, ts, "AABA"))
+		assert.NoError(t, jobs.Drain(ctx))
+		assert.Equal(t, 0, len(bob.Inbox))
+	})
+
+	t.Run("Followers", func(t *testing.T) {
+		_, err := ts.DB().Exec(`insert into actor_following (Id, ActorId, TargetActorId, TargetActorAccount, State)
+			values ('1', ?, 'https://localhost.dev/users/admin', 'admin@localhost.dev', 'accepted')`, bob.IRI)
+		assert.NoError(t, err)
+
+		inboxes, err := FollowerInboxes(ctx, ts, "https://localhost.dev/users/admin")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{bob.URL + "/inbox"}, inboxes)
+
+		assert.NoError(t, DeliverToot(ctx, ts, "AABA"))
+		assert.Equal(t, 1, len(jobs.Find("DeliverToot")))
+		assert.NoError(t, jobs.Drain(ctx))
+		assert.Equal(t, 1, len(bob.Inbox))
+
+		req := bob.Inbox[0]
+		assert.Equal(t, "/inbox", req.URL.Path)
+		body, err := io.ReadAll(req.Body)
+		assert.NoError(t, err)
+
+		var create map[string]any
+		assert.NoError(t, json.Unmarshal(body, &create))
+		assert.Equal(t, "Create", create["type"])
+		assert.Equal(t, "https://localhost.dev/users/admin", create["actor"])
+		note := create["object"].(map[string]any)
+		assert.Equal(t, "Note", note["type"])
+		assert.Equal(t, "https://localhost.dev/@admin/status/AABA", note["id"])
+		assert.Equal(t, "CW: Hello World", note["summary"])
+		assert.Contains(t, note["cc"], "https://localhost.dev/users/admin/followers")
+
+		var pubkey []byte
+		err = ts.DB().QueryRow("select PublicKey from account_securities where AccountId = 1").Scan(&pubkey)
+		assert.NoError(t, err)
+		sig, err := ParseSignature(req.Header.Get("Signature"))
+		assert.NoError(t, err)
+		assert.Equal(t, "https://localhost.dev/users/admin#main-key", sig.KeyId)
+		assert.NoError(t, VerifyRequest(req, sig, pubkey, body))
+	})
+
+	t.Run("Mentioned", func(t *testing.T) {
+		_, err := ts.DB().Exec("delete from actor_following")
+		assert.NoError(t, err)
+		_, err = ts.DB().Exec("insert into toot_mentions (Sid, ActorId) values ('AABA', ?)", bob.IRI)
+		assert.NoError(t, err)
+
+		assert.NoError(t, DeliverToot(ctx, ts, "AABA"))
+		assert.NoError(t, jobs.Drain(ctx))
+		assert.Equal(t, 2, len(bob.Inbox))
+
+		body, err := io.ReadAll(bob.Inbox[1].Body)
+		assert.NoError(t, err)
+		var create map[string]any
+		assert.NoError(t, json.Unmarshal(body, &create))
+		note := create["object"].(map[string]any)
+		assert.Contains(t, note["cc"], bob.IRI)
+		tag := note["tag"].([]any)[0].(map[string]any)
+		assert.Equal(t, "Mention", tag["type"])
+		assert.Equal(t, bob.IRI, tag["href"])
+	})
+
+	t.Run("Direct", func(t *testing.T) {
+		// followers don't get direct toots, only the mentioned actors
+		_, err := ts.DB().Exec(`insert into actor_following (Id, ActorId, TargetActorId, TargetActorAccount, State)
+			values ('1', ?, 'https://localhost.dev/users/admin', 'admin@localhost.dev', 'accepted')`, bob.IRI)
+		assert.NoError(t, err)
+		_, err = ts.DB().Exec("delete from toot_mentions")
+		assert.NoError(t, err)
+		_, err = ts.DB().Exec("update toots set Visibility = ? where Sid = 'AABA'", model.VisDirect)
+		assert.NoError(t, err)
+		assert.NoError(t, DeliverToot(ctx, ts, "AABA"))
+		assert.NoError(t, jobs.Drain(ctx))
+		assert.Equal(t, 2, len(bob.Inbox))
+
+		_, err = ts.DB().Exec("insert into toot_mentions (Sid, ActorId) values ('AABA', ?)", bob.IRI)
+		assert.NoError(t, err)
+		assert.NoError(t, DeliverToot(ctx, ts, "AABA"))
+		assert.NoError(t, jobs.Drain(ctx))
+		assert.Equal(t, 3, len(bob.Inbox))
+		body, err := io.ReadAll(bob.Inbox[2].Body)
+		assert.NoError(t, err)
+		var create map[string]any
+		assert.NoError(t, json.Unmarshal(body, &create))
+		assert.Equal(t, []any{bob.IRI}, create["to"])
+		assert.Nil(t, create["cc"])
+		note := create["object"].(map[string]any)
+		assert.Equal(t, []any{bob.IRI}, note["to"])
+		assert.Nil(t, note["cc"])
+
+		toot, _, err := localToot(ctx, ts, "AABA")
+		assert.NoError(t, err)
+		assert.NoError(t, DeleteToot(ctx, ts, toot))
+		assert.NoError(t, DeliverDelete(ctx, ts, "AABA"))
+		assert.NoError(t, jobs.Drain(ctx))
+		assert.Equal(t, 4, len(bob.Inbox))
+		body, err = io.ReadAll(bob.Inbox[3].Body)
+		assert.NoError(t, err)
+		var del map[string]any
+		assert.NoError(t, json.Unmarshal(body, &del))
+		assert.Equal(t, "Delete", del["type"])
+		assert.Equal(t, []any{bob.IRI}, del["to"])
+	})
+
+	t.Run("Failure", func(t *testing.T) {
+		// server and network errors are retried
+		err := Deliver(ctx, ts, "1", bob.URL+"/fail", `{}`)
+		assert.ErrorContains(t, err, "503")
+		err = Deliver(ctx, ts, "1", "http://127.0.0.1:1/inbox", `{}`)
+		assert.Error(t, err)
+
+		// rejections are not
+		err = Deliver(ctx, ts, "1", bob.URL+"/reject", `{}`)
+		assert.NoError(t, err)
+	})
+}
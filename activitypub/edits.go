@@ -0,0 +1,235 @@
+package activitypub
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/contribsys/sparq"
+	"github.com/contribsys/sparq/activitystreams"
+	"github.com/contribsys/sparq/markup"
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/util"
+	"github.com/pkg/errors"
+)
+
+// SaveEdit applies the edit to the toot and records it as a new
+// revision. The toot's original version becomes the first revision
+// when the toot is first edited. Accounts which are newly mentioned
+// by the edit are notified.
+func SaveEdit(ctx context.Context, svr sparq.Server, toot *model.Toot, edit *model.TootEdit, tags []string, mentions []string) error {
+	before, err := Mentions(ctx, svr, toot.Sid)
+	if err != nil {
+		return err
+	}
+	tx, err := svr.DB().BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var count int
+	err = tx.GetContext(ctx, &count, "select count(*) from toot_edits where Sid = ?", toot.Sid)
+	if err != nil {
+		return errors.Wrap(err, "toot_edits")
+	}
+	if count == 0 {
+		_, err = tx.ExecContext(ctx, `
+			insert into toot_edits (Sid, Summary, Content, Text, Lang, CreatedAt) values (?, ?, ?, ?, ?, ?)`,
+			toot.Sid, toot.Summary, toot.Content, toot.Text, toot.Lang, toot.CreatedAt.UTC().Format("2006-01-02 15:04:05"))
+		if err != nil {
+			return errors.Wrap(err, "toot_edits")
+		}
+	}
+	edited := edit.CreatedAt.UTC().Format("2006-01-02 15:04:05")
+	_, err = tx.ExecContext(ctx, `
+		insert into toot_edits (Sid, Summary, Content, Text, Lang, CreatedAt) values (?, ?, ?, ?, ?, ?)`,
+		toot.Sid, edit.Summary, edit.Content, edit.Text, edit.Lang, edited)
+	if err != nil {
+		return errors.Wrap(err, "toot_edits")
+	}
+	_, err = tx.ExecContext(ctx, `
+		update toots set Summary = ?, Content = ?, Text = ?, Lang = ?, LastEditAt = ?, UpdatedAt = current_timestamp
+		where Sid = ?`, edit.Summary, edit.Content, edit.Text, edit.Lang, edited, toot.Sid)
+	if err != nil {
+		return errors.Wrap(err, "toots")
+	}
+	err = SaveMentions(ctx, tx, toot.Sid, mentions)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "delete from toot_tags where Sid = ?", toot.Sid)
+	if err != nil {
+		return errors.Wrap(err, "toot_tags")
+	}
+	for _, tag := range tags {
+		_, err = tx.ExecContext(ctx, "insert into toot_tags (Sid, Tag) values (?, ?)", toot.Sid, strings.ToLower(tag))
+		if err != nil {
+			return errors.Wrap(err, "toot_tags")
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	added := []string{}
+	for _, iri := range mentions {
+		if !contains(before, iri) {
+			added = append(added, iri)
+		}
+	}
+	if len(added) == 0 {
+		return nil
+	}
+	// newly mentioned accounts join the conversation
+	err = JoinConversation(ctx, svr, toot)
+	if err != nil {
+		return err
+	}
+	author, err := AuthorIRI(ctx, svr, toot)
+	if err != nil {
+		return err
+	}
+	return NotifyMentioned(ctx, svr, author, toot.Uri, added)
+}
+
+// DeleteToot marks the toot and any boosts of it as deleted and
+// removes the notifications about it. The toot's URI is then
+// served as a Tombstone.
+func DeleteToot(ctx context.Context, svr sparq.Server, toot *model.Toot) error {
+	_, err := svr.DB().ExecContext(ctx, `
+		update toots set DeletedAt = current_timestamp, UpdatedAt = current_timestamp
+		where (Sid = ? or BoostOfId = ?) and DeletedAt is null`, toot.Sid, toot.Sid)
+	if err != nil {
+		return errors.Wrap(err, "toots")
+	}
+	_, err = svr.DB().ExecContext(ctx, "delete from actor_notifications where ObjectId = ?", toot.Uri)
+	return errors.Wrap(err, "actor_notifications")
+}
+
+// A remote actor edited a note or their profile. We only apply
+// edits to notes we've already stored.
+func handleUpdate(ctx context.Context, svr sparq.Server, recipient string, in *Inbound) error {
+	if in.ObjectId() == in.Actor {
+		_, err := RefreshActor(ctx, svr, in.Actor)
+		return err
+	}
+	if !noteTypes[in.ObjectType()] {
+		util.Debugf("Ignoring update of %s %s", in.ObjectType(), in.ObjectId())
+		return nil
+	}
+	var note RemoteNote
+	err := in.DecodeObject(&note)
+	if err != nil {
+		return errors.Wrap(err, "Invalid note "+in.ObjectId())
+	}
+	if note.AttributedTo != in.Actor {
+		util.Warnf("Ignoring update of note %s by %s for %s", note.Id, in.Actor, note.AttributedTo)
+		return nil
+	}
+	toot, err := remoteToot(ctx, svr, in.Actor, note.Id)
+	if err != nil || toot == nil {
+		return err
+	}
+
+	edit := &model.TootEdit{
+		Summary:   note.Summary,
+		Content:   markup.Sanitize(note.Content),
+		Lang:      toot.Lang,
+		CreatedAt: note.Updated,
+	}
+	edit.Text = markup.Text(edit.Content)
+	for code := range note.ContentMap {
+		edit.Lang = code
+		break
+	}
+	if edit.CreatedAt.IsZero() {
+		edit.CreatedAt = time.Now()
+	}
+	// Questions are updated whenever someone votes
+	if edit.Summary != toot.Summary || edit.Content != toot.Content {
+		tags := []string{}
+		for _, tag := range note.Tag {
+			name := strings.TrimPrefix(tag.Name, "#")
+			if tag.Type == activitystreams.TagHashtag && name != "" {
+				tags = append(tags, name)
+			}
+		}
+		err = SaveEdit(ctx, svr, toot, edit, tags, note.allMentioned())
+		if err != nil {
+			return err
+		}
+		err = SaveEmojis(ctx, svr, note.AttributedTo, note.Tag)
+		if err != nil {
+			return err
+		}
+	}
+	return syncRemotePoll(ctx, svr, toot, &note)
+}
+
+// A remote actor deleted a note or their account.
+func handleDelete(ctx context.Context, svr sparq.Server, recipient string, in *Inbound) error {
+	if in.ObjectId() == in.Actor {
+		return deleteActor(ctx, svr, in.Actor)
+	}
+	toot, err := remoteToot(ctx, svr, in.Actor, in.ObjectId())
+	if err != nil || toot == nil {
+		return err
+	}
+	return DeleteToot(ctx, svr, toot)
+}
+
+// remoteToot returns the stored toot with the given URI if the
+// actor is its author, nil otherwise.
+func remoteToot(ctx context.Context, svr sparq.Server, actor, uri string) (*model.Toot, error) {
+	toot, err := tootByUri(ctx, svr, uri)
+	if err != nil || toot == nil {
+		return nil, err
+	}
+	author, err := AuthorIRI(ctx, svr, toot)
+	if err != nil {
+		return nil, err
+	}
+	if author != actor {
+		util.Warnf("Ignoring change to %s by %s", uri, actor)
+		return nil, nil
+	}
+	return toot, nil
+}
+
+// deleteActor removes the follows, list memberships, toots and
+// notifications of a deleted remote actor.
+func deleteActor(ctx context.Context, svr sparq.Server, iri string) error {
+	follows := []model.ActorFollowing{}
+	err := svr.DB().SelectContext(ctx, &follows,
+		"select * from actor_following where ActorId = ? or TargetActorId = ?", iri, iri)
+	if err != nil {
+		return errors.Wrap(err, "actor_following")
+	}
+	for idx := range follows {
+		err = removeFollow(ctx, svr, &follows[idx])
+		if err != nil {
+			return err
+		}
+	}
+	_, err = svr.DB().ExecContext(ctx, `
+		update toots set DeletedAt = current_timestamp, UpdatedAt = current_timestamp
+		where ActorId = (select MastodonId from actors where Id = ?) and AuthorId is null and DeletedAt is null`, iri)
+	if err != nil {
+		return errors.Wrap(err, "toots")
+	}
+	// boosts of their toots go too
+	_, err = svr.DB().ExecContext(ctx, `
+		update toots set DeletedAt = current_timestamp, UpdatedAt = current_timestamp
+		where DeletedAt is null and BoostOfId in (select Sid from toots where DeletedAt is not null)`)
+	if err != nil {
+		return errors.Wrap(err, "toots")
+	}
+	_, err = svr.DB().ExecContext(ctx, "delete from list_accounts where ActorId = ?", iri)
+	if err != nil {
+		return errors.Wrap(err, "list_accounts")
+	}
+	_, err = svr.DB().ExecContext(ctx, "delete from actor_notifications where FromActorId = ?", iri)
+	return errors.Wrap(err, "actor_notifications")
+}
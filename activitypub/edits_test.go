@@ -0,0 +1,137 @@
+package activitypub
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/web"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEdits(t *testing.T) {
+	ts, stopper := web.NewTestServer(t, "edits")
+	defer stopper()
+	jobs := ts.Jobs().(*web.TestJobs)
+	Register(ts)
+	ctx := context.Background()
+
+	root := mux.NewRouter()
+	root.HandleFunc("/inbox", InboxHandler(ts))
+	root.HandleFunc("/@{nick}/{id}", NoteHandler(ts))
+	bob := newRemoteActor(t)
+	_, err := FetchActor(ctx, ts, bob.IRI)
+	assert.NoError(t, err)
+
+	admin, err := localAccount(ctx, ts, 1)
+	assert.NoError(t, err)
+	find := func(uri string) *model.Toot {
+		var toot model.Toot
+		err := ts.DB().GetContext(ctx, &toot, "select * from toots where Uri = ?", uri)
+		if err != nil {
+			return nil
+		}
+		return &toot
+	}
+	// the type and object of the last activity bob received
+	received := func() (string, map[string]any) {
+		assert.NotEmpty(t, bob.Inbox)
+		if len(bob.Inbox) == 0 {
+			return "", nil
+		}
+		body, err := io.ReadAll(bob.Inbox[len(bob.Inbox)-1].Body)
+		assert.NoError(t, err)
+		activity := map[string]any{}
+		assert.NoError(t, json.Unmarshal(body, &activity))
+		obj, _ := activity["object"].(map[string]any)
+		return activity["type"].(string), obj
+	}
+
+	t.Run("Outbound", func(t *testing.T) {
+		_, err := ts.DB().Exec(`insert into actor_following (Id, ActorId, TargetActorId, TargetActorAccount, State)
+			values ('1', ?, ?, 'admin@localhost.dev', 'accepted')`, bob.IRI, admin.IRI())
+		assert.NoError(t, err)
+
+		var toot model.Toot
+		assert.NoError(t, ts.DB().Get(&toot, "select * from toots where Sid = 'AABA'"))
+		edit := &model.TootEdit{Content: "Hello #again", Lang: "en", CreatedAt: time.Now()}
+		assert.NoError(t, SaveEdit(ctx, ts, &toot, edit, []string{"again"}, nil))
+		assert.NoError(t, DeliverUpdate(ctx, ts, "AABA"))
+		assert.NoError(t, jobs.Drain(ctx))
+
+		kind, note := received()
+		assert.Equal(t, "Update", kind)
+		assert.Equal(t, toot.Uri, note["id"])
+		assert.Equal(t, "Hello #again", note["content"])
+		assert.NotNil(t, note["updated"])
+
+		var revisions int
+		assert.NoError(t, ts.DB().Get(&revisions, "select count(*) from toot_edits where Sid = 'AABA'"))
+		assert.Equal(t, 2, revisions)
+
+		req := httptest.NewRequest("GET", "https://localhost.dev/@admin/AABA", nil)
+		req.Header.Set("Accept", ActivityAccept)
+		w := httptest.NewRecorder()
+		root.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+		assert.Contains(t, w.Body.String(), "Hello #again")
+
+		assert.NoError(t, DeleteToot(ctx, ts, &toot))
+		assert.NoError(t, DeliverDelete(ctx, ts, "AABA"))
+		assert.NoError(t, jobs.Drain(ctx))
+		kind, tombstone := received()
+		assert.Equal(t, "Delete", kind)
+		assert.Equal(t, "Tombstone", tombstone["type"])
+		assert.Equal(t, toot.Uri, tombstone["id"])
+
+		w = httptest.NewRecorder()
+		root.ServeHTTP(w, req)
+		assert.Equal(t, 410, w.Code)
+		assert.Contains(t, w.Body.String(), "Tombstone")
+	})
+
+	t.Run("Inbound", func(t *testing.T) {
+		_, err := ts.DB().Exec(`insert into actor_following (Id, ActorId, TargetActorId, TargetActorAccount, State)
+			values ('2', ?, ?, 'bob@remote', 'accepted')`, admin.IRI(), bob.IRI)
+		assert.NoError(t, err)
+
+		deliverAs := func(ra *remoteActor, id, kind string, object any) {
+			w := ra.post(t, root, "/inbox", map[string]any{"id": id, "type": kind, "actor": ra.IRI, "object": object})
+			assert.Equal(t, 202, w.Code, w.Body.String())
+			assert.NoError(t, jobs.Drain(ctx))
+		}
+		deliver := func(id, kind string, object any) {
+			deliverAs(bob, id, kind, object)
+		}
+		uri := bob.IRI + "/notes/1"
+		note := map[string]any{
+			"id":           uri,
+			"type":         "Note",
+			"attributedTo": bob.IRI,
+			"content":      "Hello wrold",
+			"to":           bob.IRI + "/followers",
+		}
+		deliver(uri+"/activity", "Create", note)
+		assert.NotNil(t, find(uri))
+
+		note["content"] = "Hello world"
+		note["updated"] = "2030-01-02T03:04:05Z"
+		deliver(uri+"#updates/1", "Update", note)
+		toot := find(uri)
+		assert.Equal(t, "Hello world", toot.Content)
+		assert.NotNil(t, toot.LastEditAt)
+
+		// only the author can change the note
+		mallory := newRemoteActor(t)
+		deliverAs(mallory, mallory.IRI+"/forged", "Delete", uri)
+		assert.Nil(t, find(uri).DeletedAt)
+
+		deliver(uri+"#delete", "Delete", map[string]any{"id": uri, "type": "Tombstone"})
+		assert.NotNil(t, find(uri).DeletedAt)
+	})
+}
@@ -0,0 +1,207 @@
+package activitypub
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/sparq"
+	"github.com/contribsys/sparq/activitystreams"
+	"github.com/contribsys/sparq/markup"
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/util"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+var (
+	// Remote emoji images larger than this aren't cached.
+	MaxEmojiSize = 256 * 1024
+
+	// The image types allowed for custom emoji and their
+	// file extensions.
+	EmojiTypes = map[string]string{
+		"image/png":  "png",
+		"image/gif":  "gif",
+		"image/webp": "webp",
+	}
+)
+
+// Emojis returns the usable custom emoji from the domain, "" for
+// our own, which appear in any of the texts. Remote emoji we haven't
+// cached yet are left out so clients never load remote images.
+func Emojis(ctx context.Context, dbx *sqlx.DB, domain string, texts ...string) ([]model.CustomEmoji, error) {
+	emojis := []model.CustomEmoji{}
+	codes := []string{}
+	for _, text := range texts {
+		codes = append(codes, markup.Shortcodes(text)...)
+	}
+	if len(codes) == 0 {
+		return emojis, nil
+	}
+	query, args, err := sqlx.In(`
+		select * from custom_emojis
+		where Domain = ? and Shortcode in (?) and Path != '' and not Disabled
+		order by Shortcode`, domain, codes)
+	if err != nil {
+		return nil, err
+	}
+	err = dbx.SelectContext(ctx, &emojis, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "custom_emojis")
+	}
+	return emojis, nil
+}
+
+// EmojiTags are the Emoji tags for our custom emoji used in the texts.
+func EmojiTags(ctx context.Context, svr sparq.Server, texts ...string) ([]activitystreams.Tag, error) {
+	emojis, err := Emojis(ctx, svr.DB(), "", texts...)
+	if err != nil {
+		return nil, err
+	}
+	tags := []activitystreams.Tag{}
+	for idx := range emojis {
+		emoji := &emojis[idx]
+		tags = append(tags, activitystreams.Tag{
+			Type:    activitystreams.TagEmoji,
+			ID:      emoji.Uri,
+			Name:    ":" + emoji.Shortcode + ":",
+			Updated: &emoji.UpdatedAt,
+			Icon: &activitystreams.Image{
+				Type:      "Image",
+				MediaType: emoji.MimeType,
+				URL:       emoji.PublicUri(),
+			},
+		})
+	}
+	return tags, nil
+}
+
+// SaveEmojis records the Emoji tags from a remote actor's profile or
+// note and queues a download of any which are new or have changed.
+func SaveEmojis(ctx context.Context, svr sparq.Server, actor string, tags []activitystreams.Tag) error {
+	domain := host(actor)
+	for _, tag := range tags {
+		if tag.Type != activitystreams.TagEmoji || tag.Icon == nil || host(tag.Icon.URL) == "" {
+			continue
+		}
+		code := strings.Trim(tag.Name, ":")
+		if !markup.IsShortcode(code) {
+			continue
+		}
+		var emoji model.CustomEmoji
+		err := svr.DB().GetContext(ctx, &emoji,
+			"select * from custom_emojis where Shortcode = ? and Domain = ?", code, domain)
+		if err == nil && emoji.ImageUrl == tag.Icon.URL {
+			continue
+		}
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return errors.Wrap(err, "custom_emojis")
+		}
+		uri := tag.ID
+		if uri == "" {
+			uri = tag.Icon.URL
+		}
+		var id int64
+		err = svr.DB().GetContext(ctx, &id, `
+			insert into custom_emojis (Shortcode, Domain, Uri, ImageUrl) values (?, ?, ?, ?)
+			on conflict (Shortcode, Domain) do update set Uri = excluded.Uri, ImageUrl = excluded.ImageUrl
+			returning Id`, code, domain, uri, tag.Icon.URL)
+		if err != nil {
+			return errors.Wrap(err, "Unable to save emoji "+code)
+		}
+		err = svr.Jobs().Push(ctx, client.NewJob("CacheEmoji", strconv.FormatInt(id, 10)))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cacheEmoji downloads a remote emoji's image so we can serve it.
+// Images which are too large or not an image are skipped.
+func cacheEmoji(ctx context.Context, svr sparq.Server, id string) error {
+	var emoji model.CustomEmoji
+	err := svr.DB().GetContext(ctx, &emoji, "select * from custom_emojis where Id = ?", id)
+	if errors.Is(err, sql.ErrNoRows) || emoji.IsLocal() {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "custom_emojis")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", emoji.ImageUrl, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", sparq.ServerHeader)
+	resp, err := Client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "Unable to fetch "+emoji.ImageUrl)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusGone || resp.StatusCode == http.StatusNotFound {
+		util.Infof("Emoji %s is gone: %s", emoji.Shortcode, emoji.ImageUrl)
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Unable to fetch %s: %d", emoji.ImageUrl, resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, int64(MaxEmojiSize)+1))
+	if err != nil {
+		return errors.Wrap(err, "Unable to read "+emoji.ImageUrl)
+	}
+	if len(data) > MaxEmojiSize {
+		util.Infof("Emoji %s is too large: %s", emoji.Shortcode, emoji.ImageUrl)
+		return nil
+	}
+	mimeType := http.DetectContentType(data)
+	ext, ok := EmojiTypes[mimeType]
+	if !ok {
+		util.Infof("Emoji %s is not an image (%s): %s", emoji.Shortcode, mimeType, emoji.ImageUrl)
+		return nil
+	}
+
+	path := fmt.Sprintf("emoji/%d-%x.%s", emoji.Id, rand.Uint32(), ext)
+	err = WriteEmoji(svr, path, data)
+	if err != nil {
+		return err
+	}
+	_, err = svr.DB().ExecContext(ctx, `
+		update custom_emojis set Path = ?, MimeType = ?, UpdatedAt = current_timestamp
+		where Id = ?`, path, mimeType, emoji.Id)
+	if err != nil {
+		return errors.Wrap(err, "custom_emojis")
+	}
+	RemoveEmoji(svr, &emoji)
+	return nil
+}
+
+// WriteEmoji stores an emoji image at the path under the media root.
+func WriteEmoji(svr sparq.Server, path string, data []byte) error {
+	full := filepath.Join(svr.MediaRoot(), path)
+	err := os.MkdirAll(filepath.Dir(full), 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(full, data, 0644)
+}
+
+// RemoveEmoji deletes the emoji's image, if any.
+func RemoveEmoji(svr sparq.Server, emoji *model.CustomEmoji) {
+	if !emoji.IsCached() {
+		return
+	}
+	err := os.Remove(filepath.Join(svr.MediaRoot(), emoji.Path))
+	if err != nil && !os.IsNotExist(err) {
+		util.Warnf("Unable to remove emoji %s: %v", emoji.Path, err)
+	}
+}
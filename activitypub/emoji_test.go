@@ -0,0 +1,123 @@
+package activitypub
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/contribsys/sparq/activitystreams"
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/web"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmoji(t *testing.T) {
+	ts, stopper := web.NewTestServer(t, "emoji")
+	defer stopper()
+	jobs := ts.Jobs().(*web.TestJobs)
+	Register(ts)
+	ctx := context.Background()
+
+	root := mux.NewRouter()
+	root.HandleFunc("/inbox", InboxHandler(ts))
+	bob := newRemoteActor(t)
+	admin, err := localAccount(ctx, ts, 1)
+	assert.NoError(t, err)
+	_, err = ts.DB().Exec(`insert into actor_following (Id, ActorId, TargetActorId, TargetActorAccount, State)
+		values ('1', ?, ?, 'bob@remote', 'accepted')`, admin.IRI(), bob.IRI)
+	assert.NoError(t, err)
+
+	var buf bytes.Buffer
+	assert.NoError(t, png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 16, 16))))
+	bob.Files["/emoji/blobcat.png"] = buf.Bytes()
+	bob.Files["/emoji/huge.png"] = make([]byte, MaxEmojiSize+1)
+
+	emoji := func(code string) *model.CustomEmoji {
+		var ce model.CustomEmoji
+		err := ts.DB().Get(&ce, "select * from custom_emojis where Shortcode = ?", code)
+		if err != nil {
+			return nil
+		}
+		return &ce
+	}
+
+	t.Run("Remote", func(t *testing.T) {
+		w := bob.post(t, root, "/inbox", map[string]any{
+			"id":    bob.IRI + "/notes/1/activity",
+			"type":  "Create",
+			"actor": bob.IRI,
+			"object": map[string]any{
+				"id":           bob.IRI + "/notes/1",
+				"type":         "Note",
+				"attributedTo": bob.IRI,
+				"content":      "Hello :blobcat: :huge:",
+				"to":           activitystreams.Public,
+				"tag": []map[string]any{
+					{"type": "Emoji", "id": bob.URL + "/emojis/1", "name": ":blobcat:",
+						"icon": map[string]any{"type": "Image", "mediaType": "image/png", "url": bob.URL + "/emoji/blobcat.png"}},
+					{"type": "Emoji", "name": ":huge:",
+						"icon": map[string]any{"type": "Image", "url": bob.URL + "/emoji/huge.png"}},
+					{"type": "Emoji", "name": ":no icon:"},
+				},
+			},
+		})
+		assert.Equal(t, 202, w.Code, w.Body.String())
+		assert.NoError(t, jobs.Drain(ctx))
+
+		ce := emoji("blobcat")
+		assert.NotNil(t, ce)
+		if ce == nil {
+			return
+		}
+		assert.Equal(t, host(bob.IRI), ce.Domain)
+		assert.Equal(t, bob.URL+"/emojis/1", ce.Uri)
+		assert.Equal(t, "image/png", ce.MimeType)
+		assert.True(t, ce.IsCached())
+		data, err := os.ReadFile(filepath.Join(ts.MediaRoot(), ce.Path))
+		assert.NoError(t, err)
+		assert.Equal(t, buf.Bytes(), data)
+
+		// too large to cache, so never shown
+		huge := emoji("huge")
+		assert.NotNil(t, huge)
+		assert.False(t, huge.IsCached())
+		emojis, err := Emojis(ctx, ts.DB(), host(bob.IRI), "Hello :blobcat: :huge:")
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(emojis))
+
+		// unchanged emoji aren't fetched again
+		assert.NoError(t, SaveEmojis(ctx, ts, bob.IRI, []activitystreams.Tag{{
+			Type: activitystreams.TagEmoji, Name: ":blobcat:",
+			Icon: &activitystreams.Image{URL: bob.URL + "/emoji/blobcat.png"},
+		}}))
+		assert.Empty(t, jobs.Find("CacheEmoji"))
+	})
+
+	t.Run("Local", func(t *testing.T) {
+		_, err := ts.DB().Exec(`
+			insert into custom_emojis (Shortcode, Uri, Path, MimeType) values
+			('sparkle', 'https://localhost.dev/emojis/9', 'emoji/sparkle.png', 'image/png')`)
+		assert.NoError(t, err)
+		_, err = ts.DB().Exec(`
+			insert into toots (Sid, Uri, ActorId, AuthorId, Summary, Content, Text, Visibility)
+			values ('SPK1', 'https://localhost.dev/@admin/SPK1', 1, 1, '', '<p>So :sparkle:</p>', 'So :sparkle:', 0)`)
+		assert.NoError(t, err)
+		var toot model.Toot
+		assert.NoError(t, ts.DB().Get(&toot, "select * from toots where Sid = 'SPK1'"))
+
+		note, err := NoteFor(ctx, ts, &toot, admin)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(note.Tag))
+		tag := note.Tag[0]
+		assert.Equal(t, activitystreams.TagEmoji, tag.Type)
+		assert.Equal(t, ":sparkle:", tag.Name)
+		assert.Equal(t, "https://localhost.dev/emojis/9", tag.ID)
+		assert.Equal(t, "https://localhost.dev/media/emoji/sparkle.png", tag.Icon.URL)
+		assert.Equal(t, "image/png", tag.Icon.MediaType)
+	})
+}
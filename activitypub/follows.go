@@ -0,0 +1,388 @@
+package activitypub
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/url"
+	"strings"
+
+	"github.com/contribsys/sparq"
+	"github.com/contribsys/sparq/activitystreams"
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/util"
+	"github.com/contribsys/sparq/wellknown"
+	"github.com/pkg/errors"
+)
+
+const (
+	FollowPending  = "pending"
+	FollowAccepted = "accepted"
+)
+
+var (
+	// WebfingerResolver fetches WebFinger documents, nil uses
+	// the wellknown default.
+	WebfingerResolver func(string) ([]byte, error)
+)
+
+// ResolveHandle returns the actor IRI for a handle of the form
+// "nick", "@nick@localhost" or "@nick@remote.example".
+func ResolveHandle(ctx context.Context, svr sparq.Server, handle string) (string, error) {
+	handle = strings.TrimLeft(handle, "@")
+	nick, host, _ := strings.Cut(handle, "@")
+	if host == "" || host == svr.Hostname() {
+		var count int
+		err := svr.DB().QueryRowContext(ctx, "select count(*) from accounts where Nick = ?", nick).Scan(&count)
+		if err != nil {
+			return "", errors.Wrap(err, "accounts")
+		}
+		if count == 0 {
+			return "", errors.Wrap(sql.ErrNoRows, "No such account "+nick)
+		}
+		return model.LocalIRI(nick), nil
+	}
+	return wellknown.RemoteLookup(handle, WebfingerResolver)
+}
+
+// Following returns the follow relationship between the two actors
+// or nil if the follower does not follow the target.
+func Following(ctx context.Context, svr sparq.Server, follower, target string) (*model.ActorFollowing, error) {
+	var af model.ActorFollowing
+	err := svr.DB().GetContext(ctx, &af,
+		"select * from actor_following where ActorId = ? and TargetActorId = ?", follower, target)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "actor_following")
+	}
+	return &af, nil
+}
+
+// Follow starts following the target actor. Local accounts which
+// approve followers manually and all remote actors leave the
+// follow pending until they accept it.
+func Follow(ctx context.Context, svr sparq.Server, acct *model.Account, target string) (*model.ActorFollowing, error) {
+	if target == acct.IRI() {
+		return nil, errors.New("You can't follow yourself")
+	}
+	existing, err := Following(ctx, svr, acct.IRI(), target)
+	if err != nil || existing != nil {
+		return existing, err
+	}
+
+	af := &model.ActorFollowing{
+		Id:            acct.IRI() + "#follows/" + model.Snowflakes.NextSID(),
+		ActorId:       acct.IRI(),
+		TargetActorId: target,
+		State:         FollowPending,
+	}
+	var actor *model.Actor
+	if nick, ok := model.LocalNick(target); ok {
+		var vis model.AccountVisibility
+		err := svr.DB().QueryRowContext(ctx, "select Visibility from accounts where Nick = ?", nick).Scan(&vis)
+		if err != nil {
+			return nil, errors.Wrap(err, "account "+nick)
+		}
+		if vis == model.Public {
+			af.State = FollowAccepted
+		}
+		af.TargetActorAccount = nick + "@" + svr.Hostname()
+	} else {
+		actor, err = FetchActor(ctx, svr, target)
+		if err != nil {
+			return nil, err
+		}
+		af.TargetActorAccount = HandleFor(actor)
+	}
+
+	_, err = svr.DB().ExecContext(ctx, `
+		insert into actor_following (Id, ActorId, TargetActorId, TargetActorAccount, State)
+		values (?, ?, ?, ?, ?)`, af.Id, af.ActorId, af.TargetActorId, af.TargetActorAccount, af.State)
+	if err != nil {
+		return nil, errors.Wrap(err, "actor_following")
+	}
+	if actor != nil {
+		err = SendTo(ctx, svr, acct, followFor(af), actor.Id)
+		if err != nil {
+			return nil, err
+		}
+		return af, nil
+	}
+	err = notifyFollow(ctx, svr, af)
+	if err != nil {
+		return nil, err
+	}
+	return af, nil
+}
+
+// notifyFollow tells a local account about a new follower
+// or follow request.
+func notifyFollow(ctx context.Context, svr sparq.Server, af *model.ActorFollowing) error {
+	kind := NotifyFollow
+	if af.State != FollowAccepted {
+		kind = NotifyFollowRequest
+	}
+	return Notify(ctx, svr, kind, af.TargetActorId, af.ActorId, "")
+}
+
+// Unfollow stops following the target actor, or withdraws
+// a pending follow request.
+func Unfollow(ctx context.Context, svr sparq.Server, acct *model.Account, target string) error {
+	af, err := Following(ctx, svr, acct.IRI(), target)
+	if err != nil || af == nil {
+		return err
+	}
+	err = removeFollow(ctx, svr, af)
+	if err != nil {
+		return err
+	}
+	if _, ok := model.LocalNick(target); ok {
+		return nil
+	}
+	undo := activitystreams.NewUndoActivity(acct.IRI(), followFor(af))
+	undo.ID = af.Id + "/undo"
+	return SendTo(ctx, svr, acct, undo, target)
+}
+
+// AuthorizeFollower accepts a pending follow request for the account.
+func AuthorizeFollower(ctx context.Context, svr sparq.Server, acct *model.Account, follower string) error {
+	af, err := Following(ctx, svr, follower, acct.IRI())
+	if err != nil {
+		return err
+	}
+	if af == nil {
+		return errors.Wrap(sql.ErrNoRows, "No follow request from "+follower)
+	}
+	if af.State != FollowAccepted {
+		_, err = svr.DB().ExecContext(ctx, "update actor_following set State = ? where Id = ?", FollowAccepted, af.Id)
+		if err != nil {
+			return errors.Wrap(err, "actor_following")
+		}
+	}
+	if _, ok := model.LocalNick(follower); ok {
+		return nil
+	}
+	return sendReply(ctx, svr, acct, "Accept", af)
+}
+
+// RejectFollower declines a follow request or removes an
+// existing follower.
+func RejectFollower(ctx context.Context, svr sparq.Server, acct *model.Account, follower string) error {
+	af, err := Following(ctx, svr, follower, acct.IRI())
+	if err != nil {
+		return err
+	}
+	if af == nil {
+		return errors.Wrap(sql.ErrNoRows, "No follow request from "+follower)
+	}
+	err = removeFollow(ctx, svr, af)
+	if err != nil {
+		return err
+	}
+	if _, ok := model.LocalNick(follower); ok {
+		return nil
+	}
+	return sendReply(ctx, svr, acct, "Reject", af)
+}
+
+// SendTo queues delivery of the activity to the personal inbox
+// of a single remote actor.
+func SendTo(ctx context.Context, svr sparq.Server, acct *model.Account, activity any, iri string) error {
+	actor, err := FetchActor(ctx, svr, iri)
+	if err != nil {
+		return err
+	}
+	var obj activitystreams.Object
+	err = json.Unmarshal([]byte(actor.Properties), &obj)
+	if err != nil {
+		return errors.Wrap(err, "Invalid actor "+iri)
+	}
+	if obj.Inbox == "" {
+		return errors.New("No inbox for " + iri)
+	}
+	return Broadcast(ctx, svr, acct, activity, []string{obj.Inbox})
+}
+
+// HandleFor returns the "nick@host" handle for a remote actor.
+func HandleFor(actor *model.Actor) string {
+	var obj activitystreams.Object
+	_ = json.Unmarshal([]byte(actor.Properties), &obj)
+	u, err := url.Parse(actor.Id)
+	if err != nil || obj.PreferredUsername == "" {
+		return actor.Id
+	}
+	return obj.PreferredUsername + "@" + u.Host
+}
+
+func followFor(af *model.ActorFollowing) *activitystreams.FollowActivity {
+	follow := activitystreams.NewFollowActivity(af.ActorId, af.TargetActorId)
+	follow.ID = af.Id
+	return follow
+}
+
+func sendReply(ctx context.Context, svr sparq.Server, acct *model.Account, replyType string, af *model.ActorFollowing) error {
+	follow := followFor(af)
+	follow.Context = nil
+	var reply *activitystreams.WrappedActivity
+	if replyType == "Accept" {
+		reply = activitystreams.NewAcceptActivity(acct.IRI(), follow)
+	} else {
+		reply = activitystreams.NewRejectActivity(acct.IRI(), follow)
+	}
+	reply.ID = acct.IRI() + "#" + strings.ToLower(replyType) + "s/" + model.Snowflakes.NextSID()
+	return SendTo(ctx, svr, acct, reply, af.ActorId)
+}
+
+// A remote actor wants to follow one of our accounts.
+func handleFollow(ctx context.Context, svr sparq.Server, recipient string, in *Inbound) error {
+	nick, ok := model.LocalNick(in.ObjectId())
+	if !ok {
+		util.Debugf("Ignoring follow of %s", in.ObjectId())
+		return nil
+	}
+	var acct model.Account
+	err := svr.DB().GetContext(ctx, &acct, "select * from accounts where Nick = ?", nick)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			util.Debugf("Ignoring follow of unknown account %s", nick)
+			return nil
+		}
+		return errors.Wrap(err, "accounts")
+	}
+
+	rejected, err := Rejects(ctx, svr, acct.IRI(), in.Actor)
+	if err != nil {
+		return err
+	}
+	if rejected {
+		util.Infof("Refusing follow of %s by blocked %s", nick, in.Actor)
+		return sendReply(ctx, svr, &acct, "Reject", &model.ActorFollowing{Id: in.Id, ActorId: in.Actor, TargetActorId: acct.IRI()})
+	}
+
+	af, err := Following(ctx, svr, in.Actor, acct.IRI())
+	if err != nil {
+		return err
+	}
+	if af == nil {
+		actor, err := FetchActor(ctx, svr, in.Actor)
+		if err != nil {
+			return err
+		}
+		af = &model.ActorFollowing{
+			Id:                 in.Id,
+			ActorId:            in.Actor,
+			TargetActorId:      acct.IRI(),
+			TargetActorAccount: nick + "@" + svr.Hostname(),
+			State:              FollowPending,
+		}
+		if acct.Visibility == model.Public {
+			af.State = FollowAccepted
+		}
+		_, err = svr.DB().ExecContext(ctx, `
+			insert into actor_following (Id, ActorId, TargetActorId, TargetActorAccount, State)
+			values (?, ?, ?, ?, ?)`, af.Id, af.ActorId, af.TargetActorId, af.TargetActorAccount, af.State)
+		if err != nil {
+			return errors.Wrap(err, "actor_following")
+		}
+		util.Infof("%s wants to follow %s: %s", HandleFor(actor), nick, af.State)
+		err = notifyFollow(ctx, svr, af)
+		if err != nil {
+			return err
+		}
+	}
+	if af.State != FollowAccepted {
+		// waiting for the account to approve the request
+		return nil
+	}
+	// the remote reply must reference their Follow, which
+	// may be a repeat of an earlier request
+	af.Id = in.Id
+	return sendReply(ctx, svr, &acct, "Accept", af)
+}
+
+// A remote actor has accepted or rejected our follow request.
+func handleFollowReply(ctx context.Context, svr sparq.Server, recipient string, in *Inbound) error {
+	af, err := followForReply(ctx, svr, in)
+	if err != nil || af == nil {
+		return err
+	}
+	if in.Type != "Accept" {
+		return removeFollow(ctx, svr, af)
+	}
+	_, err = svr.DB().ExecContext(ctx, "update actor_following set State = ? where Id = ?", FollowAccepted, af.Id)
+	return errors.Wrap(err, "actor_following")
+}
+
+// removeFollow deletes the follow along with the target's membership
+// in the follower's lists, since lists only contain followed actors.
+func removeFollow(ctx context.Context, svr sparq.Server, af *model.ActorFollowing) error {
+	_, err := svr.DB().ExecContext(ctx, "delete from actor_following where Id = ?", af.Id)
+	if err != nil {
+		return errors.Wrap(err, "actor_following")
+	}
+	_, err = svr.DB().ExecContext(ctx, `
+		delete from list_accounts where ActorId = ? and ListId in (
+			select l.Id from lists l join accounts a on l.AccountId = a.Id
+			where ? || a.Nick = ?)`, af.TargetActorId, model.LocalIRI(""), af.ActorId)
+	return errors.Wrap(err, "list_accounts")
+}
+
+// followForReply finds the follow which an Accept or Reject refers to.
+// Only the followed actor may reply.
+func followForReply(ctx context.Context, svr sparq.Server, in *Inbound) (*model.ActorFollowing, error) {
+	var af model.ActorFollowing
+	err := svr.DB().GetContext(ctx, &af, "select * from actor_following where Id = ?", in.ObjectId())
+	if errors.Is(err, sql.ErrNoRows) {
+		// some servers don't echo our Follow ID back
+		follow, perr := in.Embedded()
+		if perr != nil || follow.Type != "Follow" {
+			util.Debugf("Ignoring %s of unknown object %s", in.Type, in.ObjectId())
+			return nil, nil
+		}
+		err = svr.DB().GetContext(ctx, &af, "select * from actor_following where ActorId = ? and TargetActorId = ?",
+			follow.Actor, follow.ObjectId())
+	}
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			util.Debugf("Ignoring %s of unknown follow %s", in.Type, in.ObjectId())
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "actor_following")
+	}
+	if af.TargetActorId != in.Actor {
+		util.Warnf("%s cannot %s follow %s", in.Actor, in.Type, af.Id)
+		return nil, nil
+	}
+	return &af, nil
+}
+
+// Undo reverses an earlier activity by the same actor.
+func handleUndo(ctx context.Context, svr sparq.Server, recipient string, in *Inbound) error {
+	inner, err := in.Embedded()
+	if err != nil {
+		util.Debugf("Ignoring Undo of %s: %v", in.ObjectId(), err)
+		return nil
+	}
+	if inner.Actor != in.Actor {
+		util.Warnf("%s cannot undo activity by %s", in.Actor, inner.Actor)
+		return nil
+	}
+	switch inner.Type {
+	case "Follow":
+		_, err = svr.DB().ExecContext(ctx, "delete from actor_following where ActorId = ? and TargetActorId = ?",
+			in.Actor, inner.ObjectId())
+		return errors.Wrap(err, "actor_following")
+	case "Like":
+		return undoLike(ctx, svr, in.Actor, inner)
+	case "Announce":
+		return undoAnnounce(ctx, svr, in.Actor, inner)
+	case "Block":
+		_, err = svr.DB().ExecContext(ctx, "delete from actor_blocks where ActorId = ? and TargetActorId = ?",
+			in.Actor, inner.ObjectId())
+		return errors.Wrap(err, "actor_blocks")
+	}
+	util.Debugf("Ignoring Undo of %s", inner.Type)
+	return nil
+}
@@ -0,0 +1,221 @@
+package activitypub
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/contribsys/sparq"
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/web"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFollows(t *testing.T) {
+	ts, stopper := web.NewTestServer(t, "follows")
+	defer stopper()
+	jobs := ts.Jobs().(*web.TestJobs)
+	Register(ts)
+	ctx := context.Background()
+
+	root := mux.NewRouter()
+	root.HandleFunc("/users/{nick:[a-z0-9]{4,20}}/inbox", InboxHandler(ts))
+	bob := newRemoteActor(t)
+
+	admin, err := localAccount(ctx, ts, 1)
+	assert.NoError(t, err)
+
+	// the last activity bob received
+	received := func() map[string]any {
+		assert.NotEmpty(t, bob.Inbox)
+		if len(bob.Inbox) == 0 {
+			return nil
+		}
+		req := bob.Inbox[len(bob.Inbox)-1]
+		assert.Equal(t, "/users/bob/inbox", req.URL.Path)
+		activity := map[string]any{}
+		assert.NoError(t, json.NewDecoder(req.Body).Decode(&activity))
+		return activity
+	}
+
+	t.Run("Inbound", func(t *testing.T) {
+		follow := map[string]any{
+			"id":     bob.IRI + "#follows/1",
+			"type":   "Follow",
+			"actor":  bob.IRI,
+			"object": admin.IRI(),
+		}
+		w := bob.post(t, root, "/users/admin/inbox", follow)
+		assert.Equal(t, 202, w.Code, w.Body.String())
+		assert.NoError(t, jobs.Drain(ctx))
+
+		af, err := Following(ctx, ts, bob.IRI, admin.IRI())
+		assert.NoError(t, err)
+		assert.NotNil(t, af)
+		assert.Equal(t, FollowAccepted, af.State)
+		assert.Equal(t, "admin@localhost.dev", af.TargetActorAccount)
+		assert.Equal(t, 1, notifications(t, ts, NotifyFollow))
+
+		accept := received()
+		assert.Equal(t, "Accept", accept["type"])
+		assert.Equal(t, admin.IRI(), accept["actor"])
+		assert.Equal(t, follow["id"], accept["object"].(map[string]any)["id"])
+
+		undo := map[string]any{
+			"id":     bob.IRI + "#follows/1/undo",
+			"type":   "Undo",
+			"actor":  bob.IRI,
+			"object": follow,
+		}
+		w = bob.post(t, root, "/users/admin/inbox", undo)
+		assert.Equal(t, 202, w.Code, w.Body.String())
+		assert.NoError(t, jobs.Drain(ctx))
+
+		af, err = Following(ctx, ts, bob.IRI, admin.IRI())
+		assert.NoError(t, err)
+		assert.Nil(t, af)
+	})
+
+	t.Run("ManualApproval", func(t *testing.T) {
+		_, err := ts.DB().Exec("update accounts set Visibility = ? where Id = 1", model.Protected)
+		assert.NoError(t, err)
+		defer func() {
+			_, _ = ts.DB().Exec("update accounts set Visibility = ? where Id = 1", model.Public)
+		}()
+		admin.Visibility = model.Protected
+
+		count := len(bob.Inbox)
+		follow := map[string]any{
+			"id":     bob.IRI + "#follows/2",
+			"type":   "Follow",
+			"actor":  bob.IRI,
+			"object": admin.IRI(),
+		}
+		w := bob.post(t, root, "/users/admin/inbox", follow)
+		assert.Equal(t, 202, w.Code, w.Body.String())
+		assert.NoError(t, jobs.Drain(ctx))
+
+		af, err := Following(ctx, ts, bob.IRI, admin.IRI())
+		assert.NoError(t, err)
+		assert.Equal(t, FollowPending, af.State)
+		assert.Equal(t, count, len(bob.Inbox))
+		assert.Equal(t, 1, notifications(t, ts, NotifyFollowRequest))
+
+		assert.NoError(t, AuthorizeFollower(ctx, ts, admin, bob.IRI))
+		assert.NoError(t, jobs.Drain(ctx))
+		af, err = Following(ctx, ts, bob.IRI, admin.IRI())
+		assert.NoError(t, err)
+		assert.Equal(t, FollowAccepted, af.State)
+		accept := received()
+		assert.Equal(t, "Accept", accept["type"])
+		assert.Equal(t, follow["id"], accept["object"].(map[string]any)["id"])
+
+		assert.NoError(t, RejectFollower(ctx, ts, admin, bob.IRI))
+		assert.NoError(t, jobs.Drain(ctx))
+		af, err = Following(ctx, ts, bob.IRI, admin.IRI())
+		assert.NoError(t, err)
+		assert.Nil(t, af)
+		assert.Equal(t, "Reject", received()["type"])
+	})
+
+	t.Run("Outbound", func(t *testing.T) {
+		af, err := Follow(ctx, ts, admin, bob.IRI)
+		assert.NoError(t, err)
+		assert.Equal(t, FollowPending, af.State)
+		assert.Contains(t, af.TargetActorAccount, "bob@127.0.0.1")
+		assert.NoError(t, jobs.Drain(ctx))
+
+		follow := received()
+		assert.Equal(t, "Follow", follow["type"])
+		assert.Equal(t, af.Id, follow["id"])
+		assert.Equal(t, bob.IRI, follow["object"])
+
+		// only bob can accept the follow
+		eve := newRemoteActor(t)
+		accept := map[string]any{
+			"id":     eve.IRI + "#accepts/1",
+			"type":   "Accept",
+			"actor":  eve.IRI,
+			"object": follow,
+		}
+		w := eve.post(t, root, "/users/admin/inbox", accept)
+		assert.Equal(t, 202, w.Code, w.Body.String())
+		assert.NoError(t, jobs.Drain(ctx))
+		af, _ = Following(ctx, ts, admin.IRI(), bob.IRI)
+		assert.Equal(t, FollowPending, af.State)
+
+		accept["id"] = bob.IRI + "#accepts/1"
+		accept["actor"] = bob.IRI
+		w = bob.post(t, root, "/users/admin/inbox", accept)
+		assert.Equal(t, 202, w.Code, w.Body.String())
+		assert.NoError(t, jobs.Drain(ctx))
+		af, _ = Following(ctx, ts, admin.IRI(), bob.IRI)
+		assert.Equal(t, FollowAccepted, af.State)
+
+		assert.NoError(t, Unfollow(ctx, ts, admin, bob.IRI))
+		assert.NoError(t, jobs.Drain(ctx))
+		af, _ = Following(ctx, ts, admin.IRI(), bob.IRI)
+		assert.Nil(t, af)
+		undo := received()
+		assert.Equal(t, "Undo", undo["type"])
+		assert.Equal(t, follow["id"], undo["object"].(map[string]any)["id"])
+	})
+
+	t.Run("Rejected", func(t *testing.T) {
+		af, err := Follow(ctx, ts, admin, bob.IRI)
+		assert.NoError(t, err)
+		assert.NoError(t, jobs.Drain(ctx))
+
+		reject := map[string]any{
+			"id":     bob.IRI + "#rejects/1",
+			"type":   "Reject",
+			"actor":  bob.IRI,
+			"object": af.Id,
+		}
+		w := bob.post(t, root, "/users/admin/inbox", reject)
+		assert.Equal(t, 202, w.Code, w.Body.String())
+		assert.NoError(t, jobs.Drain(ctx))
+		af, _ = Following(ctx, ts, admin.IRI(), bob.IRI)
+		assert.Nil(t, af)
+	})
+
+	t.Run("Local", func(t *testing.T) {
+		_, err := Follow(ctx, ts, admin, admin.IRI())
+		assert.Error(t, err)
+
+		iri, err := ResolveHandle(ctx, ts, "@admin@localhost.dev")
+		assert.NoError(t, err)
+		assert.Equal(t, admin.IRI(), iri)
+		_, err = ResolveHandle(ctx, ts, "nosuch")
+		assert.Error(t, err)
+
+		WebfingerResolver = func(string) ([]byte, error) {
+			return json.Marshal(map[string]any{
+				"subject": "acct:bob@remote.example",
+				"links":   []map[string]string{{"rel": "self", "type": ActivityJson, "href": bob.IRI}},
+			})
+		}
+		defer func() { WebfingerResolver = nil }()
+		iri, err = ResolveHandle(ctx, ts, "@bob@remote.example")
+		assert.NoError(t, err)
+		assert.Equal(t, bob.IRI, iri)
+
+		// a mention never links to anything but a web page
+		_, err = FetchActor(ctx, ts, bob.IRI)
+		assert.NoError(t, err)
+		_, err = ts.DB().Exec("update actors set Properties = json_set(Properties, '$.url', 'javascript:alert(1)') where Id = ?", bob.IRI)
+		assert.NoError(t, err)
+		html, iris := FormatToot(ctx, ts, "Hi @bob@remote.example")
+		assert.Equal(t, []string{bob.IRI}, iris)
+		assert.Contains(t, html, `href="`+bob.IRI+`"`)
+		assert.NotContains(t, html, "javascript")
+	})
+}
+
+// the number of notifications of the given type
+func notifications(t *testing.T, ts sparq.Server, kind string) int {
+	var count int
+	assert.NoError(t, ts.DB().Get(&count, "select count(*) from actor_notifications where Type = ?", kind))
+	return count
+}
@@ -0,0 +1,212 @@
+package activitypub
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"net/http"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/sparq"
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/util"
+	"github.com/contribsys/sparq/web"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+const (
+	maxActivitySize = 1 << 20
+)
+
+// ActivityHandler processes an inbound activity of a given type.
+// The recipient is the IRI of the inbox which received the activity.
+type ActivityHandler func(ctx context.Context, svr sparq.Server, recipient string, in *Inbound) error
+
+var (
+	activityHandlers = map[string]ActivityHandler{}
+
+	// The activities a local account won't receive from actors it
+	// blocks. Others only tidy up after the actor, or are refused
+	// by their handler as a Follow is.
+	blockedTypes = map[string]bool{
+		"Create":   true,
+		"Announce": true,
+		"Like":     true,
+	}
+)
+
+// Handle registers the handler for the given activity type.
+func Handle(activityType string, fn ActivityHandler) {
+	activityHandlers[activityType] = fn
+}
+
+func SharedInbox(svr sparq.Server) string {
+	return "https://" + svr.Hostname() + "/inbox"
+}
+
+// POST /users/{nick}/inbox
+// POST /inbox
+func InboxHandler(svr sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			httpError(w, errors.New("POST only"), http.StatusMethodNotAllowed)
+			return
+		}
+
+		recipient := SharedInbox(svr)
+		if nick := mux.Vars(r)["nick"]; nick != "" {
+			var count int
+			err := svr.DB().QueryRowContext(r.Context(), "select count(*) from accounts where nick = ?", nick).Scan(&count)
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+			if count == 0 {
+				httpError(w, errors.New("No such inbox"), http.StatusNotFound)
+				return
+			}
+			recipient = model.LocalIRI(nick)
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxActivitySize))
+		if err != nil {
+			httpError(w, err, http.StatusBadRequest)
+			return
+		}
+		in, err := ParseInbound(body)
+		if err != nil {
+			httpError(w, err, http.StatusBadRequest)
+			return
+		}
+
+		sig, err := ParseSignature(r.Header.Get("Signature"))
+		if err != nil {
+			httpError(w, err, http.StatusUnauthorized)
+			return
+		}
+		if sig.Owner() != in.Actor {
+			httpError(w, errors.New("Signature does not belong to actor"), http.StatusUnauthorized)
+			return
+		}
+		if host(in.Id) != host(in.Actor) {
+			// an actor may only name activities on its own server
+			httpError(w, errors.New("Activity does not belong to actor"), http.StatusUnauthorized)
+			return
+		}
+		_, err = VerifyActor(r.Context(), svr, r, sig, body)
+		if err != nil {
+			if errors.Is(err, ErrGone) && in.Type == "Delete" {
+				// Deleted accounts broadcast their Delete to every server
+				// they know about, we can't verify them and have nothing to do.
+				w.WriteHeader(http.StatusAccepted)
+				return
+			}
+			httpError(w, err, http.StatusUnauthorized)
+			return
+		}
+
+		fresh, err := saveInbound(r.Context(), svr, recipient, in)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		if fresh {
+			job := client.NewJob("ProcessInbox", in.Id, recipient)
+			err = svr.Jobs().Push(r.Context(), job)
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// saveInbound persists the raw activity and records its delivery
+// to the recipient. Returns false if the activity was already
+// delivered to this recipient or another activity has its id.
+func saveInbound(ctx context.Context, svr sparq.Server, recipient string, in *Inbound) (bool, error) {
+	tx, err := svr.DB().BeginTxx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	res, err := tx.ExecContext(ctx, `
+		insert into objects (Id, MastodonId, Type, OriginalActorId, OriginalObjectId, Properties, Local)
+		values (?, ?, ?, ?, ?, ?, 0) on conflict do nothing`,
+		in.Id, model.Snowflakes.NextSID(), in.Type, in.Actor, in.Id, string(in.Raw))
+	if err != nil {
+		return false, errors.Wrap(err, "objects")
+	}
+	if count, _ := res.RowsAffected(); count == 0 {
+		// we process what's stored so it had better be what was
+		// just verified, e.g. the same activity sent to another inbox
+		var props string
+		err = tx.GetContext(ctx, &props, "select Properties from objects where Id = ?", in.Id)
+		if err != nil {
+			return false, errors.Wrap(err, "objects")
+		}
+		if props != string(in.Raw) {
+			util.Warnf("Ignoring %s which reuses the id %s", in.Type, in.Id)
+			return false, nil
+		}
+	}
+
+	var count int
+	err = tx.QueryRowContext(ctx, "select count(*) from inbox_objects where ActorId = ? and ObjectId = ?",
+		recipient, in.Id).Scan(&count)
+	if err != nil {
+		return false, errors.Wrap(err, "inbox_objects")
+	}
+	if count > 0 {
+		return false, nil
+	}
+	_, err = tx.ExecContext(ctx, `insert into inbox_objects (Id, ActorId, ObjectId) values (?, ?, ?)`,
+		model.Snowflakes.NextSID(), recipient, in.Id)
+	if err != nil {
+		return false, errors.Wrap(err, "inbox_objects")
+	}
+	return true, tx.Commit()
+}
+
+// ProcessInbox is the job which acts on an inbound activity
+// after it has been verified and persisted.
+func ProcessInbox(ctx context.Context, svr sparq.Server, objectId, recipient string) error {
+	var props string
+	err := svr.DB().QueryRowContext(ctx, "select Properties from objects where Id = ?", objectId).Scan(&props)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			util.Warnf("Inbox object %s not found", objectId)
+			return nil
+		}
+		return errors.Wrap(err, "objects")
+	}
+	in, err := ParseInbound([]byte(props))
+	if err != nil {
+		return err
+	}
+
+	fn, ok := activityHandlers[in.Type]
+	if !ok {
+		util.Debugf("Ignoring %s activity %s", in.Type, in.Id)
+		return nil
+	}
+	if blockedTypes[in.Type] {
+		rejected, err := Rejects(ctx, svr, recipient, in.Actor)
+		if err != nil {
+			return err
+		}
+		if rejected {
+			util.Debugf("Dropping %s activity %s from blocked %s", in.Type, in.Id, in.Actor)
+			return nil
+		}
+	}
+	util.Debugf("Processing %s activity %s for %s", in.Type, in.Id, recipient)
+	return fn(ctx, svr, recipient, in)
+}
+
+func httpError(w http.ResponseWriter, err error, code int) {
+	web.HttpError(w, err, code)
+}
@@ -0,0 +1,239 @@
+package activitypub
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/contribsys/sparq/activitystreams"
+	"github.com/contribsys/sparq/util"
+	"github.com/contribsys/sparq/web"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+// remoteActor stands in for an actor on another instance
+type remoteActor struct {
+	*httptest.Server
+	IRI     string
+	Name    string
+	Public  []byte
+	Private []byte
+	Gone    bool
+	Inbox   []*http.Request
+	Fetches []*http.Request
+	// Objects served by path, e.g. the actor's notes
+	Objects map[string]any
+	// Other files served by path, e.g. images
+	Files map[string][]byte
+}
+
+func newRemoteActor(t *testing.T) *remoteActor {
+	pub, priv := util.GenerateKeys()
+	ra := &remoteActor{Name: "Bob Remote", Public: pub, Private: priv, Objects: map[string]any{}, Files: map[string][]byte{}}
+	ra.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" && r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.Method == "POST" && r.URL.Path == "/reject" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if r.Method == "POST" {
+			// keep a copy of the delivery so tests can verify it
+			body := new(bytes.Buffer)
+			_, _ = body.ReadFrom(r.Body)
+			req := r.Clone(context.Background())
+			req.Body = io.NopCloser(bytes.NewReader(body.Bytes()))
+			ra.Inbox = append(ra.Inbox, req)
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		if data, ok := ra.Files[r.URL.Path]; ok {
+			_, _ = w.Write(data)
+			return
+		}
+		if obj, ok := ra.Objects[r.URL.Path]; ok {
+			ra.Fetches = append(ra.Fetches, r.Clone(context.Background()))
+			w.Header().Set("Content-Type", ActivityJson)
+			_ = json.NewEncoder(w).Encode(obj)
+			return
+		}
+		if r.URL.Path != "/users/bob" {
+			http.NotFound(w, r)
+			return
+		}
+		ra.Fetches = append(ra.Fetches, r.Clone(context.Background()))
+		if ra.Gone {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+		me := activitystreams.NewPerson(ra.IRI)
+		me.PreferredUsername = "bob"
+		me.Name = ra.Name
+		me.AddPubKey(string(ra.Public))
+		me.Endpoints.SharedInbox = ra.URL + "/inbox"
+		w.Header().Set("Content-Type", ActivityJson)
+		_ = json.NewEncoder(w).Encode(me)
+	}))
+	ra.IRI = ra.URL + "/users/bob"
+	t.Cleanup(ra.Close)
+	return ra
+}
+
+func (ra *remoteActor) post(t *testing.T, handler http.Handler, path string, activity map[string]any) *httptest.ResponseRecorder {
+	body, err := json.Marshal(activity)
+	assert.NoError(t, err)
+	req := httptest.NewRequest("POST", "https://localhost.dev"+path, bytes.NewReader(body))
+	req.Header.Set("Content-Type", ActivityJson)
+	err = SignRequest(req, ra.IRI+"#main-key", ra.Private, body)
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestInbox(t *testing.T) {
+	ts, stopper := web.NewTestServer(t, "inbox")
+	defer stopper()
+	jobs := ts.Jobs().(*web.TestJobs)
+	Register(ts)
+
+	root := mux.NewRouter()
+	root.HandleFunc("/users/{nick:[a-z0-9]{4,20}}/inbox", InboxHandler(ts))
+	root.HandleFunc("/inbox", InboxHandler(ts))
+	bob := newRemoteActor(t)
+
+	follow := map[string]any{
+		"@context": activitystreams.Namespace,
+		"id":       bob.IRI + "#follows/1",
+		"type":     "Follow",
+		"actor":    bob.IRI,
+		"object":   "https://localhost.dev/users/admin",
+	}
+
+	t.Run("Signed", func(t *testing.T) {
+		w := bob.post(t, root, "/users/admin/inbox", follow)
+		assert.Equal(t, 202, w.Code, w.Body.String())
+
+		var ptype, props string
+		err := ts.DB().QueryRow("select Type, Properties from objects where Id = ?", follow["id"]).Scan(&ptype, &props)
+		assert.NoError(t, err)
+		assert.Equal(t, "Follow", ptype)
+		assert.Contains(t, props, "localhost.dev/users/admin")
+
+		var recipient string
+		err = ts.DB().QueryRow("select ActorId from inbox_objects where ObjectId = ?", follow["id"]).Scan(&recipient)
+		assert.NoError(t, err)
+		assert.Equal(t, "https://localhost.dev/users/admin", recipient)
+
+		var pubkey string
+		err = ts.DB().QueryRow("select PublicKey from actors where Id = ?", bob.IRI).Scan(&pubkey)
+		assert.NoError(t, err)
+		assert.Equal(t, string(bob.Public), pubkey)
+
+		pushed := jobs.Find("ProcessInbox")
+		assert.Equal(t, 1, len(pushed))
+		assert.EqualValues(t, []interface{}{follow["id"], recipient}, pushed[0].Args)
+
+		// redelivery is accepted but not processed again
+		w = bob.post(t, root, "/users/admin/inbox", follow)
+		assert.Equal(t, 202, w.Code)
+		assert.Equal(t, 1, len(jobs.Find("ProcessInbox")))
+
+		// as is another activity reusing its id
+		reused := map[string]any{"id": follow["id"], "type": "Undo", "actor": bob.IRI, "object": follow}
+		w = bob.post(t, root, "/inbox", reused)
+		assert.Equal(t, 202, w.Code)
+		assert.Equal(t, 1, len(jobs.Find("ProcessInbox")))
+
+		assert.NoError(t, jobs.Drain(context.Background()))
+	})
+
+	t.Run("SharedInbox", func(t *testing.T) {
+		note := map[string]any{
+			"id":     bob.IRI + "/statuses/1/activity",
+			"type":   "Create",
+			"actor":  bob.IRI,
+			"to":     activitystreams.Public,
+			"object": map[string]any{"id": bob.IRI + "/statuses/1", "type": "Note", "content": "Hi"},
+		}
+		w := bob.post(t, root, "/inbox", note)
+		assert.Equal(t, 202, w.Code, w.Body.String())
+
+		var recipient string
+		err := ts.DB().QueryRow("select ActorId from inbox_objects where ObjectId = ?", note["id"]).Scan(&recipient)
+		assert.NoError(t, err)
+		assert.Equal(t, "https://localhost.dev/inbox", recipient)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		// unknown inbox
+		w := bob.post(t, root, "/users/nosuch/inbox", follow)
+		assert.Equal(t, 404, w.Code)
+
+		// unsigned
+		body, _ := json.Marshal(follow)
+		req := httptest.NewRequest("POST", "https://localhost.dev/inbox", bytes.NewReader(body))
+		w = httptest.NewRecorder()
+		root.ServeHTTP(w, req)
+		assert.Equal(t, 401, w.Code)
+
+		// body altered after signing
+		req = httptest.NewRequest("POST", "https://localhost.dev/inbox", bytes.NewReader(body))
+		assert.NoError(t, SignRequest(req, bob.IRI+"#main-key", bob.Private, []byte(`{"type":"Delete"}`)))
+		w = httptest.NewRecorder()
+		root.ServeHTTP(w, req)
+		assert.Equal(t, 401, w.Code)
+		assert.Contains(t, w.Body.String(), "Digest")
+
+		// signed by a different key
+		_, other := util.GenerateKeys()
+		req = httptest.NewRequest("POST", "https://localhost.dev/inbox", bytes.NewReader(body))
+		assert.NoError(t, SignRequest(req, bob.IRI+"#main-key", other, body))
+		w = httptest.NewRecorder()
+		root.ServeHTTP(w, req)
+		assert.Equal(t, 401, w.Code)
+		assert.Contains(t, w.Body.String(), "Invalid signature")
+
+		// an id on another server
+		w = bob.post(t, root, "/inbox", map[string]any{"id": "https://elsewhere.example/1", "type": "Follow",
+			"actor": bob.IRI, "object": "https://localhost.dev/users/admin"})
+		assert.Equal(t, 401, w.Code)
+		assert.Contains(t, w.Body.String(), "does not belong")
+
+		// actor spoofing
+		spoof := map[string]any{"id": "https://evil.example/1", "type": "Follow",
+			"actor": "https://evil.example/users/eve", "object": "https://localhost.dev/users/admin"}
+		w = bob.post(t, root, "/inbox", spoof)
+		assert.Equal(t, 401, w.Code)
+	})
+}
+
+func TestSignature(t *testing.T) {
+	sig, err := ParseSignature(`keyId="https://example.com/users/bob#main-key",algorithm="rsa-sha256",headers="(request-target) host date",signature="YWJj"`)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/users/bob", sig.Owner())
+	assert.Equal(t, []string{"(request-target)", "host", "date"}, sig.Headers)
+	assert.Equal(t, []byte("abc"), sig.Signature)
+
+	_, err = ParseSignature("")
+	assert.ErrorIs(t, err, ErrNoSignature)
+	_, err = ParseSignature(`keyId="foo"`)
+	assert.Error(t, err)
+
+	pub, priv := util.GenerateKeys()
+	req := httptest.NewRequest("GET", "https://example.com/users/bob", nil)
+	assert.NoError(t, SignRequest(req, "https://localhost.dev/users/admin#main-key", priv, nil))
+	sig, err = ParseSignature(req.Header.Get("Signature"))
+	assert.NoError(t, err)
+	assert.NoError(t, VerifyRequest(req, sig, pub, nil))
+
+	req.Header.Set("Date", "Mon, 02 Jan 2006 15:04:05 GMT")
+	assert.ErrorContains(t, VerifyRequest(req, sig, pub, nil), "window")
+}
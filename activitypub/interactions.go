@@ -0,0 +1,372 @@
+package activitypub
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/contribsys/sparq"
+	"github.com/contribsys/sparq/activitystreams"
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/util"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrCannotReblog = errors.New("This toot can't be reblogged")
+)
+
+// AuthorIRI returns the IRI of the toot's author, local or remote.
+func AuthorIRI(ctx context.Context, svr sparq.Server, toot *model.Toot) (string, error) {
+	if toot.AuthorId != nil {
+		var nick string
+		err := svr.DB().GetContext(ctx, &nick, "select Nick from accounts where Id = ?", *toot.AuthorId)
+		if err != nil {
+			return "", errors.Wrap(err, "accounts")
+		}
+		return model.LocalIRI(nick), nil
+	}
+	var iri string
+	err := svr.DB().GetContext(ctx, &iri, "select Id from actors where MastodonId = ?", toot.ActorId)
+	if err != nil {
+		return "", errors.Wrap(err, "actors")
+	}
+	return iri, nil
+}
+
+// tootByUri returns the toot with the given URI or nil if we
+// haven't seen it.
+func tootByUri(ctx context.Context, svr sparq.Server, uri string) (*model.Toot, error) {
+	var toot model.Toot
+	err := svr.DB().GetContext(ctx, &toot, "select * from toots where Uri = ? and DeletedAt is null", uri)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "toots")
+	}
+	return &toot, nil
+}
+
+// Favourite likes the toot on behalf of the account, telling
+// the author about it.
+func Favourite(ctx context.Context, svr sparq.Server, acct *model.Account, toot *model.Toot) error {
+	id := acct.IRI() + "#likes/" + model.Snowflakes.NextSID()
+	res, err := svr.DB().ExecContext(ctx, `
+		insert into actor_favorites (Id, ActorId, ObjectId) values (?, ?, ?)
+		on conflict do nothing`, id, acct.IRI(), toot.Uri)
+	if err != nil {
+		return errors.Wrap(err, "actor_favorites")
+	}
+	if count, _ := res.RowsAffected(); count == 0 {
+		// already a favourite
+		return nil
+	}
+	author, err := AuthorIRI(ctx, svr, toot)
+	if err != nil {
+		return err
+	}
+	if toot.AuthorId != nil {
+		return Notify(ctx, svr, NotifyFavourite, author, acct.IRI(), toot.Uri)
+	}
+	like := activitystreams.NewLikeActivity(acct.IRI(), toot.Uri)
+	like.ID = id
+	return SendTo(ctx, svr, acct, like, author)
+}
+
+// Unfavourite removes the account's like of the toot.
+func Unfavourite(ctx context.Context, svr sparq.Server, acct *model.Account, toot *model.Toot) error {
+	var fav model.ActorFavorite
+	err := svr.DB().GetContext(ctx, &fav,
+		"select * from actor_favorites where ActorId = ? and ObjectId = ?", acct.IRI(), toot.Uri)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return errors.Wrap(err, "actor_favorites")
+	}
+	_, err = svr.DB().ExecContext(ctx, "delete from actor_favorites where Id = ?", fav.Id)
+	if err != nil {
+		return errors.Wrap(err, "actor_favorites")
+	}
+	if toot.AuthorId != nil {
+		return nil
+	}
+	author, err := AuthorIRI(ctx, svr, toot)
+	if err != nil {
+		return err
+	}
+	like := activitystreams.NewLikeActivity(acct.IRI(), toot.Uri)
+	like.ID = fav.Id
+	like.Context = nil
+	undo := activitystreams.NewUndoActivity(acct.IRI(), like)
+	undo.ID = fav.Id + "/undo"
+	return SendTo(ctx, svr, acct, undo, author)
+}
+
+// Reblog boosts the toot to the account's followers, returning the
+// boost. Boosting a boost boosts the original toot.
+func Reblog(ctx context.Context, svr sparq.Server, acct *model.Account, toot *model.Toot) (*model.Toot, error) {
+	toot, err := original(ctx, svr, toot)
+	if err != nil {
+		return nil, err
+	}
+	own := toot.AuthorId != nil && *toot.AuthorId == uint64(acct.Id)
+	// direct toots are only for the mentioned accounts, even when our own
+	if toot.Visibility == model.VisDirect || (toot.Visibility != model.VisPublic && toot.Visibility != model.VisUnlisted && !own) {
+		return nil, ErrCannotReblog
+	}
+	boost, err := boostOf(ctx, svr, acct, toot)
+	if err != nil || boost != nil {
+		return boost, err
+	}
+
+	sid := model.Snowflakes.NextSID()
+	uri := fmt.Sprintf("https://%s/@%s/%s", svr.Hostname(), acct.Nick, sid)
+	tx, err := svr.DB().BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.ExecContext(ctx, `
+		insert into toots (Sid, Uri, ActorId, AuthorId, BoostOfId, Summary, Content, Lang, Visibility)
+		values (?, ?, ?, ?, ?, '', '', ?, ?)`, sid, uri, acct.Id, acct.Id, toot.Sid, toot.Lang, toot.Visibility)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, errors.Wrap(err, "toots")
+	}
+	_, err = tx.ExecContext(ctx, `
+		insert into actor_reblogs (Id, ActorId, ObjectId) values (?, ?, ?)`, uri+"/activity", acct.IRI(), toot.Uri)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, errors.Wrap(err, "actor_reblogs")
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	author, err := AuthorIRI(ctx, svr, toot)
+	if err != nil {
+		return nil, err
+	}
+	err = Notify(ctx, svr, NotifyReblog, author, acct.IRI(), toot.Uri)
+	if err != nil {
+		return nil, err
+	}
+	announce := announceFor(acct, toot, uri+"/activity", author)
+	err = broadcastReblog(ctx, svr, acct, announce, author)
+	if err != nil {
+		return nil, err
+	}
+	boost, err = boostOf(ctx, svr, acct, toot)
+	if err != nil {
+		return nil, err
+	}
+	Published(ctx, svr, boost)
+	return boost, nil
+}
+
+// Unreblog removes the account's boost of the toot.
+func Unreblog(ctx context.Context, svr sparq.Server, acct *model.Account, toot *model.Toot) error {
+	toot, err := original(ctx, svr, toot)
+	if err != nil {
+		return err
+	}
+	boost, err := boostOf(ctx, svr, acct, toot)
+	if err != nil || boost == nil {
+		return err
+	}
+	_, err = svr.DB().ExecContext(ctx, "delete from toots where Sid = ?", boost.Sid)
+	if err != nil {
+		return errors.Wrap(err, "toots")
+	}
+	_, err = svr.DB().ExecContext(ctx, "delete from actor_reblogs where ActorId = ? and ObjectId = ?",
+		acct.IRI(), toot.Uri)
+	if err != nil {
+		return errors.Wrap(err, "actor_reblogs")
+	}
+
+	author, err := AuthorIRI(ctx, svr, toot)
+	if err != nil {
+		return err
+	}
+	announce := announceFor(acct, toot, boost.Uri+"/activity", author)
+	announce.Context = nil
+	undo := activitystreams.NewUndoActivity(acct.IRI(), announce)
+	undo.ID = announce.ID + "/undo"
+	undo.To = announce.To
+	return broadcastReblog(ctx, svr, acct, undo, author)
+}
+
+// original returns the boosted toot if the toot is a boost.
+func original(ctx context.Context, svr sparq.Server, toot *model.Toot) (*model.Toot, error) {
+	if toot.BoostOfId == nil {
+		return toot, nil
+	}
+	var orig model.Toot
+	err := svr.DB().GetContext(ctx, &orig, "select * from toots where Sid = ?", *toot.BoostOfId)
+	if err != nil {
+		return nil, errors.Wrap(err, "toot "+*toot.BoostOfId)
+	}
+	return &orig, nil
+}
+
+// boostOf returns the account's boost of the toot or nil.
+func boostOf(ctx context.Context, svr sparq.Server, acct *model.Account, toot *model.Toot) (*model.Toot, error) {
+	var boost model.Toot
+	err := svr.DB().GetContext(ctx, &boost,
+		"select * from toots where AuthorId = ? and BoostOfId = ?", acct.Id, toot.Sid)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "toots")
+	}
+	return &boost, nil
+}
+
+func announceFor(acct *model.Account, toot *model.Toot, id, author string) *activitystreams.ReferenceActivity {
+	announce := activitystreams.NewAnnounceActivity(acct.IRI(), toot.Uri)
+	announce.ID = id
+	announce.To, announce.CC = Addressing(acct, toot.Visibility)
+	announce.CC = append(announce.CC, author)
+	return announce
+}
+
+// broadcastReblog sends the activity to the account's followers
+// and the author of the boosted toot.
+func broadcastReblog(ctx context.Context, svr sparq.Server, acct *model.Account, activity any, author string) error {
+	inboxes, err := FollowerInboxes(ctx, svr, acct.IRI())
+	if err != nil {
+		return err
+	}
+	if _, ok := model.LocalNick(author); !ok {
+		actor, err := FetchActor(ctx, svr, author)
+		if err != nil {
+			return err
+		}
+		inbox := inboxFor(actor.Properties)
+		found := false
+		for _, existing := range inboxes {
+			found = found || existing == inbox
+		}
+		if inbox != "" && !found {
+			inboxes = append(inboxes, inbox)
+		}
+	}
+	return Broadcast(ctx, svr, acct, activity, inboxes)
+}
+
+// A remote actor liked one of our toots.
+func handleLike(ctx context.Context, svr sparq.Server, recipient string, in *Inbound) error {
+	toot, err := tootByUri(ctx, svr, in.ObjectId())
+	if err != nil || toot == nil {
+		return err
+	}
+	res, err := svr.DB().ExecContext(ctx, `
+		insert into actor_favorites (Id, ActorId, ObjectId) values (?, ?, ?)
+		on conflict do nothing`, in.Id, in.Actor, toot.Uri)
+	if err != nil {
+		return errors.Wrap(err, "actor_favorites")
+	}
+	if count, _ := res.RowsAffected(); count == 0 || toot.AuthorId == nil {
+		return nil
+	}
+	author, err := AuthorIRI(ctx, svr, toot)
+	if err != nil {
+		return err
+	}
+	return Notify(ctx, svr, NotifyFavourite, author, in.Actor, toot.Uri)
+}
+
+// A remote actor boosted a toot. If one of our accounts follows
+// the actor, the boost appears in their home timeline.
+func handleAnnounce(ctx context.Context, svr sparq.Server, recipient string, in *Inbound) error {
+	toot, err := tootByUri(ctx, svr, in.ObjectId())
+	if err != nil {
+		return err
+	}
+	if toot == nil {
+		util.Debugf("Ignoring Announce of unknown toot %s", in.ObjectId())
+		return nil
+	}
+	// as with our own boosts, only public toots may be boosted
+	if toot.Visibility != model.VisPublic && toot.Visibility != model.VisUnlisted {
+		util.Debugf("Ignoring Announce of %s toot %s", model.FromVis(toot.Visibility), toot.Uri)
+		return nil
+	}
+	res, err := svr.DB().ExecContext(ctx, `
+		insert into actor_reblogs (Id, ActorId, ObjectId) values (?, ?, ?)
+		on conflict do nothing`, in.Id, in.Actor, toot.Uri)
+	if err != nil {
+		return errors.Wrap(err, "actor_reblogs")
+	}
+	if count, _ := res.RowsAffected(); count == 0 {
+		return nil
+	}
+	if toot.AuthorId != nil {
+		author, err := AuthorIRI(ctx, svr, toot)
+		if err != nil {
+			return err
+		}
+		err = Notify(ctx, svr, NotifyReblog, author, in.Actor, toot.Uri)
+		if err != nil {
+			return err
+		}
+	}
+
+	var count int
+	err = svr.DB().GetContext(ctx, &count, `
+		select count(*) from actor_following where TargetActorId = ? and State = ?`, in.Actor, FollowAccepted)
+	if err != nil {
+		return errors.Wrap(err, "actor_following")
+	}
+	if count == 0 {
+		return nil
+	}
+	actor, err := FetchActor(ctx, svr, in.Actor)
+	if err != nil {
+		return err
+	}
+	addressing := RemoteNote{To: in.To, CC: in.CC}
+	res, err = svr.DB().ExecContext(ctx, `
+		insert into toots (Sid, Uri, ActorId, BoostOfId, Summary, Content, Lang, Visibility, CreatedAt)
+		values (?, ?, ?, ?, '', '', ?, ?, ?)
+		on conflict do nothing`, model.Snowflakes.NextSID(), in.Id, actor.MastodonId, toot.Sid, toot.Lang,
+		addressing.Visibility(actor), time.Now().UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return errors.Wrap(err, "toots")
+	}
+	if count, _ := res.RowsAffected(); count == 0 {
+		return nil
+	}
+	var boost model.Toot
+	err = svr.DB().GetContext(ctx, &boost, "select * from toots where Uri = ?", in.Id)
+	if err != nil {
+		return errors.Wrap(err, "toots")
+	}
+	Published(ctx, svr, &boost)
+	return nil
+}
+
+// undoLike removes the remote actor's like.
+func undoLike(ctx context.Context, svr sparq.Server, actor string, like *Inbound) error {
+	_, err := svr.DB().ExecContext(ctx, `
+		delete from actor_favorites where ActorId = ? and (Id = ? or ObjectId = ?)`,
+		actor, like.Id, like.ObjectId())
+	return errors.Wrap(err, "actor_favorites")
+}
+
+// undoAnnounce removes the remote actor's boost.
+func undoAnnounce(ctx context.Context, svr sparq.Server, actor string, announce *Inbound) error {
+	_, err := svr.DB().ExecContext(ctx, `
+		delete from actor_reblogs where ActorId = ? and (Id = ? or ObjectId = ?)`,
+		actor, announce.Id, announce.ObjectId())
+	if err != nil {
+		return errors.Wrap(err, "actor_reblogs")
+	}
+	_, err = svr.DB().ExecContext(ctx, `
+		delete from toots where Uri = ? and AuthorId is null and BoostOfId is not null`, announce.Id)
+	return errors.Wrap(err, "toots")
+}
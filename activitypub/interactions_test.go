@@ -0,0 +1,103 @@
+package activitypub
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/contribsys/sparq/activitystreams"
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/web"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInteractions(t *testing.T) {
+	ts, stopper := web.NewTestServer(t, "interactions")
+	defer stopper()
+	jobs := ts.Jobs().(*web.TestJobs)
+	Register(ts)
+	ctx := context.Background()
+
+	root := mux.NewRouter()
+	root.HandleFunc("/inbox", InboxHandler(ts))
+	bob := newRemoteActor(t)
+
+	admin, err := localAccount(ctx, ts, 1)
+	assert.NoError(t, err)
+	var uri string
+	assert.NoError(t, ts.DB().Get(&uri, "select Uri from toots where Sid = 'AABA'"))
+
+	deliver := func(activity map[string]any) {
+		activity["actor"] = bob.IRI
+		w := bob.post(t, root, "/inbox", activity)
+		assert.Equal(t, 202, w.Code, w.Body.String())
+		assert.NoError(t, jobs.Drain(ctx))
+	}
+	count := func(table string) int {
+		var count int
+		assert.NoError(t, ts.DB().Get(&count, "select count(*) from "+table+" where ObjectId = ?", uri))
+		return count
+	}
+
+	t.Run("Like", func(t *testing.T) {
+		like := map[string]any{"id": bob.IRI + "/likes/1", "type": "Like", "object": uri}
+		deliver(like)
+		assert.Equal(t, 1, count("actor_favorites"))
+		assert.Equal(t, 1, notifications(t, ts, NotifyFavourite))
+
+		// redelivery doesn't notify twice
+		deliver(like)
+		assert.Equal(t, 1, count("actor_favorites"))
+		assert.Equal(t, 1, notifications(t, ts, NotifyFavourite))
+
+		deliver(map[string]any{"id": bob.IRI + "/likes/1/undo", "type": "Undo", "object": like})
+		assert.Equal(t, 0, count("actor_favorites"))
+	})
+
+	t.Run("Announce", func(t *testing.T) {
+		announce := map[string]any{
+			"id":     bob.IRI + "/statuses/1/activity",
+			"type":   "Announce",
+			"object": uri,
+			"to":     activitystreams.Public,
+		}
+		deliver(announce)
+		assert.Equal(t, 1, count("actor_reblogs"))
+		assert.Equal(t, 1, notifications(t, ts, NotifyReblog))
+
+		var boosts int
+		assert.NoError(t, ts.DB().Get(&boosts, "select count(*) from toots where BoostOfId = 'AABA'"))
+		assert.Equal(t, 0, boosts)
+
+		deliver(map[string]any{"id": bob.IRI + "/statuses/1/undo", "type": "Undo", "object": announce})
+		assert.Equal(t, 0, count("actor_reblogs"))
+
+		// boosts by followed actors appear in the home timeline
+		_, err := ts.DB().Exec(`
+			insert into actor_following (Id, ActorId, TargetActorId, TargetActorAccount, State)
+			values (?, ?, ?, 'bob@remote', ?)`, admin.IRI()+"#follows/1", admin.IRI(), bob.IRI, FollowAccepted)
+		assert.NoError(t, err)
+		announce["id"] = bob.IRI + "/statuses/2/activity"
+		deliver(announce)
+		assert.NoError(t, ts.DB().Get(&boosts, "select count(*) from toots where BoostOfId = 'AABA'"))
+		assert.Equal(t, 1, boosts)
+
+		deliver(map[string]any{"id": bob.IRI + "/statuses/2/undo", "type": "Undo", "object": announce})
+		assert.NoError(t, ts.DB().Get(&boosts, "select count(*) from toots where BoostOfId = 'AABA'"))
+		assert.Equal(t, 0, boosts)
+
+		// followers-only and direct toots can't be boosted
+		for _, vis := range []model.PostVisibility{model.VisPrivate, model.VisDirect} {
+			_, err = ts.DB().Exec("update toots set Visibility = ? where Sid = 'AABA'", vis)
+			assert.NoError(t, err)
+			announce["id"] = fmt.Sprintf("%s/statuses/%d/activity", bob.IRI, 3+vis)
+			deliver(announce)
+			assert.Equal(t, 0, count("actor_reblogs"))
+			assert.NoError(t, ts.DB().Get(&boosts, "select count(*) from toots where BoostOfId = 'AABA'"))
+			assert.Equal(t, 0, boosts)
+		}
+		_, err = ts.DB().Exec("update toots set Visibility = ? where Sid = 'AABA'", model.VisPublic)
+		assert.NoError(t, err)
+	})
+}
@@ -0,0 +1,66 @@
+package activitypub
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/contribsys/sparq"
+)
+
+// Register the ActivityPub jobs with the job runner
+// and the handlers for inbound activities.
+func Register(svr sparq.Server) {
+	Handle("Follow", handleFollow)
+	Handle("Accept", handleFollowReply)
+	Handle("Reject", handleFollowReply)
+	Handle("Undo", handleUndo)
+	Handle("Create", handleCreate)
+	Handle("Like", handleLike)
+	Handle("Announce", handleAnnounce)
+	Handle("Update", handleUpdate)
+	Handle("Delete", handleDelete)
+	Handle("Block", handleBlock)
+
+	js := svr.Jobs()
+	js.Register("ProcessInbox", func(ctx context.Context, args ...interface{}) error {
+		return ProcessInbox(ctx, svr, arg(args, 0), arg(args, 1))
+	})
+	js.Register("DeliverToot", func(ctx context.Context, args ...interface{}) error {
+		return deliverToot(ctx, svr, arg(args, 0))
+	})
+	js.Register("DeliverUpdate", func(ctx context.Context, args ...interface{}) error {
+		return deliverUpdate(ctx, svr, arg(args, 0))
+	})
+	js.Register("DeliverProfile", func(ctx context.Context, args ...interface{}) error {
+		return deliverProfile(ctx, svr, arg(args, 0))
+	})
+	js.Register("DeliverDelete", func(ctx context.Context, args ...interface{}) error {
+		return deliverDelete(ctx, svr, arg(args, 0))
+	})
+	js.Register("ClosePoll", func(ctx context.Context, args ...interface{}) error {
+		return closePoll(ctx, svr, arg(args, 0))
+	})
+	js.Register("FetchThread", func(ctx context.Context, args ...interface{}) error {
+		return fetchThread(ctx, svr, arg(args, 0))
+	})
+	js.Register("Deliver", func(ctx context.Context, args ...interface{}) error {
+		return Deliver(ctx, svr, arg(args, 0), arg(args, 1), arg(args, 2))
+	})
+	js.Register("RefreshActors", func(ctx context.Context, args ...interface{}) error {
+		return refreshActors(ctx, svr)
+	})
+	js.Register("RefreshActor", func(ctx context.Context, args ...interface{}) error {
+		return refreshActor(ctx, svr, arg(args, 0))
+	})
+	js.Register("CacheEmoji", func(ctx context.Context, args ...interface{}) error {
+		return cacheEmoji(ctx, svr, arg(args, 0))
+	})
+	js.Periodic("RefreshActors", ActorRefreshInterval)
+}
+
+func arg(args []interface{}, idx int) string {
+	if idx >= len(args) || args[idx] == nil {
+		return ""
+	}
+	return fmt.Sprint(args[idx])
+}
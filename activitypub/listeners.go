@@ -0,0 +1,59 @@
+package activitypub
+
+import (
+	"context"
+	"sync"
+)
+
+// listeners holds the callbacks registered for one kind of event.
+type listeners[T any] struct {
+	mu      sync.Mutex
+	next    int
+	entries []listener[T]
+}
+
+type listener[T any] struct {
+	id int
+	fn T
+}
+
+// add registers the callback and returns a func which removes it.
+func (l *listeners[T]) add(fn T) func() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.next++
+	id := l.next
+	l.entries = append(l.entries, listener[T]{id, fn})
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		for idx, entry := range l.entries {
+			if entry.id == id {
+				l.entries = append(l.entries[:idx:idx], l.entries[idx+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// all returns the callbacks in the order they were registered.
+func (l *listeners[T]) all() []T {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	result := make([]T, 0, len(l.entries))
+	for _, entry := range l.entries {
+		result = append(result, entry.fn)
+	}
+	return result
+}
+
+// Until removes the listeners once the context is done, e.g. when
+// the server they were registered for shuts down.
+func Until(ctx context.Context, unregister ...func()) {
+	go func() {
+		<-ctx.Done()
+		for _, fn := range unregister {
+			fn()
+		}
+	}()
+}
@@ -0,0 +1,36 @@
+package activitypub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListeners(t *testing.T) {
+	var ls listeners[func() int]
+	one := ls.add(func() int { return 1 })
+	ls.add(func() int { return 2 })
+	three := ls.add(func() int { return 3 })
+
+	values := func() []int {
+		result := []int{}
+		for _, fn := range ls.all() {
+			result = append(result, fn())
+		}
+		return result
+	}
+	assert.Equal(t, []int{1, 2, 3}, values())
+
+	one()
+	one()
+	assert.Equal(t, []int{2, 3}, values())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	Until(ctx, three)
+	assert.Equal(t, []int{2, 3}, values())
+	cancel()
+	assert.Eventually(t, func() bool { return len(ls.all()) == 1 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, []int{2}, values())
+}
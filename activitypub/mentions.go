@@ -0,0 +1,149 @@
+package activitypub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/contribsys/sparq"
+	"github.com/contribsys/sparq/activitystreams"
+	"github.com/contribsys/sparq/markup"
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/util"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// FormatToot renders the text of a local toot as HTML and returns
+// the IRIs of the actors it mentions. Mentions which can't be
+// resolved are left as plain text.
+func FormatToot(ctx context.Context, svr sparq.Server, text string) (string, []string) {
+	mentions := map[string]*markup.Mention{}
+	seen := map[string]bool{}
+	iris := []string{}
+	for _, handle := range markup.Mentions(text) {
+		m, err := resolveMention(ctx, svr, handle)
+		if err != nil {
+			util.Debugf("Unable to resolve mention @%s: %v", handle, err)
+			continue
+		}
+		mentions[handle] = m
+		if !seen[m.IRI] {
+			seen[m.IRI] = true
+			iris = append(iris, m.IRI)
+		}
+	}
+	return markup.Render(text, svr.Hostname(), mentions), iris
+}
+
+func resolveMention(ctx context.Context, svr sparq.Server, handle string) (*markup.Mention, error) {
+	iri, err := ResolveHandle(ctx, svr, handle)
+	if err != nil {
+		return nil, err
+	}
+	if nick, ok := model.LocalNick(iri); ok {
+		return &markup.Mention{Handle: handle, Nick: nick, IRI: iri,
+			URL: fmt.Sprintf("https://%s/@%s", svr.Hostname(), nick)}, nil
+	}
+	actor, err := FetchActor(ctx, svr, iri)
+	if err != nil {
+		return nil, err
+	}
+	var person activitystreams.Object
+	_ = json.Unmarshal([]byte(actor.Properties), &person)
+	m := &markup.Mention{Handle: handle, Nick: person.PreferredUsername, IRI: iri, URL: person.URL}
+	if m.Nick == "" {
+		m.Nick, _, _ = strings.Cut(handle, "@")
+	}
+	// the link goes into our HTML so it must be a web page
+	if !strings.HasPrefix(m.URL, "https://") && !strings.HasPrefix(m.URL, "http://") {
+		m.URL = iri
+	}
+	return m, nil
+}
+
+// SaveMentions replaces the actors mentioned in the toot.
+func SaveMentions(ctx context.Context, tx *sqlx.Tx, sid string, iris []string) error {
+	_, err := tx.ExecContext(ctx, "delete from toot_mentions where Sid = ?", sid)
+	if err != nil {
+		return errors.Wrap(err, "toot_mentions")
+	}
+	for _, iri := range iris {
+		_, err = tx.ExecContext(ctx, `
+			insert into toot_mentions (Sid, ActorId) values (?, ?)
+			on conflict do nothing`, sid, iri)
+		if err != nil {
+			return errors.Wrap(err, "toot_mentions")
+		}
+	}
+	return nil
+}
+
+// NotifyMentioned tells the mentioned local accounts about the
+// toot. Authors aren't notified about mentioning themselves.
+func NotifyMentioned(ctx context.Context, svr sparq.Server, author, uri string, iris []string) error {
+	for _, iri := range iris {
+		if _, ok := model.LocalNick(iri); !ok || iri == author {
+			continue
+		}
+		err := Notify(ctx, svr, NotifyMention, iri, author, uri)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Mentions returns the IRIs of the actors mentioned in the toot.
+func Mentions(ctx context.Context, svr sparq.Server, sid string) ([]string, error) {
+	iris := []string{}
+	err := svr.DB().SelectContext(ctx, &iris, "select ActorId from toot_mentions where Sid = ? order by rowid", sid)
+	return iris, errors.Wrap(err, "toot_mentions")
+}
+
+// mentionTags returns the Mention tags for the actors mentioned
+// in a local toot.
+func mentionTags(ctx context.Context, svr sparq.Server, iris []string) ([]activitystreams.Tag, error) {
+	tags := []activitystreams.Tag{}
+	for _, iri := range iris {
+		name, err := handleFor(ctx, svr, iri)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, activitystreams.Tag{Type: activitystreams.TagMention, HRef: iri, Name: "@" + name})
+	}
+	return tags, nil
+}
+
+// handleFor returns the nick@host handle of a local or cached actor.
+func handleFor(ctx context.Context, svr sparq.Server, iri string) (string, error) {
+	if nick, ok := model.LocalNick(iri); ok {
+		return nick + "@" + svr.Hostname(), nil
+	}
+	actor, err := FetchActor(ctx, svr, iri)
+	if err != nil {
+		return "", err
+	}
+	return HandleFor(actor), nil
+}
+
+// MentionInboxes returns the inboxes of the remote actors mentioned
+// in the toot.
+func MentionInboxes(ctx context.Context, svr sparq.Server, sid string) ([]string, error) {
+	props := []string{}
+	err := svr.DB().SelectContext(ctx, &props, `
+		select a.Properties from toot_mentions m
+		join actors a on a.Id = m.ActorId
+		where m.Sid = ? and a.PrivateKey is null`, sid)
+	if err != nil {
+		return nil, errors.Wrap(err, "toot_mentions")
+	}
+	inboxes := []string{}
+	for _, prop := range props {
+		if inbox := inboxFor(prop); inbox != "" {
+			inboxes = append(inboxes, inbox)
+		}
+	}
+	return inboxes, nil
+}
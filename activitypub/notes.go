@@ -0,0 +1,165 @@
+package activitypub
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/contribsys/sparq"
+	"github.com/contribsys/sparq/activitystreams"
+	"github.com/contribsys/sparq/model"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+// WantsActivity is true if the request asks for the ActivityStreams
+// representation of a resource rather than HTML.
+func WantsActivity(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, ActivityJson) || strings.Contains(accept, "application/ld+json")
+}
+
+// GET /@{nick}/{id}
+//
+// NoteHandler serves public and unlisted local toots as Notes.
+// Deleted toots are served as a Tombstone so remote servers know
+// to remove their copy.
+func NoteHandler(svr sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var toot model.Toot
+		err := svr.DB().GetContext(r.Context(), &toot, `
+			select t.* from toots t join accounts a on t.AuthorId = a.Id
+			where t.Sid = ? and a.Nick = ? and t.BoostOfId is null`, mux.Vars(r)["id"], mux.Vars(r)["nick"])
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				httpError(w, errors.New("No such toot"), http.StatusNotFound)
+				return
+			}
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		var obj *activitystreams.Object
+		code := http.StatusOK
+		switch {
+		case toot.DeletedAt != nil:
+			obj = activitystreams.NewTombstoneObject(toot.Uri, "Note", *toot.DeletedAt)
+			code = http.StatusGone
+		case toot.Visibility == model.VisPublic || toot.Visibility == model.VisUnlisted:
+			author, err := localAccount(r.Context(), svr, *toot.AuthorId)
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+			obj, err = NoteFor(r.Context(), svr, &toot, author)
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+		default:
+			httpError(w, errors.New("No such toot"), http.StatusNotFound)
+			return
+		}
+		obj.Context = []interface{}{activitystreams.Namespace}
+		w.Header().Add("Content-Type", ActivityJson)
+		w.WriteHeader(code)
+		_ = json.NewEncoder(w).Encode(obj)
+	}
+}
+
+// NoteFor builds the ActivityStreams representation of a local toot.
+func NoteFor(ctx context.Context, svr sparq.Server, toot *model.Toot, author *model.Account) (*activitystreams.Object, error) {
+	note := activitystreams.NewNoteObject()
+	note.ID = toot.Uri
+	note.URL = toot.Uri
+	note.AttributedTo = author.IRI()
+	note.Content = toot.Content
+	note.Published = toot.CreatedAt
+	note.Updated = toot.LastEditAt
+	note.To, note.CC = Addressing(author, toot.Visibility)
+	if toot.Summary != "" {
+		note.Summary = &toot.Summary
+	}
+	if toot.InReplyTo != nil && strings.HasPrefix(*toot.InReplyTo, "https://") {
+		note.InReplyTo = toot.InReplyTo
+	}
+	if toot.Lang != "" {
+		note.ContentMap = map[string]string{toot.Lang: toot.Content}
+	}
+
+	medias := []model.TootMedia{}
+	err := svr.DB().SelectContext(ctx, &medias, "select * from toot_medias where sid = ?", toot.Sid)
+	if err != nil {
+		return nil, errors.Wrap(err, "toot_medias")
+	}
+	for idx := range medias {
+		att := activitystreams.NewImageAttachment(medias[idx].FullUri())
+		att.Name = medias[idx].Description
+		note.Attachment = append(note.Attachment, att)
+	}
+
+	tags := []model.TootTag{}
+	err = svr.DB().SelectContext(ctx, &tags, "select * from toot_tags where sid = ?", toot.Sid)
+	if err != nil {
+		return nil, errors.Wrap(err, "toot_tags")
+	}
+	for _, tag := range tags {
+		note.Tag = append(note.Tag, activitystreams.Tag{
+			Type: activitystreams.TagHashtag,
+			HRef: fmt.Sprintf("https://%s/tags/%s", svr.Hostname(), tag.Tag),
+			Name: "#" + tag.Tag,
+		})
+	}
+
+	text := toot.Text
+	if text == "" {
+		text = toot.Content
+	}
+	emojis, err := EmojiTags(ctx, svr, toot.Summary, text)
+	if err != nil {
+		return nil, err
+	}
+	note.Tag = append(note.Tag, emojis...)
+
+	// mentioned actors are addressed directly
+	mentioned, err := Mentions(ctx, svr, toot.Sid)
+	if err != nil {
+		return nil, err
+	}
+	mentions, err := mentionTags(ctx, svr, mentioned)
+	if err != nil {
+		return nil, err
+	}
+	note.Tag = append(note.Tag, mentions...)
+	if toot.Visibility == model.VisDirect {
+		note.To = append(note.To, mentioned...)
+	} else {
+		note.CC = append(note.CC, mentioned...)
+	}
+	if toot.PollId != nil {
+		err = questionFor(ctx, svr, note, *toot.PollId)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return note, nil
+}
+
+// Addressing returns the To and CC recipients for a toot
+// with the given visibility.
+func Addressing(author *model.Account, vis model.PostVisibility) ([]string, []string) {
+	followers := author.IRI() + "/followers"
+	switch vis {
+	case model.VisPublic:
+		return []string{activitystreams.Public}, []string{followers}
+	case model.VisUnlisted:
+		return []string{followers}, []string{activitystreams.Public}
+	case model.VisPrivate, model.VisLimited:
+		return []string{followers}, nil
+	}
+	// direct toots are only addressed to mentioned accounts
+	return []string{}, nil
+}
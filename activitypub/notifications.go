@@ -0,0 +1,84 @@
+package activitypub
+
+import (
+	"context"
+
+	"github.com/contribsys/sparq"
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/util"
+	"github.com/pkg/errors"
+)
+
+// The notification types used by the Mastodon API
+const (
+	NotifyMention       = "mention"
+	NotifyFollow        = "follow"
+	NotifyFollowRequest = "follow_request"
+	NotifyFavourite     = "favourite"
+	NotifyReblog        = "reblog"
+	NotifyPoll          = "poll"
+	NotifyAdminSignUp   = "admin.sign_up"
+)
+
+// NotifyFunc is called with each new notification,
+// e.g. to stream it to the recipient.
+type NotifyFunc func(ctx context.Context, svr sparq.Server, n *model.ActorNotification)
+
+var notifyListeners listeners[NotifyFunc]
+
+// OnNotify registers a listener for new notifications, returning
+// a func which unregisters it.
+func OnNotify(fn NotifyFunc) func() {
+	return notifyListeners.add(fn)
+}
+
+// Notify tells the local account with the given IRI that the
+// actor did something, optionally to one of its toots. The
+// object is the toot's URI or empty.
+func Notify(ctx context.Context, svr sparq.Server, kind, recipient, from, object string) error {
+	if _, ok := model.LocalNick(recipient); !ok {
+		return nil
+	}
+	// you don't need to be told about your own actions, but
+	// your own polls do end
+	if recipient == from && kind != NotifyPoll {
+		return nil
+	}
+	if recipient != from {
+		quiet, err := silenced(ctx, svr, recipient, from)
+		if err != nil || quiet {
+			return err
+		}
+	}
+	if object != "" {
+		muted, err := MutedConversation(ctx, svr, recipient, object)
+		if err != nil || muted {
+			return err
+		}
+	}
+	n := &model.ActorNotification{
+		Type:        kind,
+		ActorId:     recipient,
+		FromActorId: from,
+	}
+	if object != "" {
+		n.ObjectId = &object
+	}
+	res, err := svr.DB().ExecContext(ctx, `
+		insert into actor_notifications (Type, ActorId, FromActorId, ObjectId)
+		values (?, ?, ?, ?)`, n.Type, n.ActorId, n.FromActorId, n.ObjectId)
+	if err != nil {
+		return errors.Wrap(err, "actor_notifications")
+	}
+	n.Id, _ = res.LastInsertId()
+	err = svr.DB().GetContext(ctx, n, "select * from actor_notifications where Id = ?", n.Id)
+	if err != nil {
+		return errors.Wrap(err, "actor_notifications")
+	}
+	util.Debugf("Notifying %s of %s from %s", recipient, kind, from)
+
+	for _, fn := range notifyListeners.all() {
+		fn(ctx, svr, n)
+	}
+	return nil
+}
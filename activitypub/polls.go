@@ -0,0 +1,345 @@
+package activitypub
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/sparq"
+	"github.com/contribsys/sparq/activitystreams"
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/util"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrPollExpired   = errors.New("The poll has already ended")
+	ErrAlreadyVoted  = errors.New("You have already voted on this poll")
+	ErrInvalidChoice = errors.New("Invalid poll choice")
+	ErrSelfVote      = errors.New("You can't vote in your own poll")
+)
+
+// remoteOption is one of the choices of a remote Question.
+type remoteOption struct {
+	Name    string `json:"name"`
+	Replies struct {
+		TotalItems int64 `json:"totalItems"`
+	} `json:"replies"`
+}
+
+// options returns the choices of a Question and whether
+// more than one may be chosen.
+func (rn *RemoteNote) options() ([]remoteOption, bool) {
+	if len(rn.AnyOf) > 0 {
+		return rn.AnyOf, true
+	}
+	return rn.OneOf, false
+}
+
+// isVote is true if the note is a vote in a poll rather than a reply.
+func (rn *RemoteNote) isVote() bool {
+	return rn.Name != "" && rn.Content == "" && rn.InReplyTo != ""
+}
+
+// PollOptions returns the poll's options in order.
+func PollOptions(ctx context.Context, svr sparq.Server, id uint64) ([]model.PollOption, error) {
+	options := []model.PollOption{}
+	err := svr.DB().SelectContext(ctx, &options, "select * from poll_options where PollId = ? order by Position", id)
+	return options, errors.Wrap(err, "poll_options")
+}
+
+// SchedulePollExpiry queues the job which closes the poll
+// when it expires.
+func SchedulePollExpiry(ctx context.Context, svr sparq.Server, poll *model.Poll) error {
+	if poll.ExpiresAt == nil {
+		return nil
+	}
+	job := client.NewJob("ClosePoll", strconv.FormatUint(poll.Id, 10))
+	job.At = poll.ExpiresAt.UTC().Format(time.RFC3339Nano)
+	return svr.Jobs().Push(ctx, job)
+}
+
+// closePoll ends the poll, notifying the author and everyone who
+// voted. The final results of local polls are sent to followers.
+func closePoll(ctx context.Context, svr sparq.Server, id string) error {
+	var poll model.Poll
+	err := svr.DB().GetContext(ctx, &poll, "select * from polls where Id = ?", id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return errors.Wrap(err, "polls")
+	}
+	if poll.ClosedAt != nil {
+		return nil
+	}
+	if poll.ExpiresAt != nil && poll.ExpiresAt.After(time.Now()) {
+		util.Debugf("Poll %d doesn't expire until %v", poll.Id, poll.ExpiresAt)
+		return nil
+	}
+	_, err = svr.DB().ExecContext(ctx, "update polls set ClosedAt = current_timestamp where Id = ?", poll.Id)
+	if err != nil {
+		return errors.Wrap(err, "polls")
+	}
+
+	var toot model.Toot
+	err = svr.DB().GetContext(ctx, &toot, "select * from toots where Sid = ? and DeletedAt is null", poll.Sid)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return errors.Wrap(err, "toots")
+	}
+	author, err := AuthorIRI(ctx, svr, &toot)
+	if err != nil {
+		return err
+	}
+	voters := []string{}
+	err = svr.DB().SelectContext(ctx, &voters, "select distinct ActorId from poll_votes where PollId = ?", poll.Id)
+	if err != nil {
+		return errors.Wrap(err, "poll_votes")
+	}
+	for _, iri := range append(voters, author) {
+		err = Notify(ctx, svr, NotifyPoll, iri, author, toot.Uri)
+		if err != nil {
+			return err
+		}
+	}
+
+	if toot.AuthorId == nil {
+		return nil
+	}
+	_, err = svr.DB().ExecContext(ctx, "update toots set UpdatedAt = current_timestamp where Sid = ?", toot.Sid)
+	if err != nil {
+		return errors.Wrap(err, "toots")
+	}
+	return DeliverUpdate(ctx, svr, toot.Sid)
+}
+
+// Vote casts the account's votes in the toot's poll. Votes in
+// remote polls are sent to the poll's author, one Note per choice.
+func Vote(ctx context.Context, svr sparq.Server, acct *model.Account, toot *model.Toot, poll *model.Poll, choices []int) error {
+	if poll.Expired() {
+		return ErrPollExpired
+	}
+	if toot.AuthorId != nil && *toot.AuthorId == uint64(acct.Id) {
+		return ErrSelfVote
+	}
+	if len(choices) == 0 || (!poll.Multiple && len(choices) > 1) {
+		return ErrInvalidChoice
+	}
+	options, err := PollOptions(ctx, svr, poll.Id)
+	if err != nil {
+		return err
+	}
+	for _, choice := range choices {
+		if choice < 0 || choice >= len(options) {
+			return ErrInvalidChoice
+		}
+	}
+	var count int
+	err = svr.DB().GetContext(ctx, &count,
+		"select count(*) from poll_votes where PollId = ? and ActorId = ?", poll.Id, acct.IRI())
+	if err != nil {
+		return errors.Wrap(err, "poll_votes")
+	}
+	if count > 0 {
+		return ErrAlreadyVoted
+	}
+	err = recordVotes(ctx, svr, poll.Id, acct.IRI(), choices, nil)
+	if err != nil || toot.AuthorId != nil {
+		return err
+	}
+
+	author, err := AuthorIRI(ctx, svr, toot)
+	if err != nil {
+		return err
+	}
+	for _, choice := range choices {
+		note := activitystreams.NewNoteObject()
+		note.ID = acct.IRI() + "#votes/" + model.Snowflakes.NextSID()
+		note.AttributedTo = acct.IRI()
+		note.Name = options[choice].Title
+		note.InReplyTo = &toot.Uri
+		note.To = []string{author}
+		note.Published = time.Now().UTC()
+		act := activitystreams.NewCreateActivity(note)
+		act.ID = note.ID + "/activity"
+		act.To = note.To
+		err = SendTo(ctx, svr, acct, act, author)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordVotes counts the actor's votes for the given options.
+func recordVotes(ctx context.Context, svr sparq.Server, id uint64, actor string, positions []int, uri *string) error {
+	tx, err := svr.DB().BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var voted int
+	err = tx.GetContext(ctx, &voted, "select count(*) from poll_votes where PollId = ? and ActorId = ?", id, actor)
+	if err != nil {
+		return errors.Wrap(err, "poll_votes")
+	}
+	added := 0
+	for _, pos := range positions {
+		res, err := tx.ExecContext(ctx, `
+			insert into poll_votes (PollId, Position, ActorId, Uri) values (?, ?, ?, ?)
+			on conflict do nothing`, id, pos, actor, uri)
+		if err != nil {
+			return errors.Wrap(err, "poll_votes")
+		}
+		if count, _ := res.RowsAffected(); count == 0 {
+			continue
+		}
+		added++
+		_, err = tx.ExecContext(ctx, `
+			update poll_options set VotesCount = VotesCount + 1 where PollId = ? and Position = ?`, id, pos)
+		if err != nil {
+			return errors.Wrap(err, "poll_options")
+		}
+	}
+	if voted == 0 && added > 0 {
+		_, err = tx.ExecContext(ctx, "update polls set VotersCount = VotersCount + 1 where Id = ?", id)
+		if err != nil {
+			return errors.Wrap(err, "polls")
+		}
+	}
+	return tx.Commit()
+}
+
+// saveVote records a remote actor's vote in one of our polls.
+func saveVote(ctx context.Context, svr sparq.Server, note *RemoteNote) error {
+	var poll model.Poll
+	err := svr.DB().GetContext(ctx, &poll, `
+		select p.* from polls p join toots t on p.Sid = t.Sid
+		where t.Uri = ? and t.AuthorId is not null and t.DeletedAt is null`, note.InReplyTo)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			util.Debugf("Ignoring vote %s for unknown poll %s", note.Id, note.InReplyTo)
+			return nil
+		}
+		return errors.Wrap(err, "polls")
+	}
+	if poll.Expired() {
+		util.Debugf("Ignoring late vote %s", note.Id)
+		return nil
+	}
+	options, err := PollOptions(ctx, svr, poll.Id)
+	if err != nil {
+		return err
+	}
+	for _, option := range options {
+		if option.Title != note.Name {
+			continue
+		}
+		if !poll.Multiple {
+			var count int
+			err = svr.DB().GetContext(ctx, &count,
+				"select count(*) from poll_votes where PollId = ? and ActorId = ?", poll.Id, note.AttributedTo)
+			if err != nil {
+				return errors.Wrap(err, "poll_votes")
+			}
+			if count > 0 {
+				util.Debugf("Ignoring second vote %s", note.Id)
+				return nil
+			}
+		}
+		return recordVotes(ctx, svr, poll.Id, note.AttributedTo, []int{option.Position}, &note.Id)
+	}
+	util.Warnf("Ignoring vote %s for unknown option %q", note.Id, note.Name)
+	return nil
+}
+
+// saveRemotePoll stores the options and counts of a remote Question.
+func saveRemotePoll(ctx context.Context, tx *sqlx.Tx, sid string, note *RemoteNote) (*model.Poll, error) {
+	options, multiple := note.options()
+	if len(options) == 0 {
+		return nil, nil
+	}
+	poll := &model.Poll{Sid: sid, ExpiresAt: note.EndTime, Multiple: multiple, VotersCount: note.VotersCount}
+	var expires, closed any
+	if note.EndTime != nil {
+		expires = note.EndTime.UTC().Format("2006-01-02 15:04:05")
+	}
+	if note.Closed != nil {
+		closed = time.Now().UTC().Format("2006-01-02 15:04:05")
+	}
+	res, err := tx.ExecContext(ctx, `
+		insert into polls (Sid, ExpiresAt, Multiple, VotersCount, ClosedAt) values (?, ?, ?, ?, ?)`,
+		sid, expires, multiple, note.VotersCount, closed)
+	if err != nil {
+		return nil, errors.Wrap(err, "polls")
+	}
+	id, _ := res.LastInsertId()
+	poll.Id = uint64(id)
+	for idx, option := range options {
+		_, err = tx.ExecContext(ctx, `
+			insert into poll_options (PollId, Position, Title, VotesCount) values (?, ?, ?, ?)`,
+			poll.Id, idx, option.Name, option.Replies.TotalItems)
+		if err != nil {
+			return nil, errors.Wrap(err, "poll_options")
+		}
+	}
+	_, err = tx.ExecContext(ctx, "update toots set PollId = ? where Sid = ?", poll.Id, sid)
+	return poll, errors.Wrap(err, "toots")
+}
+
+// syncRemotePoll updates the counts of a remote poll when the
+// author sends an updated Question.
+func syncRemotePoll(ctx context.Context, svr sparq.Server, toot *model.Toot, note *RemoteNote) error {
+	if toot.PollId == nil {
+		return nil
+	}
+	options, _ := note.options()
+	for idx, option := range options {
+		_, err := svr.DB().ExecContext(ctx, `
+			update poll_options set VotesCount = ? where PollId = ? and Position = ?`,
+			option.Replies.TotalItems, *toot.PollId, idx)
+		if err != nil {
+			return errors.Wrap(err, "poll_options")
+		}
+	}
+	query := "update polls set VotersCount = ? where Id = ?"
+	if note.Closed != nil {
+		query = "update polls set VotersCount = ?, ClosedAt = coalesce(ClosedAt, current_timestamp) where Id = ?"
+	}
+	_, err := svr.DB().ExecContext(ctx, query, note.VotersCount, *toot.PollId)
+	return errors.Wrap(err, "polls")
+}
+
+// questionFor turns the note into a Question with the poll's
+// options and current results.
+func questionFor(ctx context.Context, svr sparq.Server, note *activitystreams.Object, id uint64) error {
+	var poll model.Poll
+	err := svr.DB().GetContext(ctx, &poll, "select * from polls where Id = ?", id)
+	if err != nil {
+		return errors.Wrap(err, "polls")
+	}
+	options, err := PollOptions(ctx, svr, id)
+	if err != nil {
+		return err
+	}
+	note.Type = "Question"
+	note.EndTime = poll.ExpiresAt
+	note.Closed = poll.ClosedAt
+	note.VotersCount = &poll.VotersCount
+	for _, option := range options {
+		choice := activitystreams.NewQuestionOption(option.Title, option.VotesCount)
+		if poll.Multiple {
+			note.AnyOf = append(note.AnyOf, choice)
+		} else {
+			note.OneOf = append(note.OneOf, choice)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package activitypub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/web"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolls(t *testing.T) {
+	ts, stopper := web.NewTestServer(t, "polls")
+	defer stopper()
+	jobs := ts.Jobs().(*web.TestJobs)
+	Register(ts)
+	ctx := context.Background()
+
+	root := mux.NewRouter()
+	root.HandleFunc("/inbox", InboxHandler(ts))
+	bob := newRemoteActor(t)
+	admin, err := localAccount(ctx, ts, 1)
+	assert.NoError(t, err)
+
+	deliver := func(id, kind string, object any) {
+		w := bob.post(t, root, "/inbox", map[string]any{"id": id, "type": kind, "actor": bob.IRI, "object": object})
+		assert.Equal(t, 202, w.Code, w.Body.String())
+		assert.NoError(t, jobs.Drain(ctx))
+	}
+	votes := func(pollId uint64) []int64 {
+		counts := []int64{}
+		assert.NoError(t, ts.DB().Select(&counts, "select VotesCount from poll_options where PollId = ? order by Position", pollId))
+		return counts
+	}
+
+	t.Run("Local", func(t *testing.T) {
+		_, err := ts.DB().Exec(`
+			insert into polls (Id, Sid, ExpiresAt) values (1, 'AABA', datetime('now', '+1 day'))`)
+		assert.NoError(t, err)
+		_, err = ts.DB().Exec(`insert into poll_options (PollId, Position, Title) values (1, 0, 'Yes'), (1, 1, 'No')`)
+		assert.NoError(t, err)
+		_, err = ts.DB().Exec("update toots set PollId = 1 where Sid = 'AABA'")
+		assert.NoError(t, err)
+		var toot model.Toot
+		assert.NoError(t, ts.DB().Get(&toot, "select * from toots where Sid = 'AABA'"))
+
+		vote := func(id, name string) {
+			deliver(id+"/activity", "Create", map[string]any{
+				"id":           id,
+				"type":         "Note",
+				"name":         name,
+				"attributedTo": bob.IRI,
+				"inReplyTo":    toot.Uri,
+				"to":           admin.IRI(),
+			})
+		}
+		vote(bob.IRI+"#votes/1", "No")
+		assert.Equal(t, []int64{0, 1}, votes(1))
+		// only one vote per voter
+		vote(bob.IRI+"#votes/2", "Yes")
+		assert.Equal(t, []int64{0, 1}, votes(1))
+
+		var count int
+		assert.NoError(t, ts.DB().Get(&count, "select count(*) from toots where Uri like ?", bob.IRI+"#votes/%"))
+		assert.Equal(t, 0, count)
+
+		note, err := NoteFor(ctx, ts, &toot, admin)
+		assert.NoError(t, err)
+		assert.Equal(t, "Question", note.Type)
+		assert.Equal(t, 2, len(note.OneOf))
+		assert.Equal(t, "No", note.OneOf[1].Name)
+		assert.EqualValues(t, 1, note.OneOf[1].Replies.TotalItems)
+		assert.EqualValues(t, 1, *note.VotersCount)
+	})
+
+	t.Run("Remote", func(t *testing.T) {
+		_, err := ts.DB().Exec(`insert into actor_following (Id, ActorId, TargetActorId, TargetActorAccount, State)
+			values ('1', ?, ?, 'bob@remote', 'accepted')`, admin.IRI(), bob.IRI)
+		assert.NoError(t, err)
+
+		uri := bob.IRI + "/questions/1"
+		question := map[string]any{
+			"id":           uri,
+			"type":         "Question",
+			"attributedTo": bob.IRI,
+			"content":      "Cats or dogs?",
+			"to":           bob.IRI + "/followers",
+			"endTime":      time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+			"votersCount":  3,
+			"anyOf": []map[string]any{
+				{"type": "Note", "name": "Cats", "replies": map[string]any{"type": "Collection", "totalItems": 2}},
+				{"type": "Note", "name": "Dogs", "replies": map[string]any{"type": "Collection", "totalItems": 1}},
+			},
+		}
+		deliver(uri+"/activity", "Create", question)
+		var toot model.Toot
+		assert.NoError(t, ts.DB().Get(&toot, "select * from toots where Uri = ?", uri))
+		assert.NotNil(t, toot.PollId)
+		if toot.PollId == nil {
+			return
+		}
+		var poll model.Poll
+		assert.NoError(t, ts.DB().Get(&poll, "select * from polls where Id = ?", *toot.PollId))
+		assert.True(t, poll.Multiple)
+		assert.EqualValues(t, 3, poll.VotersCount)
+		assert.Equal(t, []int64{2, 1}, votes(poll.Id))
+
+		assert.NoError(t, Vote(ctx, ts, admin, &toot, &poll, []int{1}))
+		assert.Equal(t, []int64{2, 2}, votes(poll.Id))
+		assert.NoError(t, jobs.Drain(ctx))
+		assert.NotEmpty(t, bob.Inbox)
+		if len(bob.Inbox) > 0 {
+			body, err := io.ReadAll(bob.Inbox[len(bob.Inbox)-1].Body)
+			assert.NoError(t, err)
+			create := map[string]any{}
+			assert.NoError(t, json.Unmarshal(body, &create))
+			assert.Equal(t, "Create", create["type"])
+			vote := create["object"].(map[string]any)
+			assert.Equal(t, "Dogs", vote["name"])
+			assert.Equal(t, uri, vote["inReplyTo"])
+		}
+		assert.ErrorIs(t, Vote(ctx, ts, admin, &toot, &poll, []int{0}), ErrAlreadyVoted)
+
+		// the author sends new counts as they change
+		question["votersCount"] = 5
+		question["anyOf"].([]map[string]any)[0]["replies"] = map[string]any{"type": "Collection", "totalItems": 3}
+		question["anyOf"].([]map[string]any)[1]["replies"] = map[string]any{"type": "Collection", "totalItems": 3}
+		deliver(uri+"#updates/1", "Update", question)
+		assert.Equal(t, []int64{3, 3}, votes(poll.Id))
+		var edits int
+		assert.NoError(t, ts.DB().Get(&edits, "select count(*) from toot_edits where Sid = ?", toot.Sid))
+		assert.Equal(t, 0, edits)
+
+		_, err = ts.DB().Exec("update polls set ExpiresAt = datetime('now', '-1 minute') where Id = ?", poll.Id)
+		assert.NoError(t, err)
+		assert.NoError(t, closePoll(ctx, ts, fmt.Sprint(poll.Id)))
+		assert.NoError(t, ts.DB().Get(&poll, "select * from polls where Id = ?", *toot.PollId))
+		assert.NotNil(t, poll.ClosedAt)
+		assert.Equal(t, 1, notifications(t, ts, NotifyPoll))
+		assert.ErrorIs(t, Vote(ctx, ts, admin, &toot, &poll, []int{0}), ErrPollExpired)
+	})
+}
@@ -0,0 +1,105 @@
+package activitypub
+
+import (
+	"context"
+	"mime"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/sparq"
+	"github.com/contribsys/sparq/activitystreams"
+	"github.com/contribsys/sparq/markup"
+	"github.com/contribsys/sparq/model"
+	"github.com/pkg/errors"
+)
+
+// PersonFor renders the local account's profile as its actor.
+func PersonFor(ctx context.Context, svr sparq.Server, nick string) (*activitystreams.Person, error) {
+	var acct model.Account
+	err := svr.DB().GetContext(ctx, &acct, `
+		select a.*, ap.* from accounts a
+		join account_profiles ap on ap.AccountId = a.Id
+		where a.Nick = ? and not `+model.PendingAccount, nick)
+	if err != nil {
+		return nil, errors.Wrap(err, "account "+nick)
+	}
+	var key string
+	err = svr.DB().GetContext(ctx, &key, "select PublicKey from account_securities where AccountId = ?", acct.Id)
+	if err != nil {
+		return nil, errors.Wrap(err, "account_securities")
+	}
+	fields := []model.AccountField{}
+	err = svr.DB().SelectContext(ctx, &fields,
+		"select Name, Value from account_fields where AccountId = ? order by rowid", acct.Id)
+	if err != nil {
+		return nil, errors.Wrap(err, "account_fields")
+	}
+
+	me := activitystreams.NewPerson(acct.IRI())
+	if acct.Bot {
+		me.Type = "Service"
+	}
+	me.URL = acct.IRI()
+	me.Name = acct.FullName
+	me.PreferredUsername = acct.Nick
+	me.Summary = acct.Note
+	me.ManuallyApproves = acct.Visibility != model.Public
+	me.Discoverable = acct.Discoverable
+	me.Icon = profileImage(svr, acct.Avatar)
+	me.Image = profileImage(svr, acct.Header)
+	me.AddPubKey(key)
+	me.Endpoints.SharedInbox = SharedInbox(svr)
+
+	texts := []string{acct.FullName, acct.NoteSource}
+	for _, field := range fields {
+		me.Attachment = append(me.Attachment, activitystreams.NewPropertyValue(field.Name, markup.RenderField(field.Value, svr.Hostname())))
+		texts = append(texts, field.Name, field.Value)
+	}
+	me.Tag, err = EmojiTags(ctx, svr, texts...)
+	if err != nil {
+		return nil, err
+	}
+	return me, nil
+}
+
+func profileImage(svr sparq.Server, url string) *activitystreams.Image {
+	if strings.HasPrefix(url, "/") {
+		url = "https://" + svr.Hostname() + url
+	}
+	return &activitystreams.Image{
+		Type:      "Image",
+		MediaType: mime.TypeByExtension(path.Ext(url)),
+		URL:       url,
+	}
+}
+
+// DeliverProfile queues the federation of the account's
+// changed profile.
+func DeliverProfile(ctx context.Context, svr sparq.Server, acct *model.Account) error {
+	return svr.Jobs().Push(ctx, client.NewJob("DeliverProfile", strconv.FormatInt(acct.Id, 10)))
+}
+
+// deliverProfile sends an Update of the account's actor to
+// its followers.
+func deliverProfile(ctx context.Context, svr sparq.Server, id string) error {
+	acct, err := localAccount(ctx, svr, id)
+	if err != nil {
+		return err
+	}
+	me, err := PersonFor(ctx, svr, acct.Nick)
+	if err != nil {
+		return err
+	}
+	inboxes, err := FollowerInboxes(ctx, svr, acct.IRI())
+	if err != nil {
+		return err
+	}
+	act := activitystreams.NewUpdatePersonActivity(me)
+	act.ID = acct.IRI() + "#updates/" + model.Snowflakes.NextSID()
+	// the security context is needed for the key
+	act.Context = me.Context
+	me.Context = nil
+	return Broadcast(ctx, svr, acct, act, inboxes)
+}
@@ -0,0 +1,56 @@
+package activitypub
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/contribsys/sparq/web"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeliverProfile(t *testing.T) {
+	ts, stopper := web.NewTestServer(t, "profile")
+	defer stopper()
+	jobs := ts.Jobs().(*web.TestJobs)
+	Register(ts)
+	ctx := context.Background()
+
+	root := mux.NewRouter()
+	root.HandleFunc("/users/{nick:[a-z0-9]{4,20}}/inbox", InboxHandler(ts))
+	bob := newRemoteActor(t)
+
+	admin, err := localAccount(ctx, ts, 1)
+	assert.NoError(t, err)
+	w := bob.post(t, root, "/users/admin/inbox", map[string]any{
+		"id": bob.IRI + "#follows/1", "type": "Follow", "actor": bob.IRI, "object": admin.IRI()})
+	assert.Equal(t, 202, w.Code, w.Body.String())
+	assert.NoError(t, jobs.Drain(ctx))
+	bob.Inbox = nil
+
+	_, err = ts.DB().Exec("update account_profiles set Bot = true, Discoverable = false where AccountId = 1")
+	assert.NoError(t, err)
+	_, err = ts.DB().Exec("insert into account_fields (AccountId, Name, Value) values (1, 'Website', 'https://example.com')")
+	assert.NoError(t, err)
+
+	assert.NoError(t, DeliverProfile(ctx, ts, admin))
+	assert.NoError(t, jobs.Drain(ctx))
+	assert.Equal(t, 1, len(bob.Inbox))
+	update := map[string]any{}
+	assert.NoError(t, json.NewDecoder(bob.Inbox[0].Body).Decode(&update))
+	assert.Equal(t, "Update", update["type"])
+	assert.Equal(t, admin.IRI(), update["actor"])
+	assert.NotNil(t, update["@context"])
+	person := update["object"].(map[string]any)
+	assert.Equal(t, "Service", person["type"])
+	assert.Equal(t, admin.IRI(), person["id"])
+	assert.Equal(t, false, person["discoverable"])
+	assert.Nil(t, person["@context"])
+	assert.NotNil(t, person["publicKey"])
+	fields := person["attachment"].([]any)
+	field := fields[len(fields)-1].(map[string]any)
+	assert.Equal(t, "PropertyValue", field["type"])
+	assert.Equal(t, "Website", field["name"])
+	assert.Contains(t, field["value"], `<a href="https://`)
+}
@@ -0,0 +1,28 @@
+package activitypub
+
+import (
+	"context"
+
+	"github.com/contribsys/sparq"
+	"github.com/contribsys/sparq/model"
+)
+
+// TootFunc is called with each new toot or boost, local or
+// remote, e.g. to stream it to timelines.
+type TootFunc func(ctx context.Context, svr sparq.Server, toot *model.Toot)
+
+var tootListeners listeners[TootFunc]
+
+// OnToot registers a listener for new toots, returning a func
+// which unregisters it.
+func OnToot(fn TootFunc) func() {
+	return tootListeners.add(fn)
+}
+
+// Published tells the listeners about a new toot once it has
+// been saved.
+func Published(ctx context.Context, svr sparq.Server, toot *model.Toot) {
+	for _, fn := range tootListeners.all() {
+		fn(ctx, svr, toot)
+	}
+}
@@ -0,0 +1,52 @@
+package activitypub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/contribsys/sparq"
+	"github.com/contribsys/sparq/model"
+	"github.com/pkg/errors"
+)
+
+var actorTypes = map[string]bool{
+	"Person":       true,
+	"Service":      true,
+	"Application":  true,
+	"Group":        true,
+	"Organization": true,
+}
+
+// ResolveURL fetches the remote actor or note at the URL, which may
+// be the object's page rather than its IRI. Notes are saved as toots.
+func ResolveURL(ctx context.Context, svr sparq.Server, url string) (*model.Actor, *model.Toot, error) {
+	data, err := fetchObject(ctx, svr, url)
+	if err != nil {
+		return nil, nil, err
+	}
+	var obj struct {
+		Id   string `json:"id"`
+		Type string `json:"type"`
+	}
+	err = json.Unmarshal(data, &obj)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Invalid object "+url)
+	}
+	if host(obj.Id) != host(url) {
+		return nil, nil, fmt.Errorf("Object %s is hosted elsewhere: %s", url, obj.Id)
+	}
+	switch {
+	case actorTypes[obj.Type]:
+		actor, err := FetchActor(ctx, svr, obj.Id)
+		return actor, nil, err
+	case noteTypes[obj.Type]:
+		note, err := FetchNote(ctx, svr, obj.Id)
+		if err != nil {
+			return nil, nil, err
+		}
+		toot, err := SaveRemoteNote(ctx, svr, note)
+		return nil, toot, err
+	}
+	return nil, nil, fmt.Errorf("Unable to resolve %s %s", obj.Type, url)
+}
@@ -0,0 +1,51 @@
+package activitypub
+
+import (
+	"context"
+	"testing"
+
+	"github.com/contribsys/sparq/activitystreams"
+	"github.com/contribsys/sparq/web"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveURL(t *testing.T) {
+	ts, stopper := web.NewTestServer(t, "resolve")
+	defer stopper()
+	ctx := context.Background()
+
+	bob := newRemoteActor(t)
+	note := map[string]any{
+		"id":           bob.URL + "/notes/1",
+		"type":         "Note",
+		"attributedTo": bob.IRI,
+		"content":      "<p>Found <b>me</b></p>",
+		"to":           []string{activitystreams.Public},
+	}
+	bob.Objects["/notes/1"] = note
+	// the note's HTML page serves the note when asked for JSON
+	bob.Objects["/@bob/1"] = note
+	bob.Objects["/elsewhere"] = map[string]any{"id": "https://mallory.example/notes/1", "type": "Note"}
+
+	actor, toot, err := ResolveURL(ctx, ts, bob.URL+"/@bob/1")
+	assert.NoError(t, err)
+	assert.Nil(t, actor)
+	assert.NotNil(t, toot)
+	if toot != nil {
+		assert.Equal(t, bob.URL+"/notes/1", toot.Uri)
+		assert.Equal(t, "Found me", toot.Text)
+	}
+
+	actor, toot, err = ResolveURL(ctx, ts, bob.IRI)
+	assert.NoError(t, err)
+	assert.Nil(t, toot)
+	assert.NotNil(t, actor)
+	if actor != nil {
+		assert.Equal(t, bob.IRI, actor.Id)
+	}
+
+	_, _, err = ResolveURL(ctx, ts, bob.URL+"/elsewhere")
+	assert.ErrorContains(t, err, "hosted elsewhere")
+	_, _, err = ResolveURL(ctx, ts, bob.URL+"/missing")
+	assert.ErrorIs(t, err, ErrGone)
+}
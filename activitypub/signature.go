@@ -0,0 +1,217 @@
+package activitypub
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/contribsys/sparq/util"
+	"github.com/pkg/errors"
+)
+
+/*
+This implements the "draft-cavage" HTTP Signatures used by Mastodon
+and most of the Fediverse:
+
+	Signature: keyId="https://example.com/users/bob#main-key",
+	  algorithm="rsa-sha256",
+	  headers="(request-target) host date digest",
+	  signature="Base64(RSA-SHA256(signing string))"
+
+https://datatracker.ietf.org/doc/html/draft-cavage-http-signatures-12
+*/
+
+var (
+	ErrNoSignature      = errors.New("Request is not signed")
+	ErrInvalidSignature = errors.New("Invalid signature")
+
+	// Remote servers and our clock will drift, Mastodon allows
+	// signatures up to 12 hours old.
+	MaxSignatureSkew = 12 * time.Hour
+
+	signedHeaders = []string{"(request-target)", "host", "date", "digest"}
+)
+
+type Signature struct {
+	KeyId     string
+	Algorithm string
+	Headers   []string
+	Signature []byte
+}
+
+// The ActivityPub actor which owns the signing key.
+func (s *Signature) Owner() string {
+	idx := strings.Index(s.KeyId, "#")
+	if idx == -1 {
+		return s.KeyId
+	}
+	return s.KeyId[:idx]
+}
+
+func ParseSignature(header string) (*Signature, error) {
+	if header == "" {
+		return nil, ErrNoSignature
+	}
+	sig := &Signature{
+		Algorithm: "rsa-sha256",
+		Headers:   []string{"date"},
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return nil, fmt.Errorf("Invalid signature element: %q", pair)
+		}
+		value = strings.Trim(value, `"`)
+		switch key {
+		case "keyId":
+			sig.KeyId = value
+		case "algorithm":
+			sig.Algorithm = value
+		case "headers":
+			sig.Headers = strings.Fields(strings.ToLower(value))
+		case "signature":
+			data, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return nil, errors.Wrap(err, "Invalid signature encoding")
+			}
+			sig.Signature = data
+		}
+	}
+	if sig.KeyId == "" || len(sig.Signature) == 0 {
+		return nil, errors.New("Signature requires keyId and signature")
+	}
+	return sig, nil
+}
+
+func signingString(r *http.Request, headers []string) (string, error) {
+	lines := make([]string, len(headers))
+	for idx, name := range headers {
+		var value string
+		switch name {
+		case "(request-target)":
+			value = strings.ToLower(r.Method) + " " + r.URL.RequestURI()
+		case "host":
+			value = r.Host
+			if value == "" {
+				value = r.URL.Host
+			}
+		default:
+			vals := r.Header.Values(name)
+			if len(vals) == 0 {
+				return "", fmt.Errorf("Signed header missing: %s", name)
+			}
+			value = strings.Join(vals, ", ")
+		}
+		lines[idx] = name + ": " + value
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func digest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return "SHA-256=" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// SignRequest adds Date, Digest and Signature headers to the request,
+// signing it with the given PEM-encoded private key. The body must be
+// the exact bytes sent with the request, or nil for a GET.
+func SignRequest(r *http.Request, keyId string, privateKey []byte, body []byte) error {
+	key, err := util.DecodePrivateKey(privateKey)
+	if err != nil {
+		return err
+	}
+	rsakey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return errors.New("Only RSA keys are supported")
+	}
+
+	if r.Host == "" {
+		r.Host = r.URL.Host
+	}
+	r.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	headers := signedHeaders
+	if body != nil {
+		r.Header.Set("Digest", digest(body))
+	} else {
+		headers = signedHeaders[:3]
+	}
+
+	str, err := signingString(r, headers)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256([]byte(str))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, rsakey, crypto.SHA256, sum[:])
+	if err != nil {
+		return errors.Wrap(err, "Unable to sign request")
+	}
+	r.Header.Set("Signature", fmt.Sprintf(`keyId="%s",algorithm="rsa-sha256",headers="%s",signature="%s"`,
+		keyId, strings.Join(headers, " "), base64.StdEncoding.EncodeToString(sig)))
+	return nil
+}
+
+// VerifyRequest checks the Signature and Digest headers of the request
+// against the given PEM-encoded public key.
+func VerifyRequest(r *http.Request, sig *Signature, publicKey []byte, body []byte) error {
+	if sig.Algorithm != "rsa-sha256" && sig.Algorithm != "hs2019" {
+		return fmt.Errorf("Unsupported signature algorithm: %s", sig.Algorithm)
+	}
+
+	required := signedHeaders[:3]
+	if body != nil {
+		required = signedHeaders
+	}
+	for _, name := range required {
+		if !contains(sig.Headers, name) {
+			return fmt.Errorf("Signature must include header: %s", name)
+		}
+	}
+
+	when, err := http.ParseTime(r.Header.Get("Date"))
+	if err != nil {
+		return errors.Wrap(err, "Invalid Date header")
+	}
+	skew := time.Since(when)
+	if skew > MaxSignatureSkew || skew < -MaxSignatureSkew {
+		return fmt.Errorf("Signature date outside of acceptable window: %s", when)
+	}
+
+	if body != nil && r.Header.Get("Digest") != digest(body) {
+		return errors.New("Digest does not match body")
+	}
+
+	key, err := util.DecodePublicKey(publicKey)
+	if err != nil {
+		return err
+	}
+	rsakey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return errors.New("Only RSA keys are supported")
+	}
+
+	str, err := signingString(r, sig.Headers)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256([]byte(str))
+	err = rsa.VerifyPKCS1v15(rsakey, crypto.SHA256, sum[:], sig.Signature)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
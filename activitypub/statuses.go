@@ -0,0 +1,277 @@
+package activitypub
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/contribsys/sparq"
+	"github.com/contribsys/sparq/activitystreams"
+	"github.com/contribsys/sparq/markup"
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/util"
+	"github.com/pkg/errors"
+)
+
+// RemoteNote is the subset of a remote Note which we store
+// in toots.
+type RemoteNote struct {
+	Id           string                `json:"id"`
+	Type         string                `json:"type"`
+	AttributedTo string                `json:"attributedTo"`
+	InReplyTo    string                `json:"inReplyTo"`
+	Name         string                `json:"name"`
+	Summary      string                `json:"summary"`
+	Content      string                `json:"content"`
+	ContentMap   map[string]string     `json:"contentMap"`
+	Published    time.Time             `json:"published"`
+	Updated      time.Time             `json:"updated"`
+	To           stringList            `json:"to"`
+	CC           stringList            `json:"cc"`
+	Tag          []activitystreams.Tag `json:"tag"`
+	Replies      json.RawMessage       `json:"replies"`
+
+	// Question
+	OneOf       []remoteOption `json:"oneOf"`
+	AnyOf       []remoteOption `json:"anyOf"`
+	EndTime     *time.Time     `json:"endTime"`
+	Closed      any            `json:"closed"`
+	VotersCount int64          `json:"votersCount"`
+}
+
+var noteTypes = map[string]bool{
+	"Note":     true,
+	"Article":  true,
+	"Page":     true,
+	"Question": true,
+}
+
+// Visibility maps the note's addressing to a toot visibility.
+func (rn *RemoteNote) Visibility(actor *model.Actor) model.PostVisibility {
+	for _, iri := range rn.To {
+		if iri == activitystreams.Public {
+			return model.VisPublic
+		}
+	}
+	for _, iri := range rn.CC {
+		if iri == activitystreams.Public {
+			return model.VisUnlisted
+		}
+	}
+	var person activitystreams.Object
+	_ = json.Unmarshal([]byte(actor.Properties), &person)
+	followers := person.Followers
+	if followers == "" {
+		followers = actor.Id + "/followers"
+	}
+	for _, iri := range append(rn.To, rn.CC...) {
+		if iri == followers {
+			return model.VisPrivate
+		}
+	}
+	return model.VisDirect
+}
+
+func handleCreate(ctx context.Context, svr sparq.Server, recipient string, in *Inbound) error {
+	if !noteTypes[in.ObjectType()] {
+		util.Debugf("Ignoring create of %s %s", in.ObjectType(), in.ObjectId())
+		return nil
+	}
+	var note RemoteNote
+	err := in.DecodeObject(&note)
+	if err != nil {
+		return errors.Wrap(err, "Invalid note "+in.ObjectId())
+	}
+	if note.AttributedTo != in.Actor {
+		util.Warnf("Ignoring note %s created by %s for %s", note.Id, in.Actor, note.AttributedTo)
+		return nil
+	}
+	// or else anyone could claim another server's note first
+	if host(note.Id) != host(in.Actor) {
+		util.Warnf("Ignoring note %s created by %s on another host", note.Id, in.Actor)
+		return nil
+	}
+	if note.isVote() {
+		return saveVote(ctx, svr, &note)
+	}
+	relevant, err := isRelevant(ctx, svr, &note)
+	if err != nil {
+		return err
+	}
+	if !relevant {
+		util.Debugf("Ignoring unsolicited note %s", note.Id)
+		return nil
+	}
+	_, err = SaveRemoteNote(ctx, svr, &note)
+	return err
+}
+
+// isRelevant is true if the note was sent by an actor which one
+// of our accounts follows, mentions one of our accounts or is a
+// reply to one of our toots. We don't store anything else.
+func isRelevant(ctx context.Context, svr sparq.Server, note *RemoteNote) (bool, error) {
+	if len(note.Mentioned()) > 0 {
+		return true, nil
+	}
+	var count int
+	err := svr.DB().GetContext(ctx, &count, `
+		select count(*) from actor_following
+		where TargetActorId = ? and State = ?`, note.AttributedTo, FollowAccepted)
+	if err != nil {
+		return false, errors.Wrap(err, "actor_following")
+	}
+	if count > 0 || note.InReplyTo == "" {
+		return count > 0, nil
+	}
+	err = svr.DB().GetContext(ctx, &count, `
+		select count(*) from toots where Uri = ? and AuthorId is not null`, note.InReplyTo)
+	if err != nil {
+		return false, errors.Wrap(err, "toots")
+	}
+	return count > 0, nil
+}
+
+// SaveRemoteNote stores a note from a remote actor as a toot,
+// returning the existing toot if we've already seen it.
+func SaveRemoteNote(ctx context.Context, svr sparq.Server, note *RemoteNote) (*model.Toot, error) {
+	var toot model.Toot
+	err := svr.DB().GetContext(ctx, &toot, "select * from toots where Uri = ?", note.Id)
+	if err == nil {
+		return &toot, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.Wrap(err, "toots")
+	}
+
+	actor, err := FetchActor(ctx, svr, note.AttributedTo)
+	if err != nil {
+		return nil, err
+	}
+	lang := "en"
+	for code := range note.ContentMap {
+		lang = code
+		break
+	}
+	published := note.Published
+	if published.IsZero() {
+		published = time.Now()
+	}
+	var inReplyTo *string
+	if note.InReplyTo != "" {
+		inReplyTo = &note.InReplyTo
+	}
+
+	content := markup.Sanitize(note.Content)
+
+	sid := model.Snowflakes.NextSID()
+	tx, err := svr.DB().BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.ExecContext(ctx, `
+		insert into toots (Sid, Uri, InReplyTo, ActorId, Summary, Content, Text, Lang, Visibility, CreatedAt)
+		values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		sid, note.Id, inReplyTo, actor.MastodonId, note.Summary, content, markup.Text(content), lang,
+		note.Visibility(actor), published.UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, errors.Wrap(err, "Unable to save note "+note.Id)
+	}
+	poll, err := saveRemotePoll(ctx, tx, sid, note)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	err = SaveMentions(ctx, tx, sid, note.allMentioned())
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	for _, tag := range note.Tag {
+		if tag.Type != activitystreams.TagHashtag {
+			continue
+		}
+		name := strings.ToLower(strings.TrimPrefix(tag.Name, "#"))
+		if name == "" {
+			continue
+		}
+		_, err = tx.ExecContext(ctx, "insert into toot_tags (Sid, Tag) values (?, ?)", sid, name)
+		if err != nil {
+			_ = tx.Rollback()
+			return nil, errors.Wrap(err, "toot_tags")
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	err = svr.DB().GetContext(ctx, &toot, "select * from toots where Sid = ?", sid)
+	if err != nil {
+		return nil, errors.Wrap(err, "toots")
+	}
+	if poll != nil {
+		err = SchedulePollExpiry(ctx, svr, poll)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = SaveEmojis(ctx, svr, note.AttributedTo, note.Tag)
+	if err != nil {
+		return nil, err
+	}
+	err = JoinConversation(ctx, svr, &toot)
+	if err != nil {
+		return nil, err
+	}
+	err = NotifyMentioned(ctx, svr, note.AttributedTo, note.Id, note.Mentioned())
+	if err != nil {
+		return nil, err
+	}
+	Published(ctx, svr, &toot)
+	return &toot, nil
+}
+
+// Mentioned returns the local accounts which the note mentions
+// or is addressed to.
+func (rn *RemoteNote) Mentioned() []string {
+	seen := map[string]bool{}
+	iris := []string{}
+	add := func(iri string) {
+		if _, ok := model.LocalNick(iri); ok && !seen[iri] {
+			seen[iri] = true
+			iris = append(iris, iri)
+		}
+	}
+	for _, iri := range rn.To {
+		add(iri)
+	}
+	for _, iri := range rn.CC {
+		add(iri)
+	}
+	for _, tag := range rn.Tag {
+		if tag.Type == activitystreams.TagMention {
+			add(tag.HRef)
+		}
+	}
+	return iris
+}
+
+// allMentioned returns the local accounts the note mentions or is
+// addressed to, along with any remote actors it mentions.
+func (rn *RemoteNote) allMentioned() []string {
+	iris := rn.Mentioned()
+	seen := map[string]bool{}
+	for _, iri := range iris {
+		seen[iri] = true
+	}
+	for _, tag := range rn.Tag {
+		if tag.Type == activitystreams.TagMention && tag.HRef != "" && !seen[tag.HRef] {
+			seen[tag.HRef] = true
+			iris = append(iris, tag.HRef)
+		}
+	}
+	return iris
+}
@@ -0,0 +1,166 @@
+package activitypub
+
+import (
+	"context"
+	"testing"
+
+	"github.com/contribsys/sparq/activitystreams"
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/web"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreate(t *testing.T) {
+	ts, stopper := web.NewTestServer(t, "statuses")
+	defer stopper()
+	jobs := ts.Jobs().(*web.TestJobs)
+	Register(ts)
+	ctx := context.Background()
+
+	root := mux.NewRouter()
+	root.HandleFunc("/inbox", InboxHandler(ts))
+	bob := newRemoteActor(t)
+
+	admin, err := localAccount(ctx, ts, 1)
+	assert.NoError(t, err)
+
+	create := func(id string, note map[string]any) {
+		note["id"] = id
+		note["type"] = "Note"
+		note["attributedTo"] = bob.IRI
+		activity := map[string]any{
+			"id":     id + "/activity",
+			"type":   "Create",
+			"actor":  bob.IRI,
+			"object": note,
+		}
+		w := bob.post(t, root, "/inbox", activity)
+		assert.Equal(t, 202, w.Code, w.Body.String())
+		assert.NoError(t, jobs.Drain(ctx))
+	}
+	find := func(uri string) *model.Toot {
+		var toot model.Toot
+		err := ts.DB().GetContext(ctx, &toot, "select * from toots where Uri = ?", uri)
+		if err != nil {
+			return nil
+		}
+		return &toot
+	}
+
+	t.Run("Unsolicited", func(t *testing.T) {
+		create(bob.IRI+"/notes/1", map[string]any{
+			"content": "Nobody follows me",
+			"to":      activitystreams.Public,
+		})
+		assert.Nil(t, find(bob.IRI+"/notes/1"))
+	})
+
+	t.Run("Followed", func(t *testing.T) {
+		_, err := ts.DB().Exec(`
+			insert into actor_following (Id, ActorId, TargetActorId, TargetActorAccount, State)
+			values (?, ?, ?, 'bob@remote', ?)`, admin.IRI()+"#follows/1", admin.IRI(), bob.IRI, FollowAccepted)
+		assert.NoError(t, err)
+
+		create(bob.IRI+"/notes/2", map[string]any{
+			"content":   "Hello #World",
+			"published": "2030-01-02T03:04:05Z",
+			"to":        []string{activitystreams.Public},
+			"cc":        []string{bob.IRI + "/followers"},
+			"contentMap": map[string]string{
+				"de": "Hello #World",
+			},
+			"tag": []map[string]any{{"type": "Hashtag", "name": "#World"}},
+		})
+		toot := find(bob.IRI + "/notes/2")
+		assert.NotNil(t, toot)
+		if toot == nil {
+			return
+		}
+		assert.Nil(t, toot.AuthorId)
+		assert.Equal(t, "Hello #World", toot.Content)
+		assert.Equal(t, "de", toot.Lang)
+		assert.Equal(t, model.VisPublic, toot.Visibility)
+		assert.Equal(t, "2030-01-02 03:04:05", toot.CreatedAt.UTC().Format("2006-01-02 15:04:05"))
+
+		var actor model.Actor
+		assert.NoError(t, ts.DB().Get(&actor, "select * from actors where Id = ?", bob.IRI))
+		assert.EqualValues(t, actor.MastodonId, toot.ActorId)
+
+		var tags []string
+		assert.NoError(t, ts.DB().Select(&tags, "select Tag from toot_tags where Sid = ?", toot.Sid))
+		assert.Equal(t, []string{"world"}, tags)
+
+		create(bob.IRI+"/notes/3", map[string]any{
+			"content": "Followers only",
+			"to":      bob.IRI + "/followers",
+		})
+		toot = find(bob.IRI + "/notes/3")
+		assert.NotNil(t, toot)
+		if toot != nil {
+			assert.Equal(t, model.VisPrivate, toot.Visibility)
+		}
+
+		// redelivery doesn't duplicate the toot
+		create(bob.IRI+"/notes/3", map[string]any{
+			"content": "Followers only",
+			"to":      bob.IRI + "/followers",
+		})
+		var count int
+		assert.NoError(t, ts.DB().Get(&count, "select count(*) from toots where Uri = ?", bob.IRI+"/notes/3"))
+		assert.Equal(t, 1, count)
+	})
+
+	t.Run("Mentioned", func(t *testing.T) {
+		_, err := ts.DB().Exec("delete from actor_following")
+		assert.NoError(t, err)
+
+		create(bob.IRI+"/notes/4", map[string]any{
+			"content": `<p onclick="steal()">Hi <script>steal()</script>@admin</p>`,
+			"to":      admin.IRI(),
+		})
+		toot := find(bob.IRI + "/notes/4")
+		assert.NotNil(t, toot)
+		if toot != nil {
+			assert.Equal(t, model.VisDirect, toot.Visibility)
+			assert.Equal(t, "<p>Hi @admin</p>", toot.Content)
+			mentioned, err := Mentions(ctx, ts, toot.Sid)
+			assert.NoError(t, err)
+			assert.Equal(t, []string{admin.IRI()}, mentioned)
+		}
+		assert.Equal(t, 1, notifications(t, ts, NotifyMention))
+	})
+
+	t.Run("Forged", func(t *testing.T) {
+		activity := map[string]any{
+			"id":    bob.IRI + "/notes/5/activity",
+			"type":  "Create",
+			"actor": bob.IRI,
+			"object": map[string]any{
+				"id":           "https://elsewhere.example/notes/5",
+				"type":         "Note",
+				"attributedTo": "https://elsewhere.example/users/eve",
+				"content":      "Not from bob",
+				"to":           admin.IRI(),
+			},
+		}
+		w := bob.post(t, root, "/inbox", activity)
+		assert.Equal(t, 202, w.Code, w.Body.String())
+		assert.NoError(t, jobs.Drain(ctx))
+		assert.Nil(t, find("https://elsewhere.example/notes/5"))
+
+		// bob can't claim another server's note either
+		activity["id"] = bob.IRI + "/notes/6/activity"
+		activity["object"] = map[string]any{
+			"id":           "https://elsewhere.example/notes/6",
+			"type":         "Note",
+			"attributedTo": bob.IRI,
+			"content":      "Squatting",
+			"to":           admin.IRI(),
+		}
+		w = bob.post(t, root, "/inbox", activity)
+		assert.Equal(t, 202, w.Code, w.Body.String())
+		assert.NoError(t, jobs.Drain(ctx))
+		assert.Nil(t, find("https://elsewhere.example/notes/6"))
+	})
+}
@@ -0,0 +1,233 @@
+package activitypub
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/sparq"
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/util"
+	"github.com/pkg/errors"
+)
+
+var (
+	// The most ancestors of a toot which we fetch.
+	MaxThreadDepth = 20
+	// The most replies of a remote toot which we fetch.
+	MaxThreadReplies = 40
+	// The replies of a remote toot are fetched no more often than this.
+	RepliesTTL = time.Hour
+
+	// The most collection pages we follow when fetching replies.
+	maxReplyPages = 5
+)
+
+// ResolveThread queues a background fetch of the toot's missing
+// ancestors and, for remote toots, its replies.
+func ResolveThread(ctx context.Context, svr sparq.Server, toot *model.Toot) error {
+	stale := toot.AuthorId == nil &&
+		(toot.RepliesFetchedAt == nil || time.Since(*toot.RepliesFetchedAt) > RepliesTTL)
+	if !stale {
+		missing, err := missingParent(ctx, svr, toot)
+		if err != nil || !missing {
+			return err
+		}
+	}
+	return svr.Jobs().Push(ctx, client.NewJob("FetchThread", toot.Sid))
+}
+
+// missingParent is true if the toot is a reply to a remote toot
+// which we haven't seen.
+func missingParent(ctx context.Context, svr sparq.Server, toot *model.Toot) (bool, error) {
+	if toot.InReplyTo == nil || isLocal(svr, *toot.InReplyTo) {
+		return false, nil
+	}
+	var count int
+	err := svr.DB().GetContext(ctx, &count, "select count(*) from toots where Uri = ?", *toot.InReplyTo)
+	if err != nil {
+		return false, errors.Wrap(err, "toots")
+	}
+	return count == 0, nil
+}
+
+func isLocal(svr sparq.Server, iri string) bool {
+	return strings.HasPrefix(iri, "https://"+svr.Hostname()+"/")
+}
+
+// fetchThread is the job which fetches the ancestors and replies
+// of the toot. Only direct replies are fetched, their replies are
+// fetched when they are viewed.
+func fetchThread(ctx context.Context, svr sparq.Server, sid string) error {
+	var toot model.Toot
+	err := svr.DB().GetContext(ctx, &toot, "select * from toots where Sid = ? and DeletedAt is null", sid)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return errors.Wrap(err, "toots")
+	}
+	err = fetchAncestors(ctx, svr, &toot)
+	if err != nil {
+		return err
+	}
+	if toot.AuthorId != nil {
+		return nil
+	}
+	return fetchReplies(ctx, svr, &toot)
+}
+
+// fetchAncestors walks up the thread, fetching any remote toots
+// which we haven't seen.
+func fetchAncestors(ctx context.Context, svr sparq.Server, toot *model.Toot) error {
+	uri := toot.InReplyTo
+	for depth := 0; uri != nil && depth < MaxThreadDepth; depth++ {
+		var parent model.Toot
+		err := svr.DB().GetContext(ctx, &parent, "select * from toots where Uri = ?", *uri)
+		if err == nil {
+			uri = parent.InReplyTo
+			continue
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return errors.Wrap(err, "toots")
+		}
+		if isLocal(svr, *uri) {
+			return nil
+		}
+		note, err := FetchNote(ctx, svr, *uri)
+		if err != nil {
+			if errors.Is(err, ErrGone) {
+				return nil
+			}
+			return err
+		}
+		saved, err := SaveRemoteNote(ctx, svr, note)
+		if err != nil {
+			return err
+		}
+		uri = saved.InReplyTo
+	}
+	return nil
+}
+
+// fetchReplies fetches the replies collection of the remote toot
+// and saves any replies which we haven't seen.
+func fetchReplies(ctx context.Context, svr sparq.Server, toot *model.Toot) error {
+	note, err := FetchNote(ctx, svr, toot.Uri)
+	if err != nil {
+		if errors.Is(err, ErrGone) {
+			return nil
+		}
+		return err
+	}
+	iris, err := collectionItems(ctx, svr, note.Replies, MaxThreadReplies)
+	if err != nil {
+		return err
+	}
+	for _, iri := range iris {
+		if isLocal(svr, iri) {
+			continue
+		}
+		var count int
+		err := svr.DB().GetContext(ctx, &count, "select count(*) from toots where Uri = ?", iri)
+		if err != nil {
+			return errors.Wrap(err, "toots")
+		}
+		if count > 0 {
+			continue
+		}
+		reply, err := FetchNote(ctx, svr, iri)
+		if err == nil {
+			_, err = SaveRemoteNote(ctx, svr, reply)
+		}
+		if err != nil {
+			// one broken reply shouldn't prevent us from fetching the rest
+			util.Warnf("Unable to fetch reply %s: %v", iri, err)
+		}
+	}
+	_, err = svr.DB().ExecContext(ctx,
+		"update toots set RepliesFetchedAt = ? where Sid = ?", time.Now().UTC().Format("2006-01-02 15:04:05"), toot.Sid)
+	return errors.Wrap(err, "toots")
+}
+
+// FetchNote fetches the remote note. The note must be hosted
+// by its author's server.
+func FetchNote(ctx context.Context, svr sparq.Server, iri string) (*RemoteNote, error) {
+	data, err := fetchObject(ctx, svr, iri)
+	if err != nil {
+		return nil, err
+	}
+	var note RemoteNote
+	err = json.Unmarshal(data, &note)
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid note "+iri)
+	}
+	if note.Id != iri {
+		return nil, fmt.Errorf("Note ID mismatch: %s != %s", note.Id, iri)
+	}
+	if !noteTypes[note.Type] {
+		return nil, fmt.Errorf("Unexpected %s %s", note.Type, iri)
+	}
+	if host(note.Id) != host(note.AttributedTo) {
+		return nil, fmt.Errorf("Note %s attributed to foreign actor %s", note.Id, note.AttributedTo)
+	}
+	return &note, nil
+}
+
+func host(iri string) string {
+	u, err := url.Parse(iri)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// collectionItems returns the IRIs of up to max items in the
+// collection, following its pages. The collection and its pages
+// may be embedded or referenced by IRI.
+func collectionItems(ctx context.Context, svr sparq.Server, data json.RawMessage, max int) ([]string, error) {
+	iris := []string{}
+	for page := 0; len(data) > 0 && page < maxReplyPages; page++ {
+		var iri string
+		if json.Unmarshal(data, &iri) == nil {
+			if iri == "" {
+				break
+			}
+			fetched, err := fetchObject(ctx, svr, iri)
+			if err != nil {
+				return nil, err
+			}
+			data = fetched
+		}
+		var coll struct {
+			Items        []json.RawMessage `json:"items"`
+			OrderedItems []json.RawMessage `json:"orderedItems"`
+			First        json.RawMessage   `json:"first"`
+			Next         json.RawMessage   `json:"next"`
+		}
+		err := json.Unmarshal(data, &coll)
+		if err != nil {
+			return nil, errors.Wrap(err, "Invalid collection")
+		}
+		for _, item := range append(coll.Items, coll.OrderedItems...) {
+			if id := referenceId(item); id != "" {
+				iris = append(iris, id)
+			}
+			if len(iris) >= max {
+				return iris, nil
+			}
+		}
+		// a collection links to its first page, a page to the next page
+		if len(coll.First) > 0 {
+			data = coll.First
+		} else {
+			data = coll.Next
+		}
+	}
+	return iris, nil
+}
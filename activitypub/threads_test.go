@@ -0,0 +1,103 @@
+package activitypub
+
+import (
+	"context"
+	"testing"
+
+	"github.com/contribsys/sparq/activitystreams"
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/web"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThreads(t *testing.T) {
+	ts, stopper := web.NewTestServer(t, "threads")
+	defer stopper()
+	jobs := ts.Jobs().(*web.TestJobs)
+	Register(ts)
+	ctx := context.Background()
+
+	bob := newRemoteActor(t)
+	note := func(id, inReplyTo string) map[string]any {
+		obj := map[string]any{
+			"id":           bob.URL + id,
+			"type":         "Note",
+			"attributedTo": bob.IRI,
+			"content":      "Note " + id,
+			"to":           []string{activitystreams.Public},
+		}
+		if inReplyTo != "" {
+			obj["inReplyTo"] = bob.URL + inReplyTo
+		}
+		return obj
+	}
+	root := note("/notes/1", "")
+	root["replies"] = map[string]any{
+		"id":   bob.URL + "/notes/1/replies",
+		"type": "Collection",
+		"first": map[string]any{
+			"type":  "CollectionPage",
+			"next":  bob.URL + "/notes/1/replies/2",
+			"items": []string{bob.URL + "/notes/2"},
+		},
+	}
+	bob.Objects["/notes/1"] = root
+	bob.Objects["/notes/2"] = note("/notes/2", "/notes/1")
+	bob.Objects["/notes/3"] = note("/notes/3", "/notes/2")
+	bob.Objects["/notes/4"] = note("/notes/4", "/notes/1")
+	forged := note("/notes/5", "/notes/1")
+	forged["attributedTo"] = "https://mallory.example/users/mallory"
+	bob.Objects["/notes/5"] = forged
+	bob.Objects["/notes/1/replies/2"] = map[string]any{
+		"type":  "CollectionPage",
+		"items": []any{map[string]any{"id": bob.URL + "/notes/4"}, bob.URL + "/notes/5"},
+	}
+
+	find := func(id string) *model.Toot {
+		var toot model.Toot
+		err := ts.DB().GetContext(ctx, &toot, "select * from toots where Uri = ?", bob.URL+id)
+		if err != nil {
+			return nil
+		}
+		return &toot
+	}
+
+	t.Run("Ancestors", func(t *testing.T) {
+		rn, err := FetchNote(ctx, ts, bob.URL+"/notes/3")
+		assert.NoError(t, err)
+		toot, err := SaveRemoteNote(ctx, ts, rn)
+		assert.NoError(t, err)
+		assert.Nil(t, find("/notes/2"))
+
+		assert.NoError(t, ResolveThread(ctx, ts, toot))
+		assert.Equal(t, 1, len(jobs.Find("FetchThread")))
+		assert.NoError(t, jobs.Drain(ctx))
+
+		parent := find("/notes/2")
+		assert.NotNil(t, parent)
+		assert.Equal(t, bob.URL+"/notes/1", *parent.InReplyTo)
+		assert.NotNil(t, find("/notes/1"))
+	})
+
+	t.Run("Replies", func(t *testing.T) {
+		toot := find("/notes/1")
+		assert.NotNil(t, toot)
+		assert.Nil(t, toot.RepliesFetchedAt)
+		assert.NoError(t, ResolveThread(ctx, ts, toot))
+		assert.NoError(t, jobs.Drain(ctx))
+
+		assert.NotNil(t, find("/notes/4"))
+		assert.Nil(t, find("/notes/5"))
+
+		toot = find("/notes/1")
+		assert.NotNil(t, toot.RepliesFetchedAt)
+		assert.NoError(t, ResolveThread(ctx, ts, toot))
+		assert.Empty(t, jobs.Pushed)
+	})
+
+	t.Run("Mismatch", func(t *testing.T) {
+		bob.Objects["/notes/6"] = note("/notes/7", "")
+		_, err := FetchNote(ctx, ts, bob.URL+"/notes/6")
+		assert.Error(t, err)
+	})
+}
@@ -31,6 +31,79 @@ type FollowActivity struct {
 	Object    string    `json:"object"`
 }
 
+// WrappedActivity is an activity whose object is another activity,
+// e.g. Accept{Follow} or Undo{Follow}.
+type WrappedActivity struct {
+	BaseObject
+	Actor  string      `json:"actor"`
+	To     []string    `json:"to,omitempty"`
+	Object interface{} `json:"object"`
+}
+
+func NewAcceptActivity(actorIRI string, o interface{}) *WrappedActivity {
+	return newWrappedActivity("Accept", actorIRI, o)
+}
+
+func NewRejectActivity(actorIRI string, o interface{}) *WrappedActivity {
+	return newWrappedActivity("Reject", actorIRI, o)
+}
+
+func NewUndoActivity(actorIRI string, o interface{}) *WrappedActivity {
+	return newWrappedActivity("Undo", actorIRI, o)
+}
+
+func newWrappedActivity(activityType, actorIRI string, o interface{}) *WrappedActivity {
+	a := WrappedActivity{
+		BaseObject: BaseObject{
+			Context: []interface{}{
+				Namespace,
+			},
+			Type: activityType,
+		},
+		Actor:  actorIRI,
+		Object: o,
+	}
+	return &a
+}
+
+// ReferenceActivity refers to its object by IRI,
+// e.g. Like{Note} or Announce{Note}.
+type ReferenceActivity struct {
+	BaseObject
+	Actor     string    `json:"actor"`
+	Published time.Time `json:"published,omitempty"`
+	To        []string  `json:"to,omitempty"`
+	CC        []string  `json:"cc,omitempty"`
+	Object    string    `json:"object"`
+}
+
+func NewLikeActivity(actorIRI, objectIRI string) *ReferenceActivity {
+	return newReferenceActivity("Like", actorIRI, objectIRI)
+}
+
+func NewAnnounceActivity(actorIRI, objectIRI string) *ReferenceActivity {
+	return newReferenceActivity("Announce", actorIRI, objectIRI)
+}
+
+func NewBlockActivity(actorIRI, targetIRI string) *ReferenceActivity {
+	return newReferenceActivity("Block", actorIRI, targetIRI)
+}
+
+func newReferenceActivity(activityType, actorIRI, objectIRI string) *ReferenceActivity {
+	a := ReferenceActivity{
+		BaseObject: BaseObject{
+			Context: []interface{}{
+				Namespace,
+			},
+			Type: activityType,
+		},
+		Actor:     actorIRI,
+		Published: time.Now().UTC(),
+		Object:    objectIRI,
+	}
+	return &a
+}
+
 func NewCreateActivity(o *Object) *Activity {
 	a := Activity{
 		BaseObject: BaseObject{
@@ -98,6 +171,7 @@ func NewFollowActivity(actorIRI, followeeIRI string) *FollowActivity {
 type Object struct {
 	BaseObject
 	Published    time.Time         `json:"published,omitempty"`
+	Updated      *time.Time        `json:"updated,omitempty"`
 	Summary      *string           `json:"summary,omitempty"`
 	InReplyTo    *string           `json:"inReplyTo,omitempty"`
 	URL          string            `json:"url"`
@@ -110,6 +184,17 @@ type Object struct {
 	Tag          []Tag             `json:"tag,omitempty"`
 	Attachment   []Attachment      `json:"attachment,omitempty"`
 
+	// Question
+	OneOf       []QuestionOption `json:"oneOf,omitempty"`
+	AnyOf       []QuestionOption `json:"anyOf,omitempty"`
+	EndTime     *time.Time       `json:"endTime,omitempty"`
+	Closed      *time.Time       `json:"closed,omitempty"`
+	VotersCount *int64           `json:"votersCount,omitempty"`
+
+	// Tombstone
+	FormerType string     `json:"formerType,omitempty"`
+	Deleted    *time.Time `json:"deleted,omitempty"`
+
 	// Person
 	Inbox             string     `json:"inbox,omitempty"`
 	Outbox            string     `json:"outbox,omitempty"`
@@ -117,6 +202,8 @@ type Object struct {
 	Followers         string     `json:"followers,omitempty"`
 	PreferredUsername string     `json:"preferredUsername,omitempty"`
 	Icon              *Image     `json:"icon,omitempty"`
+	Image             *Image     `json:"image,omitempty"`
+	ManuallyApproves  bool       `json:"manuallyApprovesFollowers,omitempty"`
 	PublicKey         *PublicKey `json:"publicKey,omitempty"`
 	Endpoints         *Endpoints `json:"endpoints,omitempty"`
 }
@@ -145,6 +232,19 @@ func NewArticleObject() *Object {
 	return &o
 }
 
+// NewTombstoneObject marks the object with the given IRI as deleted.
+func NewTombstoneObject(id, formerType string, deleted time.Time) *Object {
+	o := Object{
+		BaseObject: BaseObject{
+			ID:   id,
+			Type: "Tombstone",
+		},
+		FormerType: formerType,
+		Deleted:    &deleted,
+	}
+	return &o
+}
+
 func NewPersonObject() *Object {
 	o := Object{
 		BaseObject: BaseObject{
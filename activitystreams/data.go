@@ -20,6 +20,19 @@ type (
 		SharedInbox string `json:"sharedInbox,omitempty"`
 	}
 
+	// QuestionOption is one of the choices of a Question, the
+	// replies count the votes for it.
+	QuestionOption struct {
+		Type    string     `json:"type"`
+		Name    string     `json:"name"`
+		Replies Collection `json:"replies"`
+	}
+
+	Collection struct {
+		Type       string `json:"type"`
+		TotalItems int64  `json:"totalItems"`
+	}
+
 	Image struct {
 		Type      string `json:"type"`
 		MediaType string `json:"mediaType"`
@@ -30,7 +43,7 @@ type (
 type OrderedCollection struct {
 	BaseObject
 	TotalItems int    `json:"totalItems"`
-	First      string `json:"first"`
+	First      string `json:"first,omitempty"`
 	Last       string `json:"last,omitempty"`
 }
 
@@ -73,3 +86,11 @@ func NewOrderedCollectionPage(accountRoot, collType string, items, page int) *Or
 	}
 	return &ocp
 }
+
+func NewQuestionOption(name string, votes int64) QuestionOption {
+	return QuestionOption{
+		Type:    "Note",
+		Name:    name,
+		Replies: Collection{Type: "Collection", TotalItems: votes},
+	}
+}
@@ -7,12 +7,38 @@ type Person struct {
 	PreferredUsername string    `json:"preferredUsername"`
 	URL               string    `json:"url"`
 	Name              string    `json:"name"`
-	Icon              Image     `json:"icon"`
+	Icon              *Image    `json:"icon,omitempty"`
+	Image             *Image    `json:"image,omitempty"`
 	Following         string    `json:"following"`
 	Followers         string    `json:"followers"`
 	Summary           string    `json:"summary"`
+	ManuallyApproves  bool      `json:"manuallyApprovesFollowers"`
+	Discoverable      bool      `json:"discoverable"`
 	PublicKey         PublicKey `json:"publicKey"`
 	Endpoints         Endpoints `json:"endpoints"`
+	Tag               []Tag     `json:"tag,omitempty"`
+
+	// Profile metadata
+	Attachment []PropertyValue `json:"attachment,omitempty"`
+}
+
+// PropertyValue is a name and value pair of profile metadata,
+// as used by Mastodon.
+type PropertyValue struct {
+	Type  string `json:"type"`
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+func NewPropertyValue(name, value string) PropertyValue {
+	return PropertyValue{Type: "PropertyValue", Name: name, Value: value}
+}
+
+// NewUpdatePersonActivity announces changes to the person's profile.
+func NewUpdatePersonActivity(p *Person) *WrappedActivity {
+	a := newWrappedActivity("Update", p.ID, p)
+	a.To = []string{Public}
+	return a
 }
 
 func NewPerson(accountRoot string) *Person {
@@ -1,9 +1,16 @@
 package activitystreams
 
+import "time"
+
 type Tag struct {
 	Type TagType `json:"type"`
-	HRef string  `json:"href"`
+	HRef string  `json:"href,omitempty"`
 	Name string  `json:"name"`
+
+	// Emoji
+	ID      string     `json:"id,omitempty"`
+	Updated *time.Time `json:"updated,omitempty"`
+	Icon    *Image     `json:"icon,omitempty"`
 }
 
 type TagType string
@@ -11,4 +18,5 @@ type TagType string
 const (
 	TagHashtag TagType = "Hashtag"
 	TagMention TagType = "Mention"
+	TagEmoji   TagType = "Emoji"
 )
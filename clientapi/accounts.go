@@ -1,16 +1,23 @@
 package clientapi
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"net/http"
-	"text/template"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/contribsys/sparq"
+	"github.com/contribsys/sparq/activitypub"
+	"github.com/contribsys/sparq/activitystreams"
+	"github.com/contribsys/sparq/db"
+	"github.com/contribsys/sparq/markup"
 	"github.com/contribsys/sparq/model"
 	"github.com/contribsys/sparq/util"
-	"github.com/contribsys/sparq/web"
 	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
 )
 
@@ -29,80 +36,25 @@ import (
 // POST https://mastodon.example/api/v1/accounts/:id/unmute
 // GET https://mastodon.example/api/v1/accounts/relationships
 // GET https://mastodon.example/api/v1/accounts/search
+// GET https://mastodon.example/api/v1/accounts/lookup
+// GET https://mastodon.example/api/v1/accounts/familiar_followers
 
 func verifyCredentialsHandler(s sparq.Server) http.HandlerFunc {
-	x := template.New("accountCredential")
-	x.Funcs(map[string]any{"rfc3339": util.Thens})
-	accountCredentialTemplate := template.Must(x.Parse(accountCredentialText))
-
 	return func(w http.ResponseWriter, r *http.Request) {
-		webctx := web.Ctx(r)
-		token := webctx.BearerCode
-		if token == "" {
-			httpError(w, errors.New("No access token supplied"), 401)
-			return
-		}
-
-		var acct model.Account
-		err := s.DB().Get(&acct, `
-		  select a.*, ap.* from accounts a
-		  join account_profiles ap on a.id = ap.accountid 
-		  join oauth_tokens ot on a.id = ot.accountid 
-			where ot.access = ?`, token)
-		if err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				httpJsonResponse(w, map[string]interface{}{"error": "Token not found, please re-authenticate"}, http.StatusUnauthorized)
-				return
-			}
-			httpError(w, err, http.StatusInternalServerError)
+		acct, err := currentAccount(s, r)
+		if err != nil {
+			httpJsonResponse(w, map[string]interface{}{"error": "Token not found, please re-authenticate"}, http.StatusUnauthorized)
 			return
 		}
-
-		// render JSON template
-		w.Header().Add("Content-Type", "application/json")
-		err = accountCredentialTemplate.Execute(w, &acct)
+		attrs, err := credentialAccount(r.Context(), s, acct)
 		if err != nil {
-			util.Error("Unable to execute template", err)
 			httpError(w, err, http.StatusInternalServerError)
 			return
 		}
+		httpJson(w, attrs)
 	}
 }
 
-var (
-	accountCredentialText = `
-{
-  "id": "{{.Id}}",
-  "username": "{{.Nick}}",
-  "acct": "{{.Nick}}",
-  "display_name": "{{.FullName}}",
-  "locked": false,
-  "bot": false,
-  "created_at": "{{.Created}}",
-  "note": "",
-  "url": "{{.URI}}",
-  "avatar": "{{.Avatar}}",
-  "avatar_static": "{{.Avatar}}",
-  "header": "{{.Header}}",
-  "header_static": "{{.Header}}",
-  "followers_count": 0,
-  "following_count": 0,
-  "statuses_count": 0,
-  "last_status_at": "{{.Created}}",
-  "source": {
-    "privacy": "public",
-    "sensitive": false,
-    "language": "en",
-    "note": "",
-    "fields": [],
-    "follow_requests_count": 0
-  },
-  "emojis": [],
-  "fields": []
-}
-`
-)
-
 // TODO
 func getAccountToots(s sparq.Server) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -145,28 +97,465 @@ func getAccountToots(s sparq.Server) http.HandlerFunc {
 	}
 }
 
-func getAccount(s sparq.Server) http.HandlerFunc {
+func accountHandler(s sparq.Server) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		sfid := mux.Vars(r)["sfid"]
-		// fmt.Printf("Hello %s\n", sfid)
+		iri, err := actorIRI(r.Context(), s, mux.Vars(r)["id"])
+		if err != nil {
+			listError(w, err)
+			return
+		}
+		attrs, err := AccountMap(r.Context(), s.DB(), iri)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		httpJson(w, attrs)
+	}
+}
 
-		userdata := map[string]interface{}{}
-		err := s.DB().QueryRowx("select * from users where sfid = ?", sfid).MapScan(userdata)
-		if err == sql.ErrNoRows {
-			httpError(w, err, http.StatusNotFound)
+// GET /api/v1/accounts/:id/followers
+func followersHandler(s sparq.Server) http.HandlerFunc {
+	return networkHandler(s, "select rowid as Id, ActorId as Iri from actor_following where TargetActorId = ? and State = ?")
+}
+
+// GET /api/v1/accounts/:id/following
+func followingHandler(s sparq.Server) http.HandlerFunc {
+	return networkHandler(s, "select rowid as Id, TargetActorId as Iri from actor_following where ActorId = ? and State = ?")
+}
+
+// networkHandler pages through the accepted follows of or by the
+// account. Like the ActivityPub collections, local accounts which
+// aren't public only show them to themselves.
+func networkHandler(s sparq.Server, query string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		iri, err := actorIRI(r.Context(), s, mux.Vars(r)["id"])
+		if err != nil {
+			listError(w, err)
+			return
+		}
+		if nick, ok := model.LocalNick(iri); ok {
+			var vis model.AccountVisibility
+			err = s.DB().GetContext(r.Context(), &vis, "select Visibility from accounts where Nick = ?", nick)
+			if err != nil {
+				httpError(w, errors.Wrap(err, "accounts"), http.StatusInternalServerError)
+				return
+			}
+			if vis != model.Public && viewerIRI(s, r) != iri {
+				httpJson(w, []any{})
+				return
+			}
+		}
+		rows := []struct {
+			Id  int64
+			Iri string
+		}{}
+		err = selectPage(r, s, &rows, query, iri, activitypub.FollowAccepted)
+		if err != nil {
+			httpError(w, err, http.StatusBadRequest)
+			return
+		}
+		results := []map[string]any{}
+		for _, row := range rows {
+			attrs, err := AccountMap(r.Context(), s.DB(), row.Iri)
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+			results = append(results, attrs)
+		}
+		if len(rows) > 0 {
+			linkHeader(w, r, strconv.FormatInt(rows[len(rows)-1].Id, 10), strconv.FormatInt(rows[0].Id, 10))
+		}
+		httpJson(w, results)
+	}
+}
+
+// GET /api/v1/accounts/relationships?id[]=1&id[]=2
+func relationshipsHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		acct, err := currentAccount(s, r)
+		if err != nil {
+			httpError(w, err, http.StatusUnauthorized)
+			return
+		}
+		err = r.ParseForm()
+		if err != nil {
+			httpError(w, err, http.StatusBadRequest)
+			return
+		}
+		results := []map[string]any{}
+		for _, id := range accountIds(r) {
+			iri, err := actorIRI(r.Context(), s, id)
+			if err != nil {
+				// unknown accounts are left out
+				if errors.Is(err, sql.ErrNoRows) {
+					continue
+				}
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+			rel, err := relationship(r.Context(), s, acct, iri)
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+			results = append(results, rel)
+		}
+		httpJson(w, results)
+	}
+}
+
+// GET /api/v1/accounts/familiar_followers?id[]=1&id[]=2
+//
+// familiarFollowersHandler lists the accounts the current account
+// follows which also follow each of the given accounts.
+func familiarFollowersHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		acct, err := currentAccount(s, r)
+		if err != nil {
+			httpError(w, err, http.StatusUnauthorized)
+			return
+		}
+		err = r.ParseForm()
+		if err != nil {
+			httpError(w, err, http.StatusBadRequest)
+			return
+		}
+		results := []map[string]any{}
+		for _, id := range accountIds(r) {
+			iri, err := actorIRI(r.Context(), s, id)
+			if err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					continue
+				}
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+			iris := []string{}
+			err = s.DB().SelectContext(r.Context(), &iris, `
+				select mine.TargetActorId from actor_following mine
+				join actor_following theirs on theirs.ActorId = mine.TargetActorId
+				where mine.ActorId = ? and mine.State = ? and theirs.TargetActorId = ? and theirs.State = ?
+				order by theirs.CreatedAt desc limit 40`,
+				acct.IRI(), activitypub.FollowAccepted, iri, activitypub.FollowAccepted)
+			if err != nil {
+				httpError(w, errors.Wrap(err, "actor_following"), http.StatusInternalServerError)
+				return
+			}
+			accounts := []map[string]any{}
+			for _, follower := range iris {
+				attrs, err := AccountMap(r.Context(), s.DB(), follower)
+				if err != nil {
+					httpError(w, err, http.StatusInternalServerError)
+					return
+				}
+				accounts = append(accounts, attrs)
+			}
+			results = append(results, map[string]any{"id": id, "accounts": accounts})
+		}
+		httpJson(w, results)
+	}
+}
+
+// accountIds returns the id[] parameters, which some clients send
+// as plain id.
+func accountIds(r *http.Request) []string {
+	if ids, ok := r.Form["id[]"]; ok {
+		return ids
+	}
+	return r.Form["id"]
+}
+
+// GET /api/v1/accounts/lookup?acct=nick@remote.example
+//
+// lookupHandler finds a local account or a remote actor we've seen
+// by its handle, without asking the remote server.
+func lookupHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handle := strings.TrimPrefix(strings.TrimSpace(r.FormValue("acct")), "@")
+		nick, host, _ := strings.Cut(handle, "@")
+		if nick == "" {
+			httpError(w, errors.New("Missing acct"), http.StatusBadRequest)
+			return
+		}
+		var iri string
+		var err error
+		if host == "" || host == s.Hostname() {
+			err = s.DB().GetContext(r.Context(), &iri,
+				"select a.Nick from accounts a where a.Nick = ? and not "+model.PendingAccount, nick)
+			iri = model.LocalIRI(iri)
+		} else {
+			iri, err = cachedActor(r.Context(), s, nick, host)
+		}
+		if err != nil {
+			listError(w, err)
 			return
 		}
+		attrs, err := AccountMap(r.Context(), s.DB(), iri)
 		if err != nil {
 			httpError(w, err, http.StatusInternalServerError)
 			return
 		}
+		httpJson(w, attrs)
+	}
+}
 
-		data, err := json.Marshal(userdata)
+// cachedActor finds the remote actor with the handle in the actors
+// cache.
+func cachedActor(ctx context.Context, s sparq.Server, nick, host string) (string, error) {
+	actors := []model.Actor{}
+	err := s.DB().SelectContext(ctx, &actors, `
+		select a.* from search_fts f
+		join actors a on a.rowid = f.rowid
+		where search_fts match ? and a.PrivateKey is null`, "PreferredUsername : "+ftsPhrase(nick))
+	if err != nil {
+		return "", errors.Wrap(err, "search_fts")
+	}
+	handle := nick + "@" + host
+	for idx := range actors {
+		if strings.EqualFold(activitypub.HandleFor(&actors[idx]), handle) {
+			return actors[idx].Id, nil
+		}
+	}
+	return "", errors.Wrap(sql.ErrNoRows, "No such account "+handle)
+}
+
+// GET /api/v1/accounts/search?q=nick
+//
+// accountSearchHandler searches local accounts and the actors cache
+// like the accounts of /api/v2/search, optionally only those the
+// current account follows.
+func accountSearchHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		acct, err := currentAccount(s, r)
+		if err != nil {
+			httpError(w, err, http.StatusUnauthorized)
+			return
+		}
+		err = r.ParseForm()
+		if err != nil {
+			httpError(w, err, http.StatusBadRequest)
+			return
+		}
+		sq := &searchQuery{
+			Q:         strings.TrimSpace(r.Form.Get("q")),
+			Viewer:    acct,
+			Resolve:   isTrue(r.Form.Get("resolve")),
+			Following: isTrue(r.Form.Get("following")),
+			Limit:     40,
+		}
+		if sq.Q == "" {
+			httpError(w, errors.New("Missing search query"), http.StatusBadRequest)
+			return
+		}
+		if value := r.Form.Get("limit"); value != "" {
+			sq.Limit, err = strconv.Atoi(value)
+			if err != nil {
+				httpError(w, errors.Wrap(err, "Invalid limit"), http.StatusBadRequest)
+				return
+			}
+		}
+		if sq.Limit <= 0 || sq.Limit > 80 {
+			sq.Limit = 80
+		}
+		if value := r.Form.Get("offset"); value != "" {
+			sq.Offset, err = strconv.Atoi(value)
+			if err != nil {
+				httpError(w, errors.Wrap(err, "Invalid offset"), http.StatusBadRequest)
+				return
+			}
+		}
+		results, err := searchAccounts(r.Context(), s, sq)
 		if err != nil {
 			httpError(w, err, http.StatusInternalServerError)
 			return
 		}
-		w.Header().Add("Content-Type", "application/json")
-		_, _ = w.Write(data)
+		httpJson(w, results)
+	}
+}
+
+// actorIRI returns the ActivityPub IRI for the given Mastodon account ID,
+// which may be a local account or a remote actor.
+func actorIRI(ctx context.Context, s sparq.Server, id string) (string, error) {
+	var nick string
+	err := s.DB().QueryRowContext(ctx,
+		"select a.Nick from accounts a where a.Id = ? and not "+model.PendingAccount, id).Scan(&nick)
+	if err == nil {
+		return model.LocalIRI(nick), nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return "", errors.Wrap(err, "accounts")
+	}
+	var iri string
+	err = s.DB().QueryRowContext(ctx, "select Id from actors where MastodonId = ?", id).Scan(&iri)
+	if err != nil {
+		return "", errors.Wrap(err, "account "+id)
+	}
+	return iri, nil
+}
+
+// AccountMap renders the Mastodon Account entity for the
+// local account or remote actor.
+func AccountMap(ctx context.Context, dbx *sqlx.DB, iri string) (map[string]any, error) {
+	attrs := map[string]any{
+		"bot":             false,
+		"group":           false,
+		"discoverable":    true,
+		"followers_count": 0,
+		"following_count": 0,
+		"statuses_count":  0,
+		"last_status_at":  nil,
+		"emojis":          []any{},
+		"fields":          []any{},
+	}
+	if nick, ok := model.LocalNick(iri); ok {
+		var acct model.Account
+		err := dbx.GetContext(ctx, &acct, `
+			select a.*, ap.* from accounts a
+			join account_profiles ap on ap.accountid = a.id
+			where a.Nick = ?`, nick)
+		if err != nil {
+			return nil, errors.Wrap(err, "account "+nick)
+		}
+		attrs["id"] = strconv.FormatInt(acct.Id, 10)
+		attrs["username"] = acct.Nick
+		attrs["acct"] = acct.Nick
+		attrs["display_name"] = acct.FullName
+		attrs["locked"] = acct.Visibility != model.Public
+		attrs["bot"] = acct.Bot
+		attrs["discoverable"] = acct.Discoverable
+		attrs["created_at"] = acct.Created()
+		attrs["note"] = acct.Note
+		attrs["url"] = acct.URI()
+		attrs["avatar"] = absoluteUrl(acct.Avatar)
+		attrs["header"] = absoluteUrl(acct.Header)
+		fields, err := accountFields(ctx, dbx, acct.Id)
+		if err != nil {
+			return nil, err
+		}
+		attrs["fields"] = fields
+		err = accountCounts(ctx, dbx, attrs, iri, acct.Id)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		var actor model.Actor
+		err := dbx.GetContext(ctx, &actor, "select * from actors where Id = ?", iri)
+		if err != nil {
+			return nil, errors.Wrap(err, "actor "+iri)
+		}
+		var obj activitystreams.Object
+		err = json.Unmarshal([]byte(actor.Properties), &obj)
+		if err != nil {
+			return nil, errors.Wrap(err, "actor "+iri)
+		}
+		attrs["id"] = strconv.FormatInt(actor.MastodonId, 10)
+		attrs["username"] = obj.PreferredUsername
+		attrs["acct"] = activitypub.HandleFor(&actor)
+		// remote profiles are untrusted HTML, display names are text
+		attrs["display_name"] = markup.Text(markup.Sanitize(obj.Name))
+		attrs["locked"] = obj.ManuallyApproves
+		attrs["bot"] = obj.Type == "Service" || obj.Type == "Application"
+		attrs["created_at"] = util.Thens(actor.CreatedAt)
+		attrs["note"] = ""
+		if obj.Summary != nil {
+			attrs["note"] = markup.Sanitize(*obj.Summary)
+		}
+		attrs["url"] = obj.URL
+		if obj.URL == "" {
+			attrs["url"] = iri
+		}
+		attrs["avatar"] = absoluteUrl("/static/default_avatar.png")
+		if obj.Icon != nil {
+			attrs["avatar"] = obj.Icon.URL
+		}
+		attrs["header"] = absoluteUrl("/static/default_header.jpg")
+		if obj.Image != nil {
+			attrs["header"] = obj.Image.URL
+		}
+		err = accountCounts(ctx, dbx, attrs, iri, actor.MastodonId)
+		if err != nil {
+			return nil, err
+		}
+	}
+	attrs["avatar_static"] = attrs["avatar"]
+	attrs["header_static"] = attrs["header"]
+
+	texts := []string{attrs["display_name"].(string), markup.Text(attrs["note"].(string))}
+	if fields, ok := attrs["fields"].([]map[string]any); ok {
+		for _, field := range fields {
+			texts = append(texts, field["name"].(string), field["value"].(string))
+		}
+	}
+	var err error
+	attrs["emojis"], err = emojiMaps(ctx, dbx, emojiDomain(iri), texts...)
+	if err != nil {
+		return nil, err
+	}
+	return attrs, nil
+}
+
+// accountCounts fills in the follow and status counts which the
+// triggers on actor_following and toots maintain. We only know of
+// the follows and toots of remote actors which reached us.
+func accountCounts(ctx context.Context, dbx *sqlx.DB, attrs map[string]any, iri string, id int64) error {
+	follows := struct {
+		FollowersCount int64
+		FollowingCount int64
+	}{}
+	err := dbx.GetContext(ctx, &follows,
+		"select FollowersCount, FollowingCount from follow_counts where ActorId = ?", iri)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return errors.Wrap(err, "follow_counts")
+	}
+	statuses := struct {
+		StatusesCount int64
+		LastStatusAt  *time.Time
+	}{}
+	err = dbx.GetContext(ctx, &statuses,
+		"select StatusesCount, LastStatusAt from status_counts where ActorId = ?", id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return errors.Wrap(err, "status_counts")
+	}
+	attrs["followers_count"] = follows.FollowersCount
+	attrs["following_count"] = follows.FollowingCount
+	attrs["statuses_count"] = statuses.StatusesCount
+	if statuses.LastStatusAt != nil {
+		attrs["last_status_at"] = statuses.LastStatusAt.UTC().Format("2006-01-02")
+	}
+	return nil
+}
+
+// accountFields renders the profile metadata of a local account.
+func accountFields(ctx context.Context, dbx *sqlx.DB, id int64) ([]map[string]any, error) {
+	fields := []struct {
+		Name       string
+		Value      string
+		VerifiedAt *time.Time
+	}{}
+	err := dbx.SelectContext(ctx, &fields,
+		"select Name, Value, VerifiedAt from account_fields where AccountId = ? order by rowid", id)
+	if err != nil {
+		return nil, errors.Wrap(err, "account_fields")
+	}
+	results := []map[string]any{}
+	for _, field := range fields {
+		attrs := map[string]any{
+			"name":        field.Name,
+			"value":       markup.RenderField(field.Value, db.InstanceHostname),
+			"verified_at": nil,
+		}
+		if field.VerifiedAt != nil {
+			attrs["verified_at"] = util.Thens(*field.VerifiedAt)
+		}
+		results = append(results, attrs)
+	}
+	return results, nil
+}
+
+func absoluteUrl(path string) string {
+	if strings.HasPrefix(path, "/") {
+		return "https://" + db.InstanceHostname + path
 	}
+	return path
 }
@@ -5,6 +5,7 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/contribsys/sparq/model"
 	"github.com/contribsys/sparq/web"
 	"github.com/stretchr/testify/assert"
 )
@@ -55,3 +56,162 @@ func TestAccounts(t *testing.T) {
 		assert.Contains(t, payload, "id")
 	})
 }
+
+func TestAccountNetwork(t *testing.T) {
+	ts, stopper := web.NewTestServer(t, "network")
+	defer stopper()
+	root := rootRouter(ts)
+	AddPublicEndpoints(ts, root.PathPrefix("/api/v1").Subrouter())
+
+	token, err := registerToken(t, ts)
+	assert.NoError(t, err)
+
+	_, err = ts.DB().Exec(`
+		insert into actors (Id, MastodonId, Type, Email, PublicKey, Properties, FetchedAt) values
+		('https://remote.example/users/bob', 1001, 'Person', '', '', '{"preferredUsername":"bob","name":"Bob"}', current_timestamp),
+		('https://other.example/users/bob', 1002, 'Person', '', '', '{"preferredUsername":"bob","name":"<b>Other</b> Bob","summary":"<p>Hi</p><script>alert(1)</script>"}', current_timestamp)`)
+	assert.NoError(t, err)
+	_, err = ts.DB().Exec(`insert into accounts (Id, Sid, Nick, Email, FullName, Visibility) values (2, 'AAAC', 'alice', 'alice@localhost.dev', 'Alice', ?)`, model.Protected)
+	assert.NoError(t, err)
+	_, err = ts.DB().Exec(`insert into account_profiles (AccountId) values (2)`)
+	assert.NoError(t, err)
+	_, err = ts.DB().Exec(`
+		insert into actor_following (Id, ActorId, TargetActorId, TargetActorAccount, State) values
+		('https://localhost.dev/users/admin#follows/1', 'https://localhost.dev/users/admin', 'https://remote.example/users/bob', 'bob@remote.example', 'accepted'),
+		('https://localhost.dev/users/admin#follows/2', 'https://localhost.dev/users/admin', 'https://localhost.dev/users/alice', 'alice', 'pending'),
+		('https://remote.example/follows/1', 'https://remote.example/users/bob', 'https://localhost.dev/users/admin', 'admin', 'accepted'),
+		('https://remote.example/follows/2', 'https://remote.example/users/bob', 'https://localhost.dev/users/alice', 'alice', 'accepted'),
+		('https://other.example/follows/1', 'https://other.example/users/bob', 'https://localhost.dev/users/admin', 'admin', 'accepted')`)
+	assert.NoError(t, err)
+	_, err = ts.DB().Exec(`
+		insert into toots (Sid, Uri, ActorId, AuthorId, Summary, Content, Visibility, CreatedAt) values
+		('BOB1', 'https://remote.example/notes/1', 1001, null, '', 'From bob', 0, '2030-01-01 00:00:01'),
+		('BOB2', 'https://remote.example/notes/2', 1001, null, '', 'More from bob', 0, '2030-01-02 00:00:01')`)
+	assert.NoError(t, err)
+
+	call := func(path string) (int, any) {
+		req := httptest.NewRequest("GET", "http://localhost.dev:9494/api/v1"+path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		root.ServeHTTP(w, req)
+		var result any
+		if w.Code == 200 {
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		}
+		return w.Code, result
+	}
+	accts := func(result any) []string {
+		names := []string{}
+		for _, item := range result.([]any) {
+			names = append(names, item.(map[string]any)["acct"].(string))
+		}
+		return names
+	}
+
+	t.Run("Counts", func(t *testing.T) {
+		code, result := call("/accounts/1001")
+		assert.Equal(t, 200, code)
+		bob := result.(map[string]any)
+		assert.EqualValues(t, 1, bob["followers_count"])
+		assert.EqualValues(t, 2, bob["following_count"])
+		assert.EqualValues(t, 2, bob["statuses_count"])
+		assert.Equal(t, "2030-01-02", bob["last_status_at"])
+
+		code, result = call("/accounts/verify_credentials")
+		assert.Equal(t, 200, code)
+		admin := result.(map[string]any)
+		assert.EqualValues(t, 2, admin["followers_count"])
+		assert.EqualValues(t, 1, admin["following_count"])
+		statuses := admin["statuses_count"].(float64)
+		assert.Greater(t, statuses, 0.0)
+
+		// alice accepts admin and bob deletes a toot
+		_, err := ts.DB().Exec("update actor_following set State = 'accepted' where Id = 'https://localhost.dev/users/admin#follows/2'")
+		assert.NoError(t, err)
+		_, err = ts.DB().Exec("update toots set DeletedAt = current_timestamp where Sid = 'BOB2'")
+		assert.NoError(t, err)
+		_, err = ts.DB().Exec("delete from actor_following where Id = 'https://other.example/follows/1'")
+		assert.NoError(t, err)
+		_, result = call("/accounts/1")
+		admin = result.(map[string]any)
+		assert.EqualValues(t, 1, admin["followers_count"])
+		assert.EqualValues(t, 2, admin["following_count"])
+		assert.EqualValues(t, statuses, admin["statuses_count"])
+		_, result = call("/accounts/1001")
+		assert.EqualValues(t, 1, result.(map[string]any)["statuses_count"])
+
+		code, _ = call("/accounts/999")
+		assert.Equal(t, 404, code)
+	})
+
+	t.Run("Listings", func(t *testing.T) {
+		code, result := call("/accounts/1/following")
+		assert.Equal(t, 200, code)
+		assert.Equal(t, []string{"alice", "bob@remote.example"}, accts(result))
+		code, result = call("/accounts/1/following?limit=1")
+		assert.Equal(t, 200, code)
+		assert.Equal(t, []string{"alice"}, accts(result))
+		_, result = call("/accounts/1001/followers")
+		assert.Equal(t, []string{"admin"}, accts(result))
+		_, result = call("/accounts/1001/following")
+		assert.Equal(t, []string{"alice", "admin"}, accts(result))
+
+		// alice is protected so her followers aren't shown to others
+		code, result = call("/accounts/2/followers")
+		assert.Equal(t, 200, code)
+		assert.Empty(t, result)
+		code, _ = call("/accounts/999/followers")
+		assert.Equal(t, 404, code)
+	})
+
+	t.Run("Relationships", func(t *testing.T) {
+		code, result := call("/accounts/relationships?id[]=1001&id[]=999&id[]=2")
+		assert.Equal(t, 200, code)
+		rels := result.([]any)
+		assert.Equal(t, 2, len(rels))
+		bob := rels[0].(map[string]any)
+		assert.Equal(t, "1001", bob["id"])
+		assert.Equal(t, true, bob["following"])
+		assert.Equal(t, true, bob["followed_by"])
+		assert.Equal(t, "2", rels[1].(map[string]any)["id"])
+
+		// admin follows bob, who follows alice
+		code, result = call("/accounts/familiar_followers?id[]=2&id[]=1001")
+		assert.Equal(t, 200, code)
+		familiar := result.([]any)
+		assert.Equal(t, 2, len(familiar))
+		assert.Equal(t, "2", familiar[0].(map[string]any)["id"])
+		assert.Equal(t, []string{"bob@remote.example"}, accts(familiar[0].(map[string]any)["accounts"]))
+		assert.Empty(t, familiar[1].(map[string]any)["accounts"])
+	})
+
+	t.Run("Lookup", func(t *testing.T) {
+		code, result := call("/accounts/lookup?acct=alice")
+		assert.Equal(t, 200, code)
+		assert.Equal(t, "2", result.(map[string]any)["id"])
+		code, result = call("/accounts/lookup?acct=@alice@localhost.dev")
+		assert.Equal(t, 200, code)
+		assert.Equal(t, "2", result.(map[string]any)["id"])
+		code, result = call("/accounts/lookup?acct=bob@other.example")
+		assert.Equal(t, 200, code)
+		assert.Equal(t, "1002", result.(map[string]any)["id"])
+		assert.Equal(t, "Other Bob", result.(map[string]any)["display_name"])
+		assert.Equal(t, "<p>Hi</p>", result.(map[string]any)["note"])
+		code, _ = call("/accounts/lookup?acct=bob@nowhere.example")
+		assert.Equal(t, 404, code)
+		code, _ = call("/accounts/lookup?acct=nobody")
+		assert.Equal(t, 404, code)
+	})
+
+	t.Run("Search", func(t *testing.T) {
+		code, result := call("/accounts/search?q=bob")
+		assert.Equal(t, 200, code)
+		assert.ElementsMatch(t, []string{"bob@remote.example", "bob@other.example"}, accts(result))
+		_, result = call("/accounts/search?q=bob&following=true")
+		assert.Equal(t, []string{"bob@remote.example"}, accts(result))
+		_, result = call("/accounts/search?q=al")
+		assert.Equal(t, []string{"alice"}, accts(result))
+		code, _ = call("/accounts/search")
+		assert.Equal(t, 400, code)
+	})
+}
@@ -0,0 +1,236 @@
+package clientapi
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/contribsys/sparq"
+	"github.com/contribsys/sparq/activitypub"
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/util"
+	"github.com/pkg/errors"
+)
+
+// POST https://mastodon.example/api/v1/accounts/:id/block
+// POST https://mastodon.example/api/v1/accounts/:id/unblock
+// POST https://mastodon.example/api/v1/accounts/:id/mute
+// POST https://mastodon.example/api/v1/accounts/:id/unmute
+// GET https://mastodon.example/api/v1/blocks
+// GET https://mastodon.example/api/v1/mutes
+// GET https://mastodon.example/api/v1/domain_blocks
+// POST https://mastodon.example/api/v1/domain_blocks
+// DELETE https://mastodon.example/api/v1/domain_blocks
+
+func blockHandler(s sparq.Server) http.HandlerFunc {
+	return relationshipHandler(s, activitypub.Block)
+}
+
+func unblockHandler(s sparq.Server) http.HandlerFunc {
+	return relationshipHandler(s, activitypub.Unblock)
+}
+
+func unmuteHandler(s sparq.Server) http.HandlerFunc {
+	return relationshipHandler(s, activitypub.Unmute)
+}
+
+// muteHandler mutes the account, along with its notifications unless
+// notifications is false, for duration seconds or indefinitely.
+func muteHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := r.ParseForm()
+		if err != nil {
+			httpError(w, err, http.StatusBadRequest)
+			return
+		}
+		notifications := true
+		if _, ok := r.Form["notifications"]; ok {
+			notifications = isTrue(r.Form.Get("notifications"))
+		}
+		var expiresAt *time.Time
+		if value := r.Form.Get("duration"); value != "" && value != "0" {
+			expiresAt, err = expiresIn(value)
+			if err != nil {
+				httpError(w, errors.New("Invalid duration: "+value), http.StatusUnprocessableEntity)
+				return
+			}
+		}
+		relationshipHandler(s, func(ctx context.Context, s sparq.Server, acct *model.Account, iri string) error {
+			return activitypub.Mute(ctx, s, acct, iri, notifications, expiresAt)
+		})(w, r)
+	}
+}
+
+func blocksHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		acct, err := currentAccount(s, r)
+		if err != nil {
+			httpError(w, err, http.StatusUnauthorized)
+			return
+		}
+		rows := []struct {
+			Id            int64
+			TargetActorId string
+		}{}
+		err = selectPage(r, s, &rows,
+			"select rowid as Id, TargetActorId from actor_blocks where ActorId = ?", acct.IRI())
+		if err != nil {
+			httpError(w, err, http.StatusBadRequest)
+			return
+		}
+		results := []map[string]any{}
+		for _, row := range rows {
+			attrs, err := AccountMap(r.Context(), s.DB(), row.TargetActorId)
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+			results = append(results, attrs)
+		}
+		if len(rows) > 0 {
+			linkHeader(w, r, strconv.FormatInt(rows[len(rows)-1].Id, 10), strconv.FormatInt(rows[0].Id, 10))
+		}
+		httpJson(w, results)
+	}
+}
+
+// mutesHandler lists the muted accounts, with when each mute ends.
+func mutesHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		acct, err := currentAccount(s, r)
+		if err != nil {
+			httpError(w, err, http.StatusUnauthorized)
+			return
+		}
+		rows := []struct {
+			Id            int64
+			TargetActorId string
+			ExpiresAt     *time.Time
+		}{}
+		err = selectPage(r, s, &rows, `
+			select rowid as Id, TargetActorId, ExpiresAt from actor_mutes
+			where ActorId = ? and (ExpiresAt is null or ExpiresAt > current_timestamp)`, acct.IRI())
+		if err != nil {
+			httpError(w, err, http.StatusBadRequest)
+			return
+		}
+		results := []map[string]any{}
+		for _, row := range rows {
+			attrs, err := AccountMap(r.Context(), s.DB(), row.TargetActorId)
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+			attrs["mute_expires_at"] = nil
+			if row.ExpiresAt != nil {
+				attrs["mute_expires_at"] = util.Thens(*row.ExpiresAt)
+			}
+			results = append(results, attrs)
+		}
+		if len(rows) > 0 {
+			linkHeader(w, r, strconv.FormatInt(rows[len(rows)-1].Id, 10), strconv.FormatInt(rows[0].Id, 10))
+		}
+		httpJson(w, results)
+	}
+}
+
+// domainBlocksHandler lists the blocked domains as strings, or blocks
+// or unblocks the domain parameter.
+func domainBlocksHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		acct, err := currentAccount(s, r)
+		if err != nil {
+			httpError(w, err, http.StatusUnauthorized)
+			return
+		}
+		switch r.Method {
+		case "GET":
+		case "POST":
+			err = activitypub.BlockDomain(r.Context(), s, acct, r.FormValue("domain"))
+			if err != nil {
+				httpError(w, err, http.StatusUnprocessableEntity)
+				return
+			}
+			httpJson(w, map[string]any{})
+			return
+		case "DELETE":
+			err = activitypub.UnblockDomain(r.Context(), s, acct, r.FormValue("domain"))
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+			httpJson(w, map[string]any{})
+			return
+		default:
+			httpError(w, errors.New("Unsupported method "+r.Method), http.StatusMethodNotAllowed)
+			return
+		}
+
+		rows := []struct {
+			Id     int64
+			Domain string
+		}{}
+		err = selectPage(r, s, &rows,
+			"select rowid as Id, Domain from actor_domain_blocks where ActorId = ?", acct.IRI())
+		if err != nil {
+			httpError(w, err, http.StatusBadRequest)
+			return
+		}
+		results := []string{}
+		for _, row := range rows {
+			results = append(results, row.Domain)
+		}
+		if len(rows) > 0 {
+			linkHeader(w, r, strconv.FormatInt(rows[len(rows)-1].Id, 10), strconv.FormatInt(rows[0].Id, 10))
+		}
+		httpJson(w, results)
+	}
+}
+
+// selectPage selects a page of the query's rows into dest, newest
+// first, paging on the rowid selected as Id with the request's
+// max_id, min_id and limit.
+func selectPage[T any](r *http.Request, s sparq.Server, dest *[]T, query string, args ...any) error {
+	err := r.ParseForm()
+	if err != nil {
+		return err
+	}
+	limit := 40
+	if value := r.Form.Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil {
+			return errors.Wrap(err, "Invalid limit")
+		}
+	}
+	if limit <= 0 || limit > 80 {
+		limit = 80
+	}
+	query = "select * from (" + query + ")"
+	if id := r.Form.Get("max_id"); id != "" {
+		query += " where Id < ?"
+		args = append(args, id)
+	} else if id := r.Form.Get("min_id"); id != "" {
+		query += " where Id > ?"
+		args = append(args, id)
+	}
+	// min_id asks for the rows immediately after the given one
+	ascending := r.Form.Get("min_id") != "" && r.Form.Get("max_id") == ""
+	if ascending {
+		query += " order by Id asc limit ?"
+	} else {
+		query += " order by Id desc limit ?"
+	}
+	args = append(args, limit)
+	err = s.DB().SelectContext(r.Context(), dest, query, args...)
+	if err != nil {
+		return errors.Wrap(err, "Invalid page")
+	}
+	if ascending {
+		rows := *dest
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package clientapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/contribsys/sparq/activitypub"
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/web"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlocks(t *testing.T) {
+	ts, stopper := web.NewTestServer(t, "blocks")
+	defer stopper()
+	root := rootRouter(ts)
+	AddPublicEndpoints(ts, root.PathPrefix("/api/v1").Subrouter())
+	ctx := context.Background()
+
+	token, err := registerToken(t, ts)
+	assert.NoError(t, err)
+
+	_, err = ts.DB().Exec(`insert into accounts (Id, Sid, Nick, Email, FullName) values (2, 'AAAC', 'alice', 'alice@localhost.dev', 'Alice')`)
+	assert.NoError(t, err)
+	_, err = ts.DB().Exec(`insert into account_profiles (AccountId) values (2)`)
+	assert.NoError(t, err)
+	_, err = ts.DB().Exec(`
+		insert into actors (Id, MastodonId, Type, Email, PublicKey, Properties, FetchedAt) values
+		('https://remote.example/users/bob', 1001, 'Person', '', '', '{"preferredUsername":"bob"}', current_timestamp)`)
+	assert.NoError(t, err)
+	_, err = ts.DB().Exec(`
+		insert into toots (Sid, Uri, ActorId, AuthorId, Summary, Content, Visibility, BoostOfId, CreatedAt) values
+		('ALC1', ?, 2, 2, '', '<p>Hi from Alice</p>', 0, null, datetime('now', '-3 hours')),
+		('BOB1', 'https://remote.example/notes/1', 1001, null, '', '<p>Hi from Bob</p>', 0, null, datetime('now', '-2 hours')),
+		('BST1', 'https://remote.example/notes/2', 1001, null, '', '', 0, 'ALC1', datetime('now', '-1 hours'))`,
+		model.LocalIRI("alice")+"/statuses/ALC1")
+	assert.NoError(t, err)
+	var alice model.Account
+	assert.NoError(t, ts.DB().Get(&alice, "select * from accounts where Id = 2"))
+
+	count := 0
+	call := func(method, path string, values url.Values) (int, any) {
+		count++
+		req := httptest.NewRequest(method, "http://localhost.dev:9494/api/v1"+path, strings.NewReader(values.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Idempotency-Key", fmt.Sprintf("blocks-%d", count))
+		w := httptest.NewRecorder()
+		root.ServeHTTP(w, req)
+		var result any
+		if w.Code == 200 {
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		}
+		return w.Code, result
+	}
+	timeline := func() []string {
+		code, result := call("GET", "/timelines/public", nil)
+		assert.Equal(t, 200, code)
+		sids := []string{}
+		for _, st := range result.([]any) {
+			sids = append(sids, st.(map[string]any)["id"].(string))
+		}
+		return sids
+	}
+	// boosts only appear in the query, public timelines leave them out
+	boosts := func() []string {
+		tq := model.TQ(ts.DB())
+		tq.Viewer = model.LocalIRI("admin")
+		res, err := tq.Execute()
+		assert.NoError(t, err)
+		sids := []string{}
+		for _, entry := range res.Toots {
+			if entry.BoostOfId != nil {
+				sids = append(sids, entry.Sid)
+			}
+		}
+		return sids
+	}
+	notifications := func() int {
+		code, result := call("GET", "/notifications", nil)
+		assert.Equal(t, 200, code)
+		return len(result.([]any))
+	}
+	assert.Subset(t, timeline(), []string{"ALC1", "BOB1"})
+	assert.Equal(t, []string{"BST1"}, boosts())
+
+	t.Run("Block", func(t *testing.T) {
+		_, err := activitypub.Follow(ctx, ts, &alice, model.LocalIRI("admin"))
+		assert.NoError(t, err)
+		assert.Equal(t, 1, notifications())
+
+		code, _ := call("POST", "/accounts/1/block", nil)
+		assert.Equal(t, 422, code)
+		code, result := call("POST", "/accounts/2/block", nil)
+		assert.Equal(t, 200, code)
+		rel := result.(map[string]any)
+		assert.Equal(t, true, rel["blocking"])
+		assert.Equal(t, false, rel["followed_by"])
+
+		// the follow is gone and existing notifications are hidden
+		af, err := activitypub.Following(ctx, ts, alice.IRI(), model.LocalIRI("admin"))
+		assert.NoError(t, err)
+		assert.Nil(t, af)
+		assert.Equal(t, 0, notifications())
+		assert.NoError(t, activitypub.Notify(ctx, ts, activitypub.NotifyFavourite, model.LocalIRI("admin"), alice.IRI(), ""))
+		var rows int
+		assert.NoError(t, ts.DB().Get(&rows, "select count(*) from actor_notifications"))
+		assert.Equal(t, 1, rows)
+
+		// alice's toot and bob's boost of it are hidden
+		sids := timeline()
+		assert.NotContains(t, sids, "ALC1")
+		assert.Contains(t, sids, "BOB1")
+		assert.Empty(t, boosts())
+
+		code, result = call("GET", "/blocks", nil)
+		assert.Equal(t, 200, code)
+		assert.Equal(t, 1, len(result.([]any)))
+		assert.Equal(t, "alice", result.([]any)[0].(map[string]any)["acct"])
+
+		// alice can't see admin either
+		tq := model.TQ(ts.DB())
+		tq.Viewer = alice.IRI()
+		res, err := tq.Execute()
+		assert.NoError(t, err)
+		for _, entry := range res.Toots {
+			assert.NotEqual(t, int64(1), entry.ActorId)
+		}
+
+		code, result = call("POST", "/accounts/2/unblock", nil)
+		assert.Equal(t, 200, code)
+		assert.Equal(t, false, result.(map[string]any)["blocking"])
+		assert.Contains(t, timeline(), "ALC1")
+		assert.Equal(t, []string{"BST1"}, boosts())
+	})
+
+	t.Run("Statuses", func(t *testing.T) {
+		var admin model.Account
+		assert.NoError(t, ts.DB().Get(&admin, "select * from accounts where Id = 1"))
+		search := func(viewer *model.Account) int {
+			results, err := searchStatuses(ctx, ts, &searchQuery{Q: "Alice", Viewer: viewer, Limit: 20})
+			assert.NoError(t, err)
+			return len(results)
+		}
+		var own model.Toot
+		assert.NoError(t, ts.DB().Get(&own, "select * from toots where AuthorId = 1 limit 1"))
+		assert.Equal(t, 1, search(&admin))
+
+		code, _ := call("POST", "/accounts/2/block", nil)
+		assert.Equal(t, 200, code)
+		// blocked toots can't be seen or acted on, either way round
+		code, _ = call("GET", "/statuses/ALC1", nil)
+		assert.Equal(t, 404, code)
+		code, _ = call("GET", "/statuses/ALC1/context", nil)
+		assert.Equal(t, 404, code)
+		code, _ = call("POST", "/statuses/ALC1/favourite", nil)
+		assert.Equal(t, 404, code)
+		assert.Equal(t, 0, search(&admin))
+		ok, err := canView(ctx, ts, &own, alice.IRI())
+		assert.NoError(t, err)
+		assert.False(t, ok)
+
+		code, _ = call("POST", "/accounts/2/unblock", nil)
+		assert.Equal(t, 200, code)
+		code, _ = call("GET", "/statuses/ALC1", nil)
+		assert.Equal(t, 200, code)
+		assert.Equal(t, 1, search(&admin))
+	})
+
+	t.Run("Mute", func(t *testing.T) {
+		_, err := ts.DB().Exec("delete from actor_notifications")
+		assert.NoError(t, err)
+		code, _ := call("POST", "/accounts/1001/mute", url.Values{"duration": {"soon"}})
+		assert.Equal(t, 422, code)
+		code, result := call("POST", "/accounts/1001/mute", url.Values{"notifications": {"false"}, "duration": {"3600"}})
+		assert.Equal(t, 200, code)
+		rel := result.(map[string]any)
+		assert.Equal(t, true, rel["muting"])
+		assert.Equal(t, false, rel["muting_notifications"])
+		assert.NotContains(t, timeline(), "BOB1")
+
+		code, result = call("GET", "/mutes", nil)
+		assert.Equal(t, 200, code)
+		mutes := result.([]any)
+		assert.Equal(t, 1, len(mutes))
+		assert.NotNil(t, mutes[0].(map[string]any)["mute_expires_at"])
+
+		// notifications still arrive unless muted too
+		bob := "https://remote.example/users/bob"
+		assert.NoError(t, activitypub.Notify(ctx, ts, activitypub.NotifyFavourite, model.LocalIRI("admin"), bob, ""))
+		assert.Equal(t, 1, notifications())
+		code, result = call("POST", "/accounts/1001/mute", nil)
+		assert.Equal(t, 200, code)
+		assert.Equal(t, true, result.(map[string]any)["muting_notifications"])
+		assert.Equal(t, 0, notifications())
+
+		// expired mutes are ignored
+		_, err = ts.DB().Exec("update actor_mutes set ExpiresAt = datetime('now', '-1 minutes')")
+		assert.NoError(t, err)
+		assert.Contains(t, timeline(), "BOB1")
+		assert.Equal(t, 1, notifications())
+		code, result = call("GET", "/mutes", nil)
+		assert.Equal(t, 200, code)
+		assert.Empty(t, result)
+
+		code, result = call("POST", "/accounts/1001/unmute", nil)
+		assert.Equal(t, 200, code)
+		assert.Equal(t, false, result.(map[string]any)["muting"])
+	})
+
+	t.Run("DomainBlocks", func(t *testing.T) {
+		code, _ := call("POST", "/domain_blocks", url.Values{"domain": {"localhost.dev"}})
+		assert.Equal(t, 422, code)
+		code, _ = call("POST", "/domain_blocks", url.Values{"domain": {"Remote.Example"}})
+		assert.Equal(t, 200, code)
+
+		code, result := call("GET", "/domain_blocks", nil)
+		assert.Equal(t, 200, code)
+		assert.Equal(t, []any{"remote.example"}, result)
+		assert.NotContains(t, timeline(), "BOB1")
+		assert.Empty(t, boosts())
+		assert.Equal(t, 0, notifications())
+
+		code, _ = call("DELETE", "/domain_blocks?domain=remote.example", nil)
+		assert.Equal(t, 200, code)
+		assert.Contains(t, timeline(), "BOB1")
+		assert.Equal(t, 1, notifications())
+	})
+}
@@ -0,0 +1,216 @@
+package clientapi
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/contribsys/sparq"
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/util"
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// GET https://mastodon.example/api/v1/conversations
+// DELETE https://mastodon.example/api/v1/conversations/:id
+// POST https://mastodon.example/api/v1/conversations/:id/read
+// POST https://mastodon.example/api/v1/conversations/:id/mute
+// POST https://mastodon.example/api/v1/conversations/:id/unmute
+
+// conversationsHandler lists the current account's conversations,
+// most recently active first.
+func conversationsHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		acct, err := currentAccount(s, r)
+		if err != nil {
+			httpError(w, err, http.StatusUnauthorized)
+			return
+		}
+		rows := []struct {
+			Id             int64
+			ConversationId int64
+		}{}
+		err = selectPage(r, s, &rows, `
+			select LastTootId as Id, ConversationId from account_conversations
+			where ActorId = ? and not Removed`, acct.IRI())
+		if err != nil {
+			httpError(w, err, http.StatusBadRequest)
+			return
+		}
+		results := []map[string]any{}
+		for _, row := range rows {
+			attrs, err := ConversationMap(r.Context(), s.DB(), acct.IRI(), row.ConversationId)
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+			results = append(results, attrs)
+		}
+		if len(rows) > 0 {
+			linkHeader(w, r, strconv.FormatInt(rows[len(rows)-1].Id, 10), strconv.FormatInt(rows[0].Id, 10))
+		}
+		httpJson(w, results)
+	}
+}
+
+// conversationHandler removes the conversation from the list until
+// someone adds to it.
+func conversationHandler(s sparq.Server) http.HandlerFunc {
+	return updateConversation(s, "DELETE", "Removed = true", false)
+}
+
+func readConversationHandler(s sparq.Server) http.HandlerFunc {
+	return updateConversation(s, "POST", "Unread = false", true)
+}
+
+// muteConversationHandler stops notifications and streaming about
+// the conversation.
+func muteConversationHandler(s sparq.Server) http.HandlerFunc {
+	return updateConversation(s, "POST", "Muted = true", true)
+}
+
+func unmuteConversationHandler(s sparq.Server) http.HandlerFunc {
+	return updateConversation(s, "POST", "Muted = false", true)
+}
+
+// updateConversation changes the current account's view of the
+// conversation in the URL, rendering it or an empty object.
+func updateConversation(s sparq.Server, method, change string, render bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			httpError(w, errors.New(method+" only"), http.StatusMethodNotAllowed)
+			return
+		}
+		acct, err := currentAccount(s, r)
+		if err != nil {
+			httpError(w, err, http.StatusUnauthorized)
+			return
+		}
+		id := mux.Vars(r)["id"]
+		res, err := s.DB().ExecContext(r.Context(), `
+			update account_conversations set `+change+`, UpdatedAt = current_timestamp
+			where ConversationId = ? and ActorId = ?`, id, acct.IRI())
+		if err != nil {
+			httpError(w, errors.Wrap(err, "account_conversations"), http.StatusInternalServerError)
+			return
+		}
+		if count, _ := res.RowsAffected(); count == 0 {
+			httpError(w, errors.New("Record not found"), http.StatusNotFound)
+			return
+		}
+		if !render {
+			httpJson(w, map[string]any{})
+			return
+		}
+		cid, _ := strconv.ParseInt(id, 10, 64)
+		attrs, err := ConversationMap(r.Context(), s.DB(), acct.IRI(), cid)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		httpJson(w, attrs)
+	}
+}
+
+// ConversationMap renders the Mastodon Conversation entity for the
+// local participant: everyone else who wrote or was mentioned in
+// the conversation and the newest toot the participant can see.
+func ConversationMap(ctx context.Context, dbx *sqlx.DB, iri string, id int64) (map[string]any, error) {
+	var ac model.AccountConversation
+	err := dbx.GetContext(ctx, &ac,
+		"select * from account_conversations where ConversationId = ? and ActorId = ?", id, iri)
+	if err != nil {
+		return nil, errors.Wrap(err, "account_conversations")
+	}
+	iris := []string{}
+	err = dbx.SelectContext(ctx, &iris, `
+		select m.ActorId from toot_mentions m join toots t on t.Sid = m.Sid
+		where t.ConversationId = ?
+		union select r.Id from toots t join actors r on t.AuthorId is null and r.MastodonId = t.ActorId
+		where t.ConversationId = ?`, id, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "toot_mentions")
+	}
+	nicks := []string{}
+	err = dbx.SelectContext(ctx, &nicks, `
+		select distinct a.Nick from toots t join accounts a on a.Id = t.AuthorId
+		where t.ConversationId = ?`, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "accounts")
+	}
+	for _, nick := range nicks {
+		iris = append(iris, model.LocalIRI(nick))
+	}
+	accounts := []map[string]any{}
+	seen := map[string]bool{iri: true}
+	for _, participant := range iris {
+		if seen[participant] {
+			continue
+		}
+		seen[participant] = true
+		attrs, err := AccountMap(ctx, dbx, participant)
+		if err != nil {
+			// the actor has been deleted
+			util.Debugf("Skipping participant %s: %v", participant, err)
+			continue
+		}
+		accounts = append(accounts, attrs)
+	}
+
+	attrs := map[string]any{
+		"id":          strconv.FormatInt(id, 10),
+		"unread":      ac.Unread,
+		"accounts":    accounts,
+		"last_status": nil,
+	}
+	nick, _ := model.LocalNick(iri)
+	sids := []string{}
+	err = dbx.SelectContext(ctx, &sids, `
+		select t.Sid from toots t
+		left outer join accounts a on a.Id = t.AuthorId
+		where t.ConversationId = ? and t.DeletedAt is null
+		and (a.Nick = ? or exists (select 1 from toot_mentions m where m.Sid = t.Sid and m.ActorId = ?))
+		order by t.rowid desc limit 1`, id, nick, iri)
+	if err != nil {
+		return nil, errors.Wrap(err, "toots")
+	}
+	if len(sids) > 0 {
+		attrs["last_status"], err = TootMapFor(dbx, sids[0], iri)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return attrs, nil
+}
+
+// conversation streams the conversation to the participant's
+// direct stream.
+func (s *Streamer) conversation(ctx context.Context, svr sparq.Server, iri string, id int64) {
+	nick, ok := model.LocalNick(iri)
+	if !ok {
+		return
+	}
+	var uid int64
+	err := svr.DB().GetContext(ctx, &uid, "select Id from accounts where Nick = ?", nick)
+	if err != nil {
+		util.Warnf("Unable to stream conversation %d: %v", id, err)
+		return
+	}
+	key := directStream(strconv.FormatInt(uid, 10))
+	if !s.isStreaming(key) {
+		return
+	}
+	attrs, err := ConversationMap(ctx, svr.DB(), iri, id)
+	if err != nil {
+		util.Warnf("Unable to stream conversation %d: %v", id, err)
+		return
+	}
+	s.Fanout(key, NewJsonEvent("conversation", attrs))
+}
+
+// directStream is the key of the account's stream of conversations.
+func directStream(uid string) string {
+	return "direct:" + uid
+}
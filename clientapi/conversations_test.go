@@ -0,0 +1,208 @@
+package clientapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/contribsys/sparq/activitypub"
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/web"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConversations(t *testing.T) {
+	ts, stopper := web.NewTestServer(t, "conversations")
+	defer stopper()
+	root := rootRouter(ts)
+	AddPublicEndpoints(ts, root.PathPrefix("/api/v1").Subrouter())
+	ctx := context.Background()
+
+	admin, err := registerToken(t, ts)
+	assert.NoError(t, err)
+	app, err := createOauthClient(ts, map[string]string{
+		"client_name": "Tusky", "redirect_uris": "urn:ietf:wg:oauth:2.0:oob", "scopes": "read write", "website": "https://tusky.app"})
+	assert.NoError(t, err)
+	store := &web.SqliteOauthStore{DB: ts.DB()}
+	for id, nick := range map[int]string{2: "alice", 3: "carol"} {
+		_, err = ts.DB().Exec(`insert into accounts (Id, Sid, Nick, Email, FullName) values (?, ?, ?, ?, ?)`,
+			id, fmt.Sprintf("AAA%d", id), nick, nick+"@localhost.dev", nick)
+		assert.NoError(t, err)
+		_, err = ts.DB().Exec(`insert into account_profiles (AccountId) values (?)`, id)
+		assert.NoError(t, err)
+		assert.NoError(t, store.Create(ctx, &model.OauthToken{
+			ClientId:        app["client_id"].(string),
+			AccountId:       uint64(id),
+			Scope:           "read write",
+			Access:          strings.ToUpper(nick),
+			AccessCreatedAt: time.Now(),
+			AccessExpiresIn: time.Hour,
+		}))
+	}
+	bob := "https://remote.example/users/bob"
+	_, err = ts.DB().Exec(`
+		insert into actors (Id, MastodonId, Type, Email, PublicKey, Properties, FetchedAt) values
+		(?, 1001, 'Person', '', '', '{"preferredUsername":"bob"}', current_timestamp)`, bob)
+	assert.NoError(t, err)
+
+	count := 0
+	call := func(token, method, path string, values url.Values) (int, any) {
+		count++
+		req := httptest.NewRequest(method, "http://localhost.dev:9494/api/v1"+path, strings.NewReader(values.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		req.Header.Set("Idempotency-Key", fmt.Sprintf("conversations-%d", count))
+		w := httptest.NewRecorder()
+		root.ServeHTTP(w, req)
+		var result any
+		if w.Code == 200 {
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		}
+		return w.Code, result
+	}
+	post := func(token string, values url.Values) string {
+		values.Set("visibility", "direct")
+		code, result := call(token, "POST", "/statuses", values)
+		assert.Equal(t, 200, code)
+		return result.(map[string]any)["id"].(string)
+	}
+	conversations := func(token string) []map[string]any {
+		code, result := call(token, "GET", "/conversations", nil)
+		assert.Equal(t, 200, code)
+		list := []map[string]any{}
+		for _, item := range result.([]any) {
+			list = append(list, item.(map[string]any))
+		}
+		return list
+	}
+	accts := func(convo map[string]any) []string {
+		names := []string{}
+		for _, item := range convo["accounts"].([]any) {
+			names = append(names, item.(map[string]any)["acct"].(string))
+		}
+		return names
+	}
+	lastStatus := func(convo map[string]any) string {
+		return convo["last_status"].(map[string]any)["id"].(string)
+	}
+
+	first := post(admin, url.Values{"status": {"@alice psst"}})
+	var id string
+
+	t.Run("Direct", func(t *testing.T) {
+		code, _ := call(admin, "GET", "/statuses/"+first, nil)
+		assert.Equal(t, 200, code)
+		code, _ = call("ALICE", "GET", "/statuses/"+first, nil)
+		assert.Equal(t, 200, code)
+		code, _ = call("", "GET", "/statuses/"+first, nil)
+		assert.Equal(t, 404, code)
+		code, _ = call("CAROL", "GET", "/statuses/"+first, nil)
+		assert.Equal(t, 404, code)
+		code, _ = call("CAROL", "POST", "/statuses/"+first+"/favourite", nil)
+		assert.Equal(t, 404, code)
+		code, _ = call("CAROL", "GET", "/statuses/"+first+"/favourited_by", nil)
+		assert.Equal(t, 404, code)
+		// not even the author can reblog a direct toot
+		code, _ = call(admin, "POST", "/statuses/"+first+"/reblog", nil)
+		assert.Equal(t, 422, code)
+
+		mine := conversations(admin)
+		assert.Equal(t, 1, len(mine))
+		id = mine[0]["id"].(string)
+		assert.Equal(t, false, mine[0]["unread"])
+		assert.Equal(t, []string{"alice"}, accts(mine[0]))
+		assert.Equal(t, first, lastStatus(mine[0]))
+
+		theirs := conversations("ALICE")
+		assert.Equal(t, 1, len(theirs))
+		assert.Equal(t, id, theirs[0]["id"])
+		assert.Equal(t, true, theirs[0]["unread"])
+		assert.Equal(t, []string{"admin"}, accts(theirs[0]))
+		assert.Empty(t, conversations("CAROL"))
+	})
+
+	t.Run("Replies", func(t *testing.T) {
+		reply := post("ALICE", url.Values{"status": {"@admin ok"}, "in_reply_to_id": {first}})
+		var uri string
+		assert.NoError(t, ts.DB().Get(&uri, "select Uri from toots where Sid = ?", first))
+		toot, err := activitypub.SaveRemoteNote(ctx, ts, &activitypub.RemoteNote{
+			Id:           "https://remote.example/notes/1",
+			AttributedTo: bob,
+			InReplyTo:    uri,
+			Content:      "Me too",
+			To:           []string{model.LocalIRI("admin")},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, model.VisDirect, toot.Visibility)
+
+		mine := conversations(admin)
+		assert.Equal(t, 1, len(mine))
+		assert.Equal(t, id, mine[0]["id"])
+		assert.Equal(t, true, mine[0]["unread"])
+		assert.ElementsMatch(t, []string{"alice", "bob@remote.example"}, accts(mine[0]))
+		assert.Equal(t, toot.Sid, lastStatus(mine[0]))
+
+		// alice wasn't sent bob's toot
+		theirs := conversations("ALICE")
+		assert.Equal(t, false, theirs[0]["unread"])
+		assert.Equal(t, reply, lastStatus(theirs[0]))
+
+		// a new thread is a new conversation
+		post("CAROL", url.Values{"status": {"@alice hi"}})
+		assert.Equal(t, 2, len(conversations("ALICE")))
+	})
+
+	t.Run("Manage", func(t *testing.T) {
+		code, result := call(admin, "POST", "/conversations/"+id+"/read", nil)
+		assert.Equal(t, 200, code)
+		assert.Equal(t, false, result.(map[string]any)["unread"])
+		code, _ = call("CAROL", "POST", "/conversations/"+id+"/read", nil)
+		assert.Equal(t, 404, code)
+
+		st := NewStreamer(ts)
+		defer activitypub.OnConversation(st.conversation)()
+		events, dereg := st.registerStreamerFor(directStream("1"))
+		defer dereg()
+		post("ALICE", url.Values{"status": {"@admin still there?"}, "in_reply_to_id": {first}})
+		event := <-events
+		assert.Equal(t, "conversation", event.Name)
+		var convo map[string]any
+		assert.NoError(t, json.Unmarshal([]byte(event.Data), &convo))
+		assert.Equal(t, id, convo["id"])
+		assert.Equal(t, true, convo["unread"])
+
+		// muted conversations are quiet
+		var notes int
+		assert.NoError(t, ts.DB().Get(&notes, "select count(*) from actor_notifications where ActorId = ?", model.LocalIRI("admin")))
+		code, _ = call(admin, "POST", "/conversations/"+id+"/mute", nil)
+		assert.Equal(t, 200, code)
+		code, result = call(admin, "GET", "/statuses/"+first, nil)
+		assert.Equal(t, 200, code)
+		assert.Equal(t, true, result.(map[string]any)["muted"])
+		post("ALICE", url.Values{"status": {"@admin hello?"}, "in_reply_to_id": {first}})
+		assert.Equal(t, 0, len(events))
+		var after int
+		assert.NoError(t, ts.DB().Get(&after, "select count(*) from actor_notifications where ActorId = ?", model.LocalIRI("admin")))
+		assert.Equal(t, notes, after)
+		code, _ = call(admin, "POST", "/conversations/"+id+"/unmute", nil)
+		assert.Equal(t, 200, code)
+
+		code, result = call(admin, "DELETE", "/conversations/"+id, nil)
+		assert.Equal(t, 200, code)
+		assert.Empty(t, result)
+		assert.Empty(t, conversations(admin))
+		post("ALICE", url.Values{"status": {"@admin come back"}, "in_reply_to_id": {first}})
+		assert.Equal(t, 1, len(conversations(admin)))
+		<-events
+	})
+
+	code, _ := call("", "GET", "/conversations", nil)
+	assert.Equal(t, 401, code)
+}
@@ -0,0 +1,239 @@
+package clientapi
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/contribsys/sparq"
+	"github.com/contribsys/sparq/activitypub"
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/util"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+// GET https://mastodon.example/api/v1/statuses/:id
+// PUT https://mastodon.example/api/v1/statuses/:id
+// DELETE https://mastodon.example/api/v1/statuses/:id
+// GET https://mastodon.example/api/v1/statuses/:id/history
+// GET https://mastodon.example/api/v1/statuses/:id/source
+
+func statusHandler(s sparq.Server) http.HandlerFunc {
+	get := getTootHandler(s)
+	edit := editTootHandler(s)
+	del := deleteTootHandler(s)
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			get(w, r)
+		case "PUT":
+			edit(w, r)
+		case "DELETE":
+			del(w, r)
+		default:
+			httpError(w, errors.New("Unsupported method "+r.Method), http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func editTootHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		acct, err := currentAccount(s, r)
+		if err != nil {
+			httpError(w, err, http.StatusUnauthorized)
+			return
+		}
+		err = r.ParseForm()
+		if err != nil {
+			httpError(w, err, http.StatusBadRequest)
+			return
+		}
+		toot, err := ownToot(r.Context(), s, acct, mux.Vars(r)["id"])
+		if err != nil {
+			listError(w, err)
+			return
+		}
+		if toot.BoostOfId != nil {
+			httpError(w, errors.New("Boosts can't be edited"), http.StatusUnprocessableEntity)
+			return
+		}
+
+		edit := &model.TootEdit{
+			Summary:   r.Form.Get("spoiler_text"),
+			Text:      r.Form.Get("status"),
+			Lang:      r.Form.Get("language"),
+			CreatedAt: time.Now(),
+		}
+		if edit.Lang == "" {
+			edit.Lang = toot.Lang
+		}
+		if edit.Text == "" {
+			var count int
+			err = s.DB().GetContext(r.Context(), &count, "select count(*) from toot_medias where Sid = ?", toot.Sid)
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+			if count == 0 {
+				httpError(w, errors.New("Please enter a message"), http.StatusUnprocessableEntity)
+				return
+			}
+		}
+		var mentions []string
+		edit.Content, mentions = activitypub.FormatToot(r.Context(), s, edit.Text)
+		err = activitypub.SaveEdit(r.Context(), s, toot, edit, extractTags(edit.Text), mentions)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		err = activitypub.DeliverUpdate(r.Context(), s, toot.Sid)
+		if err != nil {
+			util.Error("Unable to queue update for "+toot.Sid, err)
+		}
+
+		attrs, err := TootMapFor(s.DB(), toot.Sid, acct.IRI())
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		httpJson(w, attrs)
+	}
+}
+
+// deleteTootHandler returns the deleted toot along with its source
+// so the client can offer to redraft it.
+func deleteTootHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		acct, err := currentAccount(s, r)
+		if err != nil {
+			httpError(w, err, http.StatusUnauthorized)
+			return
+		}
+		toot, err := ownToot(r.Context(), s, acct, mux.Vars(r)["id"])
+		if err != nil {
+			listError(w, err)
+			return
+		}
+		attrs, err := TootMapFor(s.DB(), toot.Sid, acct.IRI())
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		attrs["text"] = sourceText(toot)
+
+		if toot.BoostOfId != nil {
+			err = activitypub.Unreblog(r.Context(), s, acct, toot)
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+			httpJson(w, attrs)
+			return
+		}
+		err = activitypub.DeleteToot(r.Context(), s, toot)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		err = activitypub.DeliverDelete(r.Context(), s, toot.Sid)
+		if err != nil {
+			util.Error("Unable to queue delete for "+toot.Sid, err)
+		}
+		httpJson(w, attrs)
+	}
+}
+
+// tootHistoryHandler lists each revision of the toot, oldest first.
+// A toot which has never been edited has a single revision.
+func tootHistoryHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		toot, err := findViewableToot(r.Context(), s, mux.Vars(r)["id"], viewerIRI(s, r))
+		if err != nil {
+			listError(w, err)
+			return
+		}
+		edits := []model.TootEdit{}
+		err = s.DB().SelectContext(r.Context(), &edits, "select * from toot_edits where Sid = ? order by Id", toot.Sid)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		if len(edits) == 0 {
+			edits = append(edits, model.TootEdit{
+				Sid:       toot.Sid,
+				Summary:   toot.Summary,
+				Content:   toot.Content,
+				Lang:      toot.Lang,
+				CreatedAt: toot.CreatedAt,
+			})
+		}
+
+		iri, err := activitypub.AuthorIRI(r.Context(), s, toot)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		account, err := AccountMap(r.Context(), s.DB(), iri)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		results := []map[string]any{}
+		for _, edit := range edits {
+			results = append(results, map[string]any{
+				"content":           edit.Content,
+				"spoiler_text":      edit.Summary,
+				"sensitive":         false,
+				"created_at":        util.Thens(edit.CreatedAt),
+				"account":           account,
+				"poll":              nil,
+				"media_attachments": []any{},
+				"emojis":            []any{},
+			})
+		}
+		httpJson(w, results)
+	}
+}
+
+// tootSourceHandler returns the plain text of one of the current
+// account's toots for editing.
+func tootSourceHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		acct, err := currentAccount(s, r)
+		if err != nil {
+			httpError(w, err, http.StatusUnauthorized)
+			return
+		}
+		toot, err := ownToot(r.Context(), s, acct, mux.Vars(r)["id"])
+		if err != nil {
+			listError(w, err)
+			return
+		}
+		httpJson(w, map[string]any{
+			"id":           toot.Sid,
+			"text":         sourceText(toot),
+			"spoiler_text": toot.Summary,
+		})
+	}
+}
+
+// ownToot returns the account's undeleted toot with the given ID.
+func ownToot(ctx context.Context, s sparq.Server, acct *model.Account, sid string) (*model.Toot, error) {
+	var toot model.Toot
+	err := s.DB().GetContext(ctx, &toot,
+		"select * from toots where Sid = ? and AuthorId = ? and DeletedAt is null", sid, acct.Id)
+	if err != nil {
+		return nil, errors.Wrap(err, "toot "+sid)
+	}
+	return &toot, nil
+}
+
+// sourceText returns the plain text the toot was written with.
+// Toots from before the text was kept only have their content.
+func sourceText(toot *model.Toot) string {
+	if toot.Text == "" {
+		return toot.Content
+	}
+	return toot.Text
+}
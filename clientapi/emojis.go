@@ -0,0 +1,271 @@
+package clientapi
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/contribsys/sparq"
+	"github.com/contribsys/sparq/activitypub"
+	"github.com/contribsys/sparq/markup"
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/util"
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// GET https://mastodon.example/api/v1/custom_emojis
+// GET https://mastodon.example/api/v1/admin/custom_emojis
+// POST https://mastodon.example/api/v1/admin/custom_emojis
+// PUT https://mastodon.example/api/v1/admin/custom_emojis/:id
+// DELETE https://mastodon.example/api/v1/admin/custom_emojis/:id
+
+var (
+	ErrShortcodeTaken = errors.New("Shortcode has already been taken")
+)
+
+// customEmojisHandler lists our own emoji which are enabled.
+func customEmojisHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		emojis := []model.CustomEmoji{}
+		err := s.DB().SelectContext(r.Context(), &emojis, `
+			select * from custom_emojis
+			where Domain = '' and Path != '' and not Disabled
+			order by Category, Shortcode`)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		results := []map[string]any{}
+		for idx := range emojis {
+			results = append(results, EmojiMap(&emojis[idx]))
+		}
+		httpJson(w, results)
+	}
+}
+
+// adminEmojisHandler lists all local and cached remote emoji, or
+// uploads a new local emoji.
+func adminEmojisHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, err := currentModerator(s, r)
+		if err != nil {
+			moderatorError(w, err)
+			return
+		}
+		if r.Method == "POST" {
+			createEmoji(w, r, s)
+			return
+		}
+		err = r.ParseForm()
+		if err != nil {
+			httpError(w, err, http.StatusBadRequest)
+			return
+		}
+		query := "select * from custom_emojis"
+		args := []any{}
+		if r.Form.Get("local") != "" {
+			query += " where Domain = ''"
+		} else if domain := r.Form.Get("by_domain"); domain != "" {
+			query += " where Domain = ?"
+			args = append(args, domain)
+		}
+		emojis := []model.CustomEmoji{}
+		err = s.DB().SelectContext(r.Context(), &emojis, query+" order by Domain, Shortcode", args...)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		results := []map[string]any{}
+		for idx := range emojis {
+			results = append(results, adminEmojiMap(&emojis[idx]))
+		}
+		httpJson(w, results)
+	}
+}
+
+func createEmoji(w http.ResponseWriter, r *http.Request, s sparq.Server) {
+	code := r.FormValue("shortcode")
+	if !markup.IsShortcode(code) {
+		httpError(w, errors.New("Invalid shortcode: "+code), http.StatusUnprocessableEntity)
+		return
+	}
+	var count int
+	err := s.DB().GetContext(r.Context(), &count,
+		"select count(*) from custom_emojis where Shortcode = ? and Domain = ''", code)
+	if err != nil {
+		httpError(w, err, http.StatusInternalServerError)
+		return
+	}
+	if count > 0 {
+		httpError(w, ErrShortcodeTaken, http.StatusUnprocessableEntity)
+		return
+	}
+
+	origfile, err := uploadedFile(r, "file")
+	if err != nil {
+		httpError(w, err, http.StatusBadRequest)
+		return
+	}
+	defer os.Remove(origfile.Name())
+	data, mimeType, err := emojiImage(origfile)
+	if err != nil {
+		httpError(w, err, http.StatusUnprocessableEntity)
+		return
+	}
+
+	emoji := &model.CustomEmoji{
+		Shortcode:       code,
+		Path:            fmt.Sprintf("emoji/%s-%x.%s", code, rand.Uint32(), activitypub.EmojiTypes[mimeType]),
+		MimeType:        mimeType,
+		Category:        r.FormValue("category"),
+		VisibleInPicker: true,
+	}
+	err = activitypub.WriteEmoji(s, emoji.Path, data)
+	if err != nil {
+		httpError(w, err, http.StatusInternalServerError)
+		return
+	}
+	res, err := s.DB().ExecContext(r.Context(), `
+		insert into custom_emojis (Shortcode, Uri, Path, MimeType, Category) values (?, '', ?, ?, ?)`,
+		emoji.Shortcode, emoji.Path, emoji.MimeType, emoji.Category)
+	if err != nil {
+		activitypub.RemoveEmoji(s, emoji)
+		httpError(w, err, http.StatusInternalServerError)
+		return
+	}
+	id, _ := res.LastInsertId()
+	// local emoji are identified by their page, as on Mastodon
+	_, err = s.DB().ExecContext(r.Context(), "update custom_emojis set Uri = ? where Id = ?",
+		absoluteUrl("/emojis/"+strconv.FormatInt(id, 10)), id)
+	if err != nil {
+		httpError(w, err, http.StatusInternalServerError)
+		return
+	}
+	renderAdminEmoji(w, r, s, strconv.FormatInt(id, 10))
+}
+
+func adminEmojiHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, err := currentModerator(s, r)
+		if err != nil {
+			moderatorError(w, err)
+			return
+		}
+		var emoji model.CustomEmoji
+		err = s.DB().GetContext(r.Context(), &emoji, "select * from custom_emojis where Id = ?", mux.Vars(r)["id"])
+		if err != nil {
+			listError(w, err)
+			return
+		}
+		switch r.Method {
+		case "GET":
+		case "PUT", "POST":
+			err = r.ParseForm()
+			if err != nil {
+				httpError(w, err, http.StatusBadRequest)
+				return
+			}
+			if _, ok := r.Form["category"]; ok {
+				emoji.Category = r.Form.Get("category")
+			}
+			if _, ok := r.Form["visible_in_picker"]; ok {
+				emoji.VisibleInPicker = isTrue(r.Form.Get("visible_in_picker"))
+			}
+			if _, ok := r.Form["disabled"]; ok {
+				emoji.Disabled = isTrue(r.Form.Get("disabled"))
+			}
+			_, err = s.DB().ExecContext(r.Context(), `
+				update custom_emojis set Category = ?, VisibleInPicker = ?, Disabled = ?, UpdatedAt = current_timestamp
+				where Id = ?`, emoji.Category, emoji.VisibleInPicker, emoji.Disabled, emoji.Id)
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+		case "DELETE":
+			_, err = s.DB().ExecContext(r.Context(), "delete from custom_emojis where Id = ?", emoji.Id)
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+			activitypub.RemoveEmoji(s, &emoji)
+			httpJson(w, map[string]any{})
+			return
+		default:
+			httpError(w, errors.New("Unsupported method "+r.Method), http.StatusMethodNotAllowed)
+			return
+		}
+		renderAdminEmoji(w, r, s, mux.Vars(r)["id"])
+	}
+}
+
+func renderAdminEmoji(w http.ResponseWriter, r *http.Request, s sparq.Server, id string) {
+	var emoji model.CustomEmoji
+	err := s.DB().GetContext(r.Context(), &emoji, "select * from custom_emojis where Id = ?", id)
+	if err != nil {
+		listError(w, err)
+		return
+	}
+	httpJson(w, adminEmojiMap(&emoji))
+}
+
+// emojiMaps renders the emoji from the domain which are used in
+// any of the texts, "" being our own domain.
+func emojiMaps(ctx context.Context, dbx *sqlx.DB, domain string, texts ...string) ([]map[string]any, error) {
+	emojis, err := activitypub.Emojis(ctx, dbx, domain, texts...)
+	if err != nil {
+		return nil, err
+	}
+	results := []map[string]any{}
+	for idx := range emojis {
+		results = append(results, EmojiMap(&emojis[idx]))
+	}
+	return results, nil
+}
+
+// EmojiMap renders the Mastodon CustomEmoji entity.
+func EmojiMap(emoji *model.CustomEmoji) map[string]any {
+	attrs := map[string]any{
+		"shortcode":         emoji.Shortcode,
+		"url":               emoji.PublicUri(),
+		"static_url":        emoji.PublicUri(),
+		"visible_in_picker": emoji.VisibleInPicker,
+		"category":          nil,
+	}
+	if emoji.Category != "" {
+		attrs["category"] = emoji.Category
+	}
+	return attrs
+}
+
+func adminEmojiMap(emoji *model.CustomEmoji) map[string]any {
+	attrs := EmojiMap(emoji)
+	attrs["id"] = strconv.FormatUint(emoji.Id, 10)
+	attrs["domain"] = nil
+	if !emoji.IsLocal() {
+		attrs["domain"] = emoji.Domain
+		attrs["image_remote_url"] = emoji.ImageUrl
+	}
+	if !emoji.IsCached() {
+		attrs["url"] = nil
+		attrs["static_url"] = nil
+	}
+	attrs["uri"] = emoji.Uri
+	attrs["disabled"] = emoji.Disabled
+	attrs["created_at"] = util.Thens(emoji.CreatedAt)
+	attrs["updated_at"] = util.Thens(emoji.UpdatedAt)
+	return attrs
+}
+
+// emojiDomain is the domain of the actor's emoji, "" for our own
+// accounts.
+func emojiDomain(iri string) string {
+	if _, ok := model.LocalNick(iri); ok {
+		return ""
+	}
+	return hostOf(iri)
+}
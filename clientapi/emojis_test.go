@@ -0,0 +1,128 @@
+package clientapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/png"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/contribsys/sparq/web"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCustomEmoji(t *testing.T) {
+	ts, stopper := web.NewTestServer(t, "emoji")
+	defer stopper()
+	root := rootRouter(ts)
+	AddPublicEndpoints(ts, root.PathPrefix("/api/v1").Subrouter())
+
+	token, err := registerToken(t, ts)
+	assert.NoError(t, err)
+
+	icon := filepath.Join(t.TempDir(), "blobcat.png")
+	file, err := os.Create(icon)
+	assert.NoError(t, err)
+	assert.NoError(t, png.Encode(file, image.NewRGBA(image.Rect(0, 0, 32, 32))))
+	assert.NoError(t, file.Close())
+	notImage := filepath.Join(t.TempDir(), "notes.txt")
+	assert.NoError(t, os.WriteFile(notImage, []byte("just text"), 0644))
+
+	upload := func(path string, fields map[string]string) (int, map[string]any) {
+		buf, wr, err := web.MultipartTestForm("file", path, fields)
+		assert.NoError(t, err)
+		req := httptest.NewRequest("POST", "http://localhost.dev:9494/api/v1/admin/custom_emojis", bytes.NewReader(buf.Bytes()))
+		req.Header.Set("Content-Type", wr.FormDataContentType())
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		root.ServeHTTP(w, req)
+		var result map[string]any
+		if w.Code == 200 {
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		}
+		return w.Code, result
+	}
+	call := func(method, path string, values url.Values) (int, any) {
+		req := httptest.NewRequest(method, "http://localhost.dev:9494/api/v1"+path, strings.NewReader(values.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		root.ServeHTTP(w, req)
+		var result any
+		if w.Code == 200 {
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		}
+		return w.Code, result
+	}
+
+	code, _ := upload(icon, map[string]string{"shortcode": "x"})
+	assert.Equal(t, 422, code)
+	code, _ = upload(notImage, map[string]string{"shortcode": "notes"})
+	assert.Equal(t, 422, code)
+
+	code, emoji := upload(icon, map[string]string{"shortcode": "blobcat", "category": "Blobs"})
+	assert.Equal(t, 200, code)
+	id := emoji["id"].(string)
+	assert.Equal(t, "blobcat", emoji["shortcode"])
+	assert.Equal(t, "Blobs", emoji["category"])
+	assert.Nil(t, emoji["domain"])
+	assert.Equal(t, "https://localhost.dev/emojis/"+id, emoji["uri"])
+	path := strings.TrimPrefix(emoji["url"].(string), "https://localhost.dev/media/")
+	assert.FileExists(t, filepath.Join(ts.MediaRoot(), path))
+
+	code, _ = upload(icon, map[string]string{"shortcode": "blobcat"})
+	assert.Equal(t, 422, code)
+
+	code, result := call("GET", "/custom_emojis", nil)
+	assert.Equal(t, 200, code)
+	emojis := result.([]any)
+	assert.Equal(t, 1, len(emojis))
+	assert.Equal(t, "blobcat", emojis[0].(map[string]any)["shortcode"])
+	assert.Equal(t, emoji["url"], emojis[0].(map[string]any)["static_url"])
+
+	t.Run("Rendering", func(t *testing.T) {
+		_, err := ts.DB().Exec("update accounts set FullName = 'Sparq :blobcat:' where Id = 1")
+		assert.NoError(t, err)
+		_, err = ts.DB().Exec("update toots set Summary = 'cw :blobcat:' where Sid = 'AABA'")
+		assert.NoError(t, err)
+
+		code, result := call("GET", "/statuses/AABA", nil)
+		assert.Equal(t, 200, code)
+		st := result.(map[string]any)
+		emojis := st["emojis"].([]any)
+		assert.Equal(t, 1, len(emojis))
+		assert.Equal(t, "blobcat", emojis[0].(map[string]any)["shortcode"])
+		assert.Equal(t, 1, len(st["account"].(map[string]any)["emojis"].([]any)))
+
+		code, result = call("GET", "/statuses/AABB", nil)
+		assert.Equal(t, 200, code)
+		assert.Empty(t, result.(map[string]any)["emojis"])
+	})
+
+	t.Run("Admin", func(t *testing.T) {
+		code, result := call("PUT", "/admin/custom_emojis/"+id, url.Values{"disabled": {"true"}})
+		assert.Equal(t, 200, code)
+		assert.Equal(t, true, result.(map[string]any)["disabled"])
+		code, result = call("GET", "/custom_emojis", nil)
+		assert.Equal(t, 200, code)
+		assert.Empty(t, result)
+
+		code, result = call("GET", "/admin/custom_emojis?local=true", nil)
+		assert.Equal(t, 200, code)
+		assert.Equal(t, 1, len(result.([]any)))
+
+		code, _ = call("DELETE", "/admin/custom_emojis/"+id, nil)
+		assert.Equal(t, 200, code)
+		assert.NoFileExists(t, filepath.Join(ts.MediaRoot(), path))
+
+		_, err := ts.DB().Exec("update accounts set RoleMask = 1 where Id = 1")
+		assert.NoError(t, err)
+		code, _ = call("GET", "/admin/custom_emojis", nil)
+		assert.Equal(t, 403, code)
+	})
+}
@@ -0,0 +1,784 @@
+package clientapi
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/contribsys/sparq"
+	"github.com/contribsys/sparq/markup"
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/util"
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// GET https://mastodon.example/api/v2/filters
+// POST https://mastodon.example/api/v2/filters
+// GET https://mastodon.example/api/v2/filters/:id
+// PUT https://mastodon.example/api/v2/filters/:id
+// DELETE https://mastodon.example/api/v2/filters/:id
+// GET https://mastodon.example/api/v2/filters/:filter_id/keywords
+// POST https://mastodon.example/api/v2/filters/:filter_id/keywords
+// GET https://mastodon.example/api/v2/filters/keywords/:id
+// PUT https://mastodon.example/api/v2/filters/keywords/:id
+// DELETE https://mastodon.example/api/v2/filters/keywords/:id
+// GET https://mastodon.example/api/v2/filters/:filter_id/statuses
+// POST https://mastodon.example/api/v2/filters/:filter_id/statuses
+// GET https://mastodon.example/api/v2/filters/statuses/:id
+// DELETE https://mastodon.example/api/v2/filters/statuses/:id
+
+func filtersHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		acct, err := currentAccount(s, r)
+		if err != nil {
+			httpError(w, err, http.StatusUnauthorized)
+			return
+		}
+		if r.Method == "POST" {
+			createFilter(w, r, s, acct)
+			return
+		}
+		filters := []model.Filter{}
+		err = s.DB().SelectContext(r.Context(), &filters,
+			"select * from filters where AccountId = ? order by Id", acct.Id)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		results := []map[string]any{}
+		for idx := range filters {
+			attrs, err := FilterMap(r.Context(), s.DB(), &filters[idx])
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+			results = append(results, attrs)
+		}
+		httpJson(w, results)
+	}
+}
+
+func createFilter(w http.ResponseWriter, r *http.Request, s sparq.Server, acct *model.Account) {
+	filter := &model.Filter{
+		AccountId: acct.Id,
+		Action:    model.FilterWarn,
+	}
+	err := updateFilter(r, filter)
+	if err != nil {
+		httpError(w, err, http.StatusUnprocessableEntity)
+		return
+	}
+	keywords, err := nestedAttributes(r, "keywords_attributes")
+	if err != nil {
+		httpError(w, err, http.StatusUnprocessableEntity)
+		return
+	}
+
+	tx, err := s.DB().BeginTxx(r.Context(), nil)
+	if err != nil {
+		httpError(w, err, http.StatusInternalServerError)
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+	res, err := tx.ExecContext(r.Context(), `
+		insert into filters (AccountId, Title, Context, Action, ExpiresAt) values (?, ?, ?, ?, ?)`,
+		filter.AccountId, filter.Title, filter.Context, filter.Action, timestamp(filter.ExpiresAt))
+	if err != nil {
+		httpError(w, err, http.StatusInternalServerError)
+		return
+	}
+	id, _ := res.LastInsertId()
+	filter.Id = uint64(id)
+	err = saveKeywords(r.Context(), tx, filter, keywords)
+	if err != nil {
+		httpError(w, err, http.StatusUnprocessableEntity)
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		httpError(w, err, http.StatusInternalServerError)
+		return
+	}
+	renderFilter(w, r, s, filter)
+}
+
+func filterHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		acct, err := currentAccount(s, r)
+		if err != nil {
+			httpError(w, err, http.StatusUnauthorized)
+			return
+		}
+		filter, err := ownedFilter(r.Context(), s, acct, mux.Vars(r)["id"])
+		if err != nil {
+			listError(w, err)
+			return
+		}
+		switch r.Method {
+		case "GET":
+		case "PUT", "POST":
+			err = updateFilter(r, filter)
+			if err != nil {
+				httpError(w, err, http.StatusUnprocessableEntity)
+				return
+			}
+			keywords, err := nestedAttributes(r, "keywords_attributes")
+			if err != nil {
+				httpError(w, err, http.StatusUnprocessableEntity)
+				return
+			}
+			tx, err := s.DB().BeginTxx(r.Context(), nil)
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+			defer func() { _ = tx.Rollback() }()
+			_, err = tx.ExecContext(r.Context(), `
+				update filters set Title = ?, Context = ?, Action = ?, ExpiresAt = ?, UpdatedAt = current_timestamp
+				where Id = ?`, filter.Title, filter.Context, filter.Action, timestamp(filter.ExpiresAt), filter.Id)
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+			err = saveKeywords(r.Context(), tx, filter, keywords)
+			if err != nil {
+				httpError(w, err, http.StatusUnprocessableEntity)
+				return
+			}
+			err = tx.Commit()
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+		case "DELETE":
+			err = deleteFilter(r.Context(), s, filter.Id)
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+			httpJson(w, map[string]any{})
+			return
+		default:
+			httpError(w, errors.New("Unsupported method "+r.Method), http.StatusMethodNotAllowed)
+			return
+		}
+		renderFilter(w, r, s, filter)
+	}
+}
+
+func renderFilter(w http.ResponseWriter, r *http.Request, s sparq.Server, filter *model.Filter) {
+	attrs, err := FilterMap(r.Context(), s.DB(), filter)
+	if err != nil {
+		httpError(w, err, http.StatusInternalServerError)
+		return
+	}
+	httpJson(w, attrs)
+}
+
+// deleteFilter removes the filter along with its keywords and statuses.
+func deleteFilter(ctx context.Context, s sparq.Server, id uint64) error {
+	for _, table := range []string{"filter_keywords", "filter_statuses"} {
+		_, err := s.DB().ExecContext(ctx, "delete from "+table+" where FilterId = ?", id)
+		if err != nil {
+			return errors.Wrap(err, table)
+		}
+	}
+	_, err := s.DB().ExecContext(ctx, "delete from filters where Id = ?", id)
+	return errors.Wrap(err, "filters")
+}
+
+// updateFilter applies the filter attributes in the request's form.
+// The attributes are all optional when updating an existing filter.
+func updateFilter(r *http.Request, filter *model.Filter) error {
+	err := r.ParseForm()
+	if err != nil {
+		return err
+	}
+	if _, ok := r.Form["title"]; ok || filter.Id == 0 {
+		filter.Title = r.Form.Get("title")
+	}
+	if filter.Title == "" {
+		return errors.New("Title can't be blank")
+	}
+	if contexts, ok := formList(r, "context"); ok || filter.Id == 0 {
+		filter.Context, err = filterContext(contexts)
+		if err != nil {
+			return err
+		}
+	}
+	if action := r.Form.Get("filter_action"); action != "" {
+		switch model.FilterAction(action) {
+		case model.FilterWarn, model.FilterHide:
+			filter.Action = model.FilterAction(action)
+		default:
+			return errors.New("Invalid filter_action: " + action)
+		}
+	}
+	if _, ok := r.Form["expires_in"]; ok {
+		filter.ExpiresAt, err = expiresIn(r.Form.Get("expires_in"))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// formList returns the values of an array parameter, which clients
+// send with or without the [] suffix.
+func formList(r *http.Request, name string) ([]string, bool) {
+	if values, ok := r.Form[name+"[]"]; ok {
+		return values, true
+	}
+	values, ok := r.Form[name]
+	return values, ok
+}
+
+// filterContext validates the contexts and joins them for storage.
+func filterContext(contexts []string) (string, error) {
+	if len(contexts) == 0 {
+		return "", errors.New("Context can't be blank")
+	}
+	for _, fc := range contexts {
+		if !model.ValidFilterContext(fc) {
+			return "", errors.New("Invalid context: " + fc)
+		}
+	}
+	return strings.Join(contexts, ","), nil
+}
+
+// expiresIn converts a number of seconds into an expiry time, a
+// blank value means the filter never expires.
+func expiresIn(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	secs, err := strconv.ParseUint(value, 10, 64)
+	if err != nil || secs == 0 {
+		return nil, errors.New("Invalid expires_in: " + value)
+	}
+	expires := time.Now().UTC().Add(time.Duration(secs) * time.Second).Truncate(time.Second)
+	return &expires, nil
+}
+
+func timestamp(tim *time.Time) any {
+	if tim == nil {
+		return nil
+	}
+	return tim.UTC().Format("2006-01-02 15:04:05")
+}
+
+// nestedAttributes collects the name[][...] parameters, e.g.
+// keywords_attributes[][keyword], into one map per entry. Entries
+// may be indexed, e.g. keywords_attributes[0][keyword], or rely on
+// parameter order.
+func nestedAttributes(r *http.Request, name string) ([]map[string]string, error) {
+	err := r.ParseForm()
+	if err != nil {
+		return nil, err
+	}
+	attribute := regexp.MustCompile(`^` + regexp.QuoteMeta(name) + `\[(\d*)\]\[(\w+)\]$`)
+	indexed := map[string]map[string]string{}
+	ordered := []map[string]string{}
+	for key, values := range r.Form {
+		match := attribute.FindStringSubmatch(key)
+		if match == nil {
+			continue
+		}
+		if match[1] != "" {
+			if indexed[match[1]] == nil {
+				indexed[match[1]] = map[string]string{}
+			}
+			indexed[match[1]][match[2]] = values[0]
+			continue
+		}
+		for idx, value := range values {
+			for len(ordered) <= idx {
+				ordered = append(ordered, map[string]string{})
+			}
+			ordered[idx][match[2]] = value
+		}
+	}
+	keys := make([]string, 0, len(indexed))
+	for key := range indexed {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, _ := strconv.Atoi(keys[i])
+		b, _ := strconv.Atoi(keys[j])
+		return a < b
+	})
+	for _, key := range keys {
+		ordered = append(ordered, indexed[key])
+	}
+	return ordered, nil
+}
+
+// saveKeywords creates, updates or destroys the filter's keywords.
+func saveKeywords(ctx context.Context, tx *sqlx.Tx, filter *model.Filter, keywords []map[string]string) error {
+	for _, attrs := range keywords {
+		id := attrs["id"]
+		if id != "" && isTrue(attrs["_destroy"]) {
+			_, err := tx.ExecContext(ctx, "delete from filter_keywords where Id = ? and FilterId = ?", id, filter.Id)
+			if err != nil {
+				return errors.Wrap(err, "filter_keywords")
+			}
+			continue
+		}
+		var kw model.FilterKeyword
+		if id != "" {
+			err := tx.GetContext(ctx, &kw, "select * from filter_keywords where Id = ? and FilterId = ?", id, filter.Id)
+			if err != nil {
+				return errors.Wrap(err, "keyword "+id)
+			}
+		} else {
+			kw.WholeWord = true
+		}
+		if value, ok := attrs["keyword"]; ok || id == "" {
+			kw.Keyword = strings.TrimSpace(value)
+		}
+		if value, ok := attrs["whole_word"]; ok {
+			kw.WholeWord = isTrue(value)
+		}
+		if kw.Keyword == "" {
+			return errors.New("Keyword can't be blank")
+		}
+		var err error
+		if id != "" {
+			_, err = tx.ExecContext(ctx, "update filter_keywords set Keyword = ?, WholeWord = ? where Id = ?",
+				kw.Keyword, kw.WholeWord, kw.Id)
+		} else {
+			_, err = tx.ExecContext(ctx, "insert into filter_keywords (FilterId, Keyword, WholeWord) values (?, ?, ?)",
+				filter.Id, kw.Keyword, kw.WholeWord)
+		}
+		if err != nil {
+			return errors.Wrap(err, "filter_keywords")
+		}
+	}
+	return nil
+}
+
+func filterKeywordsHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		acct, err := currentAccount(s, r)
+		if err != nil {
+			httpError(w, err, http.StatusUnauthorized)
+			return
+		}
+		filter, err := ownedFilter(r.Context(), s, acct, mux.Vars(r)["id"])
+		if err != nil {
+			listError(w, err)
+			return
+		}
+		if r.Method == "POST" {
+			err = r.ParseForm()
+			if err != nil {
+				httpError(w, err, http.StatusBadRequest)
+				return
+			}
+			kw := model.FilterKeyword{
+				FilterId:  filter.Id,
+				Keyword:   strings.TrimSpace(r.Form.Get("keyword")),
+				WholeWord: r.Form.Get("whole_word") == "" || isTrue(r.Form.Get("whole_word")),
+			}
+			if kw.Keyword == "" {
+				httpError(w, errors.New("Keyword can't be blank"), http.StatusUnprocessableEntity)
+				return
+			}
+			res, err := s.DB().ExecContext(r.Context(),
+				"insert into filter_keywords (FilterId, Keyword, WholeWord) values (?, ?, ?)",
+				kw.FilterId, kw.Keyword, kw.WholeWord)
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+			id, _ := res.LastInsertId()
+			kw.Id = uint64(id)
+			httpJson(w, filterKeywordMap(&kw))
+			return
+		}
+		keywords, _, err := filterParts(r.Context(), s.DB(), filter.Id)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		results := []map[string]any{}
+		for idx := range keywords {
+			results = append(results, filterKeywordMap(&keywords[idx]))
+		}
+		httpJson(w, results)
+	}
+}
+
+func filterKeywordHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		acct, err := currentAccount(s, r)
+		if err != nil {
+			httpError(w, err, http.StatusUnauthorized)
+			return
+		}
+		kw, err := ownedFilterKeyword(r.Context(), s, acct, mux.Vars(r)["id"])
+		if err != nil {
+			listError(w, err)
+			return
+		}
+		switch r.Method {
+		case "GET":
+		case "PUT", "POST":
+			err = r.ParseForm()
+			if err != nil {
+				httpError(w, err, http.StatusBadRequest)
+				return
+			}
+			if _, ok := r.Form["keyword"]; ok {
+				kw.Keyword = strings.TrimSpace(r.Form.Get("keyword"))
+			}
+			if _, ok := r.Form["whole_word"]; ok {
+				kw.WholeWord = isTrue(r.Form.Get("whole_word"))
+			}
+			if kw.Keyword == "" {
+				httpError(w, errors.New("Keyword can't be blank"), http.StatusUnprocessableEntity)
+				return
+			}
+			_, err = s.DB().ExecContext(r.Context(),
+				"update filter_keywords set Keyword = ?, WholeWord = ? where Id = ?", kw.Keyword, kw.WholeWord, kw.Id)
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+		case "DELETE":
+			_, err = s.DB().ExecContext(r.Context(), "delete from filter_keywords where Id = ?", kw.Id)
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+			httpJson(w, map[string]any{})
+			return
+		default:
+			httpError(w, errors.New("Unsupported method "+r.Method), http.StatusMethodNotAllowed)
+			return
+		}
+		httpJson(w, filterKeywordMap(kw))
+	}
+}
+
+func filterStatusesHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		acct, err := currentAccount(s, r)
+		if err != nil {
+			httpError(w, err, http.StatusUnauthorized)
+			return
+		}
+		filter, err := ownedFilter(r.Context(), s, acct, mux.Vars(r)["id"])
+		if err != nil {
+			listError(w, err)
+			return
+		}
+		if r.Method == "POST" {
+			err = r.ParseForm()
+			if err != nil {
+				httpError(w, err, http.StatusBadRequest)
+				return
+			}
+			fs := model.FilterStatus{FilterId: filter.Id}
+			err = s.DB().GetContext(r.Context(), &fs.Sid,
+				"select Sid from toots where Sid = ? and DeletedAt is null", r.Form.Get("status_id"))
+			if err != nil {
+				listError(w, err)
+				return
+			}
+			_, err = s.DB().ExecContext(r.Context(),
+				"insert into filter_statuses (FilterId, Sid) values (?, ?) on conflict do nothing", fs.FilterId, fs.Sid)
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+			err = s.DB().GetContext(r.Context(), &fs,
+				"select * from filter_statuses where FilterId = ? and Sid = ?", fs.FilterId, fs.Sid)
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+			httpJson(w, filterStatusMap(&fs))
+			return
+		}
+		_, statuses, err := filterParts(r.Context(), s.DB(), filter.Id)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		results := []map[string]any{}
+		for idx := range statuses {
+			results = append(results, filterStatusMap(&statuses[idx]))
+		}
+		httpJson(w, results)
+	}
+}
+
+func filterStatusHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		acct, err := currentAccount(s, r)
+		if err != nil {
+			httpError(w, err, http.StatusUnauthorized)
+			return
+		}
+		var fs model.FilterStatus
+		err = s.DB().GetContext(r.Context(), &fs, `
+			select fs.* from filter_statuses fs
+			join filters f on f.Id = fs.FilterId
+			where fs.Id = ? and f.AccountId = ?`, mux.Vars(r)["id"], acct.Id)
+		if err != nil {
+			listError(w, err)
+			return
+		}
+		switch r.Method {
+		case "GET":
+		case "DELETE":
+			_, err = s.DB().ExecContext(r.Context(), "delete from filter_statuses where Id = ?", fs.Id)
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+			httpJson(w, map[string]any{})
+			return
+		default:
+			httpError(w, errors.New("Unsupported method "+r.Method), http.StatusMethodNotAllowed)
+			return
+		}
+		httpJson(w, filterStatusMap(&fs))
+	}
+}
+
+// ownedFilter finds the filter with the given ID if it belongs to the
+// account. Other accounts' filters are reported as missing.
+func ownedFilter(ctx context.Context, s sparq.Server, acct *model.Account, id string) (*model.Filter, error) {
+	var filter model.Filter
+	err := s.DB().GetContext(ctx, &filter, "select * from filters where Id = ? and AccountId = ?", id, acct.Id)
+	if err != nil {
+		return nil, errors.Wrap(err, "filter "+id)
+	}
+	return &filter, nil
+}
+
+func ownedFilterKeyword(ctx context.Context, s sparq.Server, acct *model.Account, id string) (*model.FilterKeyword, error) {
+	var kw model.FilterKeyword
+	err := s.DB().GetContext(ctx, &kw, `
+		select fk.* from filter_keywords fk
+		join filters f on f.Id = fk.FilterId
+		where fk.Id = ? and f.AccountId = ?`, id, acct.Id)
+	if err != nil {
+		return nil, errors.Wrap(err, "filter keyword "+id)
+	}
+	return &kw, nil
+}
+
+func filterParts(ctx context.Context, dbx *sqlx.DB, id uint64) ([]model.FilterKeyword, []model.FilterStatus, error) {
+	keywords := []model.FilterKeyword{}
+	err := dbx.SelectContext(ctx, &keywords, "select * from filter_keywords where FilterId = ? order by Id", id)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "filter_keywords")
+	}
+	statuses := []model.FilterStatus{}
+	err = dbx.SelectContext(ctx, &statuses, "select * from filter_statuses where FilterId = ? order by Id", id)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "filter_statuses")
+	}
+	return keywords, statuses, nil
+}
+
+// FilterMap renders the Mastodon Filter entity.
+func FilterMap(ctx context.Context, dbx *sqlx.DB, filter *model.Filter) (map[string]any, error) {
+	keywords, statuses, err := filterParts(ctx, dbx, filter.Id)
+	if err != nil {
+		return nil, err
+	}
+	return filterMap(filter, keywords, statuses), nil
+}
+
+func filterMap(filter *model.Filter, keywords []model.FilterKeyword, statuses []model.FilterStatus) map[string]any {
+	attrs := map[string]any{
+		"id":            strconv.FormatUint(filter.Id, 10),
+		"title":         filter.Title,
+		"context":       filter.Contexts(),
+		"filter_action": filter.Action,
+		"expires_at":    nil,
+	}
+	if filter.ExpiresAt != nil {
+		attrs["expires_at"] = util.Thens(*filter.ExpiresAt)
+	}
+	kws := []map[string]any{}
+	for idx := range keywords {
+		kws = append(kws, filterKeywordMap(&keywords[idx]))
+	}
+	attrs["keywords"] = kws
+	sts := []map[string]any{}
+	for idx := range statuses {
+		sts = append(sts, filterStatusMap(&statuses[idx]))
+	}
+	attrs["statuses"] = sts
+	return attrs
+}
+
+func filterKeywordMap(kw *model.FilterKeyword) map[string]any {
+	return map[string]any{
+		"id":         strconv.FormatUint(kw.Id, 10),
+		"keyword":    kw.Keyword,
+		"whole_word": kw.WholeWord,
+	}
+}
+
+func filterStatusMap(fs *model.FilterStatus) map[string]any {
+	return map[string]any{
+		"id":        strconv.FormatUint(fs.Id, 10),
+		"status_id": fs.Sid,
+	}
+}
+
+// Filters are a viewer's unexpired filters for one context, ready
+// to be matched against rendered statuses.
+type Filters []*activeFilter
+
+type activeFilter struct {
+	action   model.FilterAction
+	entity   map[string]any
+	keywords []string
+	patterns []*regexp.Regexp
+	sids     map[string]bool
+}
+
+// LoadFilters finds the viewer's filters which apply in the given
+// context. Anonymous and remote viewers have no filters.
+func LoadFilters(ctx context.Context, dbx *sqlx.DB, viewer string, fctx string) (Filters, error) {
+	nick, ok := model.LocalNick(viewer)
+	if !ok {
+		return nil, nil
+	}
+	filters := []model.Filter{}
+	err := dbx.SelectContext(ctx, &filters, `
+		select f.* from filters f
+		join accounts a on a.Id = f.AccountId
+		where a.Nick = ? and (f.ExpiresAt is null or f.ExpiresAt > ?)
+		order by f.Id`, nick, time.Now().UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return nil, errors.Wrap(err, "filters")
+	}
+	result := Filters{}
+	for idx := range filters {
+		filter := &filters[idx]
+		if !filter.AppliesTo(fctx) {
+			continue
+		}
+		keywords, statuses, err := filterParts(ctx, dbx, filter.Id)
+		if err != nil {
+			return nil, err
+		}
+		af := &activeFilter{
+			action: filter.Action,
+			entity: filterMap(filter, keywords, statuses),
+			sids:   map[string]bool{},
+		}
+		for _, kw := range keywords {
+			af.keywords = append(af.keywords, kw.Keyword)
+			af.patterns = append(af.patterns, keywordPattern(kw.Keyword, kw.WholeWord))
+		}
+		for _, fs := range statuses {
+			af.sids[fs.Sid] = true
+		}
+		result = append(result, af)
+	}
+	return result, nil
+}
+
+// keywordPattern matches the keyword case-insensitively. Whole words
+// need a word boundary wherever the keyword starts or ends with a
+// word character, so "#tag" or "c++" still match. RE2's \b only
+// knows ASCII so we spell out the boundary to match "café" too.
+func keywordPattern(keyword string, wholeWord bool) *regexp.Regexp {
+	expr := regexp.QuoteMeta(keyword)
+	if wholeWord {
+		first, _ := utf8.DecodeRuneInString(keyword)
+		last, _ := utf8.DecodeLastRuneInString(keyword)
+		if isWordRune(first) {
+			expr = `(?:^|[^\p{L}\p{N}_])` + expr
+		}
+		if isWordRune(last) {
+			expr = expr + `(?:$|[^\p{L}\p{N}_])`
+		}
+	}
+	return regexp.MustCompile("(?i)" + expr)
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// Apply matches a rendered status against the filters, setting its
+// filtered results. It returns true if a matching filter hides the
+// status. Boosts are matched on the boosted status.
+func (fs Filters) Apply(attrs map[string]any) bool {
+	if len(fs) == 0 {
+		return false
+	}
+	target := attrs
+	if reblog, ok := attrs["reblog"].(map[string]any); ok {
+		target = reblog
+	}
+	text := statusText(target)
+	sid, _ := target["id"].(string)
+
+	hide := false
+	results := []map[string]any{}
+	for _, af := range fs {
+		keywords := []string{}
+		for idx, pattern := range af.patterns {
+			if pattern.MatchString(text) {
+				keywords = append(keywords, af.keywords[idx])
+			}
+		}
+		if len(keywords) == 0 && !af.sids[sid] {
+			continue
+		}
+		result := map[string]any{
+			"filter":          af.entity,
+			"keyword_matches": nil,
+			"status_matches":  nil,
+		}
+		if len(keywords) > 0 {
+			result["keyword_matches"] = keywords
+		}
+		if af.sids[sid] {
+			result["status_matches"] = []string{sid}
+		}
+		results = append(results, result)
+		hide = hide || af.action == model.FilterHide
+	}
+	attrs["filtered"] = results
+	return hide
+}
+
+// statusText is the text of a status which keywords are matched
+// against: its content warning, content and media descriptions.
+func statusText(attrs map[string]any) string {
+	parts := []string{}
+	if summary, ok := attrs["spoiler_text"].(string); ok {
+		parts = append(parts, summary)
+	}
+	if content, ok := attrs["content"].(string); ok {
+		parts = append(parts, markup.Text(content))
+	}
+	if medias, ok := attrs["media_attachments"].([]map[string]any); ok {
+		for _, media := range medias {
+			if desc, ok := media["description"].(string); ok {
+				parts = append(parts, desc)
+			}
+		}
+	}
+	return strings.Join(parts, "\n")
+}
@@ -0,0 +1,265 @@
+package clientapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/web"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilters(t *testing.T) {
+	ts, stopper := web.NewTestServer(t, "filters")
+	defer stopper()
+	root := rootRouter(ts)
+	AddPublicEndpoints(ts, root.PathPrefix("/api/v1").Subrouter())
+	AddV2Endpoints(ts, root.PathPrefix("/api/v2").Subrouter())
+
+	token, err := registerToken(t, ts)
+	assert.NoError(t, err)
+
+	_, err = ts.DB().Exec(`
+		insert into actors (Id, MastodonId, Type, Email, PublicKey, Properties, FetchedAt) values
+		('https://remote.example/users/bob', 1001, 'Person', '', '', '{"preferredUsername":"bob"}', current_timestamp)`)
+	assert.NoError(t, err)
+	_, err = ts.DB().Exec(`
+		insert into toots (Sid, Uri, ActorId, AuthorId, Summary, Content, Visibility, CreatedAt) values
+		('DRG1', 'https://remote.example/notes/1', 1001, null, '', '<p>A <b>Dragon</b> appears</p>', 0, datetime('now', '-3 hours')),
+		('DRG2', 'https://remote.example/notes/2', 1001, null, '', '<p>Here be dragons</p>', 0, datetime('now', '-2 hours')),
+		('TRU1', 'https://remote.example/notes/3', 1001, null, 'trustworthy', '<p>Behind the warning</p>', 0, datetime('now', '-1 hours'))`)
+	assert.NoError(t, err)
+
+	count := 0
+	call := func(method, path string, values url.Values) (int, any) {
+		count++
+		req := httptest.NewRequest(method, "http://localhost.dev:9494/api"+path, strings.NewReader(values.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Idempotency-Key", fmt.Sprintf("filters-%d", count))
+		w := httptest.NewRecorder()
+		root.ServeHTTP(w, req)
+		var result any
+		if w.Code == 200 {
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		}
+		return w.Code, result
+	}
+	// filtered maps each status in the timeline to its filter results
+	filtered := func(path string) map[string][]any {
+		code, result := call("GET", path, nil)
+		assert.Equal(t, 200, code)
+		statuses := map[string][]any{}
+		for _, st := range result.([]any) {
+			attrs := st.(map[string]any)
+			statuses[attrs["id"].(string)] = attrs["filtered"].([]any)
+		}
+		return statuses
+	}
+
+	code, _ := call("POST", "/v2/filters", url.Values{"title": {"Nothing"}})
+	assert.Equal(t, 422, code)
+	code, _ = call("POST", "/v2/filters", url.Values{"title": {"Nope"}, "context[]": {"everywhere"}})
+	assert.Equal(t, 422, code)
+
+	var dragons, trust string
+	t.Run("Keywords", func(t *testing.T) {
+		code, result := call("POST", "/v2/filters", url.Values{
+			"title":                             {"Dragons"},
+			"context[]":                         {"public", "thread"},
+			"keywords_attributes[][keyword]":    {"dragon", "wyvern"},
+			"keywords_attributes[][whole_word]": {"true", "false"},
+		})
+		assert.Equal(t, 200, code)
+		attrs := result.(map[string]any)
+		dragons = attrs["id"].(string)
+		assert.Equal(t, "Dragons", attrs["title"])
+		assert.Equal(t, []any{"public", "thread"}, attrs["context"])
+		assert.Equal(t, "warn", attrs["filter_action"])
+		assert.Nil(t, attrs["expires_at"])
+		keywords := attrs["keywords"].([]any)
+		assert.Equal(t, 2, len(keywords))
+		assert.Equal(t, "dragon", keywords[0].(map[string]any)["keyword"])
+		assert.Equal(t, true, keywords[0].(map[string]any)["whole_word"])
+		assert.Equal(t, false, keywords[1].(map[string]any)["whole_word"])
+
+		statuses := filtered("/v1/timelines/public")
+		assert.Empty(t, statuses["DRG2"])
+		assert.Empty(t, statuses["AABA"])
+		results := statuses["DRG1"]
+		assert.Equal(t, 1, len(results))
+		match := results[0].(map[string]any)
+		assert.Equal(t, dragons, match["filter"].(map[string]any)["id"])
+		assert.Equal(t, []any{"dragon"}, match["keyword_matches"])
+		assert.Nil(t, match["status_matches"])
+
+		code, result = call("GET", "/v1/statuses/DRG1", nil)
+		assert.Equal(t, 200, code)
+		assert.Equal(t, 1, len(result.(map[string]any)["filtered"].([]any)))
+
+		// not in the home context
+		code, result = call("GET", "/v1/timelines/home", nil)
+		assert.Equal(t, 200, code)
+		for _, st := range result.([]any) {
+			assert.Empty(t, st.(map[string]any)["filtered"])
+		}
+	})
+
+	t.Run("Hide", func(t *testing.T) {
+		code, result := call("POST", "/v2/filters", url.Values{
+			"title":                              {"Trust"},
+			"context":                            {"public"},
+			"filter_action":                      {"hide"},
+			"keywords_attributes[0][keyword]":    {"TRUST"},
+			"keywords_attributes[0][whole_word]": {"false"},
+		})
+		assert.Equal(t, 200, code)
+		trust = result.(map[string]any)["id"].(string)
+
+		statuses := filtered("/v1/timelines/public")
+		assert.NotContains(t, statuses, "TRU1")
+		assert.Contains(t, statuses, "DRG1")
+
+		// whole words don't match inside other words
+		code, result = call("GET", "/v2/filters/"+trust, nil)
+		assert.Equal(t, 200, code)
+		kw := result.(map[string]any)["keywords"].([]any)[0].(map[string]any)
+		code, _ = call("PUT", "/v2/filters/keywords/"+kw["id"].(string), url.Values{"whole_word": {"true"}})
+		assert.Equal(t, 200, code)
+		assert.Contains(t, filtered("/v1/timelines/public"), "TRU1")
+	})
+
+	t.Run("Statuses", func(t *testing.T) {
+		code, _ := call("POST", "/v2/filters/"+dragons+"/statuses", url.Values{"status_id": {"missing"}})
+		assert.Equal(t, 404, code)
+		code, result := call("POST", "/v2/filters/"+dragons+"/statuses", url.Values{"status_id": {"AABA"}})
+		assert.Equal(t, 200, code)
+		fs := result.(map[string]any)
+		assert.Equal(t, "AABA", fs["status_id"])
+
+		results := filtered("/v1/timelines/public")["AABA"]
+		assert.Equal(t, 1, len(results))
+		match := results[0].(map[string]any)
+		assert.Nil(t, match["keyword_matches"])
+		assert.Equal(t, []any{"AABA"}, match["status_matches"])
+
+		code, _ = call("DELETE", "/v2/filters/statuses/"+fs["id"].(string), nil)
+		assert.Equal(t, 200, code)
+		assert.Empty(t, filtered("/v1/timelines/public")["AABA"])
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		code, result := call("GET", "/v2/filters/"+dragons+"/keywords", nil)
+		assert.Equal(t, 200, code)
+		keywords := result.([]any)
+		assert.Equal(t, 2, len(keywords))
+		wyvern := keywords[1].(map[string]any)["id"].(string)
+
+		code, result = call("PUT", "/v2/filters/"+dragons, url.Values{
+			"title":                            {"Beasts"},
+			"keywords_attributes[0][id]":       {wyvern},
+			"keywords_attributes[0][_destroy]": {"1"},
+			"keywords_attributes[1][keyword]":  {"dragons"},
+		})
+		assert.Equal(t, 200, code)
+		attrs := result.(map[string]any)
+		assert.Equal(t, "Beasts", attrs["title"])
+		assert.Equal(t, []any{"public", "thread"}, attrs["context"])
+		words := []any{}
+		for _, kw := range attrs["keywords"].([]any) {
+			words = append(words, kw.(map[string]any)["keyword"])
+		}
+		assert.Equal(t, []any{"dragon", "dragons"}, words)
+		assert.NotEmpty(t, filtered("/v1/timelines/public")["DRG2"])
+
+		_, err := ts.DB().Exec("update filters set ExpiresAt = datetime('now', '-1 minutes') where Id = ?", dragons)
+		assert.NoError(t, err)
+		assert.Empty(t, filtered("/v1/timelines/public")["DRG1"])
+
+		code, result = call("PUT", "/v2/filters/"+dragons, url.Values{"expires_in": {"3600"}})
+		assert.Equal(t, 200, code)
+		assert.NotNil(t, result.(map[string]any)["expires_at"])
+		assert.NotEmpty(t, filtered("/v1/timelines/public")["DRG1"])
+	})
+
+	t.Run("Notifications", func(t *testing.T) {
+		_, err := ts.DB().Exec(`
+			insert into actor_notifications (Type, ActorId, FromActorId, ObjectId) values
+			('mention', ?, 'https://remote.example/users/bob', 'https://remote.example/notes/1')`, model.LocalIRI("admin"))
+		assert.NoError(t, err)
+		code, result := call("GET", "/v1/notifications", nil)
+		assert.Equal(t, 200, code)
+		assert.Equal(t, 1, len(result.([]any)))
+
+		code, _ = call("POST", "/v2/filters", url.Values{
+			"title":                          {"Quiet"},
+			"context[]":                      {"notifications"},
+			"filter_action":                  {"hide"},
+			"keywords_attributes[][keyword]": {"appears"},
+		})
+		assert.Equal(t, 200, code)
+		code, result = call("GET", "/v1/notifications", nil)
+		assert.Equal(t, 200, code)
+		assert.Empty(t, result)
+	})
+
+	t.Run("V1", func(t *testing.T) {
+		code, result := call("GET", "/v1/filters", nil)
+		assert.Equal(t, 200, code)
+		phrases := []any{}
+		for _, f := range result.([]any) {
+			phrases = append(phrases, f.(map[string]any)["phrase"])
+		}
+		assert.Equal(t, []any{"dragon", "dragons", "TRUST", "appears"}, phrases)
+
+		code, result = call("POST", "/v1/filters", url.Values{
+			"phrase":       {"Behind"},
+			"context[]":    {"public"},
+			"irreversible": {"true"},
+		})
+		assert.Equal(t, 200, code)
+		f := result.(map[string]any)
+		id := f["id"].(string)
+		assert.Equal(t, "Behind", f["phrase"])
+		assert.Equal(t, true, f["whole_word"])
+		assert.Equal(t, true, f["irreversible"])
+		assert.NotContains(t, filtered("/v1/timelines/public"), "TRU1")
+
+		code, result = call("PUT", "/v1/filters/"+id, url.Values{"irreversible": {"false"}})
+		assert.Equal(t, 200, code)
+		assert.Equal(t, false, result.(map[string]any)["irreversible"])
+		assert.NotEmpty(t, filtered("/v1/timelines/public")["TRU1"])
+
+		code, _ = call("DELETE", "/v1/filters/"+id, nil)
+		assert.Equal(t, 200, code)
+		code, _ = call("GET", "/v1/filters/"+id, nil)
+		assert.Equal(t, 404, code)
+		var filters int
+		assert.NoError(t, ts.DB().Get(&filters, "select count(*) from filters where Title = 'Behind'"))
+		assert.Equal(t, 0, filters)
+	})
+
+	code, _ = call("DELETE", "/v2/filters/"+trust, nil)
+	assert.Equal(t, 200, code)
+	code, _ = call("GET", "/v2/filters/"+trust, nil)
+	assert.Equal(t, 404, code)
+}
+
+func TestKeywordPattern(t *testing.T) {
+	matches := func(keyword, text string) bool {
+		return keywordPattern(keyword, true).MatchString(text)
+	}
+	assert.True(t, matches("cat", "a cat!"))
+	assert.False(t, matches("cat", "concatenate"))
+	assert.True(t, matches("café", "at the CAFÉ today"))
+	assert.True(t, matches("ёлка", "ёлка"))
+	assert.False(t, matches("ёлка", "ёлками"))
+	assert.False(t, matches("café", "cafés"))
+	assert.True(t, matches("#tag", "my#tag"))
+	assert.True(t, matches("c++", "c++11"))
+	assert.True(t, keywordPattern("cat", false).MatchString("concatenate"))
+}
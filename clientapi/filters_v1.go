@@ -0,0 +1,247 @@
+package clientapi
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/contribsys/sparq"
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/util"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+// GET https://mastodon.example/api/v1/filters
+// POST https://mastodon.example/api/v1/filters
+// GET https://mastodon.example/api/v1/filters/:id
+// PUT https://mastodon.example/api/v1/filters/:id
+// DELETE https://mastodon.example/api/v1/filters/:id
+//
+// Version 1 filters are single phrases. Each keyword of a v2 filter
+// appears as a v1 filter with the keyword's ID, and creating a v1
+// filter creates a v2 filter holding one keyword.
+
+// v1Filter is a keyword along with the filter it belongs to.
+type v1Filter struct {
+	model.FilterKeyword
+	Filter model.Filter
+}
+
+func v1FiltersHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		acct, err := currentAccount(s, r)
+		if err != nil {
+			httpError(w, err, http.StatusUnauthorized)
+			return
+		}
+		if r.Method == "POST" {
+			createV1Filter(w, r, s, acct)
+			return
+		}
+		filters := []model.Filter{}
+		err = s.DB().SelectContext(r.Context(), &filters,
+			"select * from filters where AccountId = ? order by Id", acct.Id)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		results := []map[string]any{}
+		for idx := range filters {
+			keywords, _, err := filterParts(r.Context(), s.DB(), filters[idx].Id)
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+			for _, kw := range keywords {
+				results = append(results, v1FilterMap(&v1Filter{kw, filters[idx]}))
+			}
+		}
+		httpJson(w, results)
+	}
+}
+
+func createV1Filter(w http.ResponseWriter, r *http.Request, s sparq.Server, acct *model.Account) {
+	f := &v1Filter{
+		Filter: model.Filter{AccountId: acct.Id},
+	}
+	f.WholeWord = true
+	err := updateV1Filter(r, f)
+	if err != nil {
+		httpError(w, err, http.StatusUnprocessableEntity)
+		return
+	}
+
+	tx, err := s.DB().BeginTxx(r.Context(), nil)
+	if err != nil {
+		httpError(w, err, http.StatusInternalServerError)
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+	res, err := tx.ExecContext(r.Context(), `
+		insert into filters (AccountId, Title, Context, Action, ExpiresAt) values (?, ?, ?, ?, ?)`,
+		f.Filter.AccountId, f.Filter.Title, f.Filter.Context, f.Filter.Action, timestamp(f.Filter.ExpiresAt))
+	if err != nil {
+		httpError(w, err, http.StatusInternalServerError)
+		return
+	}
+	id, _ := res.LastInsertId()
+	f.Filter.Id = uint64(id)
+	f.FilterId = f.Filter.Id
+	res, err = tx.ExecContext(r.Context(),
+		"insert into filter_keywords (FilterId, Keyword, WholeWord) values (?, ?, ?)", f.FilterId, f.Keyword, f.WholeWord)
+	if err != nil {
+		httpError(w, err, http.StatusInternalServerError)
+		return
+	}
+	id, _ = res.LastInsertId()
+	f.Id = uint64(id)
+	err = tx.Commit()
+	if err != nil {
+		httpError(w, err, http.StatusInternalServerError)
+		return
+	}
+	httpJson(w, v1FilterMap(f))
+}
+
+func v1FilterHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		acct, err := currentAccount(s, r)
+		if err != nil {
+			httpError(w, err, http.StatusUnauthorized)
+			return
+		}
+		f, err := ownedV1Filter(r.Context(), s, acct, mux.Vars(r)["id"])
+		if err != nil {
+			listError(w, err)
+			return
+		}
+		switch r.Method {
+		case "GET":
+		case "PUT", "POST":
+			err = updateV1Filter(r, f)
+			if err != nil {
+				httpError(w, err, http.StatusUnprocessableEntity)
+				return
+			}
+			tx, err := s.DB().BeginTxx(r.Context(), nil)
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+			defer func() { _ = tx.Rollback() }()
+			_, err = tx.ExecContext(r.Context(), `
+				update filters set Context = ?, Action = ?, ExpiresAt = ?, UpdatedAt = current_timestamp
+				where Id = ?`, f.Filter.Context, f.Filter.Action, timestamp(f.Filter.ExpiresAt), f.Filter.Id)
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+			_, err = tx.ExecContext(r.Context(),
+				"update filter_keywords set Keyword = ?, WholeWord = ? where Id = ?", f.Keyword, f.WholeWord, f.Id)
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+			err = tx.Commit()
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+		case "DELETE":
+			_, err = s.DB().ExecContext(r.Context(), "delete from filter_keywords where Id = ?", f.Id)
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+			// don't leave behind an empty filter which the v1 API can't show
+			var count int
+			err = s.DB().GetContext(r.Context(), &count, `
+				select (select count(*) from filter_keywords where FilterId = ?) +
+				(select count(*) from filter_statuses where FilterId = ?)`, f.FilterId, f.FilterId)
+			if err == nil && count == 0 {
+				err = deleteFilter(r.Context(), s, f.FilterId)
+			}
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+			httpJson(w, map[string]any{})
+			return
+		default:
+			httpError(w, errors.New("Unsupported method "+r.Method), http.StatusMethodNotAllowed)
+			return
+		}
+		httpJson(w, v1FilterMap(f))
+	}
+}
+
+// updateV1Filter applies the v1 filter attributes in the request's
+// form to the keyword and its filter.
+func updateV1Filter(r *http.Request, f *v1Filter) error {
+	err := r.ParseForm()
+	if err != nil {
+		return err
+	}
+	if _, ok := r.Form["phrase"]; ok || f.Id == 0 {
+		f.Keyword = strings.TrimSpace(r.Form.Get("phrase"))
+	}
+	if f.Keyword == "" {
+		return errors.New("Phrase can't be blank")
+	}
+	if f.Id == 0 {
+		f.Filter.Title = f.Keyword
+	}
+	if contexts, ok := formList(r, "context"); ok || f.Id == 0 {
+		f.Filter.Context, err = filterContext(contexts)
+		if err != nil {
+			return err
+		}
+	}
+	if _, ok := r.Form["whole_word"]; ok {
+		f.WholeWord = isTrue(r.Form.Get("whole_word"))
+	}
+	if _, ok := r.Form["irreversible"]; ok || f.Id == 0 {
+		f.Filter.Action = model.FilterWarn
+		if isTrue(r.Form.Get("irreversible")) {
+			f.Filter.Action = model.FilterHide
+		}
+	}
+	if _, ok := r.Form["expires_in"]; ok {
+		f.Filter.ExpiresAt, err = expiresIn(r.Form.Get("expires_in"))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ownedV1Filter(ctx context.Context, s sparq.Server, acct *model.Account, id string) (*v1Filter, error) {
+	kw, err := ownedFilterKeyword(ctx, s, acct, id)
+	if err != nil {
+		return nil, err
+	}
+	f := &v1Filter{FilterKeyword: *kw}
+	err = s.DB().GetContext(ctx, &f.Filter, "select * from filters where Id = ?", kw.FilterId)
+	if err != nil {
+		return nil, errors.Wrap(err, "filter")
+	}
+	return f, nil
+}
+
+// v1FilterMap renders the Mastodon V1::Filter entity.
+func v1FilterMap(f *v1Filter) map[string]any {
+	attrs := map[string]any{
+		"id":           strconv.FormatUint(f.Id, 10),
+		"phrase":       f.Keyword,
+		"context":      f.Filter.Contexts(),
+		"whole_word":   f.WholeWord,
+		"expires_at":   nil,
+		"irreversible": f.Filter.Action == model.FilterHide,
+	}
+	if f.Filter.ExpiresAt != nil {
+		attrs["expires_at"] = util.Thens(*f.Filter.ExpiresAt)
+	}
+	return attrs
+}
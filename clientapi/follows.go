@@ -0,0 +1,196 @@
+package clientapi
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+
+	"github.com/contribsys/sparq"
+	"github.com/contribsys/sparq/activitypub"
+	"github.com/contribsys/sparq/model"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+// POST https://mastodon.example/api/v1/accounts/:id/follow
+// POST https://mastodon.example/api/v1/accounts/:id/unfollow
+// POST https://mastodon.example/api/v1/follows
+// GET https://mastodon.example/api/v1/follow_requests
+// POST https://mastodon.example/api/v1/follow_requests/:id/authorize
+// POST https://mastodon.example/api/v1/follow_requests/:id/reject
+
+type followFn func(ctx context.Context, s sparq.Server, acct *model.Account, iri string) error
+
+func followHandler(s sparq.Server) http.HandlerFunc {
+	return relationshipHandler(s, func(ctx context.Context, s sparq.Server, acct *model.Account, iri string) error {
+		_, err := activitypub.Follow(ctx, s, acct, iri)
+		return err
+	})
+}
+
+func unfollowHandler(s sparq.Server) http.HandlerFunc {
+	return relationshipHandler(s, activitypub.Unfollow)
+}
+
+func authorizeFollowHandler(s sparq.Server) http.HandlerFunc {
+	return relationshipHandler(s, activitypub.AuthorizeFollower)
+}
+
+func rejectFollowHandler(s sparq.Server) http.HandlerFunc {
+	return relationshipHandler(s, activitypub.RejectFollower)
+}
+
+// relationshipHandler changes the relationship between the current
+// account and the account in the URL, responding with the new
+// Relationship.
+func relationshipHandler(s sparq.Server, fn followFn) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			httpError(w, errors.New("POST only"), http.StatusBadRequest)
+			return
+		}
+		acct, err := currentAccount(s, r)
+		if err != nil {
+			httpError(w, err, http.StatusUnauthorized)
+			return
+		}
+		iri, err := actorIRI(r.Context(), s, mux.Vars(r)["id"])
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				httpError(w, err, http.StatusNotFound)
+				return
+			}
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		err = fn(r.Context(), s, acct, iri)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				httpError(w, err, http.StatusNotFound)
+				return
+			}
+			httpError(w, err, http.StatusUnprocessableEntity)
+			return
+		}
+		rel, err := relationship(r.Context(), s, acct, iri)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		httpJson(w, rel)
+	}
+}
+
+// Follow a remote account by its handle, e.g. "nick@remote.example".
+func remoteFollowHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			httpError(w, errors.New("POST only"), http.StatusBadRequest)
+			return
+		}
+		acct, err := currentAccount(s, r)
+		if err != nil {
+			httpError(w, err, http.StatusUnauthorized)
+			return
+		}
+		err = r.ParseForm()
+		if err != nil {
+			httpError(w, err, http.StatusBadRequest)
+			return
+		}
+		iri, err := activitypub.ResolveHandle(r.Context(), s, r.Form.Get("uri"))
+		if err != nil {
+			httpError(w, err, http.StatusNotFound)
+			return
+		}
+		_, err = activitypub.Follow(r.Context(), s, acct, iri)
+		if err != nil {
+			httpError(w, err, http.StatusUnprocessableEntity)
+			return
+		}
+		attrs, err := AccountMap(r.Context(), s.DB(), iri)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		httpJson(w, attrs)
+	}
+}
+
+func followRequestsHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		acct, err := currentAccount(s, r)
+		if err != nil {
+			httpError(w, err, http.StatusUnauthorized)
+			return
+		}
+		iris := []string{}
+		err = s.DB().SelectContext(r.Context(), &iris, `
+			select ActorId from actor_following
+			where TargetActorId = ? and State = ?
+			order by CreatedAt desc limit 40`, acct.IRI(), activitypub.FollowPending)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		results := []map[string]any{}
+		for _, iri := range iris {
+			attrs, err := AccountMap(r.Context(), s.DB(), iri)
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+			results = append(results, attrs)
+		}
+		httpJson(w, results)
+	}
+}
+
+// relationship renders the Mastodon Relationship entity between
+// the current account and the given actor.
+func relationship(ctx context.Context, s sparq.Server, acct *model.Account, iri string) (map[string]any, error) {
+	attrs, err := AccountMap(ctx, s.DB(), iri)
+	if err != nil {
+		return nil, err
+	}
+	following, err := activitypub.Following(ctx, s, acct.IRI(), iri)
+	if err != nil {
+		return nil, err
+	}
+	followedBy, err := activitypub.Following(ctx, s, iri, acct.IRI())
+	if err != nil {
+		return nil, err
+	}
+	blocking, err := activitypub.Blocking(ctx, s, acct.IRI(), iri)
+	if err != nil {
+		return nil, err
+	}
+	blockedBy, err := activitypub.Blocking(ctx, s, iri, acct.IRI())
+	if err != nil {
+		return nil, err
+	}
+	muting, err := activitypub.Muting(ctx, s, acct.IRI(), iri)
+	if err != nil {
+		return nil, err
+	}
+	domainBlocking, err := activitypub.DomainBlocked(ctx, s, acct.IRI(), iri)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{
+		"id":                   attrs["id"],
+		"following":            following != nil && following.State == activitypub.FollowAccepted,
+		"requested":            following != nil && following.State == activitypub.FollowPending,
+		"followed_by":          followedBy != nil && followedBy.State == activitypub.FollowAccepted,
+		"showing_reblogs":      following != nil,
+		"notifying":            false,
+		"languages":            nil,
+		"blocking":             blocking != nil,
+		"blocked_by":           blockedBy != nil,
+		"muting":               muting != nil,
+		"muting_notifications": muting != nil && muting.HideNotifications,
+		"domain_blocking":      domainBlocking,
+		"endorsed":             false,
+		"note":                 "",
+	}, nil
+}
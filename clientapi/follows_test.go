@@ -0,0 +1,95 @@
+package clientapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/contribsys/sparq/activitypub"
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/web"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFollows(t *testing.T) {
+	ts, stopper := web.NewTestServer(t, "follows")
+	defer stopper()
+	root := rootRouter(ts)
+	AddPublicEndpoints(ts, root.PathPrefix("/api/v1").Subrouter())
+	ctx := context.Background()
+
+	token, err := registerToken(t, ts)
+	assert.NoError(t, err)
+
+	_, err = ts.DB().Exec(`insert into accounts (Id, Sid, Nick, Email, FullName, Visibility) values (2, 'AAAC', 'alice', 'alice@localhost.dev', 'Alice', ?)`, model.Protected)
+	assert.NoError(t, err)
+	_, err = ts.DB().Exec(`insert into account_profiles (AccountId) values (2)`)
+	assert.NoError(t, err)
+	_, err = ts.DB().Exec("update accounts set Visibility = ? where Id = 1", model.Protected)
+	assert.NoError(t, err)
+
+	call := func(method, path string) (int, any) {
+		req := httptest.NewRequest(method, "http://localhost.dev:9494/api/v1"+path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		root.ServeHTTP(w, req)
+		var result any
+		if w.Code == 200 {
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		}
+		return w.Code, result
+	}
+
+	t.Run("FollowRequests", func(t *testing.T) {
+		var alice model.Account
+		assert.NoError(t, ts.DB().Get(&alice, "select * from accounts where Id = 2"))
+		af, err := activitypub.Follow(ctx, ts, &alice, model.LocalIRI("admin"))
+		assert.NoError(t, err)
+		assert.Equal(t, activitypub.FollowPending, af.State)
+
+		code, result := call("GET", "/follow_requests")
+		assert.Equal(t, 200, code)
+		requests := result.([]any)
+		assert.Equal(t, 1, len(requests))
+		assert.Equal(t, "alice", requests[0].(map[string]any)["acct"])
+		assert.Equal(t, true, requests[0].(map[string]any)["locked"])
+
+		code, result = call("POST", "/follow_requests/2/authorize")
+		assert.Equal(t, 200, code)
+		rel := result.(map[string]any)
+		assert.Equal(t, "2", rel["id"])
+		assert.Equal(t, true, rel["followed_by"])
+
+		code, result = call("GET", "/follow_requests")
+		assert.Equal(t, 200, code)
+		assert.Equal(t, 0, len(result.([]any)))
+
+		code, _ = call("POST", "/follow_requests/3/reject")
+		assert.Equal(t, 404, code)
+	})
+
+	t.Run("Follow", func(t *testing.T) {
+		code, result := call("POST", "/accounts/2/follow")
+		assert.Equal(t, 200, code)
+		rel := result.(map[string]any)
+		assert.Equal(t, false, rel["following"])
+		assert.Equal(t, true, rel["requested"])
+
+		code, result = call("POST", "/accounts/2/unfollow")
+		assert.Equal(t, 200, code)
+		rel = result.(map[string]any)
+		assert.Equal(t, false, rel["following"])
+		assert.Equal(t, false, rel["requested"])
+
+		code, _ = call("POST", "/accounts/1/follow")
+		assert.Equal(t, 422, code)
+		code, _ = call("POST", "/accounts/12345/follow")
+		assert.Equal(t, 404, code)
+
+		req := httptest.NewRequest("POST", "http://localhost.dev:9494/api/v1/accounts/2/follow", nil)
+		w := httptest.NewRecorder()
+		root.ServeHTTP(w, req)
+		assert.Equal(t, 401, w.Code)
+	})
+}
@@ -1,12 +1,19 @@
 package clientapi
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 
 	"github.com/contribsys/sparq"
+	"github.com/contribsys/sparq/model"
 	"github.com/contribsys/sparq/web"
 	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrUnauthorized = errors.New("Unauthorized")
 )
 
 func rootRouter(s sparq.Server) *mux.Router {
@@ -26,3 +33,58 @@ func httpJsonResponse(w http.ResponseWriter, body map[string]interface{}, code i
 	enc := json.NewEncoder(w)
 	_ = enc.Encode(body)
 }
+
+func httpJson(w http.ResponseWriter, body any) {
+	w.Header().Add("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(body)
+	if err != nil {
+		httpError(w, err, http.StatusInternalServerError)
+	}
+}
+
+// currentAccount returns the account which owns the request's
+// access token. Accounts awaiting confirmation or approval can't
+// be used yet.
+func currentAccount(s sparq.Server, r *http.Request) (*model.Account, error) {
+	aid := web.Ctx(r).CurrentUserID
+	if aid == web.Anonymous {
+		return nil, ErrUnauthorized
+	}
+	return accountFor(r.Context(), s, aid)
+}
+
+// accountFor loads the active local account with the given ID.
+func accountFor(ctx context.Context, s sparq.Server, aid string) (*model.Account, error) {
+	var acct model.Account
+	err := s.DB().GetContext(ctx, &acct, `
+		select a.*, ap.* from accounts a
+		join account_profiles ap on ap.accountid = a.id
+		where a.id = ? and not `+model.PendingAccount, aid)
+	if err != nil {
+		return nil, errors.Wrap(err, "account "+aid)
+	}
+	return &acct, nil
+}
+
+// viewerIRI returns the IRI of the current account or the empty
+// string for anonymous requests.
+func viewerIRI(s sparq.Server, r *http.Request) string {
+	acct, err := currentAccount(s, r)
+	if err != nil {
+		return ""
+	}
+	return acct.IRI()
+}
+
+// uniq returns the values without duplicates, in their first order.
+func uniq(values []string) []string {
+	seen := map[string]bool{}
+	result := []string{}
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			result = append(result, value)
+		}
+	}
+	return result
+}
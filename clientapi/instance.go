@@ -111,6 +111,10 @@ func createOauthClient(svr sparq.Server, hash map[string]string) (map[string]int
 	if err != nil {
 		return nil, errors.Wrap(err, "oauth_client create")
 	}
+	keys, err := vapidKeys(context.Background(), svr.DB())
+	if err != nil {
+		return nil, err
+	}
 
 	return map[string]interface{}{
 		"name":          hash["client_name"],
@@ -118,7 +122,7 @@ func createOauthClient(svr sparq.Server, hash map[string]string) (map[string]int
 		"redirect_uri":  hash["redirect_uris"],
 		"client_id":     clientId,
 		"client_secret": clientSecret,
-		"vapid_key":     nil,
+		"vapid_key":     keys.Public,
 	}, nil
 }
 
@@ -152,10 +156,16 @@ func appsVerifyHandler(svr sparq.Server) http.HandlerFunc {
 			return
 		}
 
+		keys, err := vapidKeys(r.Context(), svr.DB())
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+
 		b, err := json.Marshal(map[string]interface{}{
 			"name":      name,
 			"website":   website,
-			"vapid_key": nil,
+			"vapid_key": keys.Public,
 		})
 		if err != nil {
 			httpError(w, err, http.StatusInternalServerError)
@@ -202,27 +212,35 @@ func instanceHandler(svr sparq.Server) http.HandlerFunc {
 		Admin:           admin,
 		Fields:          fields,
 	}
-	buf := new(bytes.Buffer)
-	err = instanceTemplate.Execute(buf, inst)
-	if err != nil {
-		panic(err.Error())
-	}
-	code := 200
 	return func(w http.ResponseWriter, r *http.Request) {
+		// admins may change the registration mode at any time
+		mode, err := RegistrationMode(r.Context(), svr.DB())
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		current := *inst
+		current.RegistrationMode = mode
+		buf := new(bytes.Buffer)
+		err = instanceTemplate.Execute(buf, &current)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
 		w.Header().Add("Content-Type", "application/json; charset=utf-8")
-		w.WriteHeader(code)
 		_, _ = w.Write(buf.Bytes())
 	}
 }
 
 type Instance struct {
-	Description     string
-	SoftwareName    string
-	SoftwareVersion string
-	Thumbnail       string
-	Domain          string
-	Admin           model.Account
-	Fields          [][]interface{}
+	Description      string
+	SoftwareName     string
+	SoftwareVersion  string
+	Thumbnail        string
+	Domain           string
+	Admin            model.Account
+	Fields           [][]interface{}
+	RegistrationMode model.RegistrationMode
 }
 
 type User struct {
@@ -243,9 +261,9 @@ var (
 		"stats": { "user_count": 0, "status_count": 0, "domain_count": 0 },
 		"thumbnail": "{{.Thumbnail}}",
 		"languages": [ "en" ],
-		"registrations": true,
-		"approval_required": true,
-		"invites_enabled": false,
+		"registrations": {{ne .RegistrationMode "invite"}},
+		"approval_required": {{eq .RegistrationMode "approval"}},
+		"invites_enabled": true,
 		"configuration": {
 			"accounts": { "max_featured_tags": 10 },
 			"statuses": { "max_characters": 500, "max_media_attachments": 4, "characters_reserved_per_url": 23 },
@@ -0,0 +1,262 @@
+package clientapi
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"github.com/contribsys/sparq"
+	"github.com/contribsys/sparq/activitypub"
+	"github.com/contribsys/sparq/model"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+// POST https://mastodon.example/api/v1/statuses/:id/favourite
+// POST https://mastodon.example/api/v1/statuses/:id/unfavourite
+// POST https://mastodon.example/api/v1/statuses/:id/reblog
+// POST https://mastodon.example/api/v1/statuses/:id/unreblog
+// POST https://mastodon.example/api/v1/statuses/:id/bookmark
+// POST https://mastodon.example/api/v1/statuses/:id/unbookmark
+// GET https://mastodon.example/api/v1/statuses/:id/favourited_by
+// GET https://mastodon.example/api/v1/statuses/:id/reblogged_by
+// GET https://mastodon.example/api/v1/favourites
+// GET https://mastodon.example/api/v1/bookmarks
+
+// statusFn acts on the toot for the account, returning the
+// toot to render in response.
+type statusFn func(ctx context.Context, s sparq.Server, acct *model.Account, toot *model.Toot) (*model.Toot, error)
+
+func favouriteHandler(s sparq.Server) http.HandlerFunc {
+	return statusActionHandler(s, func(ctx context.Context, s sparq.Server, acct *model.Account, toot *model.Toot) (*model.Toot, error) {
+		return toot, activitypub.Favourite(ctx, s, acct, toot)
+	})
+}
+
+func unfavouriteHandler(s sparq.Server) http.HandlerFunc {
+	return statusActionHandler(s, func(ctx context.Context, s sparq.Server, acct *model.Account, toot *model.Toot) (*model.Toot, error) {
+		return toot, activitypub.Unfavourite(ctx, s, acct, toot)
+	})
+}
+
+func reblogHandler(s sparq.Server) http.HandlerFunc {
+	return statusActionHandler(s, activitypub.Reblog)
+}
+
+func unreblogHandler(s sparq.Server) http.HandlerFunc {
+	return statusActionHandler(s, func(ctx context.Context, s sparq.Server, acct *model.Account, toot *model.Toot) (*model.Toot, error) {
+		return toot, activitypub.Unreblog(ctx, s, acct, toot)
+	})
+}
+
+func bookmarkHandler(s sparq.Server) http.HandlerFunc {
+	return statusActionHandler(s, func(ctx context.Context, s sparq.Server, acct *model.Account, toot *model.Toot) (*model.Toot, error) {
+		_, err := s.DB().ExecContext(ctx, `
+			insert into actor_bookmarks (ActorId, ObjectId) values (?, ?)
+			on conflict do nothing`, acct.IRI(), toot.Uri)
+		return toot, errors.Wrap(err, "actor_bookmarks")
+	})
+}
+
+func unbookmarkHandler(s sparq.Server) http.HandlerFunc {
+	return statusActionHandler(s, func(ctx context.Context, s sparq.Server, acct *model.Account, toot *model.Toot) (*model.Toot, error) {
+		_, err := s.DB().ExecContext(ctx,
+			"delete from actor_bookmarks where ActorId = ? and ObjectId = ?", acct.IRI(), toot.Uri)
+		return toot, errors.Wrap(err, "actor_bookmarks")
+	})
+}
+
+// statusActionHandler applies the action to the toot in the URL,
+// or the boosted toot if it is a boost, and renders the result.
+func statusActionHandler(s sparq.Server, fn statusFn) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			httpError(w, errors.New("POST only"), http.StatusBadRequest)
+			return
+		}
+		acct, err := currentAccount(s, r)
+		if err != nil {
+			httpError(w, err, http.StatusUnauthorized)
+			return
+		}
+		toot, err := findViewableToot(r.Context(), s, mux.Vars(r)["id"], acct.IRI())
+		if err != nil {
+			listError(w, err)
+			return
+		}
+		result, err := fn(r.Context(), s, acct, toot)
+		if err != nil {
+			if errors.Is(err, activitypub.ErrCannotReblog) {
+				httpError(w, err, http.StatusUnprocessableEntity)
+				return
+			}
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		attrs, err := TootMapFor(s.DB(), result.Sid, acct.IRI())
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		httpJson(w, attrs)
+	}
+}
+
+// findToot returns the toot with the given ID or, if the toot
+// is a boost, the boosted toot.
+func findToot(ctx context.Context, s sparq.Server, sid string) (*model.Toot, error) {
+	var toot model.Toot
+	err := s.DB().GetContext(ctx, &toot, "select * from toots where Sid = ? and DeletedAt is null", sid)
+	if err != nil {
+		return nil, errors.Wrap(err, "toot "+sid)
+	}
+	if toot.BoostOfId != nil {
+		return findToot(ctx, s, *toot.BoostOfId)
+	}
+	return &toot, nil
+}
+
+// findViewableToot is findToot for the toots which the viewer may
+// see, the rest aren't found.
+func findViewableToot(ctx context.Context, s sparq.Server, sid, viewer string) (*model.Toot, error) {
+	toot, err := findToot(ctx, s, sid)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := canView(ctx, s, toot, viewer)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.Wrap(sql.ErrNoRows, "toot "+sid)
+	}
+	return toot, nil
+}
+
+func favouritedByHandler(s sparq.Server) http.HandlerFunc {
+	return actorsForTootHandler(s, "actor_favorites")
+}
+
+func rebloggedByHandler(s sparq.Server) http.HandlerFunc {
+	return actorsForTootHandler(s, "actor_reblogs")
+}
+
+// actorsForTootHandler lists the accounts which favourited or
+// reblogged the toot, most recent first.
+func actorsForTootHandler(s sparq.Server, table string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		toot, err := findViewableToot(r.Context(), s, mux.Vars(r)["id"], viewerIRI(s, r))
+		if err != nil {
+			listError(w, err)
+			return
+		}
+		iris := []string{}
+		err = s.DB().SelectContext(r.Context(), &iris,
+			"select ActorId from "+table+" where ObjectId = ? order by CreatedAt desc limit 40", toot.Uri)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		results := []map[string]any{}
+		for _, iri := range iris {
+			attrs, err := AccountMap(r.Context(), s.DB(), iri)
+			if err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					// the actor has been deleted
+					continue
+				}
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+			results = append(results, attrs)
+		}
+		httpJson(w, results)
+	}
+}
+
+func favouritesHandler(s sparq.Server) http.HandlerFunc {
+	return savedTootsHandler(s, "actor_favorites")
+}
+
+func bookmarksHandler(s sparq.Server) http.HandlerFunc {
+	return savedTootsHandler(s, "actor_bookmarks")
+}
+
+// savedTootsHandler lists the toots the current account favourited
+// or bookmarked, most recent first. Pages are based on when the toot
+// was saved rather than the toot's ID.
+func savedTootsHandler(s sparq.Server, table string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		acct, err := currentAccount(s, r)
+		if err != nil {
+			httpError(w, err, http.StatusUnauthorized)
+			return
+		}
+		err = r.ParseForm()
+		if err != nil {
+			httpError(w, err, http.StatusBadRequest)
+			return
+		}
+		limit := 20
+		if value := r.Form.Get("limit"); value != "" {
+			limit, err = strconv.Atoi(value)
+			if err != nil {
+				httpError(w, errors.Wrap(err, "Invalid limit"), http.StatusBadRequest)
+				return
+			}
+		}
+		if limit <= 0 || limit > 40 {
+			limit = 40
+		}
+
+		query := `select x.rowid as Id, t.Sid from ` + table + ` x
+			join toots t on t.Uri = x.ObjectId
+			where x.ActorId = ? and t.DeletedAt is null`
+		args := []any{acct.IRI()}
+		if id := r.Form.Get("max_id"); id != "" {
+			query += " and x.rowid < ?"
+			args = append(args, id)
+		}
+		if id := r.Form.Get("min_id"); id != "" {
+			query += " and x.rowid > ?"
+			args = append(args, id)
+		}
+		// min_id asks for the toots saved immediately after the given one
+		ascending := r.Form.Get("min_id") != "" && r.Form.Get("max_id") == ""
+		if ascending {
+			query += " order by x.rowid asc limit ?"
+		} else {
+			query += " order by x.rowid desc limit ?"
+		}
+		args = append(args, limit)
+
+		saved := []struct {
+			Id  int64
+			Sid string
+		}{}
+		err = s.DB().SelectContext(r.Context(), &saved, query, args...)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		if ascending {
+			for i, j := 0, len(saved)-1; i < j; i, j = i+1, j-1 {
+				saved[i], saved[j] = saved[j], saved[i]
+			}
+		}
+		results := []map[string]any{}
+		for _, row := range saved {
+			attrs, err := TootMapFor(s.DB(), row.Sid, acct.IRI())
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+			results = append(results, attrs)
+		}
+		if len(saved) > 0 {
+			linkHeader(w, r, strconv.FormatInt(saved[len(saved)-1].Id, 10), strconv.FormatInt(saved[0].Id, 10))
+		}
+		httpJson(w, results)
+	}
+}
@@ -0,0 +1,157 @@
+package clientapi
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/web"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInteractions(t *testing.T) {
+	ts, stopper := web.NewTestServer(t, "interactions")
+	defer stopper()
+	jobs := ts.Jobs().(*web.TestJobs)
+	root := rootRouter(ts)
+	AddPublicEndpoints(ts, root.PathPrefix("/api/v1").Subrouter())
+
+	token, err := registerToken(t, ts)
+	assert.NoError(t, err)
+
+	bob := "https://remote.example/users/bob"
+	_, err = ts.DB().Exec(`
+		insert into actors (Id, MastodonId, Type, Email, PublicKey, Properties, FetchedAt) values
+		(?, 1001, 'Person', '', '', ?, current_timestamp)`,
+		bob, `{"preferredUsername":"bob","inbox":"https://remote.example/users/bob/inbox"}`)
+	assert.NoError(t, err)
+	_, err = ts.DB().Exec(`
+		insert into actor_following (Id, ActorId, TargetActorId, TargetActorAccount, State)
+		values (?, ?, ?, 'admin@localhost.dev', 'accepted')`, bob+"#follows/1", bob, model.LocalIRI("admin"))
+	assert.NoError(t, err)
+	_, err = ts.DB().Exec(`
+		insert into toots (Sid, Uri, ActorId, AuthorId, Summary, Content, Visibility, CreatedAt) values
+		('BOB1', 'https://remote.example/notes/1', 1001, null, '', 'From bob', 0, '2030-01-01 00:00:01'),
+		('BOB2', 'https://remote.example/notes/2', 1001, null, '', 'Bob, followers only', 2, '2030-01-01 00:00:02')`)
+	assert.NoError(t, err)
+
+	call := func(method, path string) (int, any) {
+		req := httptest.NewRequest(method, "http://localhost.dev:9494/api/v1"+path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		root.ServeHTTP(w, req)
+		var result any
+		if w.Code == 200 {
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		}
+		return w.Code, result
+	}
+	status := func(method, path string) map[string]any {
+		code, result := call(method, path)
+		assert.Equal(t, 200, code, path)
+		if code != 200 {
+			return map[string]any{}
+		}
+		return result.(map[string]any)
+	}
+	// the type of the last activity delivered
+	delivered := func() string {
+		deliveries := jobs.Find("Deliver")
+		if len(deliveries) == 0 {
+			return ""
+		}
+		activity := map[string]any{}
+		assert.NoError(t, json.Unmarshal([]byte(deliveries[len(deliveries)-1].Args[2].(string)), &activity))
+		return activity["type"].(string)
+	}
+
+	t.Run("Favourite", func(t *testing.T) {
+		toot := status("POST", "/statuses/BOB1/favourite")
+		assert.Equal(t, true, toot["favourited"])
+		assert.EqualValues(t, 1, toot["favourites_count"])
+		assert.Equal(t, "Like", delivered())
+
+		// favouriting twice is harmless
+		toot = status("POST", "/statuses/BOB1/favourite")
+		assert.EqualValues(t, 1, toot["favourites_count"])
+
+		code, result := call("GET", "/statuses/BOB1/favourited_by")
+		assert.Equal(t, 200, code)
+		assert.Equal(t, "admin", result.([]any)[0].(map[string]any)["acct"])
+
+		code, result = call("GET", "/favourites")
+		assert.Equal(t, 200, code)
+		assert.Equal(t, 1, len(result.([]any)))
+
+		toot = status("POST", "/statuses/BOB1/unfavourite")
+		assert.Equal(t, false, toot["favourited"])
+		assert.EqualValues(t, 0, toot["favourites_count"])
+		assert.Equal(t, "Undo", delivered())
+
+		code, _ = call("POST", "/statuses/NOPE/favourite")
+		assert.Equal(t, 404, code)
+	})
+
+	t.Run("Bookmark", func(t *testing.T) {
+		toot := status("POST", "/statuses/AABA/bookmark")
+		assert.Equal(t, true, toot["bookmarked"])
+		status("POST", "/statuses/BOB1/bookmark")
+
+		code, result := call("GET", "/bookmarks?limit=1")
+		assert.Equal(t, 200, code)
+		assert.Equal(t, "BOB1", result.([]any)[0].(map[string]any)["id"])
+		code, result = call("GET", "/bookmarks?max_id=2")
+		assert.Equal(t, 200, code)
+		assert.Equal(t, "AABA", result.([]any)[0].(map[string]any)["id"])
+
+		toot = status("POST", "/statuses/AABA/unbookmark")
+		assert.Equal(t, false, toot["bookmarked"])
+		code, result = call("GET", "/bookmarks")
+		assert.Equal(t, 200, code)
+		assert.Equal(t, 1, len(result.([]any)))
+
+		// bookmarks are private
+		assert.Empty(t, jobs.Find("Deliver")[2:])
+	})
+
+	t.Run("Reblog", func(t *testing.T) {
+		boost := status("POST", "/statuses/BOB1/reblog")
+		assert.NotEqual(t, "BOB1", boost["id"])
+		assert.Equal(t, "admin", boost["account"].(map[string]any)["acct"])
+		original := boost["reblog"].(map[string]any)
+		assert.Equal(t, "BOB1", original["id"])
+		assert.Equal(t, true, original["reblogged"])
+		assert.EqualValues(t, 1, original["reblogs_count"])
+		assert.Equal(t, "Announce", delivered())
+
+		// reblogging the boost reblogs the original
+		again := status("POST", "/statuses/"+boost["id"].(string)+"/reblog")
+		assert.Equal(t, boost["id"], again["id"])
+
+		code, result := call("GET", "/statuses/BOB1/reblogged_by")
+		assert.Equal(t, 200, code)
+		assert.Equal(t, 1, len(result.([]any)))
+
+		code, result = call("GET", "/timelines/home")
+		assert.Equal(t, 200, code)
+		assert.Equal(t, boost["id"], result.([]any)[0].(map[string]any)["id"])
+		code, result = call("GET", "/timelines/public")
+		assert.Equal(t, 200, code)
+		for _, toot := range result.([]any) {
+			assert.Nil(t, toot.(map[string]any)["reblog"])
+		}
+
+		// admin doesn't follow bob so can't see, let alone reblog, BOB2
+		code, _ = call("POST", "/statuses/BOB2/reblog")
+		assert.Equal(t, 404, code)
+
+		toot := status("POST", "/statuses/BOB1/unreblog")
+		assert.Equal(t, "BOB1", toot["id"])
+		assert.Equal(t, false, toot["reblogged"])
+		assert.EqualValues(t, 0, toot["reblogs_count"])
+		assert.Equal(t, "Undo", delivered())
+		code, _ = call("GET", "/statuses/"+boost["id"].(string))
+		assert.Equal(t, 404, code)
+	})
+}
@@ -0,0 +1,148 @@
+package clientapi
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/contribsys/sparq"
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/util"
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// GET https://mastodon.example/api/v1/admin/invites
+// POST https://mastodon.example/api/v1/admin/invites
+// DELETE https://mastodon.example/api/v1/admin/invites/:id
+
+// adminInvitesHandler lists the invites or creates one which may be
+// used max_uses times and expires in expires_in seconds, both
+// optional.
+func adminInvitesHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		mod, err := currentModerator(s, r)
+		if err != nil {
+			moderatorError(w, err)
+			return
+		}
+		switch r.Method {
+		case "GET":
+		case "POST":
+			var maxUses *int64
+			if value := r.FormValue("max_uses"); value != "" {
+				uses, err := strconv.ParseInt(value, 10, 64)
+				if err != nil || uses <= 0 {
+					httpError(w, errors.New("Invalid max_uses: "+value), http.StatusUnprocessableEntity)
+					return
+				}
+				maxUses = &uses
+			}
+			expiresAt, err := expiresIn(r.FormValue("expires_in"))
+			if err != nil {
+				httpError(w, err, http.StatusUnprocessableEntity)
+				return
+			}
+			code, err := randomToken(4)
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+			res, err := s.DB().ExecContext(r.Context(), `
+				insert into invites (Code, AccountId, MaxUses, ExpiresAt) values (?, ?, ?, ?)`,
+				code, mod.Id, maxUses, timestamp(expiresAt))
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+			id, _ := res.LastInsertId()
+			attrs, err := inviteMap(r.Context(), s.DB(), id)
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+			httpJson(w, attrs)
+			return
+		default:
+			httpError(w, errors.New("Unsupported method "+r.Method), http.StatusMethodNotAllowed)
+			return
+		}
+
+		rows := []struct{ Id int64 }{}
+		err = selectPage(r, s, &rows, "select Id from invites")
+		if err != nil {
+			httpError(w, err, http.StatusBadRequest)
+			return
+		}
+		results := []map[string]any{}
+		for _, row := range rows {
+			attrs, err := inviteMap(r.Context(), s.DB(), row.Id)
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+			results = append(results, attrs)
+		}
+		if len(rows) > 0 {
+			linkHeader(w, r, strconv.FormatInt(rows[len(rows)-1].Id, 10), strconv.FormatInt(rows[0].Id, 10))
+		}
+		httpJson(w, results)
+	}
+}
+
+// adminInviteHandler shows an invite or expires it so it can't be
+// used again. It's kept to show who was invited by whom.
+func adminInviteHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, err := currentModerator(s, r)
+		if err != nil {
+			moderatorError(w, err)
+			return
+		}
+		id := mux.Vars(r)["id"]
+		switch r.Method {
+		case "GET":
+		case "DELETE":
+			_, err = s.DB().ExecContext(r.Context(), `
+				update invites set ExpiresAt = current_timestamp
+				where Id = ? and (ExpiresAt is null or ExpiresAt > current_timestamp)`, id)
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+		default:
+			httpError(w, errors.New("Unsupported method "+r.Method), http.StatusMethodNotAllowed)
+			return
+		}
+		attrs, err := inviteMap(r.Context(), s.DB(), id)
+		if err != nil {
+			listError(w, err)
+			return
+		}
+		httpJson(w, attrs)
+	}
+}
+
+func inviteMap(ctx context.Context, dbx *sqlx.DB, id any) (map[string]any, error) {
+	var invite model.Invite
+	err := dbx.GetContext(ctx, &invite, "select * from invites where Id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	attrs := map[string]any{
+		"id":         strconv.FormatInt(invite.Id, 10),
+		"code":       invite.Code,
+		"url":        absoluteUrl("/signup?invite_code=" + url.QueryEscape(invite.Code)),
+		"uses":       invite.Uses,
+		"max_uses":   invite.MaxUses,
+		"expires_at": nil,
+		"expired":    invite.IsExpired(),
+		"created_at": util.Thens(invite.CreatedAt),
+	}
+	if invite.ExpiresAt != nil {
+		attrs["expires_at"] = util.Thens(*invite.ExpiresAt)
+	}
+	return attrs, nil
+}
@@ -0,0 +1,92 @@
+package clientapi
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/contribsys/sparq"
+	"github.com/contribsys/sparq/activitypub"
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/util"
+	"github.com/pkg/errors"
+)
+
+var (
+	// MediaPurgeInterval is how often, in seconds, we purge
+	// media which was uploaded but never attached to a toot.
+	MediaPurgeInterval int64 = 3600
+	// OrphanMediaAge is how long uploaded media may stay
+	// unattached before it is purged.
+	OrphanMediaAge = 24 * time.Hour
+)
+
+// Register the client API jobs with the job runner.
+func Register(svr sparq.Server) {
+	js := svr.Jobs()
+	js.Register("PublishScheduled", func(ctx context.Context, args ...interface{}) error {
+		if len(args) == 0 {
+			return errors.New("Missing scheduled toot ID")
+		}
+		return publishScheduled(ctx, svr, fmt.Sprint(args[0]))
+	})
+	js.Register("SendConfirmation", func(ctx context.Context, args ...interface{}) error {
+		if len(args) == 0 {
+			return errors.New("Missing account ID")
+		}
+		return sendConfirmation(ctx, svr, fmt.Sprint(args[0]))
+	})
+	js.Register("PurgeMedia", func(ctx context.Context, args ...interface{}) error {
+		return purgeMedia(ctx, svr)
+	})
+	js.Periodic("PurgeMedia", MediaPurgeInterval)
+	js.Register("ComputeTrends", func(ctx context.Context, args ...interface{}) error {
+		return computeTrends(ctx, svr)
+	})
+	js.Periodic("ComputeTrends", TrendsInterval)
+	js.Register("DeliverPush", func(ctx context.Context, args ...interface{}) error {
+		if len(args) < 2 {
+			return errors.New("Missing subscription or notification ID")
+		}
+		return deliverPush(ctx, svr, fmt.Sprint(args[0]), fmt.Sprint(args[1]))
+	})
+	// the listener is global so it goes when this server does
+	activitypub.Until(svr.Context(), activitypub.OnNotify(queuePush))
+}
+
+// purgeMedia deletes media which was never attached to a toot,
+// along with its files. Media reserved for a scheduled toot is kept.
+func purgeMedia(ctx context.Context, svr sparq.Server) error {
+	medias := []model.TootMedia{}
+	err := svr.DB().SelectContext(ctx, &medias,
+		"select * from toot_medias where Sid = '' and ScheduledId is null")
+	if err != nil {
+		return errors.Wrap(err, "toot_medias")
+	}
+	cutoff := time.Now().Add(-OrphanMediaAge)
+	count := 0
+	for idx := range medias {
+		media := &medias[idx]
+		if media.CreatedAt.After(cutoff) {
+			continue
+		}
+		for _, variant := range []string{"full", "thumb"} {
+			path := svr.MediaRoot() + strings.TrimPrefix(media.DiskPath(variant), "/media")
+			err := os.Remove(path)
+			if err != nil && !os.IsNotExist(err) {
+				return errors.Wrap(err, "media "+path)
+			}
+		}
+		_, err := svr.DB().ExecContext(ctx, "delete from toot_medias where Id = ?", media.Id)
+		if err != nil {
+			return errors.Wrap(err, "toot_medias")
+		}
+		count++
+	}
+	if count > 0 {
+		util.Infof("Purged %d orphaned media", count)
+	}
+	return nil
+}
@@ -0,0 +1,317 @@
+package clientapi
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"github.com/contribsys/sparq"
+	"github.com/contribsys/sparq/activitypub"
+	"github.com/contribsys/sparq/model"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+// GET https://mastodon.example/api/v1/lists
+// POST https://mastodon.example/api/v1/lists
+// GET https://mastodon.example/api/v1/lists/:id
+// PUT https://mastodon.example/api/v1/lists/:id
+// DELETE https://mastodon.example/api/v1/lists/:id
+// GET https://mastodon.example/api/v1/lists/:id/accounts
+// POST https://mastodon.example/api/v1/lists/:id/accounts
+// DELETE https://mastodon.example/api/v1/lists/:id/accounts
+// GET https://mastodon.example/api/v1/accounts/:id/lists
+
+var (
+	ErrNotFollowed = errors.New("You must follow the account to add it to a list")
+)
+
+func listsHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		acct, err := currentAccount(s, r)
+		if err != nil {
+			httpError(w, err, http.StatusUnauthorized)
+			return
+		}
+		if r.Method == "POST" {
+			createList(w, r, s, acct)
+			return
+		}
+		lists := []model.List{}
+		err = s.DB().SelectContext(r.Context(), &lists,
+			"select * from lists where AccountId = ? order by Title", acct.Id)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		results := []map[string]any{}
+		for idx := range lists {
+			results = append(results, listMap(&lists[idx]))
+		}
+		httpJson(w, results)
+	}
+}
+
+func createList(w http.ResponseWriter, r *http.Request, s sparq.Server, acct *model.Account) {
+	list := &model.List{
+		AccountId:     acct.Id,
+		RepliesPolicy: model.RepliesList,
+	}
+	err := updateList(r, list)
+	if err != nil {
+		httpError(w, err, http.StatusUnprocessableEntity)
+		return
+	}
+	res, err := s.DB().ExecContext(r.Context(), `
+		insert into lists (AccountId, Title, RepliesPolicy, Exclusive) values (?, ?, ?, ?)`,
+		list.AccountId, list.Title, list.RepliesPolicy, list.Exclusive)
+	if err != nil {
+		httpError(w, err, http.StatusInternalServerError)
+		return
+	}
+	id, _ := res.LastInsertId()
+	list.Id = uint64(id)
+	httpJson(w, listMap(list))
+}
+
+// updateList applies the list attributes in the request's form.
+func updateList(r *http.Request, list *model.List) error {
+	err := r.ParseForm()
+	if err != nil {
+		return err
+	}
+	if _, ok := r.Form["title"]; ok || list.Id == 0 {
+		list.Title = r.Form.Get("title")
+	}
+	if list.Title == "" {
+		return errors.New("Title can't be blank")
+	}
+	if policy := r.Form.Get("replies_policy"); policy != "" {
+		if !model.ValidRepliesPolicy(policy) {
+			return errors.New("Invalid replies_policy: " + policy)
+		}
+		list.RepliesPolicy = model.RepliesPolicy(policy)
+	}
+	if _, ok := r.Form["exclusive"]; ok {
+		list.Exclusive = isTrue(r.Form.Get("exclusive"))
+	}
+	return nil
+}
+
+func listHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		acct, err := currentAccount(s, r)
+		if err != nil {
+			httpError(w, err, http.StatusUnauthorized)
+			return
+		}
+		list, err := ownedList(r.Context(), s, acct, mux.Vars(r)["id"])
+		if err != nil {
+			listError(w, err)
+			return
+		}
+		switch r.Method {
+		case "GET":
+		case "PUT", "POST":
+			err = updateList(r, list)
+			if err != nil {
+				httpError(w, err, http.StatusUnprocessableEntity)
+				return
+			}
+			_, err = s.DB().ExecContext(r.Context(), `
+				update lists set Title = ?, RepliesPolicy = ?, Exclusive = ?, UpdatedAt = current_timestamp
+				where Id = ?`, list.Title, list.RepliesPolicy, list.Exclusive, list.Id)
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+		case "DELETE":
+			err = deleteList(r.Context(), s, list.Id)
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+			httpJson(w, map[string]any{})
+			return
+		default:
+			httpError(w, errors.New("Unsupported method "+r.Method), http.StatusMethodNotAllowed)
+			return
+		}
+		httpJson(w, listMap(list))
+	}
+}
+
+// deleteList removes the list and its members. SQLite doesn't
+// enforce foreign keys so we can't rely on the cascade.
+func deleteList(ctx context.Context, s sparq.Server, id uint64) error {
+	tx, err := s.DB().BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	_, err = tx.ExecContext(ctx, "delete from list_accounts where ListId = ?", id)
+	if err != nil {
+		return errors.Wrap(err, "list_accounts")
+	}
+	_, err = tx.ExecContext(ctx, "delete from lists where Id = ?", id)
+	if err != nil {
+		return errors.Wrap(err, "lists")
+	}
+	return tx.Commit()
+}
+
+func listAccountsHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		acct, err := currentAccount(s, r)
+		if err != nil {
+			httpError(w, err, http.StatusUnauthorized)
+			return
+		}
+		list, err := ownedList(r.Context(), s, acct, mux.Vars(r)["id"])
+		if err != nil {
+			listError(w, err)
+			return
+		}
+		err = r.ParseForm()
+		if err != nil {
+			httpError(w, err, http.StatusBadRequest)
+			return
+		}
+
+		switch r.Method {
+		case "GET":
+			limit := 40
+			if value := r.Form.Get("limit"); value != "" {
+				limit, err = strconv.Atoi(value)
+				if err != nil || limit < 0 {
+					httpError(w, errors.New("Invalid limit"), http.StatusBadRequest)
+					return
+				}
+			}
+			iris := []string{}
+			query := "select ActorId from list_accounts where ListId = ? order by CreatedAt"
+			if limit > 0 {
+				query += " limit " + strconv.Itoa(limit)
+			}
+			err = s.DB().SelectContext(r.Context(), &iris, query, list.Id)
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+			results := []map[string]any{}
+			for _, iri := range iris {
+				attrs, err := AccountMap(r.Context(), s.DB(), iri)
+				if err != nil {
+					httpError(w, err, http.StatusInternalServerError)
+					return
+				}
+				results = append(results, attrs)
+			}
+			httpJson(w, results)
+			return
+		case "POST", "DELETE":
+		default:
+			httpError(w, errors.New("Unsupported method "+r.Method), http.StatusMethodNotAllowed)
+			return
+		}
+
+		ids := r.Form["account_ids[]"]
+		if len(ids) == 0 {
+			ids = r.Form["account_ids"]
+		}
+		for _, id := range ids {
+			iri, err := actorIRI(r.Context(), s, id)
+			if err != nil {
+				listError(w, err)
+				return
+			}
+			if r.Method == "DELETE" {
+				_, err = s.DB().ExecContext(r.Context(),
+					"delete from list_accounts where ListId = ? and ActorId = ?", list.Id, iri)
+				if err != nil {
+					httpError(w, err, http.StatusInternalServerError)
+					return
+				}
+				continue
+			}
+			af, err := activitypub.Following(r.Context(), s, acct.IRI(), iri)
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+			if af == nil || af.State != activitypub.FollowAccepted {
+				// a pending request doesn't let us see their private toots
+				httpError(w, ErrNotFollowed, http.StatusUnprocessableEntity)
+				return
+			}
+			_, err = s.DB().ExecContext(r.Context(), `
+				insert into list_accounts (ListId, ActorId) values (?, ?)
+				on conflict do nothing`, list.Id, iri)
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+		}
+		httpJson(w, map[string]any{})
+	}
+}
+
+// The current account's lists which contain the given account.
+func accountListsHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		acct, err := currentAccount(s, r)
+		if err != nil {
+			httpError(w, err, http.StatusUnauthorized)
+			return
+		}
+		iri, err := actorIRI(r.Context(), s, mux.Vars(r)["id"])
+		if err != nil {
+			listError(w, err)
+			return
+		}
+		lists := []model.List{}
+		err = s.DB().SelectContext(r.Context(), &lists, `
+			select l.* from lists l
+			join list_accounts la on la.ListId = l.Id
+			where l.AccountId = ? and la.ActorId = ?
+			order by l.Title`, acct.Id, iri)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		results := []map[string]any{}
+		for idx := range lists {
+			results = append(results, listMap(&lists[idx]))
+		}
+		httpJson(w, results)
+	}
+}
+
+// ownedList finds the list with the given ID if it belongs to the
+// account. Other accounts' lists are reported as missing.
+func ownedList(ctx context.Context, s sparq.Server, acct *model.Account, id string) (*model.List, error) {
+	var list model.List
+	err := s.DB().GetContext(ctx, &list, "select * from lists where Id = ? and AccountId = ?", id, acct.Id)
+	if err != nil {
+		return nil, errors.Wrap(err, "list "+id)
+	}
+	return &list, nil
+}
+
+func listError(w http.ResponseWriter, err error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		httpError(w, errors.New("Record not found"), http.StatusNotFound)
+		return
+	}
+	httpError(w, err, http.StatusInternalServerError)
+}
+
+func listMap(list *model.List) map[string]any {
+	return map[string]any{
+		"id":             strconv.FormatUint(list.Id, 10),
+		"title":          list.Title,
+		"replies_policy": list.RepliesPolicy,
+		"exclusive":      list.Exclusive,
+	}
+}
@@ -0,0 +1,186 @@
+package clientapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/contribsys/sparq/activitypub"
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/web"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLists(t *testing.T) {
+	ts, stopper := web.NewTestServer(t, "lists")
+	defer stopper()
+	root := rootRouter(ts)
+	AddPublicEndpoints(ts, root.PathPrefix("/api/v1").Subrouter())
+	ctx := context.Background()
+
+	token, err := registerToken(t, ts)
+	assert.NoError(t, err)
+
+	_, err = ts.DB().Exec(`
+		insert into actors (Id, MastodonId, Type, Email, PublicKey, Properties, FetchedAt) values
+		('https://remote.example/users/bob', 1001, 'Person', '', '', '{"preferredUsername":"bob"}', current_timestamp)`)
+	assert.NoError(t, err)
+	_, err = ts.DB().Exec(`insert into accounts (Id, Sid, Nick, Email, FullName) values (2, 'AAAC', 'alice', 'alice@localhost.dev', 'Alice')`)
+	assert.NoError(t, err)
+	_, err = ts.DB().Exec(`insert into account_profiles (AccountId) values (2)`)
+	assert.NoError(t, err)
+	_, err = ts.DB().Exec(`
+		insert into actor_following (Id, ActorId, TargetActorId, TargetActorAccount, State)
+		values ('https://localhost.dev/users/admin#follows/1', 'https://localhost.dev/users/admin',
+			'https://remote.example/users/bob', 'bob@remote.example', 'accepted')`)
+	assert.NoError(t, err)
+	_, err = ts.DB().Exec(`
+		insert into toots (Sid, Uri, ActorId, AuthorId, InReplyTo, Summary, Content, Visibility, CreatedAt) values
+		('BOB1', 'https://remote.example/notes/1', 1001, null, null, '', 'From bob', 0, '2030-01-01 00:00:01'),
+		('BOB2', 'https://remote.example/notes/2', 1001, null, 'https://remote.example/notes/1', '', 'Bob replies to bob', 0, '2030-01-01 00:00:02'),
+		('BOB3', 'https://remote.example/notes/3', 1001, null, 'https://localhost.dev/@admin/status/AABA', '', 'Bob replies to admin', 0, '2030-01-01 00:00:03'),
+		('ALI1', 'https://localhost.dev/@alice/ALI1', 2, 2, null, '', 'From alice', 0, '2030-01-01 00:00:04')`)
+	assert.NoError(t, err)
+
+	call := func(method, path string, form url.Values) (int, any) {
+		req := httptest.NewRequest(method, "http://localhost.dev:9494/api/v1"+path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		root.ServeHTTP(w, req)
+		var result any
+		if w.Code == 200 {
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		}
+		return w.Code, result
+	}
+	timeline := func(path string) []string {
+		code, result := call("GET", path, nil)
+		assert.Equal(t, 200, code)
+		sids := []string{}
+		for _, toot := range result.([]any) {
+			sids = append(sids, toot.(map[string]any)["id"].(string))
+		}
+		return sids
+	}
+
+	var listId string
+	t.Run("CRUD", func(t *testing.T) {
+		code, _ := call("POST", "/lists", url.Values{})
+		assert.Equal(t, 422, code)
+		code, _ = call("POST", "/lists", url.Values{"title": {"Work"}, "replies_policy": {"everyone"}})
+		assert.Equal(t, 422, code)
+
+		code, result := call("POST", "/lists", url.Values{"title": {"Work"}})
+		assert.Equal(t, 200, code)
+		list := result.(map[string]any)
+		listId = list["id"].(string)
+		assert.Equal(t, "Work", list["title"])
+		assert.Equal(t, "list", list["replies_policy"])
+		assert.Equal(t, false, list["exclusive"])
+
+		code, result = call("PUT", "/lists/"+listId, url.Values{"replies_policy": {"none"}})
+		assert.Equal(t, 200, code)
+		list = result.(map[string]any)
+		assert.Equal(t, "Work", list["title"])
+		assert.Equal(t, "none", list["replies_policy"])
+
+		code, result = call("GET", "/lists", nil)
+		assert.Equal(t, 200, code)
+		assert.Equal(t, 1, len(result.([]any)))
+
+		code, _ = call("GET", "/lists/12345", nil)
+		assert.Equal(t, 404, code)
+
+		// other accounts can't see our lists
+		_, err := ts.DB().Exec(`insert into lists (Id, AccountId, Title) values (999, 2, 'Alice''s')`)
+		assert.NoError(t, err)
+		code, _ = call("GET", "/lists/999", nil)
+		assert.Equal(t, 404, code)
+		code, _ = call("GET", "/timelines/list/999", nil)
+		assert.Equal(t, 404, code)
+	})
+
+	t.Run("Accounts", func(t *testing.T) {
+		code, _ := call("POST", "/lists/"+listId+"/accounts", url.Values{"account_ids[]": {"2"}})
+		assert.Equal(t, 422, code)
+		// nor until alice accepts our request
+		_, err := ts.DB().Exec(`
+			insert into actor_following (Id, ActorId, TargetActorId, TargetActorAccount, State)
+			values ('https://localhost.dev/users/admin#follows/2', 'https://localhost.dev/users/admin',
+				'https://localhost.dev/users/alice', 'alice@localhost.dev', 'pending')`)
+		assert.NoError(t, err)
+		code, _ = call("POST", "/lists/"+listId+"/accounts", url.Values{"account_ids[]": {"2"}})
+		assert.Equal(t, 422, code)
+		code, _ = call("POST", "/lists/"+listId+"/accounts", url.Values{"account_ids[]": {"1001"}})
+		assert.Equal(t, 200, code)
+
+		code, result := call("GET", "/lists/"+listId+"/accounts", nil)
+		assert.Equal(t, 200, code)
+		accounts := result.([]any)
+		assert.Equal(t, 1, len(accounts))
+		assert.Equal(t, "1001", accounts[0].(map[string]any)["id"])
+
+		code, result = call("GET", "/accounts/1001/lists", nil)
+		assert.Equal(t, 200, code)
+		assert.Equal(t, 1, len(result.([]any)))
+	})
+
+	t.Run("Timeline", func(t *testing.T) {
+		assert.Equal(t, []string{"BOB1"}, timeline("/timelines/list/"+listId))
+
+		call("PUT", "/lists/"+listId, url.Values{"replies_policy": {"list"}})
+		assert.Equal(t, []string{"BOB2", "BOB1"}, timeline("/timelines/list/"+listId))
+
+		call("PUT", "/lists/"+listId, url.Values{"replies_policy": {"followed"}})
+		assert.Equal(t, []string{"BOB3", "BOB2", "BOB1"}, timeline("/timelines/list/"+listId))
+	})
+
+	t.Run("Exclusive", func(t *testing.T) {
+		assert.Equal(t, []string{"BOB3", "BOB2", "BOB1", "AABB", "AABA"}, timeline("/timelines/home"))
+
+		code, result := call("PUT", "/lists/"+listId, url.Values{"exclusive": {"true"}})
+		assert.Equal(t, 200, code)
+		assert.Equal(t, true, result.(map[string]any)["exclusive"])
+		assert.Equal(t, []string{"AABB", "AABA"}, timeline("/timelines/home"))
+	})
+
+	t.Run("Unfollow", func(t *testing.T) {
+		var admin model.Account
+		assert.NoError(t, ts.DB().Get(&admin, "select * from accounts where Id = 1"))
+		_, err := ts.DB().Exec("update actors set Properties = ? where MastodonId = 1001",
+			`{"preferredUsername":"bob","inbox":"https://remote.example/users/bob/inbox"}`)
+		assert.NoError(t, err)
+		assert.NoError(t, activitypub.Unfollow(ctx, ts, &admin, "https://remote.example/users/bob"))
+
+		code, result := call("GET", "/lists/"+listId+"/accounts", nil)
+		assert.Equal(t, 200, code)
+		assert.Equal(t, 0, len(result.([]any)))
+
+		code, _ = call("DELETE", "/lists/"+listId, nil)
+		assert.Equal(t, 200, code)
+		code, _ = call("GET", "/lists/"+listId, nil)
+		assert.Equal(t, 404, code)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		code, result := call("POST", "/lists", url.Values{"title": {"Doomed"}})
+		assert.Equal(t, 200, code)
+		doomed := result.(map[string]any)["id"].(string)
+		_, err := ts.DB().Exec("insert into list_accounts (ListId, ActorId) values (?, 'https://remote.example/users/bob')", doomed)
+		assert.NoError(t, err)
+		code, _ = call("DELETE", "/lists/"+doomed, nil)
+		assert.Equal(t, 200, code)
+
+		// the members go with it and the next list doesn't inherit them
+		var count int
+		assert.NoError(t, ts.DB().Get(&count, "select count(*) from list_accounts where ListId = ?", doomed))
+		assert.Equal(t, 0, count)
+		code, result = call("POST", "/lists", url.Values{"title": {"Fresh"}})
+		assert.Equal(t, 200, code)
+		assert.NotEqual(t, doomed, result.(map[string]any)["id"])
+	})
+}
@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"image"
+	_ "image/gif"
 	_ "image/jpeg"
+	_ "image/png"
 	"io"
 	"math/rand"
 	"net/http"
@@ -14,6 +16,7 @@ import (
 	"time"
 
 	"github.com/contribsys/sparq"
+	"github.com/contribsys/sparq/activitypub"
 	"github.com/contribsys/sparq/model"
 	"github.com/contribsys/sparq/util"
 	"github.com/contribsys/sparq/util/blurhash"
@@ -22,6 +25,11 @@ import (
 	"github.com/pkg/errors"
 )
 
+var (
+	// Custom emoji are shrunk to fit within this many pixels.
+	EmojiSize = 128
+)
+
 // TODO: focus
 func postMediaHandler(s sparq.Server) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -41,20 +49,8 @@ func postMediaHandler(s sparq.Server) http.HandlerFunc {
 		salt := strconv.FormatUint(uint64(rand.Uint32()), 16)
 		util.Debugf("[%s] Starting media creation for account %s", salt, aid)
 
-		ffile, _, err := r.FormFile("file")
-		if err != nil {
-			httpError(w, err, http.StatusBadRequest)
-			return
-		}
-		util.Debugf("[%s] Parse request: %v", salt, time.Since(start))
-
 		// 0. Save original media to disk
-		origfile, err := os.CreateTemp("", "orig-*")
-		if err != nil {
-			httpError(w, err, http.StatusBadRequest)
-			return
-		}
-		_, err = io.Copy(origfile, ffile)
+		origfile, err := uploadedFile(r, "file")
 		if err != nil {
 			httpError(w, err, http.StatusBadRequest)
 			return
@@ -221,6 +217,71 @@ func postMediaHandler(s sparq.Server) http.HandlerFunc {
 	}
 }
 
+// uploadedFile copies the request's file in the given field to a
+// temporary file, which the caller must remove.
+func uploadedFile(r *http.Request, field string) (*os.File, error) {
+	ffile, _, err := r.FormFile(field)
+	if err != nil {
+		return nil, err
+	}
+	defer ffile.Close()
+	origfile, err := os.CreateTemp("", "orig-*")
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.Copy(origfile, ffile)
+	if err != nil {
+		os.Remove(origfile.Name())
+		return nil, err
+	}
+	return origfile, nil
+}
+
+// emojiImage checks an uploaded custom emoji, shrinking it to fit
+// within EmojiSize pixels, and returns the image and its type.
+func emojiImage(orig *os.File) ([]byte, string, error) {
+	return fitImage(orig, activitypub.EmojiTypes, EmojiSize, EmojiSize, activitypub.MaxEmojiSize)
+}
+
+// fitImage checks an uploaded image is one of the types, shrinking
+// it to fit within width x height pixels, and returns the image and
+// its type.
+func fitImage(orig *os.File, types map[string]string, width, height, maxSize int) ([]byte, string, error) {
+	head := make([]byte, 512)
+	n, err := orig.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return nil, "", err
+	}
+	mimeType := http.DetectContentType(head[:n])
+	ext, ok := types[mimeType]
+	if !ok {
+		return nil, "", errors.New("Unsupported image type " + mimeType)
+	}
+	filename := orig.Name()
+	// webp can't be decoded here so it must already be small enough
+	cfg, _, err := image.DecodeConfig(io.NewSectionReader(orig, 0, 1<<30))
+	if err == nil && (cfg.Width > width || cfg.Height > height) {
+		resized, err := os.CreateTemp("", "fit-*."+ext)
+		if err != nil {
+			return nil, "", err
+		}
+		defer os.Remove(resized.Name())
+		_, err = resize(filename, resized, width, height)
+		if err != nil {
+			return nil, "", err
+		}
+		filename = resized.Name()
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(data) > maxSize {
+		return nil, "", fmt.Errorf("Image must be smaller than %dKB", maxSize/1024)
+	}
+	return data, mimeType, nil
+}
+
 // -format '{"height": %h, "width": %w}'
 func compact(filename string, newfile *os.File) (string, error) {
 	return run("convert", "-quality", "60", "-strip", filename, newfile.Name())
@@ -230,6 +291,11 @@ func thumb(filename string, newfile *os.File) (string, error) {
 	return run("convert", "-thumbnail", "100", filename, newfile.Name())
 }
 
+func resize(filename string, newfile *os.File, width, height int) (string, error) {
+	size := fmt.Sprintf("%dx%d", width, height)
+	return run("convert", filename, "-resize", size, newfile.Name())
+}
+
 // func file(file *os.File) (string, error) {
 // return run("file", file.Name())
 // }
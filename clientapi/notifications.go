@@ -0,0 +1,253 @@
+package clientapi
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/contribsys/sparq"
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/util"
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// GET https://mastodon.example/api/v1/notifications
+// GET https://mastodon.example/api/v1/notifications/:id
+// POST https://mastodon.example/api/v1/notifications/clear
+// POST https://mastodon.example/api/v1/notifications/:id/dismiss
+
+// The actors whose notifications an account no longer sees, whether
+// from before or after they were blocked or muted. Takes the
+// account's IRI three times.
+const silencedActors = `select TargetActorId from actor_blocks where ActorId = ?
+	union select ActorId from actor_blocks where TargetActorId = ?
+	union select TargetActorId from actor_mutes where ActorId = ? and HideNotifications
+		and (ExpiresAt is null or ExpiresAt > current_timestamp)`
+
+func notificationsHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		acct, err := currentAccount(s, r)
+		if err != nil {
+			httpError(w, err, http.StatusUnauthorized)
+			return
+		}
+		err = r.ParseForm()
+		if err != nil {
+			httpError(w, err, http.StatusBadRequest)
+			return
+		}
+		limit := uint64(40)
+		if value := r.Form.Get("limit"); value != "" {
+			limit, err = strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				httpError(w, errors.Wrap(err, "Invalid limit"), http.StatusBadRequest)
+				return
+			}
+		}
+		if limit == 0 || limit > 80 {
+			limit = 80
+		}
+
+		iri := acct.IRI()
+		query := squirrel.Select("*").From("actor_notifications").
+			Where("ActorId = ?", iri).
+			Where("FromActorId not in ("+silencedActors+")", iri, iri, iri).
+			Where(`not exists (select 1 from actor_domain_blocks d
+				where d.ActorId = ? and FromActorId like '%://' || d.Domain || '/%')`, iri).
+			Limit(limit)
+		if types := r.Form["types[]"]; len(types) > 0 {
+			query = query.Where(squirrel.Eq{"Type": types})
+		}
+		if types := r.Form["exclude_types[]"]; len(types) > 0 {
+			query = query.Where(squirrel.NotEq{"Type": types})
+		}
+		if id := r.Form.Get("account_id"); id != "" {
+			iri, err := actorIRI(r.Context(), s, id)
+			if err != nil {
+				listError(w, err)
+				return
+			}
+			query = query.Where("FromActorId = ?", iri)
+		}
+		if id := r.Form.Get("max_id"); id != "" {
+			query = query.Where("Id < ?", id)
+		}
+		if id := r.Form.Get("since_id"); id != "" {
+			query = query.Where("Id > ?", id)
+		}
+		// min_id asks for the notifications immediately after the given one
+		ascending := r.Form.Get("min_id") != "" && r.Form.Get("max_id") == ""
+		if id := r.Form.Get("min_id"); id != "" {
+			query = query.Where("Id > ?", id)
+		}
+		if ascending {
+			query = query.OrderBy("Id ASC")
+		} else {
+			query = query.OrderBy("Id DESC")
+		}
+		sqlq, args, err := query.ToSql()
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		notes := []model.ActorNotification{}
+		err = s.DB().SelectContext(r.Context(), &notes, sqlq, args...)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		if ascending {
+			for i, j := 0, len(notes)-1; i < j; i, j = i+1, j-1 {
+				notes[i], notes[j] = notes[j], notes[i]
+			}
+		}
+
+		filters, err := LoadFilters(r.Context(), s.DB(), acct.IRI(), "notifications")
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		results := []map[string]any{}
+		for idx := range notes {
+			attrs, err := NotificationMap(r.Context(), s.DB(), &notes[idx])
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+			if filterNotification(filters, attrs) {
+				continue
+			}
+			results = append(results, attrs)
+		}
+		if len(notes) > 0 {
+			linkHeader(w, r, strconv.FormatInt(notes[len(notes)-1].Id, 10), strconv.FormatInt(notes[0].Id, 10))
+		}
+		httpJson(w, results)
+	}
+}
+
+func notificationHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		acct, err := currentAccount(s, r)
+		if err != nil {
+			httpError(w, err, http.StatusUnauthorized)
+			return
+		}
+		var n model.ActorNotification
+		err = s.DB().GetContext(r.Context(), &n,
+			"select * from actor_notifications where Id = ? and ActorId = ?", mux.Vars(r)["id"], acct.IRI())
+		if err != nil {
+			listError(w, err)
+			return
+		}
+		attrs, err := NotificationMap(r.Context(), s.DB(), &n)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		filters, err := LoadFilters(r.Context(), s.DB(), acct.IRI(), "notifications")
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		filterNotification(filters, attrs)
+		httpJson(w, attrs)
+	}
+}
+
+func dismissNotificationHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			httpError(w, errors.New("POST only"), http.StatusBadRequest)
+			return
+		}
+		acct, err := currentAccount(s, r)
+		if err != nil {
+			httpError(w, err, http.StatusUnauthorized)
+			return
+		}
+		query := "delete from actor_notifications where ActorId = ?"
+		args := []any{acct.IRI()}
+		if id, ok := mux.Vars(r)["id"]; ok {
+			query += " and Id = ?"
+			args = append(args, id)
+		}
+		_, err = s.DB().ExecContext(r.Context(), query, args...)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		httpJson(w, map[string]any{})
+	}
+}
+
+// NotificationMap renders the Mastodon Notification entity.
+func NotificationMap(ctx context.Context, dbx *sqlx.DB, n *model.ActorNotification) (map[string]any, error) {
+	account, err := AccountMap(ctx, dbx, n.FromActorId)
+	if err != nil {
+		return nil, err
+	}
+	attrs := map[string]any{
+		"id":         strconv.FormatInt(n.Id, 10),
+		"type":       n.Type,
+		"created_at": util.Thens(n.CreatedAt),
+		"account":    account,
+	}
+	if n.ObjectId != nil {
+		var sid string
+		err := dbx.GetContext(ctx, &sid, "select Sid from toots where Uri = ? and DeletedAt is null", *n.ObjectId)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.Wrap(err, "toots")
+		}
+		if sid != "" {
+			attrs["status"], err = TootMapFor(dbx, sid, n.ActorId)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return attrs, nil
+}
+
+// filterNotification applies the filters to the notification's status.
+// It returns true if the notification should be left out.
+func filterNotification(filters Filters, attrs map[string]any) bool {
+	status, ok := attrs["status"].(map[string]any)
+	return ok && filters.Apply(status)
+}
+
+// notify streams new notifications to the recipient.
+func (s *Streamer) notify(ctx context.Context, svr sparq.Server, n *model.ActorNotification) {
+	nick, ok := model.LocalNick(n.ActorId)
+	if !ok {
+		return
+	}
+	var id int64
+	err := svr.DB().GetContext(ctx, &id, "select Id from accounts where Nick = ?", nick)
+	if err != nil {
+		util.Warnf("Unable to stream notification %d: %v", n.Id, err)
+		return
+	}
+	key := strconv.FormatInt(id, 10)
+	if !s.isStreaming(key) {
+		return
+	}
+	attrs, err := NotificationMap(ctx, svr.DB(), n)
+	if err != nil {
+		util.Warnf("Unable to stream notification %d: %v", n.Id, err)
+		return
+	}
+	filters, err := LoadFilters(ctx, svr.DB(), n.ActorId, "notifications")
+	if err != nil {
+		util.Warnf("Unable to stream notification %d: %v", n.Id, err)
+		return
+	}
+	if filterNotification(filters, attrs) {
+		return
+	}
+	s.Fanout(key, NewJsonEvent("notification", attrs))
+}
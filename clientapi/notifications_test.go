@@ -0,0 +1,130 @@
+package clientapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/contribsys/sparq/activitypub"
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/web"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotifications(t *testing.T) {
+	ts, stopper := web.NewTestServer(t, "notifications")
+	defer stopper()
+	root := rootRouter(ts)
+	AddPublicEndpoints(ts, root.PathPrefix("/api/v1").Subrouter())
+	ctx := context.Background()
+
+	token, err := registerToken(t, ts)
+	assert.NoError(t, err)
+
+	admin := model.LocalIRI("admin")
+	bob := "https://remote.example/users/bob"
+	_, err = ts.DB().Exec(`
+		insert into actors (Id, MastodonId, Type, Email, PublicKey, Properties, FetchedAt) values
+		(?, 1001, 'Person', '', '', '{"preferredUsername":"bob"}', current_timestamp)`, bob)
+	assert.NoError(t, err)
+	_, err = ts.DB().Exec(`insert into accounts (Id, Sid, Nick, Email, FullName) values (2, 'AAAC', 'alice', 'alice@localhost.dev', 'Alice')`)
+	assert.NoError(t, err)
+	_, err = ts.DB().Exec(`insert into account_profiles (AccountId) values (2)`)
+	assert.NoError(t, err)
+
+	call := func(method, path string) (int, any, string) {
+		req := httptest.NewRequest(method, "http://localhost.dev:9494/api/v1"+path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		root.ServeHTTP(w, req)
+		var result any
+		if w.Code == 200 {
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		}
+		return w.Code, result, w.Header().Get("Link")
+	}
+	types := func(path string) []string {
+		code, result, _ := call("GET", path)
+		assert.Equal(t, 200, code)
+		kinds := []string{}
+		for _, n := range result.([]any) {
+			kinds = append(kinds, n.(map[string]any)["type"].(string))
+		}
+		return kinds
+	}
+
+	t.Run("Create", func(t *testing.T) {
+		var alice model.Account
+		assert.NoError(t, ts.DB().Get(&alice, "select * from accounts where Id = 2"))
+		_, err := activitypub.Follow(ctx, ts, &alice, admin)
+		assert.NoError(t, err)
+
+		assert.NoError(t, activitypub.Notify(ctx, ts, activitypub.NotifyMention, admin, bob, "https://localhost.dev/@admin/status/AABA"))
+		assert.NoError(t, activitypub.Notify(ctx, ts, activitypub.NotifyFavourite, admin, bob, "https://localhost.dev/@admin/status/AABB"))
+		// not for remote actors or yourself
+		assert.NoError(t, activitypub.Notify(ctx, ts, activitypub.NotifyMention, bob, admin, ""))
+		assert.NoError(t, activitypub.Notify(ctx, ts, activitypub.NotifyMention, admin, admin, ""))
+
+		var count int
+		assert.NoError(t, ts.DB().Get(&count, "select count(*) from actor_notifications"))
+		assert.Equal(t, 3, count)
+	})
+
+	t.Run("List", func(t *testing.T) {
+		assert.Equal(t, []string{"favourite", "mention", "follow"}, types("/notifications"))
+		assert.Equal(t, []string{"mention", "follow"}, types("/notifications?types[]=mention&types[]=follow"))
+		assert.Equal(t, []string{"follow"}, types("/notifications?exclude_types[]=mention&exclude_types[]=favourite"))
+		assert.Equal(t, []string{"follow"}, types("/notifications?account_id=2"))
+
+		code, result, link := call("GET", "/notifications?limit=1")
+		assert.Equal(t, 200, code)
+		notes := result.([]any)
+		assert.Equal(t, 1, len(notes))
+		latest := notes[0].(map[string]any)
+		assert.Equal(t, "bob@remote.example", latest["account"].(map[string]any)["acct"])
+		assert.Equal(t, "AABB", latest["status"].(map[string]any)["id"])
+		assert.Contains(t, link, "max_id="+latest["id"].(string))
+
+		assert.Equal(t, []string{"mention", "follow"}, types("/notifications?max_id="+latest["id"].(string)))
+		assert.Equal(t, []string{}, types("/notifications?since_id="+latest["id"].(string)))
+
+		code, result, _ = call("GET", "/notifications/"+latest["id"].(string))
+		assert.Equal(t, 200, code)
+		assert.Equal(t, "favourite", result.(map[string]any)["type"])
+		code, _, _ = call("GET", "/notifications/12345")
+		assert.Equal(t, 404, code)
+	})
+
+	t.Run("Dismiss", func(t *testing.T) {
+		var id string
+		assert.NoError(t, ts.DB().Get(&id, "select Id from actor_notifications where Type = 'favourite'"))
+		code, _, _ := call("POST", "/notifications/"+id+"/dismiss")
+		assert.Equal(t, 200, code)
+		assert.Equal(t, []string{"mention", "follow"}, types("/notifications"))
+
+		code, _, _ = call("POST", "/notifications/clear")
+		assert.Equal(t, 200, code)
+		assert.Equal(t, []string{}, types("/notifications"))
+	})
+
+	t.Run("Stream", func(t *testing.T) {
+		st := NewStreamer(ts)
+		defer activitypub.OnNotify(st.notify)()
+		events, dereg := st.registerStreamerFor("1")
+		defer dereg()
+
+		assert.NoError(t, activitypub.Notify(ctx, ts, activitypub.NotifyReblog, admin, bob, "https://localhost.dev/@admin/status/AABA"))
+		event := <-events
+		assert.Equal(t, "notification", event.Name)
+		var n map[string]any
+		assert.NoError(t, json.Unmarshal([]byte(event.Data), &n))
+		assert.Equal(t, "reblog", n["type"])
+		assert.Equal(t, "AABA", n["status"].(map[string]any)["id"])
+	})
+
+	req := httptest.NewRequest("GET", "http://localhost.dev:9494/api/v1/notifications", nil)
+	w := httptest.NewRecorder()
+	root.ServeHTTP(w, req)
+	assert.Equal(t, 401, w.Code)
+}
@@ -0,0 +1,160 @@
+package clientapi
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/contribsys/sparq"
+	"github.com/contribsys/sparq/activitypub"
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/util"
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// GET https://mastodon.example/api/v1/polls/:id
+// POST https://mastodon.example/api/v1/polls/:id/votes
+
+func pollHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var poll model.Poll
+		err := s.DB().GetContext(r.Context(), &poll, "select * from polls where Id = ?", mux.Vars(r)["id"])
+		if err != nil {
+			listError(w, err)
+			return
+		}
+		// the poll is as private as its toot
+		viewer := viewerIRI(s, r)
+		_, err = findViewableToot(r.Context(), s, poll.Sid, viewer)
+		if err != nil {
+			listError(w, err)
+			return
+		}
+		attrs, err := PollMap(r.Context(), s.DB(), poll.Id, viewer)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		httpJson(w, attrs)
+	}
+}
+
+func voteHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			httpError(w, errors.New("POST only"), http.StatusBadRequest)
+			return
+		}
+		acct, err := currentAccount(s, r)
+		if err != nil {
+			httpError(w, err, http.StatusUnauthorized)
+			return
+		}
+		err = r.ParseForm()
+		if err != nil {
+			httpError(w, err, http.StatusBadRequest)
+			return
+		}
+		var poll model.Poll
+		err = s.DB().GetContext(r.Context(), &poll, "select * from polls where Id = ?", mux.Vars(r)["id"])
+		if err != nil {
+			listError(w, err)
+			return
+		}
+		toot, err := findViewableToot(r.Context(), s, poll.Sid, acct.IRI())
+		if err != nil {
+			listError(w, err)
+			return
+		}
+
+		choices := []int{}
+		for _, value := range r.Form["choices[]"] {
+			choice, err := strconv.Atoi(value)
+			if err != nil {
+				httpError(w, activitypub.ErrInvalidChoice, http.StatusUnprocessableEntity)
+				return
+			}
+			choices = append(choices, choice)
+		}
+		err = activitypub.Vote(r.Context(), s, acct, toot, &poll, choices)
+		if err != nil {
+			switch {
+			case errors.Is(err, activitypub.ErrPollExpired),
+				errors.Is(err, activitypub.ErrAlreadyVoted),
+				errors.Is(err, activitypub.ErrInvalidChoice),
+				errors.Is(err, activitypub.ErrSelfVote):
+				httpError(w, err, http.StatusUnprocessableEntity)
+			default:
+				httpError(w, err, http.StatusInternalServerError)
+			}
+			return
+		}
+		attrs, err := PollMap(r.Context(), s.DB(), poll.Id, acct.IRI())
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		httpJson(w, attrs)
+	}
+}
+
+// PollMap renders the Mastodon Poll entity as seen by the given
+// actor IRI. Hidden totals are only shown once the poll ends.
+func PollMap(ctx context.Context, dbx *sqlx.DB, id uint64, viewer string) (map[string]any, error) {
+	var poll model.Poll
+	err := dbx.GetContext(ctx, &poll, "select * from polls where Id = ?", id)
+	if err != nil {
+		return nil, errors.Wrap(err, "polls")
+	}
+	options := []model.PollOption{}
+	err = dbx.SelectContext(ctx, &options, "select * from poll_options where PollId = ? order by Position", id)
+	if err != nil {
+		return nil, errors.Wrap(err, "poll_options")
+	}
+	own := []int{}
+	if viewer != "" {
+		err = dbx.SelectContext(ctx, &own,
+			"select Position from poll_votes where PollId = ? and ActorId = ? order by Position", id, viewer)
+		if err != nil {
+			return nil, errors.Wrap(err, "poll_votes")
+		}
+	}
+
+	expired := poll.Expired()
+	var votes int64
+	choices := []map[string]any{}
+	for _, option := range options {
+		votes += option.VotesCount
+		var count any = option.VotesCount
+		if poll.HideTotals && !expired {
+			count = nil
+		}
+		choices = append(choices, map[string]any{
+			"title":       option.Title,
+			"votes_count": count,
+		})
+	}
+	attrs := map[string]any{
+		"id":           strconv.FormatUint(poll.Id, 10),
+		"expires_at":   nil,
+		"expired":      expired,
+		"multiple":     poll.Multiple,
+		"votes_count":  votes,
+		"voters_count": nil,
+		"options":      choices,
+		"emojis":       []any{},
+	}
+	if poll.ExpiresAt != nil {
+		attrs["expires_at"] = util.Thens(*poll.ExpiresAt)
+	}
+	if poll.Multiple {
+		attrs["voters_count"] = poll.VotersCount
+	}
+	if viewer != "" {
+		attrs["voted"] = len(own) > 0
+		attrs["own_votes"] = own
+	}
+	return attrs, nil
+}
@@ -0,0 +1,149 @@
+package clientapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/contribsys/sparq/activitypub"
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/web"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolls(t *testing.T) {
+	ts, stopper := web.NewTestServer(t, "polls")
+	defer stopper()
+	jobs := ts.Jobs().(*web.TestJobs)
+	activitypub.Register(ts)
+	token, err := registerToken(t, ts)
+	assert.NoError(t, err)
+	root := rootRouter(ts)
+	AddPublicEndpoints(ts, root.PathPrefix("/api/v1").Subrouter())
+
+	_, err = ts.DB().Exec(`insert into accounts (Id, Sid, Nick, Email, FullName) values (2, 'AAAC', 'alice', 'alice@localhost.dev', 'Alice')`)
+	assert.NoError(t, err)
+	_, err = ts.DB().Exec(`insert into account_profiles (AccountId) values (2)`)
+	assert.NoError(t, err)
+	_, err = ts.DB().Exec(`
+		insert into toots (Sid, Uri, ActorId, AuthorId, PollId, Summary, Content) values
+		('ALI1', 'https://localhost.dev/@alice/ALI1', 2, 2, 1, '', 'Tabs or spaces?')`)
+	assert.NoError(t, err)
+	_, err = ts.DB().Exec(`
+		insert into polls (Id, Sid, ExpiresAt, HideTotals) values (1, 'ALI1', datetime('now', '+1 day'), 1)`)
+	assert.NoError(t, err)
+	_, err = ts.DB().Exec(`insert into poll_options (PollId, Position, Title) values (1, 0, 'Tabs'), (1, 1, 'Spaces')`)
+	assert.NoError(t, err)
+
+	call := func(method, path string, values url.Values) (int, map[string]any) {
+		req := httptest.NewRequest(method, "http://localhost.dev:9494/api/v1"+path, strings.NewReader(values.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Idempotency-Key", "polls-"+path)
+		w := httptest.NewRecorder()
+		root.ServeHTTP(w, req)
+		result := map[string]any{}
+		if w.Code == 200 {
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		}
+		return w.Code, result
+	}
+
+	t.Run("Create", func(t *testing.T) {
+		code, toot := call("POST", "/statuses", url.Values{
+			"status":            {"Best editor?"},
+			"poll[expires_in]":  {"600"},
+			"poll[multiple]":    {"true"},
+			"poll[options][]":   {"vim", "emacs", "nano"},
+			"poll[hide_totals]": {"false"},
+		})
+		assert.Equal(t, 200, code)
+		poll := toot["poll"].(map[string]any)
+		assert.Equal(t, true, poll["multiple"])
+		assert.Equal(t, false, poll["expired"])
+		assert.EqualValues(t, 0, poll["voters_count"])
+		assert.Equal(t, 3, len(poll["options"].([]any)))
+		assert.NotNil(t, poll["expires_at"])
+
+		closers := jobs.Find("ClosePoll")
+		assert.Equal(t, 1, len(closers))
+		assert.NotEmpty(t, closers[0].At)
+
+		code, _ = call("POST", "/polls/"+poll["id"].(string)+"/votes", url.Values{"choices[]": {"0"}})
+		assert.Equal(t, 422, code)
+
+		code, _ = call("POST", "/statuses", url.Values{
+			"status":           {"Too quick"},
+			"poll[expires_in]": {"10"},
+			"poll[options][]":  {"yes", "no"},
+		})
+		assert.Equal(t, 422, code)
+	})
+
+	t.Run("Vote", func(t *testing.T) {
+		code, _ := call("POST", "/polls/1/votes", url.Values{"choices[]": {"0", "1"}})
+		assert.Equal(t, 422, code)
+		code, _ = call("POST", "/polls/1/votes", url.Values{"choices[]": {"2"}})
+		assert.Equal(t, 422, code)
+
+		code, poll := call("POST", "/polls/1/votes", url.Values{"choices[]": {"1"}})
+		assert.Equal(t, 200, code)
+		assert.Equal(t, true, poll["voted"])
+		assert.Equal(t, []any{float64(1)}, poll["own_votes"])
+		assert.EqualValues(t, 1, poll["votes_count"])
+		// totals are hidden until the poll ends
+		assert.Nil(t, poll["options"].([]any)[1].(map[string]any)["votes_count"])
+
+		code, _ = call("POST", "/polls/1/votes", url.Values{"choices[]": {"0"}})
+		assert.Equal(t, 422, code)
+
+		code, toot := call("GET", "/statuses/ALI1", nil)
+		assert.Equal(t, 200, code)
+		assert.Equal(t, true, toot["poll"].(map[string]any)["voted"])
+	})
+
+	t.Run("Expire", func(t *testing.T) {
+		_, err := ts.DB().Exec("update polls set ExpiresAt = datetime('now', '-1 minute') where Id = 1")
+		assert.NoError(t, err)
+		past := time.Now().Add(-time.Minute)
+		assert.NoError(t, activitypub.SchedulePollExpiry(context.Background(), ts, &model.Poll{Id: 1, ExpiresAt: &past}))
+		assert.NoError(t, jobs.Drain(context.Background()))
+
+		code, poll := call("GET", "/polls/1", nil)
+		assert.Equal(t, 200, code)
+		assert.Equal(t, true, poll["expired"])
+		assert.EqualValues(t, 1, poll["options"].([]any)[1].(map[string]any)["votes_count"])
+		code, _ = call("POST", "/polls/1/votes", url.Values{"choices[]": {"0"}})
+		assert.Equal(t, 422, code)
+
+		// the voter and the author are both told
+		var count int
+		assert.NoError(t, ts.DB().Get(&count, "select count(*) from actor_notifications where Type = 'poll'"))
+		assert.Equal(t, 2, count)
+	})
+
+	t.Run("Private", func(t *testing.T) {
+		// a poll in a toot we can't see isn't found
+		_, err := ts.DB().Exec(`
+			insert into toots (Sid, Uri, ActorId, AuthorId, PollId, Summary, Content, Visibility) values
+			('ALI2', 'https://localhost.dev/@alice/ALI2', 2, 2, 100, '', 'Just between us', 3)`)
+		assert.NoError(t, err)
+		_, err = ts.DB().Exec(`
+			insert into polls (Id, Sid, ExpiresAt) values (100, 'ALI2', datetime('now', '+1 day'))`)
+		assert.NoError(t, err)
+		_, err = ts.DB().Exec(`insert into poll_options (PollId, Position, Title) values (100, 0, 'Yes'), (100, 1, 'No')`)
+		assert.NoError(t, err)
+
+		code, _ := call("GET", "/polls/100", nil)
+		assert.Equal(t, 404, code)
+		code, _ = call("POST", "/polls/100/votes", url.Values{"choices[]": {"0"}})
+		assert.Equal(t, 404, code)
+		var votes int
+		assert.NoError(t, ts.DB().Get(&votes, "select count(*) from poll_votes where PollId = 100"))
+		assert.Equal(t, 0, votes)
+	})
+}
@@ -0,0 +1,302 @@
+package clientapi
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/contribsys/sparq"
+	"github.com/contribsys/sparq/activitypub"
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/util"
+	"github.com/pkg/errors"
+)
+
+// PATCH https://mastodon.example/api/v1/accounts/update_credentials
+
+var (
+	// Avatars and headers are shrunk to fit within these sizes.
+	AvatarWidth, AvatarHeight = 400, 400
+	HeaderWidth, HeaderHeight = 1500, 500
+
+	// Uploaded avatars and headers larger than this are refused.
+	MaxProfileImageSize = 2 * 1024 * 1024
+
+	// The image types allowed for avatars and headers and their
+	// file extensions.
+	ProfileImageTypes = map[string]string{
+		"image/jpeg": "jpg",
+		"image/png":  "png",
+		"image/gif":  "gif",
+		"image/webp": "webp",
+	}
+
+	MaxDisplayName = 30
+	MaxNote        = 500
+	MaxFields      = 4
+	MaxFieldLength = 255
+)
+
+const (
+	maxProfileForm = 8 << 20
+	// uploaded avatars and headers live here under the media root
+	profileImageDir = "profiles"
+)
+
+// profileField is a name and value of profile metadata.
+type profileField struct {
+	Name  string
+	Value string
+}
+
+func updateCredentialsHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PATCH" {
+			httpError(w, errors.New("PATCH only"), http.StatusMethodNotAllowed)
+			return
+		}
+		acct, err := currentAccount(s, r)
+		if err != nil {
+			httpError(w, err, http.StatusUnauthorized)
+			return
+		}
+		err = r.ParseMultipartForm(maxProfileForm)
+		if err != nil && !errors.Is(err, http.ErrNotMultipart) {
+			httpError(w, err, http.StatusBadRequest)
+			return
+		}
+		fields, err := updateProfile(r, acct)
+		if err != nil {
+			httpError(w, err, http.StatusUnprocessableEntity)
+			return
+		}
+
+		old := map[string]string{"avatar": acct.Avatar, "header": acct.Header}
+		for field, size := range map[string][2]int{"avatar": {AvatarWidth, AvatarHeight}, "header": {HeaderWidth, HeaderHeight}} {
+			if r.MultipartForm == nil || len(r.MultipartForm.File[field]) == 0 {
+				continue
+			}
+			path, err := saveProfileImage(r, s, acct, field, size[0], size[1])
+			if err != nil {
+				httpError(w, err, http.StatusUnprocessableEntity)
+				return
+			}
+			if field == "avatar" {
+				acct.Avatar = path
+			} else {
+				acct.Header = path
+			}
+		}
+
+		err = saveProfile(r.Context(), s, acct, fields)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		if acct.Avatar != old["avatar"] {
+			removeProfileImage(s, old["avatar"])
+		}
+		if acct.Header != old["header"] {
+			removeProfileImage(s, old["header"])
+		}
+		err = activitypub.DeliverProfile(r.Context(), s, acct)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		attrs, err := credentialAccount(r.Context(), s, acct)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		httpJson(w, attrs)
+	}
+}
+
+// updateProfile applies the profile attributes in the request's form
+// to the account, returning the new profile fields or nil if they
+// aren't changed.
+func updateProfile(r *http.Request, acct *model.Account) ([]profileField, error) {
+	if _, ok := r.Form["display_name"]; ok {
+		acct.FullName = strings.TrimSpace(r.Form.Get("display_name"))
+		if utf8.RuneCountInString(acct.FullName) > MaxDisplayName {
+			return nil, fmt.Errorf("Display name must be at most %d characters", MaxDisplayName)
+		}
+	}
+	if _, ok := r.Form["note"]; ok {
+		acct.NoteSource = strings.TrimSpace(r.Form.Get("note"))
+		if utf8.RuneCountInString(acct.NoteSource) > MaxNote {
+			return nil, fmt.Errorf("Note must be at most %d characters", MaxNote)
+		}
+	}
+	if _, ok := r.Form["locked"]; ok {
+		acct.Visibility = model.Public
+		if isTrue(r.Form.Get("locked")) {
+			acct.Visibility = model.Protected
+		}
+	}
+	if _, ok := r.Form["bot"]; ok {
+		acct.Bot = isTrue(r.Form.Get("bot"))
+	}
+	if _, ok := r.Form["discoverable"]; ok {
+		acct.Discoverable = isTrue(r.Form.Get("discoverable"))
+	}
+
+	attrs, err := nestedAttributes(r, "fields_attributes")
+	if err != nil || len(attrs) == 0 {
+		return nil, err
+	}
+	fields := []profileField{}
+	for _, attr := range attrs {
+		field := profileField{
+			Name:  strings.TrimSpace(attr["name"]),
+			Value: strings.TrimSpace(attr["value"]),
+		}
+		// blank fields are how clients remove them
+		if field.Name == "" && field.Value == "" {
+			continue
+		}
+		if field.Name == "" {
+			return nil, errors.New("Field name can't be blank")
+		}
+		if utf8.RuneCountInString(field.Name) > MaxFieldLength || utf8.RuneCountInString(field.Value) > MaxFieldLength {
+			return nil, fmt.Errorf("Fields must be at most %d characters", MaxFieldLength)
+		}
+		fields = append(fields, field)
+	}
+	if len(fields) > MaxFields {
+		return nil, fmt.Errorf("At most %d fields are allowed", MaxFields)
+	}
+	return fields, nil
+}
+
+// saveProfile stores the account's profile, replacing its fields
+// unless they are nil.
+func saveProfile(ctx context.Context, s sparq.Server, acct *model.Account, fields []profileField) error {
+	note := ""
+	if acct.NoteSource != "" {
+		note, _ = activitypub.FormatToot(ctx, s, acct.NoteSource)
+	}
+	tx, err := s.DB().BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	_, err = tx.ExecContext(ctx, `
+		update accounts set FullName = ?, Visibility = ?, UpdatedAt = current_timestamp where Id = ?`,
+		acct.FullName, acct.Visibility, acct.Id)
+	if err != nil {
+		return errors.Wrap(err, "accounts")
+	}
+	_, err = tx.ExecContext(ctx, `
+		update account_profiles set Note = ?, NoteSource = ?, Avatar = ?, Header = ?, Bot = ?, Discoverable = ?
+		where AccountId = ?`, note, acct.NoteSource, acct.Avatar, acct.Header, acct.Bot, acct.Discoverable, acct.Id)
+	if err != nil {
+		return errors.Wrap(err, "account_profiles")
+	}
+	if fields != nil {
+		_, err = tx.ExecContext(ctx, "delete from account_fields where AccountId = ?", acct.Id)
+		if err != nil {
+			return errors.Wrap(err, "account_fields")
+		}
+		for _, field := range fields {
+			_, err = tx.ExecContext(ctx, "insert into account_fields (AccountId, Name, Value) values (?, ?, ?)",
+				acct.Id, field.Name, field.Value)
+			if err != nil {
+				return errors.Wrap(err, "account_fields")
+			}
+		}
+	}
+	acct.Note = note
+	return tx.Commit()
+}
+
+// saveProfileImage shrinks the uploaded avatar or header to fit and
+// stores it, returning its path.
+func saveProfileImage(r *http.Request, s sparq.Server, acct *model.Account, field string, width, height int) (string, error) {
+	origfile, err := uploadedFile(r, field)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(origfile.Name())
+	data, mimeType, err := fitImage(origfile, ProfileImageTypes, width, height, MaxProfileImageSize)
+	if err != nil {
+		return "", errors.Wrap(err, "Invalid "+field)
+	}
+	path := fmt.Sprintf("%s/%d-%s-%x.%s", profileImageDir, acct.Id, field, rand.Uint32(), ProfileImageTypes[mimeType])
+	full := filepath.Join(s.MediaRoot(), path)
+	err = os.MkdirAll(filepath.Dir(full), 0755)
+	if err != nil {
+		return "", err
+	}
+	err = os.WriteFile(full, data, 0644)
+	if err != nil {
+		return "", err
+	}
+	return "/media/" + path, nil
+}
+
+// removeProfileImage deletes a replaced avatar or header, leaving
+// the default images alone.
+func removeProfileImage(s sparq.Server, path string) {
+	if !strings.HasPrefix(path, "/media/"+profileImageDir+"/") {
+		return
+	}
+	err := os.Remove(filepath.Join(s.MediaRoot(), strings.TrimPrefix(path, "/media/")))
+	if err != nil && !os.IsNotExist(err) {
+		util.Warnf("Unable to remove %s: %v", path, err)
+	}
+}
+
+// credentialAccount renders the Mastodon CredentialAccount entity,
+// the current account along with the source of its profile.
+func credentialAccount(ctx context.Context, s sparq.Server, acct *model.Account) (map[string]any, error) {
+	attrs, err := AccountMap(ctx, s.DB(), acct.IRI())
+	if err != nil {
+		return nil, err
+	}
+	// the source has the fields as typed, not their HTML
+	raw := []struct {
+		Name       string
+		Value      string
+		VerifiedAt *time.Time
+	}{}
+	err = s.DB().SelectContext(ctx, &raw,
+		"select Name, Value, VerifiedAt from account_fields where AccountId = ? order by rowid", acct.Id)
+	if err != nil {
+		return nil, errors.Wrap(err, "account_fields")
+	}
+	fields := []map[string]any{}
+	for _, field := range raw {
+		source := map[string]any{
+			"name":        field.Name,
+			"value":       field.Value,
+			"verified_at": nil,
+		}
+		if field.VerifiedAt != nil {
+			source["verified_at"] = util.Thens(*field.VerifiedAt)
+		}
+		fields = append(fields, source)
+	}
+	var requests int
+	err = s.DB().GetContext(ctx, &requests,
+		"select count(*) from actor_following where TargetActorId = ? and State = ?", acct.IRI(), activitypub.FollowPending)
+	if err != nil {
+		return nil, errors.Wrap(err, "actor_following")
+	}
+	attrs["source"] = map[string]any{
+		"privacy":               "public",
+		"sensitive":             false,
+		"language":              "",
+		"note":                  acct.NoteSource,
+		"fields":                fields,
+		"follow_requests_count": requests,
+	}
+	return attrs, nil
+}
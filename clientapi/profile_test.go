@@ -0,0 +1,141 @@
+package clientapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/contribsys/sparq/web"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateCredentials(t *testing.T) {
+	ts, stopper := web.NewTestServer(t, "profile")
+	defer stopper()
+	jobs := ts.Jobs().(*web.TestJobs)
+	root := rootRouter(ts)
+	AddPublicEndpoints(ts, root.PathPrefix("/api/v1").Subrouter())
+
+	token, err := registerToken(t, ts)
+	assert.NoError(t, err)
+
+	count := 0
+	call := func(method, path string, values url.Values) (int, map[string]any) {
+		count++
+		req := httptest.NewRequest(method, "http://localhost.dev:9494/api/v1"+path, strings.NewReader(values.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Idempotency-Key", fmt.Sprintf("profile-%d", count))
+		w := httptest.NewRecorder()
+		root.ServeHTTP(w, req)
+		var result map[string]any
+		if w.Code == 200 {
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		}
+		return w.Code, result
+	}
+
+	code, _ := call("POST", "/accounts/update_credentials", url.Values{"display_name": {"Nope"}})
+	assert.Equal(t, 405, code)
+	code, _ = call("PATCH", "/accounts/update_credentials", url.Values{"display_name": {strings.Repeat("x", 31)}})
+	assert.Equal(t, 422, code)
+	tooMany := url.Values{}
+	for i := 0; i < 5; i++ {
+		tooMany.Set(fmt.Sprintf("fields_attributes[%d][name]", i), fmt.Sprintf("field%d", i))
+		tooMany.Set(fmt.Sprintf("fields_attributes[%d][value]", i), "value")
+	}
+	code, _ = call("PATCH", "/accounts/update_credentials", tooMany)
+	assert.Equal(t, 422, code)
+	assert.Empty(t, jobs.Find("DeliverProfile"))
+
+	code, acct := call("PATCH", "/accounts/update_credentials", url.Values{
+		"display_name":                {"The Admin"},
+		"note":                        {"Runs this #sparq server"},
+		"locked":                      {"true"},
+		"bot":                         {"true"},
+		"discoverable":                {"false"},
+		"fields_attributes[0][name]":  {"Website"},
+		"fields_attributes[0][value]": {"https://example.com"},
+		"fields_attributes[1][name]":  {""},
+		"fields_attributes[1][value]": {""},
+		"fields_attributes[2][name]":  {"Pronouns"},
+		"fields_attributes[2][value]": {"they/them"},
+	})
+	assert.Equal(t, 200, code)
+	assert.Equal(t, "The Admin", acct["display_name"])
+	assert.Contains(t, acct["note"], "sparq")
+	assert.Equal(t, true, acct["locked"])
+	assert.Equal(t, true, acct["bot"])
+	assert.Equal(t, false, acct["discoverable"])
+	assert.Equal(t, 2, len(acct["fields"].([]any)))
+	source := acct["source"].(map[string]any)
+	assert.Equal(t, "Runs this #sparq server", source["note"])
+	assert.Equal(t, "Pronouns", source["fields"].([]any)[1].(map[string]any)["name"])
+	// values are HTML, the source keeps what was typed
+	assert.Contains(t, acct["fields"].([]any)[0].(map[string]any)["value"], `<a href="https://example.com"`)
+	assert.Equal(t, "https://example.com", source["fields"].([]any)[0].(map[string]any)["value"])
+	assert.Equal(t, 1, len(jobs.Find("DeliverProfile")))
+
+	code, acct = call("GET", "/accounts/verify_credentials", nil)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, "The Admin", acct["display_name"])
+	assert.Equal(t, true, acct["bot"])
+
+	// attributes left out are unchanged
+	code, acct = call("PATCH", "/accounts/update_credentials", url.Values{"locked": {"false"}})
+	assert.Equal(t, 200, code)
+	assert.Equal(t, false, acct["locked"])
+	assert.Equal(t, "The Admin", acct["display_name"])
+	assert.Equal(t, 2, len(acct["fields"].([]any)))
+
+	t.Run("Avatar", func(t *testing.T) {
+		avatar := filepath.Join(t.TempDir(), "avatar.png")
+		file, err := os.Create(avatar)
+		assert.NoError(t, err)
+		assert.NoError(t, png.Encode(file, image.NewRGBA(image.Rect(0, 0, 64, 64))))
+		assert.NoError(t, file.Close())
+		notImage := filepath.Join(t.TempDir(), "notes.txt")
+		assert.NoError(t, os.WriteFile(notImage, []byte("just text"), 0644))
+
+		upload := func(path string) (int, map[string]any) {
+			count++
+			buf, wr, err := web.MultipartTestForm("avatar", path, map[string]string{"display_name": "Avatar Admin"})
+			assert.NoError(t, err)
+			req := httptest.NewRequest("PATCH", "http://localhost.dev:9494/api/v1/accounts/update_credentials", bytes.NewReader(buf.Bytes()))
+			req.Header.Set("Content-Type", wr.FormDataContentType())
+			req.Header.Set("Authorization", "Bearer "+token)
+			req.Header.Set("Idempotency-Key", fmt.Sprintf("profile-%d", count))
+			w := httptest.NewRecorder()
+			root.ServeHTTP(w, req)
+			var result map[string]any
+			if w.Code == 200 {
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+			}
+			return w.Code, result
+		}
+
+		code, _ := upload(notImage)
+		assert.Equal(t, 422, code)
+
+		code, acct := upload(avatar)
+		assert.Equal(t, 200, code)
+		assert.Equal(t, "Avatar Admin", acct["display_name"])
+		first := acct["avatar"].(string)
+		assert.Contains(t, first, "/media/profiles/1-avatar-")
+		assert.FileExists(t, filepath.Join(ts.MediaRoot(), strings.SplitN(first, "/media/", 2)[1]))
+
+		// the replaced avatar is removed
+		code, acct = upload(avatar)
+		assert.Equal(t, 200, code)
+		assert.NotEqual(t, first, acct["avatar"])
+		assert.NoFileExists(t, filepath.Join(ts.MediaRoot(), strings.SplitN(first, "/media/", 2)[1]))
+	})
+}
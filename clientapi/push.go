@@ -0,0 +1,443 @@
+package clientapi
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/sparq"
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/util"
+	"github.com/contribsys/sparq/web"
+	"github.com/contribsys/sparq/webpush"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// POST https://mastodon.example/api/v1/push/subscription
+// GET https://mastodon.example/api/v1/push/subscription
+// PUT https://mastodon.example/api/v1/push/subscription
+// DELETE https://mastodon.example/api/v1/push/subscription
+
+var (
+	// PushTTL is how long push services should hold a message
+	// for a browser which is offline.
+	PushTTL = 48 * time.Hour
+
+	// pushAlerts are the notification types a subscription can
+	// ask to be pushed.
+	pushAlerts = []string{"mention", "status", "reblog", "follow", "follow_request",
+		"favourite", "poll", "update", "admin.sign_up", "admin.report"}
+
+	pushPolicies = map[string]bool{"all": true, "followed": true, "follower": true, "none": true}
+)
+
+// alertOf returns the subscription's alert column for the
+// notification type or nil if the type can't be pushed.
+func alertOf(sub *model.Subscription, kind string) *int {
+	switch kind {
+	case "mention":
+		return &sub.AlertMention
+	case "status":
+		return &sub.AlertStatus
+	case "reblog":
+		return &sub.AlertReblog
+	case "follow":
+		return &sub.AlertFollow
+	case "follow_request":
+		return &sub.AlertFollowRequest
+	case "favourite":
+		return &sub.AlertFavorite
+	case "poll":
+		return &sub.AlertPoll
+	case "update":
+		return &sub.AlertUpdate
+	case "admin.sign_up":
+		return &sub.AlertAdminSignUp
+	case "admin.report":
+		return &sub.AlertAdminReport
+	}
+	return nil
+}
+
+// vapidKeys returns the instance's VAPID key pair, creating it the
+// first time it is needed.
+func vapidKeys(ctx context.Context, dbx *sqlx.DB) (*webpush.Keys, error) {
+	var value string
+	err := dbx.GetContext(ctx, &value, "select Value from instance_settings where Name = 'VapidKeys'")
+	if errors.Is(err, sql.ErrNoRows) {
+		err = createVapidKeys(ctx, dbx)
+		if err != nil {
+			return nil, err
+		}
+		err = dbx.GetContext(ctx, &value, "select Value from instance_settings where Name = 'VapidKeys'")
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "instance_settings")
+	}
+	var keys webpush.Keys
+	err = json.Unmarshal([]byte(value), &keys)
+	if err != nil {
+		return nil, errors.Wrap(err, "VapidKeys")
+	}
+	return &keys, nil
+}
+
+func createVapidKeys(ctx context.Context, dbx *sqlx.DB) error {
+	keys, err := webpush.GenerateKeys()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(keys)
+	if err != nil {
+		return err
+	}
+	// another process may beat us to it, theirs wins
+	_, err = dbx.ExecContext(ctx, `
+		insert into instance_settings (Name, Value) values ('VapidKeys', ?)
+		on conflict (Name) do nothing`, string(data))
+	return errors.Wrap(err, "instance_settings")
+}
+
+// pushSubscriptionHandler manages the Web Push subscription of the
+// current account in the token's app. Each app has at most one.
+func pushSubscriptionHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		acct, err := currentAccount(s, r)
+		if err != nil {
+			httpError(w, err, http.StatusUnauthorized)
+			return
+		}
+		app := web.Ctx(r).ClientApp()
+		if app == nil {
+			httpError(w, ErrUnauthorized, http.StatusUnauthorized)
+			return
+		}
+		err = r.ParseForm()
+		if err != nil {
+			httpError(w, err, http.StatusBadRequest)
+			return
+		}
+
+		var sub model.Subscription
+		err = s.DB().GetContext(r.Context(), &sub,
+			"select * from subscriptions where ActorId = ? and ClientId = ?", acct.IRI(), app.ClientId)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			httpError(w, errors.Wrap(err, "subscriptions"), http.StatusInternalServerError)
+			return
+		}
+		exists := err == nil
+
+		switch r.Method {
+		case "POST":
+			sub = model.Subscription{
+				Id:        strconv.FormatUint(model.Snowflakes.NextID(), 10),
+				ActorId:   acct.IRI(),
+				ClientId:  app.ClientId,
+				Endpoint:  r.Form.Get("subscription[endpoint]"),
+				KeyP256dh: r.Form.Get("subscription[keys][p256dh]"),
+				KeyAuth:   r.Form.Get("subscription[keys][auth]"),
+				Policy:    "all",
+			}
+			err = validateSubscription(&sub)
+			if err != nil {
+				httpError(w, err, http.StatusUnprocessableEntity)
+				return
+			}
+			err = readAlerts(r, &sub)
+			if err != nil {
+				httpError(w, err, http.StatusUnprocessableEntity)
+				return
+			}
+			// a new subscription replaces the app's old one
+			_, err = s.DB().ExecContext(r.Context(),
+				"delete from subscriptions where ActorId = ? and ClientId = ?", sub.ActorId, sub.ClientId)
+			if err != nil {
+				httpError(w, errors.Wrap(err, "subscriptions"), http.StatusInternalServerError)
+				return
+			}
+			_, err = s.DB().NamedExecContext(r.Context(), `
+				insert into subscriptions (Id, ActorId, ClientId, Endpoint, KeyP256dh, KeyAuth,
+					AlertMention, AlertStatus, AlertReblog, AlertFollow, AlertFollowRequest, AlertFavorite,
+					AlertPoll, AlertUpdate, AlertAdminSignUp, AlertAdminReport, Policy)
+				values (:Id, :ActorId, :ClientId, :Endpoint, :KeyP256dh, :KeyAuth,
+					:AlertMention, :AlertStatus, :AlertReblog, :AlertFollow, :AlertFollowRequest, :AlertFavorite,
+					:AlertPoll, :AlertUpdate, :AlertAdminSignUp, :AlertAdminReport, :Policy)`, &sub)
+			if err != nil {
+				httpError(w, errors.Wrap(err, "subscriptions"), http.StatusInternalServerError)
+				return
+			}
+		case "GET":
+			if !exists {
+				httpError(w, errors.New("Record not found"), http.StatusNotFound)
+				return
+			}
+		case "PUT":
+			if !exists {
+				httpError(w, errors.New("Record not found"), http.StatusNotFound)
+				return
+			}
+			err = readAlerts(r, &sub)
+			if err != nil {
+				httpError(w, err, http.StatusUnprocessableEntity)
+				return
+			}
+			_, err = s.DB().NamedExecContext(r.Context(), `
+				update subscriptions set AlertMention = :AlertMention, AlertStatus = :AlertStatus,
+					AlertReblog = :AlertReblog, AlertFollow = :AlertFollow,
+					AlertFollowRequest = :AlertFollowRequest, AlertFavorite = :AlertFavorite,
+					AlertPoll = :AlertPoll, AlertUpdate = :AlertUpdate,
+					AlertAdminSignUp = :AlertAdminSignUp, AlertAdminReport = :AlertAdminReport,
+					Policy = :Policy
+				where Id = :Id`, &sub)
+			if err != nil {
+				httpError(w, errors.Wrap(err, "subscriptions"), http.StatusInternalServerError)
+				return
+			}
+		case "DELETE":
+			_, err = s.DB().ExecContext(r.Context(), "delete from subscriptions where Id = ?", sub.Id)
+			if err != nil {
+				httpError(w, errors.Wrap(err, "subscriptions"), http.StatusInternalServerError)
+				return
+			}
+			httpJson(w, map[string]any{})
+			return
+		default:
+			httpError(w, errors.New("Unsupported method "+r.Method), http.StatusMethodNotAllowed)
+			return
+		}
+
+		keys, err := vapidKeys(r.Context(), s.DB())
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		httpJson(w, SubscriptionMap(&sub, keys.Public))
+	}
+}
+
+// validateSubscription checks the endpoint and the browser's keys
+// so we don't fail later when encrypting for it.
+func validateSubscription(sub *model.Subscription) error {
+	u, err := url.Parse(sub.Endpoint)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return errors.New("Invalid endpoint: " + sub.Endpoint)
+	}
+	key, err := webpush.Decode(sub.KeyP256dh)
+	if err != nil || len(key) != 65 || key[0] != 4 {
+		return errors.New("Invalid p256dh key")
+	}
+	auth, err := webpush.Decode(sub.KeyAuth)
+	if err != nil || len(auth) != 16 {
+		return errors.New("Invalid auth secret")
+	}
+	return nil
+}
+
+// readAlerts sets the subscription's alerts and policy from the
+// request. Alerts which aren't given are turned off.
+func readAlerts(r *http.Request, sub *model.Subscription) error {
+	for _, kind := range pushAlerts {
+		alert := 0
+		if isTrue(r.Form.Get("data[alerts][" + kind + "]")) {
+			alert = 1
+		}
+		*alertOf(sub, kind) = alert
+	}
+	if policy := r.Form.Get("data[policy]"); policy != "" {
+		if !pushPolicies[policy] {
+			return errors.New("Invalid policy: " + policy)
+		}
+		sub.Policy = policy
+	}
+	return nil
+}
+
+// SubscriptionMap renders the Mastodon WebPushSubscription entity.
+func SubscriptionMap(sub *model.Subscription, serverKey string) map[string]any {
+	alerts := map[string]any{}
+	for _, kind := range pushAlerts {
+		alerts[kind] = *alertOf(sub, kind) != 0
+	}
+	return map[string]any{
+		"id":         sub.Id,
+		"endpoint":   sub.Endpoint,
+		"alerts":     alerts,
+		"policy":     sub.Policy,
+		"server_key": serverKey,
+	}
+}
+
+// queuePush queues delivery of the notification to each of the
+// recipient's push subscriptions.
+func queuePush(ctx context.Context, svr sparq.Server, n *model.ActorNotification) {
+	ids := []string{}
+	err := svr.DB().SelectContext(ctx, &ids, "select Id from subscriptions where ActorId = ?", n.ActorId)
+	if err != nil {
+		util.Warnf("Unable to push notification %d: %v", n.Id, err)
+		return
+	}
+	for _, id := range ids {
+		err := svr.Jobs().Push(ctx, client.NewJob("DeliverPush", id, strconv.FormatInt(n.Id, 10)))
+		if err != nil {
+			util.Warnf("Unable to push notification %d: %v", n.Id, err)
+		}
+	}
+}
+
+// deliverPush sends the notification to the subscription if it
+// wants this kind from this sender. Subscriptions which the push
+// service has forgotten are deleted.
+func deliverPush(ctx context.Context, svr sparq.Server, id, nid string) error {
+	var sub model.Subscription
+	err := svr.DB().GetContext(ctx, &sub, "select * from subscriptions where Id = ?", id)
+	if errors.Is(err, sql.ErrNoRows) {
+		// unsubscribed since
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "subscriptions")
+	}
+	var n model.ActorNotification
+	err = svr.DB().GetContext(ctx, &n, "select * from actor_notifications where Id = ?", nid)
+	if errors.Is(err, sql.ErrNoRows) {
+		// dismissed already
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "actor_notifications")
+	}
+	wanted, err := wantsPush(ctx, svr.DB(), &sub, &n)
+	if err != nil || !wanted {
+		return err
+	}
+
+	attrs, err := NotificationMap(ctx, svr.DB(), &n)
+	if err != nil {
+		return err
+	}
+	filters, err := LoadFilters(ctx, svr.DB(), n.ActorId, "notifications")
+	if err != nil {
+		return err
+	}
+	if filterNotification(filters, attrs) {
+		return nil
+	}
+	payload, err := pushPayload(ctx, svr.DB(), &sub, attrs)
+	if err != nil {
+		return err
+	}
+	keys, err := vapidKeys(ctx, svr.DB())
+	if err != nil {
+		return err
+	}
+
+	msg := &webpush.Message{
+		Endpoint: sub.Endpoint,
+		P256dh:   sub.KeyP256dh,
+		Auth:     sub.KeyAuth,
+		Payload:  payload,
+		TTL:      PushTTL,
+	}
+	err = webpush.Send(ctx, msg, "mailto:admin@"+svr.Hostname(), keys)
+	if errors.Is(err, webpush.ErrGone) {
+		util.Infof("Removing expired push subscription %s", sub.Id)
+		_, err = svr.DB().ExecContext(ctx, "delete from subscriptions where Id = ?", sub.Id)
+		return errors.Wrap(err, "subscriptions")
+	}
+	return err
+}
+
+// wantsPush is true if the subscription's alerts and policy let
+// the notification through.
+func wantsPush(ctx context.Context, dbx *sqlx.DB, sub *model.Subscription, n *model.ActorNotification) (bool, error) {
+	alert := alertOf(sub, n.Type)
+	if alert == nil || *alert == 0 {
+		return false, nil
+	}
+	var query string
+	switch sub.Policy {
+	case "none":
+		return false, nil
+	case "followed":
+		query = "select count(*) from actor_following where ActorId = ? and TargetActorId = ? and State = 'accepted'"
+	case "follower":
+		query = "select count(*) from actor_following where TargetActorId = ? and ActorId = ? and State = 'accepted'"
+	default:
+		return true, nil
+	}
+	var count int
+	err := dbx.GetContext(ctx, &count, query, sub.ActorId, n.FromActorId)
+	return count > 0, errors.Wrap(err, "actor_following")
+}
+
+// pushPayload is the JSON which Mastodon apps expect in a push
+// message: a title and body to show at once and the token to fetch
+// the notification with.
+func pushPayload(ctx context.Context, dbx *sqlx.DB, sub *model.Subscription, attrs map[string]any) ([]byte, error) {
+	nick, _ := model.LocalNick(sub.ActorId)
+	tokens := []string{}
+	err := dbx.SelectContext(ctx, &tokens, `
+		select t.Access from oauth_tokens t join accounts a on a.Id = t.AccountId
+		where t.ClientId = ? and a.Nick = ? and t.Access != ''
+		order by t.AccessCreatedAt desc limit 1`, sub.ClientId, nick)
+	if err != nil {
+		return nil, errors.Wrap(err, "oauth_tokens")
+	}
+	token := ""
+	if len(tokens) > 0 {
+		token = tokens[0]
+	}
+
+	account, _ := attrs["account"].(map[string]any)
+	name, _ := account["display_name"].(string)
+	if name == "" {
+		name, _ = account["username"].(string)
+	}
+	kind := attrs["type"].(string)
+	title := map[string]string{
+		"mention":        name + " mentioned you",
+		"status":         name + " just posted",
+		"reblog":         name + " boosted your post",
+		"follow":         name + " followed you",
+		"follow_request": name + " requested to follow you",
+		"favourite":      name + " favourited your post",
+		"poll":           "A poll has ended",
+		"update":         name + " edited a post",
+		"admin.sign_up":  name + " signed up",
+		"admin.report":   "New report",
+	}[kind]
+	body := ""
+	if status, ok := attrs["status"].(map[string]any); ok {
+		body = statusText(status)
+	} else if note, ok := account["note"].(string); ok {
+		body = statusText(map[string]any{"content": note})
+	}
+
+	return json.Marshal(map[string]any{
+		"access_token":      token,
+		"preferred_locale":  "en",
+		"notification_id":   attrs["id"],
+		"notification_type": kind,
+		"icon":              account["avatar_static"],
+		"title":             title,
+		"body":              truncate(body, 140),
+	})
+}
+
+// truncate shortens the text to at most size runes, ending it with
+// an ellipsis if anything was cut.
+func truncate(text string, size int) string {
+	if utf8.RuneCountInString(text) <= size {
+		return text
+	}
+	runes := []rune(text)
+	return strings.TrimSpace(string(runes[:size-1])) + "…"
+}
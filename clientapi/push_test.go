@@ -0,0 +1,218 @@
+package clientapi
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/contribsys/sparq/activitypub"
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/web"
+	"github.com/contribsys/sparq/webpush"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPushSubscriptions(t *testing.T) {
+	ts, stopper := web.NewTestServer(t, "push")
+	defer stopper()
+	jobs := ts.Jobs().(*web.TestJobs)
+	activitypub.Register(ts)
+	Register(ts)
+	root := rootRouter(ts)
+	AddPublicEndpoints(ts, root.PathPrefix("/api/v1").Subrouter())
+	ctx := context.Background()
+
+	admin, err := registerToken(t, ts)
+	assert.NoError(t, err)
+	app, err := createOauthClient(ts, map[string]string{
+		"client_name": "Elk", "redirect_uris": "urn:ietf:wg:oauth:2.0:oob", "scopes": "read write push", "website": "https://elk.zone"})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, app["vapid_key"])
+	_, err = ts.DB().Exec(`insert into accounts (Id, Sid, Nick, Email, FullName) values (2, 'AAA2', 'alice', 'alice@localhost.dev', 'Alice')`)
+	assert.NoError(t, err)
+	_, err = ts.DB().Exec(`insert into account_profiles (AccountId) values (2)`)
+	assert.NoError(t, err)
+	store := &web.SqliteOauthStore{DB: ts.DB()}
+	assert.NoError(t, store.Create(ctx, &model.OauthToken{
+		ClientId:        app["client_id"].(string),
+		AccountId:       2,
+		Scope:           "read write push",
+		Access:          "ALICE",
+		AccessCreatedAt: time.Now(),
+		AccessExpiresIn: time.Hour,
+	}))
+
+	// the browser's half of the subscription
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	secret := []byte("alice's secret!!")
+	p256dh := base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes())
+	auth := base64.RawURLEncoding.EncodeToString(secret)
+
+	// a push service which decrypts what it's given like the
+	// browser would
+	var mu sync.Mutex
+	pushed := []map[string]any{}
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/gone" {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+		assert.Equal(t, "aes128gcm", r.Header.Get("Content-Encoding"))
+		assert.True(t, strings.HasSuffix(r.Header.Get("Authorization"), ", k="+app["vapid_key"].(string)))
+		body, _ := io.ReadAll(r.Body)
+		plain, err := webpush.Decrypt(key, secret, body)
+		assert.NoError(t, err)
+		payload := map[string]any{}
+		assert.NoError(t, json.Unmarshal(plain, &payload))
+		mu.Lock()
+		pushed = append(pushed, payload)
+		mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer service.Close()
+
+	count := 0
+	call := func(token, method, path string, values url.Values) (int, map[string]any) {
+		count++
+		req := httptest.NewRequest(method, "http://localhost.dev:9494/api/v1"+path, strings.NewReader(values.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Idempotency-Key", fmt.Sprintf("push-%d", count))
+		w := httptest.NewRecorder()
+		root.ServeHTTP(w, req)
+		result := map[string]any{}
+		if w.Code == 200 {
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		}
+		return w.Code, result
+	}
+	subscribe := func(endpoint string) string {
+		code, sub := call("ALICE", "POST", "/push/subscription", url.Values{
+			"subscription[endpoint]":       {endpoint},
+			"subscription[keys][p256dh]":   {p256dh},
+			"subscription[keys][auth]":     {auth},
+			"data[alerts][mention]":        {"true"},
+			"data[alerts][favourite]":      {"true"},
+			"data[alerts][follow_request]": {"false"},
+		})
+		assert.Equal(t, 200, code)
+		return sub["id"].(string)
+	}
+	mention := func(text string) {
+		code, _ := call(admin, "POST", "/statuses", url.Values{"status": {"@alice " + text}})
+		assert.Equal(t, 200, code)
+	}
+
+	t.Run("Subscription", func(t *testing.T) {
+		code, _ := call("ALICE", "GET", "/push/subscription", nil)
+		assert.Equal(t, 404, code)
+		code, _ = call("ALICE", "POST", "/push/subscription", url.Values{
+			"subscription[endpoint]":     {"not a url"},
+			"subscription[keys][p256dh]": {p256dh},
+			"subscription[keys][auth]":   {auth},
+		})
+		assert.Equal(t, 422, code)
+		code, _ = call("ALICE", "POST", "/push/subscription", url.Values{
+			"subscription[endpoint]":     {service.URL + "/alice"},
+			"subscription[keys][p256dh]": {auth},
+			"subscription[keys][auth]":   {auth},
+		})
+		assert.Equal(t, 422, code)
+
+		id := subscribe(service.URL + "/alice")
+		code, sub := call("ALICE", "GET", "/push/subscription", nil)
+		assert.Equal(t, 200, code)
+		assert.Equal(t, id, sub["id"])
+		assert.Equal(t, service.URL+"/alice", sub["endpoint"])
+		assert.Equal(t, "all", sub["policy"])
+		assert.Equal(t, app["vapid_key"], sub["server_key"])
+		alerts := sub["alerts"].(map[string]any)
+		assert.Equal(t, true, alerts["mention"])
+		assert.Equal(t, true, alerts["favourite"])
+		assert.Equal(t, false, alerts["follow_request"])
+		assert.Equal(t, false, alerts["admin.sign_up"])
+
+		// the subscription belongs to alice's app
+		code, _ = call(admin, "GET", "/push/subscription", nil)
+		assert.Equal(t, 404, code)
+
+		code, sub = call("ALICE", "PUT", "/push/subscription", url.Values{
+			"data[alerts][follow]": {"true"},
+			"data[policy]":         {"followed"},
+		})
+		assert.Equal(t, 200, code)
+		assert.Equal(t, id, sub["id"])
+		assert.Equal(t, "followed", sub["policy"])
+		alerts = sub["alerts"].(map[string]any)
+		assert.Equal(t, false, alerts["mention"])
+		assert.Equal(t, true, alerts["follow"])
+
+		code, _ = call("ALICE", "PUT", "/push/subscription", url.Values{"data[policy]": {"everyone"}})
+		assert.Equal(t, 422, code)
+
+		code, _ = call("ALICE", "DELETE", "/push/subscription", nil)
+		assert.Equal(t, 200, code)
+		code, _ = call("ALICE", "GET", "/push/subscription", nil)
+		assert.Equal(t, 404, code)
+	})
+
+	t.Run("Deliver", func(t *testing.T) {
+		subscribe(service.URL + "/alice")
+		mention("are you getting this?")
+		assert.Equal(t, 1, len(jobs.Find("DeliverPush")))
+		assert.NoError(t, jobs.Drain(ctx))
+
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Equal(t, 1, len(pushed))
+		payload := pushed[0]
+		assert.Equal(t, "mention", payload["notification_type"])
+		assert.Equal(t, "ALICE", payload["access_token"])
+		assert.Equal(t, "Sparq Admin mentioned you", payload["title"])
+		assert.Contains(t, payload["body"], "are you getting this?")
+		assert.NotEmpty(t, payload["notification_id"])
+	})
+
+	t.Run("Policy", func(t *testing.T) {
+		code, _ := call("ALICE", "PUT", "/push/subscription", url.Values{
+			"data[alerts][mention]": {"true"},
+			"data[policy]":          {"followed"},
+		})
+		assert.Equal(t, 200, code)
+		mention("alice doesn't follow me")
+		assert.Equal(t, 1, len(jobs.Find("DeliverPush")))
+		assert.NoError(t, jobs.Drain(ctx))
+
+		code, _ = call("ALICE", "PUT", "/push/subscription", url.Values{"data[alerts][follow]": {"true"}})
+		assert.Equal(t, 200, code)
+		mention("mentions are off")
+		assert.NoError(t, jobs.Drain(ctx))
+
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Equal(t, 1, len(pushed))
+	})
+
+	t.Run("Prune", func(t *testing.T) {
+		subscribe(service.URL + "/gone")
+		mention("hello?")
+		assert.NoError(t, jobs.Drain(ctx))
+
+		code, _ := call("ALICE", "GET", "/push/subscription", nil)
+		assert.Equal(t, 404, code)
+		mention("anyone?")
+		assert.Empty(t, jobs.Find("DeliverPush"))
+	})
+}
@@ -0,0 +1,624 @@
+package clientapi
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"net/http"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/sparq"
+	"github.com/contribsys/sparq/activitypub"
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/oauth2"
+	"github.com/contribsys/sparq/util"
+	"github.com/contribsys/sparq/web"
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// POST https://mastodon.example/api/v1/accounts
+// GET https://mastodon.example/api/v1/admin/accounts
+// GET https://mastodon.example/api/v1/admin/accounts/:id
+// POST https://mastodon.example/api/v1/admin/accounts/:id/approve
+// POST https://mastodon.example/api/v1/admin/accounts/:id/reject
+// GET https://mastodon.example/api/v1/admin/registration_mode
+// PUT https://mastodon.example/api/v1/admin/registration_mode
+
+var (
+	MinPasswordLength = 8
+	// bcrypt ignores anything longer
+	MaxPasswordLength = 72
+	MaxReason         = 500
+
+	ErrInvalidConfirmation = errors.New("Invalid confirmation token")
+
+	// nicks must match the routes for local users
+	nickPattern = regexp.MustCompile(`^[a-z0-9]{4,20}$`)
+)
+
+// Registration is someone signing up for a new account.
+type Registration struct {
+	Username   string
+	Email      string
+	Password   string
+	Agreement  bool
+	Reason     string
+	InviteCode string
+
+	mode   model.RegistrationMode
+	invite *model.Invite
+}
+
+// Validate checks the registration can be made under the instance's
+// registration mode, returning an error which can be shown to the
+// person signing up.
+func (reg *Registration) Validate(ctx context.Context, s sparq.Server) error {
+	reg.Username = strings.ToLower(strings.TrimSpace(reg.Username))
+	reg.Email = strings.TrimSpace(reg.Email)
+	reg.Reason = strings.TrimSpace(reg.Reason)
+	reg.InviteCode = strings.TrimSpace(reg.InviteCode)
+
+	if !nickPattern.MatchString(reg.Username) {
+		return errors.New("Username must be 4-20 lowercase letters or digits")
+	}
+	addr, err := mail.ParseAddress(reg.Email)
+	if err != nil || addr.Address != reg.Email {
+		return errors.New("Invalid email address")
+	}
+	if len(reg.Password) < MinPasswordLength || len(reg.Password) > MaxPasswordLength {
+		return errors.Errorf("Password must be %d-%d characters", MinPasswordLength, MaxPasswordLength)
+	}
+	if !reg.Agreement {
+		return errors.New("You must agree to the server rules")
+	}
+	if len(reg.Reason) > MaxReason {
+		return errors.Errorf("Reason must be at most %d characters", MaxReason)
+	}
+
+	var count int
+	err = s.DB().GetContext(ctx, &count, "select count(*) from accounts where Nick = ?", reg.Username)
+	if err != nil {
+		return errors.Wrap(err, "accounts")
+	}
+	if count > 0 {
+		return errors.New("Username has already been taken")
+	}
+	err = s.DB().GetContext(ctx, &count, "select count(*) from accounts where lower(Email) = lower(?)", reg.Email)
+	if err != nil {
+		return errors.Wrap(err, "accounts")
+	}
+	if count > 0 {
+		return errors.New("Email has already been taken")
+	}
+
+	reg.mode, err = RegistrationMode(ctx, s.DB())
+	if err != nil {
+		return err
+	}
+	reg.invite = nil
+	if reg.InviteCode != "" {
+		var invite model.Invite
+		err = s.DB().GetContext(ctx, &invite, "select * from invites where Code = ?", reg.InviteCode)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return errors.Wrap(err, "invites")
+		}
+		if err != nil || !invite.IsUsable() {
+			return errors.New("Invalid or expired invite code")
+		}
+		reg.invite = &invite
+	}
+	if reg.mode == model.RegistrationsInvite && reg.invite == nil {
+		return errors.New("You need an invite to sign up")
+	}
+	return nil
+}
+
+// SignUp creates the account for a validated registration. Unless
+// registrations are open or the registration uses an invite, the
+// account must be approved by a moderator, who is notified. Either
+// way it can't be used until the email address is confirmed.
+func SignUp(ctx context.Context, s sparq.Server, reg *Registration) (*model.Account, error) {
+	if reg.mode == "" {
+		err := reg.Validate(ctx, s)
+		if err != nil {
+			return nil, err
+		}
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(reg.Password), 12)
+	if err != nil {
+		return nil, err
+	}
+	pub, priv := util.GenerateKeys()
+	token, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+	var approvedAt, inviteId any
+	approved := reg.mode == model.RegistrationsOpen || reg.invite != nil
+	if approved {
+		approvedAt = time.Now().UTC().Format("2006-01-02 15:04:05")
+	}
+	if reg.invite != nil {
+		inviteId = reg.invite.Id
+	}
+
+	tx, err := s.DB().BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	res, err := tx.ExecContext(ctx, "insert into accounts (Sid, Nick, Email, FullName) values (?, ?, ?, '')",
+		strconv.FormatUint(model.Snowflakes.NextID(), 10), reg.Username, reg.Email)
+	if err != nil {
+		return nil, errors.Wrap(err, "accounts")
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.ExecContext(ctx, `
+		insert into account_securities (AccountId, PasswordHash, PublicKey, PrivateKey)
+		values (?, ?, ?, ?)`, id, hash, pub, priv)
+	if err != nil {
+		return nil, errors.Wrap(err, "account_securities")
+	}
+	_, err = tx.ExecContext(ctx, "insert into account_profiles (AccountId) values (?)", id)
+	if err != nil {
+		return nil, errors.Wrap(err, "account_profiles")
+	}
+	_, err = tx.ExecContext(ctx, `
+		insert into account_registrations (AccountId, Reason, InviteId, ConfirmationToken, ApprovedAt)
+		values (?, ?, ?, ?, ?)`, id, reg.Reason, inviteId, token, approvedAt)
+	if err != nil {
+		return nil, errors.Wrap(err, "account_registrations")
+	}
+	if reg.invite != nil {
+		// someone else may have used the last of the invite
+		res, err = tx.ExecContext(ctx, `
+			update invites set Uses = Uses + 1
+			where Id = ? and (MaxUses is null or Uses < MaxUses)`, reg.invite.Id)
+		if err != nil {
+			return nil, errors.Wrap(err, "invites")
+		}
+		if count, _ := res.RowsAffected(); count == 0 {
+			return nil, errors.New("Invalid or expired invite code")
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	var acct model.Account
+	err = s.DB().GetContext(ctx, &acct, `
+		select a.*, ap.* from accounts a
+		join account_profiles ap on ap.AccountId = a.Id
+		where a.Id = ?`, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "accounts")
+	}
+	util.Infof("New registration %s (%d)", acct.Nick, acct.Id)
+	err = s.Jobs().Push(ctx, client.NewJob("SendConfirmation", strconv.FormatInt(id, 10)))
+	if err != nil {
+		return nil, err
+	}
+	if !approved {
+		err = notifyModerators(ctx, s, &acct)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &acct, nil
+}
+
+func notifyModerators(ctx context.Context, s sparq.Server, acct *model.Account) error {
+	nicks := []string{}
+	err := s.DB().SelectContext(ctx, &nicks, "select Nick from accounts where RoleMask & ? != 0 and Id != ?",
+		model.RoleModerator|model.RoleAdmin, acct.Id)
+	if err != nil {
+		return errors.Wrap(err, "accounts")
+	}
+	for _, nick := range nicks {
+		err = activitypub.Notify(ctx, s, activitypub.NotifyAdminSignUp, model.LocalIRI(nick), acct.IRI(), "")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendConfirmation sends the link which confirms the account's email
+// address. There's no mailer yet so the link is logged for the admin
+// to pass along.
+func sendConfirmation(ctx context.Context, s sparq.Server, id string) error {
+	var row struct {
+		Email             string
+		ConfirmationToken string
+	}
+	err := s.DB().GetContext(ctx, &row, `
+		select a.Email, ar.ConfirmationToken from accounts a
+		join account_registrations ar on ar.AccountId = a.Id
+		where a.Id = ? and ar.ConfirmedAt is null`, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// already confirmed or rejected
+			return nil
+		}
+		return errors.Wrap(err, "account_registrations")
+	}
+	util.Infof("Confirm %s at https://%s/auth/confirmation?confirmation_token=%s",
+		row.Email, s.Hostname(), row.ConfirmationToken)
+	return nil
+}
+
+// ConfirmEmail confirms the email address of the registration with
+// the given token and returns it.
+func ConfirmEmail(ctx context.Context, s sparq.Server, token string) (*model.AccountRegistration, error) {
+	var reg model.AccountRegistration
+	err := s.DB().GetContext(ctx, &reg, "select * from account_registrations where ConfirmationToken = ?", token)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidConfirmation
+		}
+		return nil, errors.Wrap(err, "account_registrations")
+	}
+	now := time.Now().UTC()
+	_, err = s.DB().ExecContext(ctx, `
+		update account_registrations set ConfirmedAt = ?, ConfirmationToken = null
+		where AccountId = ?`, now.Format("2006-01-02 15:04:05"), reg.AccountId)
+	if err != nil {
+		return nil, errors.Wrap(err, "account_registrations")
+	}
+	reg.ConfirmedAt = &now
+	reg.ConfirmationToken = nil
+	return &reg, nil
+}
+
+// RegistrationMode is how people may sign up for accounts.
+func RegistrationMode(ctx context.Context, dbx *sqlx.DB) (model.RegistrationMode, error) {
+	var mode model.RegistrationMode
+	err := dbx.GetContext(ctx, &mode, "select Value from instance_settings where Name = 'RegistrationMode'")
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.RegistrationsApproval, nil
+		}
+		return "", errors.Wrap(err, "instance_settings")
+	}
+	return mode, nil
+}
+
+func randomToken(size int) (string, error) {
+	data := make([]byte, size)
+	_, err := rand.Read(data)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(data), nil
+}
+
+// registerHandler signs up for an account on behalf of the app which
+// owns the request's token, returning an access token for the new
+// account which works once it is confirmed and approved.
+func registerHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			httpError(w, errors.New("POST only"), http.StatusMethodNotAllowed)
+			return
+		}
+		app := web.Ctx(r).ClientApp()
+		if app == nil {
+			httpError(w, errors.New("This method requires an authenticated app"), http.StatusUnauthorized)
+			return
+		}
+		err := r.ParseForm()
+		if err != nil {
+			httpError(w, err, http.StatusBadRequest)
+			return
+		}
+		reg := &Registration{
+			Username:   r.Form.Get("username"),
+			Email:      r.Form.Get("email"),
+			Password:   r.Form.Get("password"),
+			Agreement:  isTrue(r.Form.Get("agreement")),
+			Reason:     r.Form.Get("reason"),
+			InviteCode: r.Form.Get("invite_code"),
+		}
+		err = reg.Validate(r.Context(), s)
+		if err != nil {
+			httpError(w, err, http.StatusUnprocessableEntity)
+			return
+		}
+		acct, err := SignUp(r.Context(), s, reg)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		createdAt := time.Now()
+		access, _, err := oauth2.NewAccessGenerate().Token(r.Context(), app.ClientId, strconv.FormatInt(acct.Id, 10), createdAt, false)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		store := &web.SqliteOauthStore{DB: s.DB()}
+		err = store.Create(r.Context(), &model.OauthToken{
+			ClientId:        app.ClientId,
+			AccountId:       uint64(acct.Id),
+			Scope:           app.Scopes,
+			Access:          access,
+			AccessCreatedAt: createdAt,
+			AccessExpiresIn: oauth2.DefaultAuthorizeCodeTokenCfg.AccessTokenExp,
+			CreatedAt:       createdAt,
+		})
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		httpJson(w, map[string]any{
+			"access_token": access,
+			"token_type":   "Bearer",
+			"scope":        app.Scopes,
+			"created_at":   createdAt.Unix(),
+		})
+	}
+}
+
+// adminAccountsHandler lists the local accounts for moderators,
+// only those awaiting approval if pending is true.
+func adminAccountsHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, err := currentModerator(s, r)
+		if err != nil {
+			moderatorError(w, err)
+			return
+		}
+		query := "select a.Id from accounts a"
+		if isTrue(r.FormValue("pending")) {
+			query += " join account_registrations ar on ar.AccountId = a.Id where ar.ApprovedAt is null"
+		}
+		rows := []struct{ Id int64 }{}
+		err = selectPage(r, s, &rows, query)
+		if err != nil {
+			httpError(w, err, http.StatusBadRequest)
+			return
+		}
+		results := []map[string]any{}
+		for _, row := range rows {
+			attrs, err := AdminAccountMap(r.Context(), s.DB(), row.Id)
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+			results = append(results, attrs)
+		}
+		if len(rows) > 0 {
+			linkHeader(w, r, strconv.FormatInt(rows[len(rows)-1].Id, 10), strconv.FormatInt(rows[0].Id, 10))
+		}
+		httpJson(w, results)
+	}
+}
+
+func adminAccountHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, err := currentModerator(s, r)
+		if err != nil {
+			moderatorError(w, err)
+			return
+		}
+		attrs, err := AdminAccountMap(r.Context(), s.DB(), mux.Vars(r)["id"])
+		if err != nil {
+			listError(w, err)
+			return
+		}
+		httpJson(w, attrs)
+	}
+}
+
+// approveAccountHandler lets a pending account be used once its email
+// address is confirmed.
+func approveAccountHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			httpError(w, errors.New("POST only"), http.StatusMethodNotAllowed)
+			return
+		}
+		mod, err := currentModerator(s, r)
+		if err != nil {
+			moderatorError(w, err)
+			return
+		}
+		id := mux.Vars(r)["id"]
+		res, err := s.DB().ExecContext(r.Context(), `
+			update account_registrations set ApprovedAt = current_timestamp
+			where AccountId = ? and ApprovedAt is null`, id)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		if count, _ := res.RowsAffected(); count == 0 {
+			httpError(w, errors.New("Account is not awaiting approval"), http.StatusUnprocessableEntity)
+			return
+		}
+		util.Infof("%s approved account %s", mod.Nick, id)
+		attrs, err := AdminAccountMap(r.Context(), s.DB(), id)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		httpJson(w, attrs)
+	}
+}
+
+// rejectAccountHandler deletes an account awaiting approval.
+func rejectAccountHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			httpError(w, errors.New("POST only"), http.StatusMethodNotAllowed)
+			return
+		}
+		mod, err := currentModerator(s, r)
+		if err != nil {
+			moderatorError(w, err)
+			return
+		}
+		id := mux.Vars(r)["id"]
+		attrs, err := AdminAccountMap(r.Context(), s.DB(), id)
+		if err != nil {
+			listError(w, err)
+			return
+		}
+		if attrs["approved"] != false {
+			httpError(w, errors.New("Account is not awaiting approval"), http.StatusUnprocessableEntity)
+			return
+		}
+		err = deleteRegistration(r.Context(), s, id, model.LocalIRI(attrs["username"].(string)))
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		util.Infof("%s rejected account %s", mod.Nick, id)
+		httpJson(w, attrs)
+	}
+}
+
+func deleteRegistration(ctx context.Context, s sparq.Server, id, iri string) error {
+	tx, err := s.DB().BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	for _, table := range []string{"account_registrations", "account_fields", "account_profiles", "account_securities", "oauth_tokens"} {
+		_, err = tx.ExecContext(ctx, "delete from "+table+" where AccountId = ?", id)
+		if err != nil {
+			return errors.Wrap(err, table)
+		}
+	}
+	_, err = tx.ExecContext(ctx, "delete from actor_notifications where FromActorId = ?", iri)
+	if err != nil {
+		return errors.Wrap(err, "actor_notifications")
+	}
+	_, err = tx.ExecContext(ctx, "delete from accounts where Id = ?", id)
+	if err != nil {
+		return errors.Wrap(err, "accounts")
+	}
+	return tx.Commit()
+}
+
+// AdminAccountMap renders the Mastodon Admin::Account entity for a
+// local account.
+func AdminAccountMap(ctx context.Context, dbx *sqlx.DB, id any) (map[string]any, error) {
+	var acct model.Account
+	err := dbx.GetContext(ctx, &acct, "select * from accounts where Id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	reg := model.AccountRegistration{}
+	err = dbx.GetContext(ctx, &reg, "select * from account_registrations where AccountId = ?", acct.Id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.Wrap(err, "account_registrations")
+	}
+	// accounts which didn't sign up were made by an admin
+	signedUp := err == nil
+	account, err := AccountMap(ctx, dbx, acct.IRI())
+	if err != nil {
+		return nil, err
+	}
+	attrs := map[string]any{
+		"id":                    strconv.FormatInt(acct.Id, 10),
+		"username":              acct.Nick,
+		"domain":                nil,
+		"created_at":            util.Thens(*acct.CreatedAt),
+		"email":                 acct.Email,
+		"ip":                    nil,
+		"ips":                   []any{},
+		"locale":                "en",
+		"invite_request":        nil,
+		"role":                  roleMap(&acct),
+		"confirmed":             !signedUp || reg.ConfirmedAt != nil,
+		"approved":              !signedUp || reg.ApprovedAt != nil,
+		"disabled":              false,
+		"silenced":              false,
+		"suspended":             false,
+		"account":               account,
+		"invited_by_account_id": nil,
+	}
+	if reg.Reason != "" {
+		attrs["invite_request"] = reg.Reason
+	}
+	if reg.InviteId != nil {
+		var inviter int64
+		err = dbx.GetContext(ctx, &inviter, "select AccountId from invites where Id = ?", *reg.InviteId)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.Wrap(err, "invites")
+		}
+		if err == nil {
+			attrs["invited_by_account_id"] = strconv.FormatInt(inviter, 10)
+		}
+	}
+	return attrs, nil
+}
+
+// roleMap renders the Mastodon Role entity for the account's most
+// privileged role.
+func roleMap(acct *model.Account) map[string]any {
+	name := "User"
+	switch {
+	case acct.HasRole(model.RoleAdmin):
+		name = "Admin"
+	case acct.HasRole(model.RoleModerator):
+		name = "Moderator"
+	}
+	return map[string]any{
+		"id":          strconv.Itoa(int(acct.RoleMask)),
+		"name":        name,
+		"color":       "",
+		"permissions": "0",
+		"highlighted": name != "User",
+	}
+}
+
+// registrationModeHandler shows admins how people may sign up and
+// lets them change it.
+func registrationModeHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, err := currentAdmin(s, r)
+		if err != nil {
+			moderatorError(w, err)
+			return
+		}
+		switch r.Method {
+		case "GET":
+		case "PUT", "POST":
+			value := r.FormValue("mode")
+			if !model.ValidRegistrationMode(value) {
+				httpError(w, errors.New("Invalid registration mode: "+value), http.StatusUnprocessableEntity)
+				return
+			}
+			_, err = s.DB().ExecContext(r.Context(), `
+				insert into instance_settings (Name, Value) values ('RegistrationMode', ?)
+				on conflict (Name) do update set Value = excluded.Value`, value)
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+		default:
+			httpError(w, errors.New("Unsupported method "+r.Method), http.StatusMethodNotAllowed)
+			return
+		}
+		mode, err := RegistrationMode(r.Context(), s.DB())
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		httpJson(w, map[string]any{"mode": mode})
+	}
+}
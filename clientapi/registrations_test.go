@@ -0,0 +1,214 @@
+package clientapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/contribsys/sparq/activitypub"
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/web"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistrations(t *testing.T) {
+	ts, stopper := web.NewTestServer(t, "registrations")
+	defer stopper()
+	jobs := ts.Jobs().(*web.TestJobs)
+	root := rootRouter(ts)
+	AddPublicEndpoints(ts, root.PathPrefix("/api/v1").Subrouter())
+	ctx := context.Background()
+
+	admin, err := registerToken(t, ts)
+	assert.NoError(t, err)
+	// apps sign people up with a client credentials token
+	app, err := createOauthClient(ts, map[string]string{
+		"client_name": "Tusky", "redirect_uris": "urn:ietf:wg:oauth:2.0:oob", "scopes": "read write", "website": "https://tusky.app"})
+	assert.NoError(t, err)
+	store := &web.SqliteOauthStore{DB: ts.DB()}
+	assert.NoError(t, store.Create(ctx, &model.OauthToken{
+		ClientId:        app["client_id"].(string),
+		Scope:           "read write",
+		Access:          "APPTOKEN",
+		AccessCreatedAt: time.Now(),
+		AccessExpiresIn: time.Hour,
+	}))
+
+	count := 0
+	call := func(token, method, path string, values url.Values) (int, map[string]any) {
+		count++
+		req := httptest.NewRequest(method, "http://localhost.dev:9494/api/v1"+path, strings.NewReader(values.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		req.Header.Set("Idempotency-Key", fmt.Sprintf("registrations-%d", count))
+		w := httptest.NewRecorder()
+		root.ServeHTTP(w, req)
+		var result map[string]any
+		if w.Code == 200 && strings.HasPrefix(strings.TrimSpace(w.Body.String()), "{") {
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		}
+		return w.Code, result
+	}
+	list := func(path string) []any {
+		req := httptest.NewRequest("GET", "http://localhost.dev:9494/api/v1"+path, nil)
+		req.Header.Set("Authorization", "Bearer "+admin)
+		w := httptest.NewRecorder()
+		root.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+		var result []any
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		return result
+	}
+	signup := func(username string, extra url.Values) (int, map[string]any) {
+		values := url.Values{
+			"username":  {username},
+			"email":     {username + "@example.com"},
+			"password":  {"secret123"},
+			"agreement": {"true"},
+		}
+		for k, v := range extra {
+			values[k] = v
+		}
+		return call("APPTOKEN", "POST", "/accounts", values)
+	}
+	confirm := func(nick string) {
+		var token string
+		assert.NoError(t, ts.DB().Get(&token, `
+			select ar.ConfirmationToken from account_registrations ar
+			join accounts a on a.Id = ar.AccountId where a.Nick = ?`, nick))
+		_, err := ConfirmEmail(ctx, ts, token)
+		assert.NoError(t, err)
+	}
+
+	code, inst := call("", "GET", "/instance", nil)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, true, inst["registrations"])
+	assert.Equal(t, true, inst["approval_required"])
+
+	t.Run("Approval", func(t *testing.T) {
+		code, _ := call("", "POST", "/accounts", url.Values{"username": {"alice"}})
+		assert.Equal(t, 401, code)
+		code, _ = signup("al", nil)
+		assert.Equal(t, 422, code)
+		code, _ = signup("alice", url.Values{"agreement": {"false"}})
+		assert.Equal(t, 422, code)
+		code, _ = signup("alice", url.Values{"password": {"short"}})
+		assert.Equal(t, 422, code)
+		code, _ = signup("admin", nil)
+		assert.Equal(t, 422, code)
+
+		code, token := signup("alice", url.Values{"reason": {"I like sparqs"}})
+		assert.Equal(t, 200, code)
+		access := token["access_token"].(string)
+		assert.Equal(t, "Bearer", token["token_type"])
+		assert.Equal(t, 1, len(jobs.Find("SendConfirmation")))
+		code, _ = signup("alice2", url.Values{"email": {"ALICE@example.com"}})
+		assert.Equal(t, 422, code)
+
+		// alice can't do anything until confirmed and approved
+		code, _ = call(access, "GET", "/accounts/verify_credentials", nil)
+		assert.Equal(t, 401, code)
+		notes := list("/notifications")
+		assert.Equal(t, 1, len(notes))
+		assert.Equal(t, activitypub.NotifyAdminSignUp, notes[0].(map[string]any)["type"])
+
+		pending := list("/admin/accounts?pending=true")
+		assert.Equal(t, 1, len(pending))
+		acct := pending[0].(map[string]any)
+		assert.Equal(t, "alice", acct["username"])
+		assert.Equal(t, "I like sparqs", acct["invite_request"])
+		assert.Equal(t, false, acct["confirmed"])
+		assert.Equal(t, false, acct["approved"])
+		id := acct["id"].(string)
+
+		confirm("alice")
+		_, err := ConfirmEmail(ctx, ts, "nosuch")
+		assert.ErrorIs(t, err, ErrInvalidConfirmation)
+		code, _ = call(access, "GET", "/accounts/verify_credentials", nil)
+		assert.Equal(t, 401, code)
+
+		code, _ = call(access, "POST", "/admin/accounts/"+id+"/approve", nil)
+		assert.Equal(t, 401, code)
+		code, acct = call(admin, "POST", "/admin/accounts/"+id+"/approve", nil)
+		assert.Equal(t, 200, code)
+		assert.Equal(t, true, acct["confirmed"])
+		assert.Equal(t, true, acct["approved"])
+		code, _ = call(admin, "POST", "/admin/accounts/"+id+"/approve", nil)
+		assert.Equal(t, 422, code)
+		assert.Empty(t, list("/admin/accounts?pending=true"))
+
+		code, me := call(access, "GET", "/accounts/verify_credentials", nil)
+		assert.Equal(t, 200, code)
+		assert.Equal(t, "alice", me["username"])
+	})
+
+	t.Run("Reject", func(t *testing.T) {
+		code, _ := signup("carol", nil)
+		assert.Equal(t, 200, code)
+		id := list("/admin/accounts?pending=true")[0].(map[string]any)["id"].(string)
+		code, acct := call(admin, "POST", "/admin/accounts/"+id+"/reject", nil)
+		assert.Equal(t, 200, code)
+		assert.Equal(t, "carol", acct["username"])
+		code, _ = call(admin, "GET", "/admin/accounts/"+id, nil)
+		assert.Equal(t, 404, code)
+		var rows int
+		assert.NoError(t, ts.DB().Get(&rows, "select count(*) from account_profiles where AccountId = ?", id))
+		assert.Equal(t, 0, rows)
+		// the name can be used again
+		code, _ = signup("carol", nil)
+		assert.Equal(t, 200, code)
+	})
+
+	t.Run("Invites", func(t *testing.T) {
+		code, _ := call(admin, "PUT", "/admin/registration_mode", url.Values{"mode": {"closed"}})
+		assert.Equal(t, 422, code)
+		code, result := call(admin, "PUT", "/admin/registration_mode", url.Values{"mode": {"invite"}})
+		assert.Equal(t, 200, code)
+		assert.Equal(t, "invite", result["mode"])
+		_, inst := call("", "GET", "/instance", nil)
+		assert.Equal(t, false, inst["registrations"])
+		assert.Equal(t, false, inst["approval_required"])
+
+		code, _ = signup("dave", nil)
+		assert.Equal(t, 422, code)
+		code, _ = signup("dave", url.Values{"invite_code": {"nosuch"}})
+		assert.Equal(t, 422, code)
+
+		code, _ = call(admin, "POST", "/admin/invites", url.Values{"max_uses": {"-1"}})
+		assert.Equal(t, 422, code)
+		code, invite := call(admin, "POST", "/admin/invites", url.Values{"max_uses": {"1"}, "expires_in": {"3600"}})
+		assert.Equal(t, 200, code)
+		assert.NotNil(t, invite["expires_at"])
+		assert.Contains(t, invite["url"], "/signup?invite_code=")
+		inviteCode := invite["code"].(string)
+
+		// invites skip the approval queue
+		code, token := signup("dave", url.Values{"invite_code": {inviteCode}})
+		assert.Equal(t, 200, code)
+		confirm("dave")
+		code, _ = call(token["access_token"].(string), "GET", "/accounts/verify_credentials", nil)
+		assert.Equal(t, 200, code)
+		code, _ = signup("erin", url.Values{"invite_code": {inviteCode}})
+		assert.Equal(t, 422, code)
+
+		code, invite = call(admin, "POST", "/admin/invites", nil)
+		assert.Equal(t, 200, code)
+		assert.Nil(t, invite["max_uses"])
+		code, invite = call(admin, "DELETE", "/admin/invites/"+invite["id"].(string), nil)
+		assert.Equal(t, 200, code)
+		assert.Equal(t, true, invite["expired"])
+		code, _ = signup("erin", url.Values{"invite_code": {invite["code"].(string)}})
+		assert.Equal(t, 422, code)
+
+		invites := list("/admin/invites")
+		assert.Equal(t, 2, len(invites))
+		assert.EqualValues(t, 1, invites[1].(map[string]any)["uses"])
+	})
+}
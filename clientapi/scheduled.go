@@ -0,0 +1,355 @@
+package clientapi
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/sparq"
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/util"
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// GET https://mastodon.example/api/v1/scheduled_statuses
+// GET https://mastodon.example/api/v1/scheduled_statuses/:id
+// PUT https://mastodon.example/api/v1/scheduled_statuses/:id
+// DELETE https://mastodon.example/api/v1/scheduled_statuses/:id
+
+// MinScheduleDelay is how far in the future a toot must be
+// scheduled, otherwise it should be posted immediately.
+const MinScheduleDelay = 5 * time.Minute
+
+// parseScheduledAt parses the requested publication time,
+// which must be at least MinScheduleDelay in the future.
+func parseScheduledAt(value string) (time.Time, error) {
+	at, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return at, errors.Wrap(err, "Invalid scheduled_at")
+	}
+	if at.Before(time.Now().Add(MinScheduleDelay)) {
+		return at, errors.New("Scheduled time must be at least 5 minutes in the future")
+	}
+	return at.UTC(), nil
+}
+
+// scheduleToot stores the toot to be published later and renders
+// the ScheduledStatus. Attached media is reserved for the toot
+// so it isn't purged before publication.
+func scheduleToot(w http.ResponseWriter, r *http.Request, svr sparq.Server, toot *Toot) {
+	at, err := parseScheduledAt(toot.ScheduledAt)
+	if err != nil {
+		httpError(w, err, http.StatusUnprocessableEntity)
+		return
+	}
+	params, err := json.Marshal(toot)
+	if err != nil {
+		httpError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	ctx := r.Context()
+	tx, err := svr.DB().BeginTxx(ctx, nil)
+	if err != nil {
+		httpError(w, err, http.StatusInternalServerError)
+		return
+	}
+	res, err := tx.ExecContext(ctx, `
+		insert into scheduled_toots (AccountId, ScheduledAt, Params) values (?, ?, ?)`,
+		toot.AuthorId, at.Format("2006-01-02 15:04:05"), string(params))
+	if err != nil {
+		_ = tx.Rollback()
+		httpError(w, errors.Wrap(err, "scheduled_toots"), http.StatusInternalServerError)
+		return
+	}
+	id, _ := res.LastInsertId()
+	if len(toot.MediaIds) > 0 {
+		query, args, err := sqlx.In(`
+			update toot_medias set ScheduledId = ?
+			where Id in (?) and AccountId = ? and Sid = ''`, id, toot.MediaIds, toot.AuthorId)
+		if err != nil {
+			_ = tx.Rollback()
+			httpError(w, errors.Wrap(err, "medias"), http.StatusInternalServerError)
+			return
+		}
+		res, err := tx.ExecContext(ctx, tx.Rebind(query), args...)
+		if err != nil {
+			_ = tx.Rollback()
+			httpError(w, errors.Wrap(err, "media exec"), http.StatusInternalServerError)
+			return
+		}
+		if count, _ := res.RowsAffected(); count != int64(len(uniq(toot.MediaIds))) {
+			_ = tx.Rollback()
+			httpError(w, ErrInvalidMedia, http.StatusUnprocessableEntity)
+			return
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		httpError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	st := &model.ScheduledToot{Id: id, AccountId: toot.AuthorId, ScheduledAt: at, Params: string(params)}
+	err = pushScheduled(ctx, svr, st)
+	if err != nil {
+		httpError(w, err, http.StatusInternalServerError)
+		return
+	}
+	attrs, err := ScheduledTootMap(ctx, svr.DB(), st)
+	if err != nil {
+		httpError(w, err, http.StatusInternalServerError)
+		return
+	}
+	httpJson(w, attrs)
+}
+
+// pushScheduled queues the job which publishes the toot.
+func pushScheduled(ctx context.Context, svr sparq.Server, st *model.ScheduledToot) error {
+	job := client.NewJob("PublishScheduled", strconv.FormatInt(st.Id, 10))
+	job.At = st.ScheduledAt.UTC().Format(time.RFC3339Nano)
+	return svr.Jobs().Push(ctx, job)
+}
+
+// publishScheduled publishes the scheduled toot once it is due.
+// A toot which was cancelled or rescheduled later is left alone,
+// a rescheduled toot has its own job.
+func publishScheduled(ctx context.Context, svr sparq.Server, id string) error {
+	var st model.ScheduledToot
+	err := svr.DB().GetContext(ctx, &st, "select * from scheduled_toots where Id = ?", id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return errors.Wrap(err, "scheduled_toots")
+	}
+	if st.ScheduledAt.After(time.Now()) {
+		return nil
+	}
+	var toot Toot
+	err = json.Unmarshal([]byte(st.Params), &toot)
+	if err != nil {
+		return errors.Wrap(err, "params "+id)
+	}
+	toot.ScheduledAt = ""
+	post, err := publishToot(ctx, svr, &toot)
+	if err != nil {
+		return err
+	}
+	_, err = svr.DB().ExecContext(ctx, "delete from scheduled_toots where Id = ?", st.Id)
+	if err != nil {
+		return errors.Wrap(err, "scheduled_toots")
+	}
+	util.Infof("Published scheduled toot %s as %s", id, post.Uri)
+	return nil
+}
+
+func scheduledTootsHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		acct, err := currentAccount(s, r)
+		if err != nil {
+			httpError(w, err, http.StatusUnauthorized)
+			return
+		}
+		err = r.ParseForm()
+		if err != nil {
+			httpError(w, err, http.StatusBadRequest)
+			return
+		}
+		limit := uint64(20)
+		if value := r.Form.Get("limit"); value != "" {
+			limit, err = strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				httpError(w, errors.Wrap(err, "Invalid limit"), http.StatusBadRequest)
+				return
+			}
+		}
+		if limit == 0 || limit > 40 {
+			limit = 40
+		}
+
+		query := squirrel.Select("*").From("scheduled_toots").
+			Where("AccountId = ?", acct.Id).
+			Limit(limit)
+		if id := r.Form.Get("max_id"); id != "" {
+			query = query.Where("Id < ?", id)
+		}
+		if id := r.Form.Get("since_id"); id != "" {
+			query = query.Where("Id > ?", id)
+		}
+		// min_id asks for the toots scheduled immediately after the given one
+		ascending := r.Form.Get("min_id") != "" && r.Form.Get("max_id") == ""
+		if id := r.Form.Get("min_id"); id != "" {
+			query = query.Where("Id > ?", id)
+		}
+		if ascending {
+			query = query.OrderBy("Id ASC")
+		} else {
+			query = query.OrderBy("Id DESC")
+		}
+		sqlq, args, err := query.ToSql()
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		rows := []model.ScheduledToot{}
+		err = s.DB().SelectContext(r.Context(), &rows, sqlq, args...)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		if ascending {
+			for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+				rows[i], rows[j] = rows[j], rows[i]
+			}
+		}
+
+		results := []map[string]any{}
+		for idx := range rows {
+			attrs, err := ScheduledTootMap(r.Context(), s.DB(), &rows[idx])
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+			results = append(results, attrs)
+		}
+		if len(rows) > 0 {
+			linkHeader(w, r, strconv.FormatInt(rows[len(rows)-1].Id, 10), strconv.FormatInt(rows[0].Id, 10))
+		}
+		httpJson(w, results)
+	}
+}
+
+func scheduledTootHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		acct, err := currentAccount(s, r)
+		if err != nil {
+			httpError(w, err, http.StatusUnauthorized)
+			return
+		}
+		var st model.ScheduledToot
+		err = s.DB().GetContext(r.Context(), &st,
+			"select * from scheduled_toots where Id = ? and AccountId = ?", mux.Vars(r)["id"], acct.Id)
+		if err != nil {
+			listError(w, err)
+			return
+		}
+
+		switch r.Method {
+		case "GET":
+		case "PUT":
+			err = r.ParseForm()
+			if err != nil {
+				httpError(w, err, http.StatusBadRequest)
+				return
+			}
+			at, err := parseScheduledAt(r.Form.Get("scheduled_at"))
+			if err != nil {
+				httpError(w, err, http.StatusUnprocessableEntity)
+				return
+			}
+			_, err = s.DB().ExecContext(r.Context(),
+				"update scheduled_toots set ScheduledAt = ? where Id = ?", at.Format("2006-01-02 15:04:05"), st.Id)
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+			st.ScheduledAt = at
+			err = pushScheduled(r.Context(), s, &st)
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+		case "DELETE":
+			// release the media so it can be purged
+			_, err = s.DB().ExecContext(r.Context(),
+				"update toot_medias set ScheduledId = null where ScheduledId = ?", st.Id)
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+			_, err = s.DB().ExecContext(r.Context(), "delete from scheduled_toots where Id = ?", st.Id)
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+			httpJson(w, map[string]any{})
+			return
+		default:
+			httpError(w, errors.New("Unsupported method "+r.Method), http.StatusMethodNotAllowed)
+			return
+		}
+
+		attrs, err := ScheduledTootMap(r.Context(), s.DB(), &st)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		httpJson(w, attrs)
+	}
+}
+
+// ScheduledTootMap renders the Mastodon ScheduledStatus entity.
+func ScheduledTootMap(ctx context.Context, dbx *sqlx.DB, st *model.ScheduledToot) (map[string]any, error) {
+	var toot Toot
+	err := json.Unmarshal([]byte(st.Params), &toot)
+	if err != nil {
+		return nil, errors.Wrap(err, "params")
+	}
+	at := util.Thens(st.ScheduledAt)
+	params := map[string]any{
+		"text":            toot.Content,
+		"media_ids":       toot.MediaIds,
+		"sensitive":       toot.Sensitive,
+		"spoiler_text":    toot.Summary,
+		"visibility":      model.FromVis(model.ToVis(toot.Visibility)),
+		"in_reply_to_id":  toot.InReplyTo,
+		"language":        nil,
+		"application_id":  nil,
+		"scheduled_at":    at,
+		"poll":            nil,
+		"idempotency":     nil,
+		"with_rate_limit": false,
+	}
+	if toot.LanguageCode != "" {
+		params["language"] = toot.LanguageCode
+	}
+	if toot.AppId != nil {
+		params["application_id"] = strconv.FormatUint(*toot.AppId, 10)
+	}
+	if toot.MediaIds == nil {
+		params["media_ids"] = []string{}
+	}
+	if toot.Poll != nil {
+		params["poll"] = map[string]any{
+			"options":     toot.Poll.Options,
+			"expires_in":  strconv.Itoa(toot.Poll.ExpiresIn),
+			"multiple":    toot.Poll.MultipleChoice,
+			"hide_totals": toot.Poll.HideTotals,
+		}
+	}
+
+	medias := []model.TootMedia{}
+	err = dbx.SelectContext(ctx, &medias, "select * from toot_medias where ScheduledId = ? order by Id", st.Id)
+	if err != nil {
+		return nil, errors.Wrap(err, "toot_medias")
+	}
+	attachments := []map[string]any{}
+	for idx := range medias {
+		attachments = append(attachments, toAttachmentMap(&medias[idx]))
+	}
+
+	return map[string]any{
+		"id":                strconv.FormatInt(st.Id, 10),
+		"scheduled_at":      at,
+		"params":            params,
+		"media_attachments": attachments,
+	}, nil
+}
@@ -0,0 +1,173 @@
+package clientapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/contribsys/sparq/activitypub"
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/web"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduledStatuses(t *testing.T) {
+	ts, stopper := web.NewTestServer(t, "scheduled")
+	defer stopper()
+	jobs := ts.Jobs().(*web.TestJobs)
+	activitypub.Register(ts)
+	Register(ts)
+	token, err := registerToken(t, ts)
+	assert.NoError(t, err)
+	root := rootRouter(ts)
+	AddPublicEndpoints(ts, root.PathPrefix("/api/v1").Subrouter())
+
+	_, err = ts.DB().Exec(`
+		insert into toot_medias (Id, AccountId, Salt, CreatedAt) values
+		(101, 1, 'sched', datetime('now', '-2 days')),
+		(102, 1, 'orphan', datetime('now', '-2 days')),
+		(103, 1, 'fresh', datetime('now'))`)
+	assert.NoError(t, err)
+
+	count := 0
+	call := func(method, path string, values url.Values) (int, any) {
+		count++
+		req := httptest.NewRequest(method, "http://localhost.dev:9494/api/v1"+path, strings.NewReader(values.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Idempotency-Key", fmt.Sprintf("scheduled-%d", count))
+		w := httptest.NewRecorder()
+		root.ServeHTTP(w, req)
+		var result any
+		if w.Code == 200 {
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		}
+		return w.Code, result
+	}
+	later := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+
+	code, _ := call("POST", "/statuses", url.Values{
+		"status":       {"Too soon"},
+		"scheduled_at": {time.Now().Add(time.Minute).UTC().Format(time.RFC3339)},
+	})
+	assert.Equal(t, 422, code)
+
+	code, result := call("POST", "/statuses", url.Values{
+		"status":       {"Good morning!"},
+		"visibility":   {"unlisted"},
+		"media_ids[]":  {"101"},
+		"scheduled_at": {later},
+	})
+	assert.Equal(t, 200, code)
+	st := result.(map[string]any)
+	id := st["id"].(string)
+	params := st["params"].(map[string]any)
+	assert.Equal(t, "Good morning!", params["text"])
+	assert.Equal(t, "unlisted", params["visibility"])
+	assert.Equal(t, 1, len(st["media_attachments"].([]any)))
+	assert.Equal(t, 1, len(jobs.Find("PublishScheduled")))
+
+	var published int
+	err = ts.DB().Get(&published, "select count(*) from toots where Content = 'Good morning!'")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, published)
+
+	t.Run("Manage", func(t *testing.T) {
+		code, result := call("GET", "/scheduled_statuses", nil)
+		assert.Equal(t, 200, code)
+		assert.Equal(t, 1, len(result.([]any)))
+
+		code, _ = call("GET", "/scheduled_statuses/9999", nil)
+		assert.Equal(t, 404, code)
+
+		code, _ = call("PUT", "/scheduled_statuses/"+id, url.Values{"scheduled_at": {"tomorrow"}})
+		assert.Equal(t, 422, code)
+
+		tomorrow := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+		code, result = call("PUT", "/scheduled_statuses/"+id, url.Values{"scheduled_at": {tomorrow.Format(time.RFC3339)}})
+		assert.Equal(t, 200, code)
+		assert.Equal(t, tomorrow.Format(time.RFC3339Nano), result.(map[string]any)["scheduled_at"])
+		assert.Equal(t, 2, len(jobs.Find("PublishScheduled")))
+
+		// nothing is due yet
+		assert.NoError(t, jobs.Drain(context.Background()))
+		err = ts.DB().Get(&published, "select count(*) from toots where Content = 'Good morning!'")
+		assert.NoError(t, err)
+		assert.Equal(t, 0, published)
+
+		code, _ = call("POST", "/statuses", url.Values{
+			"status":       {"Never mind"},
+			"scheduled_at": {later},
+		})
+		assert.Equal(t, 200, code)
+		code, result = call("GET", "/scheduled_statuses", nil)
+		assert.Equal(t, 200, code)
+		list := result.([]any)
+		assert.Equal(t, 2, len(list))
+		cancel := list[0].(map[string]any)["id"].(string)
+		code, _ = call("DELETE", "/scheduled_statuses/"+cancel, nil)
+		assert.Equal(t, 200, code)
+		code, _ = call("GET", "/scheduled_statuses/"+cancel, nil)
+		assert.Equal(t, 404, code)
+	})
+
+	t.Run("Purge", func(t *testing.T) {
+		var media model.TootMedia
+		err := ts.DB().Get(&media, "select * from toot_medias where Id = 102")
+		assert.NoError(t, err)
+		path := ts.MediaRoot() + strings.TrimPrefix(media.DiskPath("full"), "/media")
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, os.WriteFile(path, []byte("jpeg"), 0644))
+
+		assert.NoError(t, purgeMedia(context.Background(), ts))
+		ids := []int64{}
+		err = ts.DB().Select(&ids, "select Id from toot_medias where Id > 100 order by Id")
+		assert.NoError(t, err)
+		assert.Equal(t, []int64{101, 103}, ids)
+		_, err = os.Stat(path)
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("Publish", func(t *testing.T) {
+		_, err := ts.DB().Exec("update scheduled_toots set ScheduledAt = datetime('now', '-1 minute') where Id = ?", id)
+		assert.NoError(t, err)
+		assert.NoError(t, publishScheduled(context.Background(), ts, id))
+
+		var toot model.Toot
+		err = ts.DB().Get(&toot, "select * from toots where Text = 'Good morning!'")
+		assert.NoError(t, err)
+		assert.Equal(t, model.VisUnlisted, toot.Visibility)
+		var sid string
+		err = ts.DB().Get(&sid, "select Sid from toot_medias where Id = 101")
+		assert.NoError(t, err)
+		assert.Equal(t, toot.Sid, sid)
+
+		code, result := call("GET", "/scheduled_statuses", nil)
+		assert.Equal(t, 200, code)
+		assert.Equal(t, 0, len(result.([]any)))
+	})
+	t.Run("Media", func(t *testing.T) {
+		// only our own unattached media may be attached
+		_, err := ts.DB().Exec(`insert into toot_medias (Id, AccountId, Salt, CreatedAt) values (104, 2, 'theirs', datetime('now'))`)
+		assert.NoError(t, err)
+		for _, ids := range [][]string{{"101"}, {"104"}, {"103", "104"}} {
+			code, _ := call("POST", "/statuses", url.Values{"status": {"Mine now"}, "media_ids[]": ids})
+			assert.Equal(t, 422, code, ids)
+			code, _ = call("POST", "/statuses", url.Values{"status": {"Mine later"}, "media_ids[]": ids, "scheduled_at": {later}})
+			assert.Equal(t, 422, code, ids)
+		}
+		var attached int
+		assert.NoError(t, ts.DB().Get(&attached, "select count(*) from toot_medias where Id in (103, 104) and (Sid != '' or ScheduledId is not null)"))
+		assert.Equal(t, 0, attached)
+
+		code, _ := call("POST", "/statuses", url.Values{"status": {"Mine"}, "media_ids[]": {"103", "103"}})
+		assert.Equal(t, 200, code)
+	})
+}
@@ -0,0 +1,388 @@
+package clientapi
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/contribsys/sparq"
+	"github.com/contribsys/sparq/activitypub"
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/util"
+	"github.com/pkg/errors"
+)
+
+// GET https://mastodon.example/api/v2/search
+
+var (
+	handleRegexp = regexp.MustCompile(`^@?([[:word:].-]+)@([[:alnum:].-]+(?::[0-9]+)?)$`)
+	tagPrefix    = regexp.MustCompile(`^[[:word:]]+$`)
+)
+
+type searchQuery struct {
+	Q         string
+	Viewer    *model.Account
+	Resolve   bool
+	Following bool
+	AccountId string
+	MaxId     string
+	MinId     string
+	Limit     int
+	Offset    int
+	// Only hashtags which a moderator has reviewed
+	ExcludeUnreviewed bool
+}
+
+func searchHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := r.ParseForm()
+		if err != nil {
+			httpError(w, err, http.StatusBadRequest)
+			return
+		}
+		sq := &searchQuery{
+			Q:                 strings.TrimSpace(r.Form.Get("q")),
+			Following:         isTrue(r.Form.Get("following")),
+			AccountId:         r.Form.Get("account_id"),
+			MaxId:             r.Form.Get("max_id"),
+			MinId:             r.Form.Get("min_id"),
+			Limit:             20,
+			ExcludeUnreviewed: isTrue(r.Form.Get("exclude_unreviewed")),
+		}
+		if sq.Q == "" {
+			httpError(w, errors.New("Missing search query"), http.StatusBadRequest)
+			return
+		}
+		if value := r.Form.Get("limit"); value != "" {
+			sq.Limit, err = strconv.Atoi(value)
+			if err != nil {
+				httpError(w, errors.Wrap(err, "Invalid limit"), http.StatusBadRequest)
+				return
+			}
+		}
+		if sq.Limit <= 0 || sq.Limit > 40 {
+			sq.Limit = 40
+		}
+		if value := r.Form.Get("offset"); value != "" {
+			sq.Offset, err = strconv.Atoi(value)
+			if err != nil {
+				httpError(w, errors.Wrap(err, "Invalid offset"), http.StatusBadRequest)
+				return
+			}
+		}
+		// only accounts may make us fetch remote objects
+		sq.Viewer, _ = currentAccount(s, r)
+		sq.Resolve = sq.Viewer != nil && isTrue(r.Form.Get("resolve"))
+
+		kind := r.Form.Get("type")
+		results := map[string]any{
+			"accounts": []any{},
+			"statuses": []any{},
+			"hashtags": []any{},
+		}
+		if kind == "" || kind == "accounts" {
+			results["accounts"], err = searchAccounts(r.Context(), s, sq)
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+		}
+		if kind == "" || kind == "statuses" {
+			results["statuses"], err = searchStatuses(r.Context(), s, sq)
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+		}
+		if kind == "" || kind == "hashtags" {
+			results["hashtags"], err = searchHashtags(r.Context(), s, sq)
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+		}
+		httpJson(w, results)
+	}
+}
+
+// searchAccounts finds local accounts by nick or name and cached
+// remote actors by username or name. A handle or URL is resolved
+// when asked to.
+func searchAccounts(ctx context.Context, s sparq.Server, sq *searchQuery) ([]map[string]any, error) {
+	iris := []string{}
+	term := strings.TrimPrefix(sq.Q, "@")
+	host := ""
+	if m := handleRegexp.FindStringSubmatch(sq.Q); m != nil {
+		term, host = m[1], m[2]
+	}
+	if sq.Resolve {
+		iri, err := resolveAccount(ctx, s, sq.Q, host)
+		if err != nil {
+			util.Debugf("Unable to resolve %s: %v", sq.Q, err)
+		} else if iri != "" {
+			iris = append(iris, iri)
+		}
+	}
+
+	if term == "" {
+		return []map[string]any{}, nil
+	}
+	if host == "" || host == s.Hostname() {
+		nicks := []string{}
+		err := s.DB().SelectContext(ctx, &nicks, `
+			select a.Nick from accounts a
+			where (a.Nick like ? escape '\' or a.FullName like ? escape '\') and not `+model.PendingAccount+`
+			order by a.Nick = ? desc, a.Nick`,
+			likeEscape(term)+"%", "%"+likeEscape(term)+"%", term)
+		if err != nil {
+			return nil, errors.Wrap(err, "accounts")
+		}
+		for _, nick := range nicks {
+			iris = append(iris, model.LocalIRI(nick))
+		}
+	}
+	if host != s.Hostname() {
+		remote := []string{}
+		err := s.DB().SelectContext(ctx, &remote, `
+			select a.Id from search_fts f
+			join actors a on a.rowid = f.rowid
+			where search_fts match ? and a.PrivateKey is null
+			order by f.PreferredUsername = ? desc, f.rank`, "{Name PreferredUsername} : "+ftsPhrase(term)+"*", term)
+		if err != nil {
+			return nil, errors.Wrap(err, "search_fts")
+		}
+		for _, iri := range remote {
+			if host == "" || hostOf(iri) == host {
+				iris = append(iris, iri)
+			}
+		}
+	}
+
+	results := []map[string]any{}
+	seen := map[string]bool{}
+	skipped := 0
+	for _, iri := range iris {
+		if seen[iri] || len(results) == sq.Limit {
+			continue
+		}
+		seen[iri] = true
+		if sq.Following {
+			if sq.Viewer == nil {
+				break
+			}
+			af, err := activitypub.Following(ctx, s, sq.Viewer.IRI(), iri)
+			if err != nil {
+				return nil, err
+			}
+			if af == nil || af.State != activitypub.FollowAccepted {
+				continue
+			}
+		}
+		if skipped < sq.Offset {
+			skipped++
+			continue
+		}
+		account, err := AccountMap(ctx, s.DB(), iri)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, account)
+	}
+	return results, nil
+}
+
+// resolveAccount looks up the account with the handle or at the
+// URL, fetching it if we haven't seen it.
+func resolveAccount(ctx context.Context, s sparq.Server, q, host string) (string, error) {
+	if host != "" {
+		iri, err := activitypub.ResolveHandle(ctx, s, q)
+		if err != nil {
+			return "", err
+		}
+		if _, ok := model.LocalNick(iri); !ok {
+			_, err = activitypub.FetchActor(ctx, s, iri)
+		}
+		return iri, err
+	}
+	if !isURL(q) || hostOf(q) == s.Hostname() {
+		return "", nil
+	}
+	var iri string
+	err := s.DB().GetContext(ctx, &iri, "select Id from actors where Id = ?", q)
+	if err == nil {
+		return iri, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return "", errors.Wrap(err, "actors")
+	}
+	actor, _, err := activitypub.ResolveURL(ctx, s, q)
+	if err != nil || actor == nil {
+		return "", err
+	}
+	return actor.Id, nil
+}
+
+// searchStatuses finds the toots containing all of the words in
+// the query which the viewer is allowed to see, most recent first.
+// A URL finds the toot with that URI, resolving it when asked to.
+func searchStatuses(ctx context.Context, s sparq.Server, sq *searchQuery) ([]map[string]any, error) {
+	viewer := ""
+	if sq.Viewer != nil {
+		viewer = sq.Viewer.IRI()
+	}
+	if isURL(sq.Q) {
+		toot, err := resolveStatus(ctx, s, sq)
+		if err != nil {
+			util.Debugf("Unable to resolve %s: %v", sq.Q, err)
+			return []map[string]any{}, nil
+		}
+		if toot == nil {
+			return []map[string]any{}, nil
+		}
+		ok, err := canView(ctx, s, toot, viewer)
+		if err != nil || !ok {
+			return []map[string]any{}, err
+		}
+		attrs, err := TootMapFor(s.DB(), toot.Sid, viewer)
+		if err != nil {
+			return nil, err
+		}
+		return []map[string]any{attrs}, nil
+	}
+
+	terms := []string{}
+	for _, word := range strings.Fields(sq.Q) {
+		terms = append(terms, ftsPhrase(word))
+	}
+	query := `select t.Sid from toot_fts f
+		join toots t on t.rowid = f.rowid
+		where toot_fts match ? and t.DeletedAt is null and t.BoostOfId is null`
+	args := []any{strings.Join(terms, " ")}
+	if sq.Viewer == nil {
+		query += " and t.Visibility in (?, ?)"
+		args = append(args, model.VisPublic, model.VisUnlisted)
+	} else {
+		// the same rules as canView
+		query += ` and (t.Visibility in (?, ?) or t.AuthorId = ?
+			or (t.Visibility = ? and exists (
+				select 1 from actor_following af
+				where af.ActorId = ? and af.State = ? and af.TargetActorId in (
+					select r.Id from actors r where t.AuthorId is null and r.MastodonId = t.ActorId
+					union select ? || a.Nick from accounts a where a.Id = t.AuthorId)))
+			or (t.Visibility = ? and exists (
+				select 1 from toot_mentions m where m.Sid = t.Sid and m.ActorId = ?)))`
+		args = append(args, model.VisPublic, model.VisUnlisted, sq.Viewer.Id,
+			model.VisPrivate, viewer, activitypub.FollowAccepted, model.LocalIRI(""),
+			model.VisDirect, viewer)
+		blocked, bargs, _ := model.BlockedFrom("t", viewer).ToSql()
+		query += " and " + blocked
+		args = append(args, bargs...)
+	}
+	if sq.AccountId != "" {
+		query += " and t.ActorId = ?"
+		args = append(args, sq.AccountId)
+	}
+	cursor := "(select CreatedAt, Sid from toots where Sid = ?)"
+	if sq.MaxId != "" {
+		query += " and (t.CreatedAt, t.Sid) < " + cursor
+		args = append(args, sq.MaxId)
+	}
+	if sq.MinId != "" {
+		query += " and (t.CreatedAt, t.Sid) > " + cursor
+		args = append(args, sq.MinId)
+	}
+	query += " order by t.CreatedAt desc, t.Sid desc limit ? offset ?"
+	args = append(args, sq.Limit, sq.Offset)
+
+	sids := []string{}
+	err := s.DB().SelectContext(ctx, &sids, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "toot_fts")
+	}
+	results := []map[string]any{}
+	for _, sid := range sids {
+		attrs, err := TootMapFor(s.DB(), sid, viewer)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, attrs)
+	}
+	return results, nil
+}
+
+// resolveStatus returns the toot at the URL, fetching remote toots
+// we haven't seen when asked to.
+func resolveStatus(ctx context.Context, s sparq.Server, sq *searchQuery) (*model.Toot, error) {
+	var toot model.Toot
+	err := s.DB().GetContext(ctx, &toot, "select * from toots where Uri = ? and DeletedAt is null", sq.Q)
+	if err == nil {
+		return &toot, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.Wrap(err, "toots")
+	}
+	if !sq.Resolve || hostOf(sq.Q) == s.Hostname() {
+		return nil, nil
+	}
+	_, found, err := activitypub.ResolveURL(ctx, s, sq.Q)
+	return found, err
+}
+
+// searchHashtags finds the tags starting with the query, most used
+// first.
+func searchHashtags(ctx context.Context, s sparq.Server, sq *searchQuery) ([]map[string]any, error) {
+	term := strings.ToLower(strings.TrimPrefix(sq.Q, "#"))
+	results := []map[string]any{}
+	if !tagPrefix.MatchString(term) {
+		return results, nil
+	}
+	query := `select Name from (
+			select Tag as Name, count(*) as Uses from toot_tags where Tag like ? escape '\' group by Tag
+			union all select Name, 0 from tags where Name like ? escape '\')`
+	args := []any{likeEscape(term) + "%", likeEscape(term) + "%"}
+	if sq.ExcludeUnreviewed {
+		query += " where Name in (select Name from tags where ReviewedAt is not null)"
+	}
+	query += " group by Name order by Name = ? desc, sum(Uses) desc, Name limit ? offset ?"
+	args = append(args, term, sq.Limit, sq.Offset)
+
+	names := []string{}
+	err := s.DB().SelectContext(ctx, &names, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "toot_tags")
+	}
+	for _, name := range names {
+		attrs, err := TagMap(ctx, s.DB(), name, sq.Viewer)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, attrs)
+	}
+	return results, nil
+}
+
+// ftsPhrase quotes the text as an FTS5 phrase so any operators
+// within it are treated as text.
+func ftsPhrase(text string) string {
+	return `"` + strings.ReplaceAll(text, `"`, `""`) + `"`
+}
+
+func likeEscape(text string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(text)
+}
+
+func isURL(text string) bool {
+	return strings.HasPrefix(text, "https://") || strings.HasPrefix(text, "http://")
+}
+
+func hostOf(iri string) string {
+	u, err := url.Parse(iri)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
@@ -0,0 +1,128 @@
+package clientapi
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/web"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearch(t *testing.T) {
+	ts, stopper := web.NewTestServer(t, "search")
+	defer stopper()
+	root := rootRouter(ts)
+	AddV2Endpoints(ts, root.PathPrefix("/api/v2").Subrouter())
+
+	token, err := registerToken(t, ts)
+	assert.NoError(t, err)
+
+	_, err = ts.DB().Exec(`
+		insert into actors (Id, MastodonId, Type, Email, PublicKey, Properties, FetchedAt) values
+		('https://remote.example/users/bob', 1001, 'Person', '', '', '{"preferredUsername":"bob","name":"Bob Builder"}', current_timestamp),
+		('https://other.example/users/bobby', 1002, 'Person', '', '', '{"preferredUsername":"bobby","name":"Bobby"}', current_timestamp)`)
+	assert.NoError(t, err)
+	_, err = ts.DB().Exec(`insert into accounts (Id, Sid, Nick, Email, FullName) values (2, 'AAAC', 'alice', 'alice@localhost.dev', 'Alice Sparq')`)
+	assert.NoError(t, err)
+	_, err = ts.DB().Exec(`insert into account_profiles (AccountId) values (2)`)
+	assert.NoError(t, err)
+	_, err = ts.DB().Exec(`
+		insert into toots (Sid, Uri, ActorId, AuthorId, Summary, Content, Text, Visibility, CreatedAt) values
+		('ALI1', 'https://localhost.dev/@alice/ALI1', 2, 2, '', '<p>Searching for sparq</p>', 'Searching for sparq', ?, '2030-01-01 00:00:01'),
+		('ALI2', 'https://localhost.dev/@alice/ALI2', 2, 2, '', '<p>Secret sparq</p>', 'Secret sparq', ?, '2030-01-01 00:00:02'),
+		('BOB1', 'https://remote.example/notes/1', 1001, null, '', '<p>Direct sparq</p>', 'Direct sparq', ?, '2030-01-01 00:00:03'),
+		('BOB2', 'https://remote.example/notes/2', 1001, null, '', '<p>Hidden sparq</p>', 'Hidden sparq', ?, '2030-01-01 00:00:04')`,
+		model.VisPublic, model.VisPrivate, model.VisDirect, model.VisDirect)
+	assert.NoError(t, err)
+	_, err = ts.DB().Exec(`insert into toot_mentions (Sid, ActorId) values ('BOB1', ?)`, model.LocalIRI("admin"))
+	assert.NoError(t, err)
+	_, err = ts.DB().Exec(`insert into toot_tags (Sid, Tag) values ('ALI1', 'sparq'), ('ALI2', 'sparql'), ('BOB1', 'sparql')`)
+	assert.NoError(t, err)
+
+	search := func(params url.Values, bearer string) map[string][]string {
+		req := httptest.NewRequest("GET", "http://localhost.dev:9494/api/v2/search?"+params.Encode(), nil)
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		w := httptest.NewRecorder()
+		root.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code, w.Body.String())
+		var result map[string][]map[string]any
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		ids := map[string][]string{}
+		for kind, items := range result {
+			ids[kind] = []string{}
+			for _, item := range items {
+				if kind == "hashtags" {
+					ids[kind] = append(ids[kind], item["name"].(string))
+				} else if kind == "accounts" {
+					ids[kind] = append(ids[kind], item["acct"].(string))
+				} else {
+					ids[kind] = append(ids[kind], item["id"].(string))
+				}
+			}
+		}
+		return ids
+	}
+
+	t.Run("Accounts", func(t *testing.T) {
+		result := search(url.Values{"q": {"bob"}, "type": {"accounts"}}, token)
+		assert.Equal(t, []string{"bob@remote.example", "bobby@other.example"}, result["accounts"])
+		assert.Empty(t, result["statuses"])
+
+		result = search(url.Values{"q": {"@bob@other.example"}, "type": {"accounts"}}, token)
+		assert.Equal(t, []string{"bobby@other.example"}, result["accounts"])
+		result = search(url.Values{"q": {"builder"}, "type": {"accounts"}}, token)
+		assert.Equal(t, []string{"bob@remote.example"}, result["accounts"])
+		result = search(url.Values{"q": {"sparq"}, "type": {"accounts"}}, token)
+		assert.Equal(t, []string{"admin", "alice"}, result["accounts"])
+
+		_, err := ts.DB().Exec(`insert into actor_following (Id, ActorId, TargetActorId, TargetActorAccount, State)
+			values ('f1', ?, 'https://other.example/users/bobby', 'bobby@other.example', 'accepted')`, model.LocalIRI("admin"))
+		assert.NoError(t, err)
+		result = search(url.Values{"q": {"bob"}, "following": {"true"}}, token)
+		assert.Equal(t, []string{"bobby@other.example"}, result["accounts"])
+	})
+
+	t.Run("Statuses", func(t *testing.T) {
+		result := search(url.Values{"q": {"sparq"}, "type": {"statuses"}}, "")
+		assert.Equal(t, []string{"ALI1"}, result["statuses"])
+		result = search(url.Values{"q": {"sparq"}, "type": {"statuses"}}, token)
+		assert.Equal(t, []string{"BOB1", "ALI1"}, result["statuses"])
+
+		_, err := ts.DB().Exec(`insert into actor_following (Id, ActorId, TargetActorId, TargetActorAccount, State)
+			values ('f2', ?, ?, 'alice@localhost.dev', 'accepted')`, model.LocalIRI("admin"), model.LocalIRI("alice"))
+		assert.NoError(t, err)
+		result = search(url.Values{"q": {"SPARQ"}, "type": {"statuses"}}, token)
+		assert.Equal(t, []string{"BOB1", "ALI2", "ALI1"}, result["statuses"])
+		result = search(url.Values{"q": {"sparq"}, "account_id": {"2"}, "max_id": {"ALI2"}}, token)
+		assert.Equal(t, []string{"ALI1"}, result["statuses"])
+		result = search(url.Values{"q": {"searching sparq"}}, token)
+		assert.Equal(t, []string{"ALI1"}, result["statuses"])
+		result = search(url.Values{"q": {`"test" OR toot*`}}, token)
+		assert.Empty(t, result["statuses"])
+
+		result = search(url.Values{"q": {"https://localhost.dev/@alice/ALI1"}}, "")
+		assert.Equal(t, []string{"ALI1"}, result["statuses"])
+		result = search(url.Values{"q": {"https://remote.example/notes/2"}}, token)
+		assert.Empty(t, result["statuses"])
+
+		// edits are reindexed
+		_, err = ts.DB().Exec(`update toots set Text = 'Edited away' where Sid = 'ALI1'`)
+		assert.NoError(t, err)
+		result = search(url.Values{"q": {"searching"}}, token)
+		assert.Empty(t, result["statuses"])
+	})
+
+	t.Run("Hashtags", func(t *testing.T) {
+		result := search(url.Values{"q": {"#sparq"}, "type": {"hashtags"}}, token)
+		assert.Equal(t, []string{"sparq", "sparql"}, result["hashtags"])
+		result = search(url.Values{"q": {"spa"}, "type": {"hashtags"}}, token)
+		assert.Equal(t, []string{"sparql", "sparq"}, result["hashtags"])
+		result = search(url.Values{"q": {"spa"}, "type": {"hashtags"}, "exclude_unreviewed": {"true"}}, token)
+		assert.Empty(t, result["hashtags"])
+	})
+}
@@ -6,7 +6,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"regexp"
 	"strconv"
 	"strings"
 	"sync"
@@ -14,7 +13,10 @@ import (
 	"time"
 
 	"github.com/contribsys/sparq"
+	"github.com/contribsys/sparq/activitypub"
+	"github.com/contribsys/sparq/markup"
 	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/util"
 	"github.com/contribsys/sparq/web"
 	"github.com/gorilla/mux"
 	"github.com/jmoiron/sqlx"
@@ -22,21 +24,26 @@ import (
 )
 
 var (
+	// ErrInvalidMedia means a toot named media which isn't the
+	// author's to attach.
+	ErrInvalidMedia = errors.New("Media not found or already attached")
+
 	dupeDetector = map[string]time.Time{}
 	dupeMu       sync.Mutex
 	submitCount  int32
 )
 
+// Poll expiry limits, in seconds
+const (
+	MinPollExpiry = 5 * 60
+	MaxPollExpiry = 31 * 24 * 60 * 60
+)
+
 type Poll struct {
 	ExpiresIn      int
 	HideTotals     bool
 	MultipleChoice bool
-	O1             string
-	O2             string
-	O3             string
-	O4             string
-	O5             string
-	O6             string
+	Options        []string
 }
 type Toot struct {
 	AuthorId           uint64
@@ -49,6 +56,7 @@ type Toot struct {
 	Visibility         string
 	LanguageCode       string
 	ScheduledAt        string
+	AppId              *uint64
 	*Poll
 }
 
@@ -60,7 +68,13 @@ func getTootHandler(svr sparq.Server) http.HandlerFunc {
 		}
 
 		sid := mux.Vars(r)["id"]
-		attrs, err := TootMap(svr.DB(), sid)
+		viewer := viewerIRI(svr, r)
+		_, err := findViewableToot(r.Context(), svr, sid, viewer)
+		if err != nil {
+			listError(w, err)
+			return
+		}
+		attrs, err := TootMapFor(svr.DB(), sid, viewer)
 		if err != nil {
 			if errors.Is(err, sql.ErrNoRows) {
 				httpError(w, err, http.StatusNotFound)
@@ -69,6 +83,13 @@ func getTootHandler(svr sparq.Server) http.HandlerFunc {
 			httpError(w, err, http.StatusInternalServerError)
 			return
 		}
+		// a status asked for by ID is always shown, even if hidden by a filter
+		filters, err := LoadFilters(r.Context(), svr.DB(), viewer, "thread")
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		filters.Apply(attrs)
 		w.Header().Add("Content-Type", "application/json")
 		enc := json.NewEncoder(w)
 		err = enc.Encode(attrs)
@@ -112,11 +133,29 @@ func PostTootHandler(svr sparq.Server) http.HandlerFunc {
 			LanguageCode: r.Form.Get("language"),
 			ScheduledAt:  r.Form.Get("scheduled_at"),
 		}
+		if app := ctx.ClientApp(); app != nil {
+			toot.AppId = &app.Id
+		}
 		rto := r.Form.Get("in_reply_to_id")
 		if rto != "" {
+			parent, err := findToot(r.Context(), svr, rto)
+			if err != nil {
+				listError(w, err)
+				return
+			}
+			ok, err := canView(r.Context(), svr, parent, viewerIRI(svr, r))
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+			if !ok {
+				listError(w, sql.ErrNoRows)
+				return
+			}
 			toot.InReplyTo = &rto
 		}
 		medias := r.Form["media_ids[]"]
+		toot.MediaIds = medias
 		if toot.Content == "" && len(medias) == 0 {
 			httpError(w, errors.New("Please enter a message"), 400)
 			return
@@ -130,31 +169,19 @@ func PostTootHandler(svr sparq.Server) http.HandlerFunc {
 				httpError(w, err, 401)
 				return
 			}
+			if expy < MinPollExpiry || expy > MaxPollExpiry {
+				httpError(w, errors.New("Polls must last between 5 minutes and a month"), http.StatusUnprocessableEntity)
+				return
+			}
 			p := &Poll{}
 			p.ExpiresIn = expy
 			p.HideTotals = r.Form.Get("poll[hide_totals]") == "true"
 			p.MultipleChoice = r.Form.Get("poll[multiple]") == "true"
-			opts := r.Form["poll[options][]"]
-			if len(opts) < 2 || len(opts) > 6 {
+			p.Options = r.Form["poll[options][]"]
+			if len(p.Options) < 2 || len(p.Options) > 6 {
 				httpError(w, errors.New("Polls must have between 2 and 6 options"), 401)
 				return
 			}
-			// ugh this is horrible, is there a cleaner way to convert from an
-			// array to named fields?
-			p.O1 = opts[0]
-			p.O2 = opts[1]
-			if len(opts) > 2 {
-				p.O3 = opts[2]
-			}
-			if len(opts) > 3 {
-				p.O4 = opts[3]
-			}
-			if len(opts) > 4 {
-				p.O5 = opts[4]
-			}
-			if len(opts) > 5 {
-				p.O6 = opts[5]
-			}
 			toot.Poll = p
 		}
 
@@ -171,7 +198,16 @@ func PostTootHandler(svr sparq.Server) http.HandlerFunc {
 		dupeDetector[ikey] = time.Now()
 		defer dupeCleaner()
 
-		post, err := saveToot(svr, r, toot, medias)
+		if toot.ScheduledAt != "" {
+			scheduleToot(w, r, svr, toot)
+			return
+		}
+
+		post, err := publishToot(r.Context(), svr, toot)
+		if errors.Is(err, ErrInvalidMedia) {
+			httpError(w, err, http.StatusUnprocessableEntity)
+			return
+		}
 		if err != nil {
 			httpError(w, err, http.StatusInternalServerError)
 			return
@@ -221,62 +257,126 @@ func cleanDupeMap() {
 	}
 }
 
-func saveToot(svr sparq.Server, r *http.Request, toot *Toot, medias []string) (*model.Toot, error) {
+// publishToot saves the toot and queues its delivery to followers.
+func publishToot(ctx context.Context, svr sparq.Server, toot *Toot) (*model.Toot, error) {
+	post, err := saveToot(ctx, svr, toot)
+	if err != nil {
+		return nil, err
+	}
+	err = activitypub.DeliverToot(ctx, svr, post.Sid)
+	if err != nil {
+		util.Error("Unable to queue delivery for "+post.Sid, err)
+	}
+	return post, nil
+}
+
+func saveToot(ctx context.Context, svr sparq.Server, toot *Toot) (*model.Toot, error) {
+	medias := toot.MediaIds
+	var nick string
+	err := svr.DB().QueryRowContext(ctx, "select nick from accounts where id = ?", toot.AuthorId).Scan(&nick)
+	if err != nil {
+		return nil, errors.Wrap(err, "author")
+	}
+	lang := toot.LanguageCode
+	if lang == "" {
+		lang = "en"
+	}
+
+	// replies are stored with the parent's URI, a reply to a
+	// toot which has since been deleted is posted on its own
+	var inReplyTo *string
+	var inReplyToAccountId *uint64
+	if toot.InReplyTo != nil {
+		parent, err := findToot(ctx, svr, *toot.InReplyTo)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		if parent != nil {
+			inReplyTo = &parent.Uri
+			inReplyToAccountId = &parent.ActorId
+		}
+	}
+
+	content, mentions := activitypub.FormatToot(ctx, svr, toot.Content)
+
 	sid := model.Snowflakes.NextSID()
 	p := &model.Toot{
 		Sid:        sid,
-		Uri:        fmt.Sprintf("https://%s/@%s/%s", svr.Hostname(), "admin", sid),
+		Uri:        fmt.Sprintf("https://%s/@%s/%s", svr.Hostname(), nick, sid),
 		AccountId:  toot.AuthorId,
+		AuthorId:   &toot.AuthorId,
 		Summary:    toot.Summary,
-		Content:    toot.Content,
+		Content:    content,
+		Text:       toot.Content,
+		Lang:       lang,
 		Visibility: model.ToVis(toot.Visibility),
-		InReplyTo:  toot.InReplyTo,
-		CreatedAt:  time.Now(),
-	}
-	x := web.Ctx(r).ClientApp()
-	if x != nil {
-		p.AppId = &x.Id
+		AppId:      toot.AppId,
+
+		InReplyTo:          inReplyTo,
+		InReplyToAccountId: inReplyToAccountId,
+		CreatedAt:          time.Now(),
 	}
-	tx, err := svr.DB().Begin()
+	tx, err := svr.DB().BeginTxx(ctx, nil)
 	if err != nil {
 		return nil, err
 	}
+	var poll *model.Poll
 	if toot.Poll != nil {
 		po := toot.Poll
-		res, err := tx.ExecContext(r.Context(), `
-			insert into polls (expires_in, multiple, hide, o1, o2, o3, o4, o5, o6) values (?, ?, ?, ?, ?, ?, ?)`,
-			po.ExpiresIn, po.MultipleChoice, po.HideTotals,
-			po.O1, po.O2, po.O3, po.O4, po.O5, po.O6)
+		expires := time.Now().Add(time.Duration(po.ExpiresIn) * time.Second).UTC()
+		res, err := tx.ExecContext(ctx, `
+			insert into polls (Sid, ExpiresAt, Multiple, HideTotals) values (?, ?, ?, ?)`,
+			sid, expires.Format("2006-01-02 15:04:05"), po.MultipleChoice, po.HideTotals)
 		if err != nil {
 			_ = tx.Rollback()
-			return nil, err
+			return nil, errors.Wrap(err, "polls")
 		}
 		x, _ := res.LastInsertId()
 		y := uint64(x)
 		p.PollId = &y
+		for idx, title := range po.Options {
+			_, err = tx.ExecContext(ctx, `
+				insert into poll_options (PollId, Position, Title) values (?, ?, ?)`, y, idx, title)
+			if err != nil {
+				_ = tx.Rollback()
+				return nil, errors.Wrap(err, "poll_options")
+			}
+		}
+		poll = &model.Poll{Id: y, Sid: sid, ExpiresAt: &expires}
 	}
-	_, err = tx.ExecContext(r.Context(), `
-	  insert into toots (Sid, Uri, InReplyTo, AuthorId, ActorId, PollId, Summary, Content, Lang, Visibility, AppId) values
-		(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		p.Sid, p.Uri, p.InReplyTo, p.AuthorId, p.AuthorId, p.PollId, p.Summary, p.Content, p.Lang, p.Visibility, p.AppId)
+	_, err = tx.ExecContext(ctx, `
+	  insert into toots (Sid, Uri, InReplyTo, InReplyToAccountId, AuthorId, ActorId, PollId, Summary, Content, Text, Lang, Visibility, AppId) values
+		(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		p.Sid, p.Uri, p.InReplyTo, p.InReplyToAccountId, p.AuthorId, p.AuthorId, p.PollId, p.Summary, p.Content, p.Text, p.Lang, p.Visibility, p.AppId)
 	if err != nil {
 		_ = tx.Rollback()
 		return nil, err
 	}
 	if len(medias) > 0 {
-		query, args, err := sqlx.In(`update toot_medias set sid = ? where id in (?)`, p.Sid, medias)
+		query, args, err := sqlx.In(`
+			update toot_medias set sid = ?, ScheduledId = null
+			where id in (?) and AccountId = ? and Sid = ''`, p.Sid, medias, p.AuthorId)
 		if err != nil {
 			_ = tx.Rollback()
 			return nil, errors.Wrap(err, "medias")
 		}
 		query = svr.DB().Rebind(query)
-		_, err = tx.ExecContext(r.Context(), query, args...)
+		res, err := tx.ExecContext(ctx, query, args...)
 		if err != nil {
 			_ = tx.Rollback()
 			return nil, errors.Wrap(err, "media exec")
 		}
+		if count, _ := res.RowsAffected(); count != int64(len(uniq(medias))) {
+			_ = tx.Rollback()
+			return nil, ErrInvalidMedia
+		}
 	}
-	err = saveTags(r.Context(), tx, p)
+	err = saveTags(ctx, tx, p)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	err = activitypub.SaveMentions(ctx, tx, p.Sid, mentions)
 	if err != nil {
 		_ = tx.Rollback()
 		return nil, err
@@ -285,11 +385,26 @@ func saveToot(svr sparq.Server, r *http.Request, toot *Toot, medias []string) (*
 	if err != nil {
 		return nil, err
 	}
+	err = activitypub.JoinConversation(ctx, svr, p)
+	if err != nil {
+		return nil, err
+	}
+	err = activitypub.NotifyMentioned(ctx, svr, model.LocalIRI(nick), p.Uri, mentions)
+	if err != nil {
+		return nil, err
+	}
+	if poll != nil {
+		err = activitypub.SchedulePollExpiry(ctx, svr, poll)
+		if err != nil {
+			return nil, err
+		}
+	}
+	activitypub.Published(ctx, svr, p)
 	return p, nil
 }
 
-func saveTags(ctx context.Context, tx *sql.Tx, p *model.Toot) error {
-	tags := extractTags(p.Content)
+func saveTags(ctx context.Context, tx *sqlx.Tx, p *model.Toot) error {
+	tags := extractTags(p.Text)
 	for _, tag := range tags {
 		fmt.Printf("Saving tag for %s: %s\n", p.Sid, tag)
 		_, err := tx.ExecContext(ctx, `insert into toot_tags (sid, tag) values (?, ?)`, p.Sid, strings.ToLower(tag))
@@ -300,37 +415,68 @@ func saveTags(ctx context.Context, tx *sql.Tx, p *model.Toot) error {
 	return nil
 }
 
-var (
-	tagRegexp = regexp.MustCompile(`\s*#([[:alpha:]][[:word:]]{1,20})(?:\s|\z)`)
-	empty     = []string{}
-)
-
 func extractTags(content string) []string {
-	result := tagRegexp.FindAllStringSubmatch(content, -1)
-	if result == nil {
-		return empty
-	}
-	rc := []string{}
-	for _, matches := range result {
-		rc = append(rc, matches[1])
-	}
-	return rc
+	return markup.Tags(content)
 }
 
 func TootMap(db *sqlx.DB, sid string) (map[string]interface{}, error) {
+	return TootMapFor(db, sid, "")
+}
+
+// TootMapFor renders the toot as seen by the given actor IRI, which
+// determines whether it is favourited, reblogged or bookmarked.
+func TootMapFor(db *sqlx.DB, sid string, viewer string) (map[string]interface{}, error) {
 	attrs := map[string]interface{}{}
 	base := `select t.sid as id, t.AuthorId as authorId, t.CreatedAt as created_at, t.Summary as spoiler_text, t.Visibility as viz, t.Lang as language,
-	        t.URI as uri, t.URI as url, 0 as replies_count, 0 as reblogs_count, 0 as favourites_count, false as favourited,
-					false as reblogged, false as muted, false as bookmarked, t.Content as content, null as reblog,
-					null as media_attachments, null as mentions, null as tags, null as emojis, null as card, null as poll,
-					oc.name as app_name, oc.website as app_website
+	        t.URI as uri, t.URI as url, t.LastEditAt as edited_at,
+					p.Sid as in_reply_to_id, cast(p.ActorId as text) as in_reply_to_account_id,
+					(select count(*) from toots x where x.InReplyTo = t.Uri and x.DeletedAt is null) as replies_count,
+					(select count(*) from actor_reblogs x where x.ObjectId = t.Uri) as reblogs_count,
+					(select count(*) from actor_favorites x where x.ObjectId = t.Uri) as favourites_count,
+					exists (select 1 from actor_favorites x where x.ObjectId = t.Uri and x.ActorId = ?) as favourited,
+					exists (select 1 from actor_reblogs x where x.ObjectId = t.Uri and x.ActorId = ?) as reblogged,
+					exists (select 1 from account_conversations x where x.ConversationId = t.ConversationId and x.ActorId = ? and x.Muted) as muted,
+					exists (select 1 from actor_bookmarks x where x.ObjectId = t.Uri and x.ActorId = ?) as bookmarked,
+					t.Content as content, t.BoostOfId as reblog,
+					null as media_attachments, null as mentions, null as tags, null as card, t.PollId as poll,
+					oc.name as app_name, oc.website as app_website, a.Nick as author_nick, r.Id as actor_iri
 					from toots t
 					left outer join oauth_clients oc on t.appid = oc.id
-					where t.sid = ?`
-	err := db.QueryRowx(base, sid).MapScan(attrs)
+					left outer join accounts a on t.AuthorId = a.Id
+					left outer join actors r on t.AuthorId is null and t.ActorId = r.MastodonId
+					left outer join toots p on p.Uri = t.InReplyTo
+					where t.sid = ? and t.DeletedAt is null`
+	err := db.QueryRowx(base, viewer, viewer, viewer, viewer, sid).MapScan(attrs)
 	if err != nil {
 		return nil, errors.Wrap(err, "Error with toot "+sid)
 	}
+	for _, flag := range []string{"favourited", "reblogged", "muted", "bookmarked"} {
+		attrs[flag] = attrs[flag] == int64(1)
+	}
+	if id, ok := attrs["poll"].(int64); ok {
+		attrs["poll"], err = PollMap(context.Background(), db, uint64(id), viewer)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if boostOf, ok := attrs["reblog"].(string); ok {
+		attrs["reblog"], err = TootMapFor(db, boostOf, viewer)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	iri, _ := attrs["actor_iri"].(string)
+	if nick, ok := attrs["author_nick"].(string); ok {
+		iri = model.LocalIRI(nick)
+	}
+	delete(attrs, "author_nick")
+	delete(attrs, "actor_iri")
+	account, err := AccountMap(context.Background(), db, iri)
+	if err != nil {
+		return nil, err
+	}
+	attrs["account"] = account
 
 	attrs["visibility"] = model.FromVis(model.PostVisibility(attrs["viz"].(int64)))
 	delete(attrs, "viz")
@@ -341,12 +487,26 @@ func TootMap(db *sqlx.DB, sid string) (map[string]interface{}, error) {
 	}
 	attrs["media_attachments"] = medias
 
-	tags, err := fetchTootTags(attrs["content"].(string))
+	tags, err := fetchTootTags(db, sid)
 	if err != nil {
 		return nil, err
 	}
 	attrs["tags"] = tags
 
+	mentions, err := fetchTootMentions(db, sid)
+	if err != nil {
+		return nil, err
+	}
+	attrs["mentions"] = mentions
+	attrs["filtered"] = []map[string]any{}
+
+	summary, _ := attrs["spoiler_text"].(string)
+	content, _ := attrs["content"].(string)
+	attrs["emojis"], err = emojiMaps(context.Background(), db, emojiDomain(iri), summary, markup.Text(content))
+	if err != nil {
+		return nil, err
+	}
+
 	if attrs["app_name"] != nil {
 		attrs["application"] = map[string]any{
 			"name":    attrs["app_name"],
@@ -360,14 +520,45 @@ func TootMap(db *sqlx.DB, sid string) (map[string]interface{}, error) {
 	return attrs, nil
 }
 
-func fetchTootTags(content string) ([]map[string]any, error) {
-	tags := extractTags(content)
+func fetchTootTags(dbx *sqlx.DB, sid string) ([]map[string]any, error) {
+	tags := []string{}
+	err := dbx.Select(&tags, "select Tag from toot_tags where Sid = ? order by rowid", sid)
+	if err != nil {
+		return nil, errors.Wrap(err, "toot_tags")
+	}
 	results := []map[string]any{}
 	for _, tag := range tags {
-		tagm := map[string]any{
+		results = append(results, map[string]any{
 			"name": tag,
+			"url":  absoluteUrl("/tags/" + tag),
+		})
+	}
+	return results, nil
+}
+
+// fetchTootMentions lists the accounts mentioned in the toot,
+// skipping remote actors which are no longer cached.
+func fetchTootMentions(dbx *sqlx.DB, sid string) ([]map[string]any, error) {
+	iris := []string{}
+	err := dbx.Select(&iris, "select ActorId from toot_mentions where Sid = ? order by rowid", sid)
+	if err != nil {
+		return nil, errors.Wrap(err, "toot_mentions")
+	}
+	results := []map[string]any{}
+	for _, iri := range iris {
+		account, err := AccountMap(context.Background(), dbx, iri)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return nil, err
 		}
-		results = append(results, tagm)
+		results = append(results, map[string]any{
+			"id":       account["id"],
+			"username": account["username"],
+			"acct":     account["acct"],
+			"url":      account["url"],
+		})
 	}
 	return results, nil
 }
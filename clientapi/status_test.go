@@ -102,3 +102,129 @@ func TestStatus(t *testing.T) {
 		assert.Equal(t, sid, testy["id"])
 	})
 }
+
+func TestEditStatus(t *testing.T) {
+	ts, stopper := web.NewTestServer(t, "editstatus")
+	defer stopper()
+	jobs := ts.Jobs().(*web.TestJobs)
+	token, err := registerToken(t, ts)
+	assert.NoError(t, err)
+	root := rootRouter(ts)
+	AddPublicEndpoints(ts, root.PathPrefix("/api/v1").Subrouter())
+
+	call := func(method, path string, values url.Values) (int, map[string]any) {
+		req := httptest.NewRequest(method, "http://localhost.dev:9494/api/v1"+path, strings.NewReader(values.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Idempotency-Key", "edit-"+method)
+		w := httptest.NewRecorder()
+		root.ServeHTTP(w, req)
+		result := map[string]any{}
+		if w.Code == 200 && strings.HasPrefix(w.Body.String(), "{") {
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		}
+		return w.Code, result
+	}
+
+	code, toot := call("POST", "/statuses", url.Values{"status": {"Frist post #typo"}})
+	assert.Equal(t, 200, code)
+	sid := toot["id"].(string)
+	assert.Nil(t, toot["edited_at"])
+
+	t.Run("Edit", func(t *testing.T) {
+		code, toot := call("PUT", "/statuses/"+sid, url.Values{"status": {"First post #fixed"}, "spoiler_text": {"oops"}})
+		assert.Equal(t, 200, code)
+		assert.Equal(t, `<p>First post <a href="https://localhost.dev/tags/fixed" class="mention hashtag" rel="tag">#<span>fixed</span></a></p>`, toot["content"])
+		assert.Equal(t, "oops", toot["spoiler_text"])
+		assert.NotNil(t, toot["edited_at"])
+		assert.Equal(t, "fixed", toot["tags"].([]any)[0].(map[string]any)["name"])
+		assert.Equal(t, 1, len(jobs.Find("DeliverUpdate")))
+
+		code, _ = call("PUT", "/statuses/"+sid, url.Values{"status": {""}})
+		assert.Equal(t, 422, code)
+		_, err := ts.DB().Exec(`
+			insert into toots (Sid, Uri, ActorId, Summary, Content) values
+			('BOB1', 'https://remote.example/notes/1', 1001, '', 'From bob')`)
+		assert.NoError(t, err)
+		code, _ = call("PUT", "/statuses/BOB1", url.Values{"status": {"Not mine"}})
+		assert.Equal(t, 404, code)
+
+		req := httptest.NewRequest("GET", "http://localhost.dev:9494/api/v1/statuses/"+sid+"/history", nil)
+		w := httptest.NewRecorder()
+		root.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+		history := []map[string]any{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &history))
+		assert.Equal(t, 2, len(history))
+		assert.Contains(t, history[0]["content"], "Frist post")
+		assert.Contains(t, history[1]["content"], "First post")
+
+		// the history of a toot we can't see isn't found either
+		_, err = ts.DB().Exec(`
+			insert into toots (Sid, Uri, ActorId, Summary, Content, Visibility) values
+			('BOB2', 'https://remote.example/notes/2', 1001, '', 'Just for carol', 3)`)
+		assert.NoError(t, err)
+		code, _ = call("GET", "/statuses/BOB2/history", nil)
+		assert.Equal(t, 404, code)
+
+		code, source := call("GET", "/statuses/"+sid+"/source", nil)
+		assert.Equal(t, 200, code)
+		assert.Equal(t, "First post #fixed", source["text"])
+		assert.Equal(t, "oops", source["spoiler_text"])
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		code, toot := call("DELETE", "/statuses/"+sid, nil)
+		assert.Equal(t, 200, code)
+		assert.Equal(t, "First post #fixed", toot["text"])
+		assert.Equal(t, 1, len(jobs.Find("DeliverDelete")))
+
+		code, _ = call("GET", "/statuses/"+sid, nil)
+		assert.Equal(t, 404, code)
+		code, _ = call("DELETE", "/statuses/"+sid, nil)
+		assert.Equal(t, 404, code)
+
+		var deleted int
+		assert.NoError(t, ts.DB().Get(&deleted, "select count(*) from toots where Sid = ? and DeletedAt is not null", sid))
+		assert.Equal(t, 1, deleted)
+	})
+}
+
+func TestMentions(t *testing.T) {
+	ts, stopper := web.NewTestServer(t, "mentions")
+	defer stopper()
+	token, err := registerToken(t, ts)
+	assert.NoError(t, err)
+	root := rootRouter(ts)
+	AddPublicEndpoints(ts, root.PathPrefix("/api/v1").Subrouter())
+
+	_, err = ts.DB().Exec(`insert into accounts (Id, Sid, Nick, Email, FullName) values (2, 'AAAC', 'alice', 'alice@localhost.dev', 'Alice')`)
+	assert.NoError(t, err)
+	_, err = ts.DB().Exec(`insert into account_profiles (AccountId) values (2)`)
+	assert.NoError(t, err)
+
+	form := url.Values{"status": {"Hey @alice and @nobody, <b>look</b> at #this"}}
+	req := httptest.NewRequest("POST", "http://localhost.dev:9494/api/v1/statuses", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Idempotency-Key", "mentions-1")
+	w := httptest.NewRecorder()
+	root.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	toot := map[string]any{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &toot))
+	assert.Equal(t, `<p>Hey <span class="h-card"><a href="https://localhost.dev/@alice" class="u-url mention">@<span>alice</span></a></span> `+
+		`and @nobody, &lt;b&gt;look&lt;/b&gt; at <a href="https://localhost.dev/tags/this" class="mention hashtag" rel="tag">#<span>this</span></a></p>`,
+		toot["content"])
+	mentions := toot["mentions"].([]any)
+	assert.Equal(t, 1, len(mentions))
+	assert.Equal(t, "alice", mentions[0].(map[string]any)["acct"])
+	assert.Equal(t, "https://localhost.dev/tags/this", toot["tags"].([]any)[0].(map[string]any)["url"])
+
+	var count int
+	err = ts.DB().Get(&count, "select count(*) from actor_notifications where Type = 'mention' and ActorId = ? and ObjectId = ?",
+		"https://localhost.dev/users/alice", toot["uri"])
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
@@ -2,17 +2,22 @@ package clientapi
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"io"
 	"math/rand"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/contribsys/sparq"
+	"github.com/contribsys/sparq/model"
 	"github.com/contribsys/sparq/util"
+	"github.com/contribsys/sparq/web"
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
 )
@@ -60,12 +65,26 @@ func (s *Streamer) Run(ctx context.Context) {
 }
 
 func (s *Streamer) Fanout(key any, event StreamEvent) {
-	chans := s.streamListeners[key]
-	for _, chn := range chans {
-		chn <- event
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, chn := range s.streamListeners[key] {
+		// don't let a slow client block the caller
+		select {
+		case chn <- event:
+		default:
+			util.Debugf("Dropping %s event for slow stream", event.Name)
+		}
 	}
 }
 
+func (s *Streamer) isStreaming(key any) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.streamListeners[key]) > 0
+}
+
+// Handler streams events as Server-Sent Events. The stream is named
+// by the path, e.g. /streaming/user or /streaming/hashtag/local?tag=sparq.
 func (s *Streamer) Handler(sp sparq.Server) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != "GET" {
@@ -78,13 +97,20 @@ func (s *Streamer) Handler(sp sparq.Server) http.HandlerFunc {
 			return
 		}
 
-		key := mux.Vars(r)["key"]
-		if key == "user" {
-			store, err := sessionStore.Get(r, "sparq-session")
-			if err != nil {
-				httpError(w, err, 500)
-			}
-			key = store.Values["uid"].(string)
+		acct, err := streamingAccount(sp, r)
+		if err != nil && !errors.Is(err, ErrUnauthorized) {
+			httpError(w, err, http.StatusUnauthorized)
+			return
+		}
+		name := mux.Vars(r)["key"]
+		if strings.HasSuffix(r.URL.Path, "/local") {
+			name += ":local"
+		}
+		query := r.URL.Query()
+		key, err := streamKey(r.Context(), sp, acct, name, query.Get("tag"), query.Get("list"))
+		if err != nil {
+			httpError(w, err, streamStatus(err))
+			return
 		}
 
 		chanl, dereg := s.registerStreamerFor(key)
@@ -124,6 +150,25 @@ func (s *Streamer) Handler(sp sparq.Server) http.HandlerFunc {
 	}
 }
 
+// streamingAccount returns the account of the access token or, for
+// the web UI, the session. It returns ErrUnauthorized for anonymous
+// viewers, who may only read public streams.
+func streamingAccount(s sparq.Server, r *http.Request) (*model.Account, error) {
+	acct, err := currentAccount(s, r)
+	if !errors.Is(err, ErrUnauthorized) {
+		return acct, err
+	}
+	store, err := sessionStore.Get(r, "sparq-session")
+	if err != nil {
+		return nil, ErrUnauthorized
+	}
+	uid, ok := store.Values["uid"].(string)
+	if !ok {
+		return nil, ErrUnauthorized
+	}
+	return accountFor(r.Context(), s, uid)
+}
+
 func (s *Streamer) ping(ctx context.Context) {
 	ping := StreamEvent{Name: ":ping"}
 
@@ -135,7 +180,12 @@ func (s *Streamer) ping(ctx context.Context) {
 			s.mu.Lock()
 			for _, mp := range s.streamListeners {
 				for _, chn := range mp {
-					chn <- ping
+					// a full stream has no need of a ping and the
+					// client may be gone, so don't wait on it
+					select {
+					case chn <- ping:
+					default:
+					}
 				}
 			}
 			s.mu.Unlock()
@@ -169,3 +219,170 @@ func (s *Streamer) registerStreamerFor(key any) (<-chan StreamEvent, func()) {
 		delete(s.streamListeners[key], code)
 	}
 }
+
+var (
+	ErrUnknownStream = errors.New("Unknown stream")
+	ErrMissingParam  = errors.New("Missing stream parameter")
+)
+
+// timelineStream is the key of a stream of new toots in a timeline
+// as the viewer, an account ID or web.Anonymous, sees it. User
+// streams are keyed by account ID and carry notifications and the
+// home timeline.
+type timelineStream struct {
+	Name   string
+	Param  string
+	Viewer string
+}
+
+// streamKey returns the key of the stream with the given Mastodon
+// name for the account, which is nil for anonymous viewers.
+func streamKey(ctx context.Context, svr sparq.Server, acct *model.Account, name, tag, list string) (any, error) {
+	viewer := web.Anonymous
+	if acct != nil {
+		viewer = strconv.FormatInt(acct.Id, 10)
+	}
+	switch name {
+	case "user", "direct", "list":
+		if acct == nil {
+			return nil, ErrUnauthorized
+		}
+	}
+	switch name {
+	case "user":
+		return viewer, nil
+	case "direct":
+		return directStream(viewer), nil
+	case "public", "public:local":
+		return timelineStream{Name: name, Viewer: viewer}, nil
+	case "hashtag", "hashtag:local":
+		tag = strings.ToLower(strings.TrimPrefix(tag, "#"))
+		if tag == "" {
+			return nil, ErrMissingParam
+		}
+		return timelineStream{Name: name, Param: tag, Viewer: viewer}, nil
+	case "list":
+		if list == "" {
+			return nil, ErrMissingParam
+		}
+		l, err := ownedList(ctx, svr, acct, list)
+		if err != nil {
+			return nil, err
+		}
+		return timelineStream{Name: name, Param: strconv.FormatUint(l.Id, 10), Viewer: viewer}, nil
+	}
+	return nil, errors.Wrap(ErrUnknownStream, name)
+}
+
+// streamStatus is the HTTP status for an error from streamKey.
+func streamStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrUnauthorized):
+		return http.StatusUnauthorized
+	case errors.Is(err, sql.ErrNoRows):
+		return http.StatusNotFound
+	case errors.Is(err, ErrUnknownStream), errors.Is(err, ErrMissingParam):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// update streams a new toot to each stream whose timeline it
+// belongs in, rendered for the stream's viewer.
+func (s *Streamer) update(ctx context.Context, svr sparq.Server, toot *model.Toot) {
+	if toot.Visibility == model.VisDirect {
+		return
+	}
+	s.mu.Lock()
+	keys := []any{}
+	for key, listeners := range s.streamListeners {
+		if len(listeners) > 0 {
+			keys = append(keys, key)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, key := range keys {
+		tq, fctx, err := timelineFor(ctx, svr, key)
+		if err != nil {
+			util.Warnf("Unable to stream toot %s: %v", toot.Sid, err)
+			continue
+		}
+		if tq == nil {
+			continue
+		}
+		tq.Sid = toot.Sid
+		result, err := tq.Execute()
+		if err != nil {
+			util.Warnf("Unable to stream toot %s: %v", toot.Sid, err)
+			continue
+		}
+		if result.IsEmpty() {
+			continue
+		}
+		attrs, err := TootMapFor(svr.DB(), toot.Sid, tq.Viewer)
+		if err != nil {
+			util.Warnf("Unable to stream toot %s: %v", toot.Sid, err)
+			continue
+		}
+		filters, err := LoadFilters(ctx, svr.DB(), tq.Viewer, fctx)
+		if err != nil {
+			util.Warnf("Unable to stream toot %s: %v", toot.Sid, err)
+			continue
+		}
+		if filters.Apply(attrs) {
+			continue
+		}
+		s.Fanout(key, NewJsonEvent("update", attrs))
+	}
+}
+
+// timelineFor returns the query for the timeline streamed to the
+// key, as the REST API would run it, and its filter context. Keys
+// of streams without toots return nil.
+func timelineFor(ctx context.Context, svr sparq.Server, key any) (*model.TimelineQuery, string, error) {
+	tq := model.TQ(svr.DB())
+	switch key := key.(type) {
+	case string:
+		if _, err := strconv.ParseInt(key, 10, 64); err != nil {
+			// direct streams carry conversations instead
+			return nil, "", nil
+		}
+		acct, err := accountFor(ctx, svr, key)
+		if err != nil {
+			return nil, "", err
+		}
+		tq.HomeFor = acct
+		tq.Viewer = acct.IRI()
+		tq.Visibilities = []model.PostVisibility{model.VisPublic, model.VisUnlisted, model.VisPrivate}
+		return tq, "home", nil
+	case timelineStream:
+		if key.Viewer != web.Anonymous {
+			acct, err := accountFor(ctx, svr, key.Viewer)
+			if err != nil {
+				return nil, "", err
+			}
+			tq.Viewer = acct.IRI()
+		}
+		tq.Local = strings.HasSuffix(key.Name, ":local")
+		switch strings.TrimSuffix(key.Name, ":local") {
+		case "public":
+			tq.Visibility = model.VisPublic
+			tq.ExcludeBoosts = true
+			return tq, "public", nil
+		case "hashtag":
+			tq.Visibility = model.VisPublic
+			tq.Tags = []string{key.Param}
+			return tq, "public", nil
+		case "list":
+			id, err := strconv.ParseUint(key.Param, 10, 64)
+			if err != nil {
+				return nil, "", err
+			}
+			tq.ListId = id
+			tq.Visibilities = []model.PostVisibility{model.VisPublic, model.VisUnlisted, model.VisPrivate}
+			return tq, "home", nil
+		}
+	}
+	return nil, "", nil
+}
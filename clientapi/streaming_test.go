@@ -2,9 +2,18 @@ package clientapi
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/contribsys/sparq/activitypub"
+	"github.com/contribsys/sparq/model"
 	"github.com/contribsys/sparq/web"
+	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -19,11 +28,223 @@ func TestStreaming(t *testing.T) {
 
 	handler := s.Handler(ts)
 	assert.NotNil(t, handler)
-	// TODO How to test?
-	// r := httptest.NewRequest("GET", "http://localhost:9494/api/v1/streaming/user", nil)
-	// w := httptest.NewRecorder()
-	// handler(w, r)
-	// assert.Equal(t, 401, w.Code)
+
+	root := rootRouter(ts)
+	AddPublicEndpoints(ts, root.PathPrefix("/api/v1").Subrouter())
+	for _, path := range []string{"/user", "/direct", "/list?list=1", "/bogus", "/hashtag"} {
+		req := httptest.NewRequest("GET", "http://localhost.dev:9494/api/v1/streaming"+path, nil)
+		w := httptest.NewRecorder()
+		root.ServeHTTP(w, req)
+		if path == "/bogus" || path == "/hashtag" {
+			assert.Equal(t, 400, w.Code, path)
+		} else {
+			assert.Equal(t, 401, w.Code, path)
+		}
+	}
 
 	cancel()
 }
+
+func TestSocketStreaming(t *testing.T) {
+	ts, stopper := web.NewTestServer(t, "sockets")
+	defer stopper()
+	root := rootRouter(ts)
+	AddPublicEndpoints(ts, root.PathPrefix("/api/v1").Subrouter())
+	bgctx := context.Background()
+
+	// a streamer of our own so we can tell when a subscription
+	// has been registered
+	st := NewStreamer(ts)
+	defer activitypub.OnNotify(st.notify)()
+	defer activitypub.OnToot(st.update)()
+	sockets := rootRouter(ts)
+	sockets.HandleFunc("/api/v1/streaming", st.SocketHandler(ts))
+	srv := httptest.NewServer(sockets)
+	defer srv.Close()
+
+	admin, err := registerToken(t, ts)
+	assert.NoError(t, err)
+	app, err := createOauthClient(ts, map[string]string{
+		"client_name": "Ivory", "redirect_uris": "urn:ietf:wg:oauth:2.0:oob", "scopes": "read write", "website": "https://tapbots.com"})
+	assert.NoError(t, err)
+	_, err = ts.DB().Exec(`insert into accounts (Id, Sid, Nick, Email, FullName) values (2, 'AAA2', 'alice', 'alice@localhost.dev', 'Alice')`)
+	assert.NoError(t, err)
+	_, err = ts.DB().Exec(`insert into account_profiles (AccountId) values (2)`)
+	assert.NoError(t, err)
+	store := &web.SqliteOauthStore{DB: ts.DB()}
+	assert.NoError(t, store.Create(bgctx, &model.OauthToken{
+		ClientId:        app["client_id"].(string),
+		AccountId:       2,
+		Scope:           "read write",
+		Access:          "ALICE",
+		AccessCreatedAt: time.Now(),
+		AccessExpiresIn: time.Hour,
+	}))
+	_, err = ts.DB().Exec(`insert into actor_following (Id, ActorId, TargetActorId, TargetActorAccount, State) values
+		('https://localhost.dev/follows/1', ?, ?, 'alice@localhost.dev', 'accepted')`, model.LocalIRI("admin"), model.LocalIRI("alice"))
+	assert.NoError(t, err)
+	_, err = ts.DB().Exec(`insert into lists (Id, AccountId, Title) values (7, 1, 'Friends')`)
+	assert.NoError(t, err)
+	_, err = ts.DB().Exec(`insert into list_accounts (ListId, ActorId) values (7, ?)`, model.LocalIRI("alice"))
+	assert.NoError(t, err)
+
+	count := 0
+	post := func(text string) {
+		count++
+		values := url.Values{"status": {text}}
+		req := httptest.NewRequest("POST", "http://localhost.dev:9494/api/v1/statuses", strings.NewReader(values.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Authorization", "Bearer ALICE")
+		req.Header.Set("Idempotency-Key", fmt.Sprintf("sockets-%d", count))
+		w := httptest.NewRecorder()
+		root.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+	}
+	// a socket and the messages it receives, read in the background
+	// as a read timeout would break the connection
+	type client struct {
+		*websocket.Conn
+		messages chan map[string]any
+	}
+	dial := func(query string) *client {
+		conn, resp, err := websocket.DefaultDialer.Dial(strings.Replace(srv.URL, "http", "ws", 1)+"/api/v1/streaming"+query, nil)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		c := &client{conn, make(chan map[string]any, 100)}
+		go func() {
+			for {
+				msg := map[string]any{}
+				if conn.ReadJSON(&msg) != nil {
+					close(c.messages)
+					return
+				}
+				c.messages <- msg
+			}
+		}()
+		return c
+	}
+	send := func(c *client, msg map[string]string) {
+		assert.NoError(t, c.WriteJSON(msg))
+	}
+	// receive collects messages until the socket is quiet, keyed
+	// by stream and event or by error
+	receive := func(c *client, wait time.Duration) map[string][]map[string]any {
+		got := map[string][]map[string]any{}
+		for {
+			var msg map[string]any
+			select {
+			case msg = <-c.messages:
+			case <-time.After(wait):
+				return got
+			}
+			if msg == nil {
+				return got
+			}
+			key := fmt.Sprint(msg["error"])
+			if stream, ok := msg["stream"].([]any); ok {
+				parts := []string{}
+				for _, part := range stream {
+					parts = append(parts, part.(string))
+				}
+				key = strings.Join(parts, ":") + " " + msg["event"].(string)
+			}
+			got[key] = append(got[key], msg)
+		}
+	}
+	streaming := func(key any) {
+		assert.Eventually(t, func() bool { return st.isStreaming(key) }, time.Second, 5*time.Millisecond)
+	}
+
+	conn := dial("?stream=user&access_token=" + admin)
+	defer conn.Close()
+
+	t.Run("Subscribe", func(t *testing.T) {
+		send(conn, map[string]string{"type": "subscribe", "stream": "public"})
+		send(conn, map[string]string{"type": "subscribe", "stream": "hashtag", "tag": "#Sparq"})
+		send(conn, map[string]string{"type": "subscribe", "stream": "list", "list": "7"})
+		streaming("1")
+		streaming(timelineStream{Name: "public", Viewer: "1"})
+		streaming(timelineStream{Name: "hashtag", Param: "sparq", Viewer: "1"})
+		streaming(timelineStream{Name: "list", Param: "7", Viewer: "1"})
+
+		post("Hello from the #sparq socket")
+		got := receive(conn, 500*time.Millisecond)
+		for _, key := range []string{"user update", "public update", "hashtag:sparq update", "list:7 update"} {
+			assert.Equal(t, 1, len(got[key]), key)
+		}
+		assert.Equal(t, 4, len(got))
+		toot := map[string]any{}
+		assert.NoError(t, json.Unmarshal([]byte(got["user update"][0]["payload"].(string)), &toot))
+		assert.Contains(t, toot["content"], "Hello from the")
+		assert.Equal(t, "alice", toot["account"].(map[string]any)["acct"])
+	})
+
+	t.Run("Notifications", func(t *testing.T) {
+		post("@admin are you there?")
+		got := receive(conn, 500*time.Millisecond)
+		assert.Equal(t, 1, len(got["user notification"]))
+		assert.Equal(t, 1, len(got["user update"]))
+	})
+
+	t.Run("Unsubscribe", func(t *testing.T) {
+		send(conn, map[string]string{"type": "unsubscribe", "stream": "hashtag", "tag": "sparq"})
+		send(conn, map[string]string{"type": "unsubscribe", "stream": "public"})
+		assert.Eventually(t, func() bool {
+			return !st.isStreaming(timelineStream{Name: "public", Viewer: "1"})
+		}, time.Second, 5*time.Millisecond)
+
+		post("Still #sparq")
+		got := receive(conn, 300*time.Millisecond)
+		assert.Equal(t, 1, len(got["user update"]))
+		assert.Empty(t, got["hashtag:sparq update"])
+		assert.Empty(t, got["public update"])
+	})
+
+	t.Run("Anonymous", func(t *testing.T) {
+		anon := dial("?stream=public:local")
+		defer anon.Close()
+		send(anon, map[string]string{"type": "subscribe", "stream": "user"})
+		send(anon, map[string]string{"type": "subscribe", "stream": "list", "list": "7"})
+		send(anon, map[string]string{"type": "subscribe", "stream": "trending"})
+		send(anon, map[string]string{"type": "dance"})
+		streaming(timelineStream{Name: "public:local", Viewer: web.Anonymous})
+
+		post("Hello world")
+		got := receive(anon, 300*time.Millisecond)
+		assert.Equal(t, 1, len(got["public:local update"]))
+		assert.Equal(t, 2, len(got["Unauthorized"]))
+		assert.EqualValues(t, 401, got["Unauthorized"][0]["status"])
+		assert.Equal(t, 1, len(got["trending: Unknown stream"]))
+		assert.Equal(t, 1, len(got["Unknown message type: dance"]))
+
+		// the admin's socket saw it too
+		got = receive(conn, 100*time.Millisecond)
+		assert.Equal(t, 1, len(got["user update"]))
+		assert.Equal(t, 1, len(got["list:7 update"]))
+	})
+
+	t.Run("Private", func(t *testing.T) {
+		// followers-only toots stay out of public streams
+		send(conn, map[string]string{"type": "subscribe", "stream": "public"})
+		streaming(timelineStream{Name: "public", Viewer: "1"})
+		count++
+		values := url.Values{"status": {"Just for followers"}, "visibility": {"private"}}
+		req := httptest.NewRequest("POST", "http://localhost.dev:9494/api/v1/statuses", strings.NewReader(values.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Authorization", "Bearer ALICE")
+		req.Header.Set("Idempotency-Key", fmt.Sprintf("sockets-%d", count))
+		w := httptest.NewRecorder()
+		root.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+
+		got := receive(conn, 300*time.Millisecond)
+		assert.Equal(t, 1, len(got["user update"]))
+		assert.Equal(t, 1, len(got["list:7 update"]))
+		assert.Empty(t, got["public update"])
+	})
+
+	// a bad token is refused before upgrading
+	_, resp, err := websocket.DefaultDialer.Dial(strings.Replace(srv.URL, "http", "ws", 1)+"/api/v1/streaming?access_token=nope", nil)
+	assert.Error(t, err)
+	assert.Equal(t, 401, resp.StatusCode)
+}
@@ -0,0 +1,215 @@
+package clientapi
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/contribsys/sparq"
+	"github.com/contribsys/sparq/markup"
+	"github.com/contribsys/sparq/model"
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// GET https://mastodon.example/api/v1/tags/:name
+// POST https://mastodon.example/api/v1/tags/:name/follow
+// POST https://mastodon.example/api/v1/tags/:name/unfollow
+// GET https://mastodon.example/api/v1/followed_tags
+
+var (
+	// TagHistoryDays is how many days of usage are shown for a tag.
+	TagHistoryDays = 7
+)
+
+func tagHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name, err := tagName(r)
+		if err != nil {
+			httpError(w, err, http.StatusNotFound)
+			return
+		}
+		acct, _ := currentAccount(s, r)
+		attrs, err := TagMap(r.Context(), s.DB(), name, acct)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		httpJson(w, attrs)
+	}
+}
+
+func followTagHandler(s sparq.Server) http.HandlerFunc {
+	return tagFollowHandler(s, func(ctx context.Context, acct *model.Account, tag *model.Tag) error {
+		_, err := s.DB().ExecContext(ctx, `
+			insert into account_tags (AccountId, TagId) values (?, ?) on conflict do nothing`, acct.Id, tag.Id)
+		return errors.Wrap(err, "account_tags")
+	})
+}
+
+func unfollowTagHandler(s sparq.Server) http.HandlerFunc {
+	return tagFollowHandler(s, func(ctx context.Context, acct *model.Account, tag *model.Tag) error {
+		_, err := s.DB().ExecContext(ctx, `
+			delete from account_tags where AccountId = ? and TagId = ?`, acct.Id, tag.Id)
+		return errors.Wrap(err, "account_tags")
+	})
+}
+
+// tagFollowHandler changes whether the current account follows the
+// tag in the URL, responding with the updated Tag.
+func tagFollowHandler(s sparq.Server, fn func(context.Context, *model.Account, *model.Tag) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			httpError(w, errors.New("POST only"), http.StatusBadRequest)
+			return
+		}
+		acct, err := currentAccount(s, r)
+		if err != nil {
+			httpError(w, err, http.StatusUnauthorized)
+			return
+		}
+		name, err := tagName(r)
+		if err != nil {
+			httpError(w, err, http.StatusNotFound)
+			return
+		}
+		tag, err := findOrCreateTag(r.Context(), s.DB(), name)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		err = fn(r.Context(), acct, tag)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		attrs, err := TagMap(r.Context(), s.DB(), name, acct)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		httpJson(w, attrs)
+	}
+}
+
+// followedTagsHandler lists the tags followed by the current
+// account, most recently followed first.
+func followedTagsHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		acct, err := currentAccount(s, r)
+		if err != nil {
+			httpError(w, err, http.StatusUnauthorized)
+			return
+		}
+		names := []string{}
+		err = s.DB().SelectContext(r.Context(), &names, `
+			select g.Name from account_tags at
+			join tags g on g.Id = at.TagId
+			where at.AccountId = ?
+			order by at.CreatedAt desc, at.rowid desc`, acct.Id)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		results := []map[string]any{}
+		for _, name := range names {
+			attrs, err := TagMap(r.Context(), s.DB(), name, acct)
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+			results = append(results, attrs)
+		}
+		httpJson(w, results)
+	}
+}
+
+// tagName returns the lowercased tag name in the URL.
+func tagName(r *http.Request) (string, error) {
+	name := mux.Vars(r)["name"]
+	if !markup.IsTag(name) {
+		return "", errors.New("Invalid tag " + name)
+	}
+	return strings.ToLower(name), nil
+}
+
+func findOrCreateTag(ctx context.Context, dbx *sqlx.DB, name string) (*model.Tag, error) {
+	_, err := dbx.ExecContext(ctx, "insert into tags (Name) values (?) on conflict do nothing", name)
+	if err != nil {
+		return nil, errors.Wrap(err, "tags")
+	}
+	var tag model.Tag
+	err = dbx.GetContext(ctx, &tag, "select * from tags where Name = ?", name)
+	if err != nil {
+		return nil, errors.Wrap(err, "tags")
+	}
+	return &tag, nil
+}
+
+// TagMap renders the tag with its recent usage. Following is only
+// included when there is a current account.
+func TagMap(ctx context.Context, dbx *sqlx.DB, name string, viewer *model.Account) (map[string]any, error) {
+	history, err := tagHistory(ctx, dbx, name)
+	if err != nil {
+		return nil, err
+	}
+	attrs := map[string]any{
+		"name":    name,
+		"url":     absoluteUrl("/tags/" + name),
+		"history": history,
+	}
+	if viewer != nil {
+		var count int
+		err = dbx.GetContext(ctx, &count, `
+			select count(*) from account_tags at
+			join tags g on g.Id = at.TagId
+			where at.AccountId = ? and g.Name = ?`, viewer.Id, name)
+		if err != nil {
+			return nil, errors.Wrap(err, "account_tags")
+		}
+		attrs["following"] = count > 0
+	}
+	return attrs, nil
+}
+
+// tagHistory counts the daily uses of the tag in public and
+// unlisted toots, and the accounts using it, most recent day first.
+func tagHistory(ctx context.Context, dbx *sqlx.DB, name string) ([]map[string]any, error) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	first := today.AddDate(0, 0, 1-TagHistoryDays)
+	days := []struct {
+		Day      string
+		Uses     int64
+		Accounts int64
+	}{}
+	err := dbx.SelectContext(ctx, &days, `
+		select date(t.CreatedAt) as Day, count(*) as Uses, count(distinct t.ActorId) as Accounts
+		from toot_tags tt
+		join toots t on t.Sid = tt.Sid
+		where tt.Tag = ? and t.DeletedAt is null and t.Visibility in (?, ?) and t.CreatedAt >= ?
+		group by date(t.CreatedAt)`,
+		name, model.VisPublic, model.VisUnlisted, first.Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return nil, errors.Wrap(err, "tag history")
+	}
+	history := []map[string]any{}
+	for idx := 0; idx < TagHistoryDays; idx++ {
+		day := today.AddDate(0, 0, -idx)
+		entry := map[string]any{
+			"day":      strconv.FormatInt(day.Unix(), 10),
+			"uses":     "0",
+			"accounts": "0",
+		}
+		for _, d := range days {
+			if d.Day == day.Format("2006-01-02") {
+				entry["uses"] = strconv.FormatInt(d.Uses, 10)
+				entry["accounts"] = strconv.FormatInt(d.Accounts, 10)
+			}
+		}
+		history = append(history, entry)
+	}
+	return history, nil
+}
@@ -0,0 +1,140 @@
+package clientapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/contribsys/sparq/web"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashtags(t *testing.T) {
+	ts, stopper := web.NewTestServer(t, "hashtags")
+	defer stopper()
+	root := rootRouter(ts)
+	AddPublicEndpoints(ts, root.PathPrefix("/api/v1").Subrouter())
+
+	token, err := registerToken(t, ts)
+	assert.NoError(t, err)
+
+	_, err = ts.DB().Exec(`
+		insert into actors (Id, MastodonId, Type, Email, PublicKey, Properties, FetchedAt) values
+		('https://remote.example/users/bob', 1001, 'Person', '', '', '{"preferredUsername":"bob"}', current_timestamp),
+		('https://remote.example/users/eve', 1002, 'Person', '', '', '{"preferredUsername":"eve"}', current_timestamp)`)
+	assert.NoError(t, err)
+	_, err = ts.DB().Exec(`
+		insert into toots (Sid, Uri, ActorId, AuthorId, Summary, Content, Visibility, CreatedAt) values
+		('BOB1', 'https://remote.example/notes/1', 1001, null, '', '#Go #sparq', 0, datetime('now', '-3 hours')),
+		('BOB2', 'https://remote.example/notes/2', 1001, null, '', '#go, followers only', 2, datetime('now', '-2 hours')),
+		('EVE1', 'https://remote.example/notes/3', 1002, null, '', '#go #rust', 0, datetime('now', '-1 hours')),
+		('EVE2', 'https://remote.example/notes/4', 1002, null, '', '#old', 0, datetime('now', '-3 days'))`)
+	assert.NoError(t, err)
+	_, err = ts.DB().Exec(`
+		insert into toot_tags (Sid, Tag) values
+		('BOB1', 'go'), ('BOB1', 'sparq'), ('BOB2', 'go'), ('EVE1', 'go'), ('EVE1', 'rust'), ('EVE2', 'old')`)
+	assert.NoError(t, err)
+
+	call := func(method, path string) (int, any) {
+		req := httptest.NewRequest(method, "http://localhost.dev:9494/api/v1"+path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		root.ServeHTTP(w, req)
+		var result any
+		if w.Code == 200 {
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		}
+		return w.Code, result
+	}
+	timeline := func(path string) []string {
+		code, result := call("GET", path)
+		assert.Equal(t, 200, code)
+		sids := []string{}
+		for _, toot := range result.([]any) {
+			sids = append(sids, toot.(map[string]any)["id"].(string))
+		}
+		return sids
+	}
+
+	t.Run("Timeline", func(t *testing.T) {
+		assert.Equal(t, []string{"EVE1", "BOB1"}, timeline("/timelines/tag/Go"))
+		assert.Equal(t, []string{"EVE1", "BOB1"}, timeline("/timelines/tag/rust?any[]=sparq"))
+		assert.Equal(t, []string{"BOB1"}, timeline("/timelines/tag/go?all[]=sparq"))
+		assert.Equal(t, []string{"BOB1"}, timeline("/timelines/tag/go?none[]=rust"))
+		assert.Empty(t, timeline("/timelines/tag/go?local=true"))
+	})
+
+	t.Run("Follow", func(t *testing.T) {
+		code, tag := call("GET", "/tags/Go")
+		assert.Equal(t, 200, code)
+		attrs := tag.(map[string]any)
+		assert.Equal(t, "go", attrs["name"])
+		assert.Equal(t, "https://localhost.dev/tags/go", attrs["url"])
+		assert.Equal(t, false, attrs["following"])
+		history := attrs["history"].([]any)
+		assert.Equal(t, 7, len(history))
+		assert.Equal(t, "2", history[0].(map[string]any)["uses"])
+		assert.Equal(t, "2", history[0].(map[string]any)["accounts"])
+
+		code, _ = call("GET", "/tags/not-a-tag")
+		assert.Equal(t, 404, code)
+
+		assert.NotContains(t, timeline("/timelines/home"), "BOB1")
+		code, tag = call("POST", "/tags/sparq/follow")
+		assert.Equal(t, 200, code)
+		assert.Equal(t, true, tag.(map[string]any)["following"])
+		home := timeline("/timelines/home")
+		assert.Contains(t, home, "BOB1")
+		assert.NotContains(t, home, "EVE1")
+
+		code, tags := call("GET", "/followed_tags")
+		assert.Equal(t, 200, code)
+		assert.Equal(t, 1, len(tags.([]any)))
+
+		code, tag = call("POST", "/tags/sparq/unfollow")
+		assert.Equal(t, 200, code)
+		assert.Equal(t, false, tag.(map[string]any)["following"])
+		assert.NotContains(t, timeline("/timelines/home"), "BOB1")
+	})
+
+	t.Run("Trends", func(t *testing.T) {
+		assert.NoError(t, computeTrends(context.Background(), ts))
+
+		// nothing trends until it has been reviewed
+		code, trends := call("GET", "/trends/tags")
+		assert.Equal(t, 200, code)
+		assert.Empty(t, trends)
+
+		code, pending := call("GET", "/admin/trends/tags")
+		assert.Equal(t, 200, code)
+		tags := pending.([]any)
+		assert.Equal(t, 1, len(tags))
+		tag := tags[0].(map[string]any)
+		assert.Equal(t, "go", tag["name"])
+		assert.Equal(t, true, tag["requires_review"])
+
+		code, _ = call("POST", "/admin/trends/tags/999/approve")
+		assert.Equal(t, 404, code)
+		code, approved := call("POST", "/admin/trends/tags/"+tag["id"].(string)+"/approve")
+		assert.Equal(t, 200, code)
+		assert.Equal(t, true, approved.(map[string]any)["trendable"])
+		assert.Equal(t, false, approved.(map[string]any)["requires_review"])
+
+		code, trends = call("GET", "/trends/tags")
+		assert.Equal(t, 200, code)
+		assert.Equal(t, 1, len(trends.([]any)))
+		assert.Equal(t, "go", trends.([]any)[0].(map[string]any)["name"])
+
+		code, _ = call("POST", "/admin/trends/tags/"+tag["id"].(string)+"/reject")
+		assert.Equal(t, 200, code)
+		code, trends = call("GET", "/trends/tags")
+		assert.Equal(t, 200, code)
+		assert.Empty(t, trends)
+
+		_, err := ts.DB().Exec("update accounts set RoleMask = 1 where Id = 1")
+		assert.NoError(t, err)
+		code, _ = call("GET", "/admin/trends/tags")
+		assert.Equal(t, 403, code)
+	})
+}
@@ -0,0 +1,212 @@
+package clientapi
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+
+	"github.com/contribsys/sparq"
+	"github.com/contribsys/sparq/activitypub"
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/util"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+// GET https://mastodon.example/api/v1/statuses/:id/context
+
+var (
+	// The most ancestors and descendants rendered in a thread.
+	MaxAncestors   = 40
+	MaxDescendants = 60
+)
+
+func contextHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			httpError(w, errors.New("GET only"), http.StatusBadRequest)
+			return
+		}
+		ctx := r.Context()
+		viewer := viewerIRI(s, r)
+		var toot model.Toot
+		err := s.DB().GetContext(ctx, &toot,
+			"select * from toots where Sid = ? and DeletedAt is null", mux.Vars(r)["id"])
+		if err != nil {
+			listError(w, err)
+			return
+		}
+		ok, err := canView(ctx, s, &toot, viewer)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			listError(w, sql.ErrNoRows)
+			return
+		}
+
+		// the thread is rendered with what we have now, anything
+		// missing will be there next time
+		err = activitypub.ResolveThread(ctx, s, &toot)
+		if err != nil {
+			util.Warnf("Unable to resolve thread for %s: %v", toot.Sid, err)
+		}
+
+		ancestors, err := threadAncestors(ctx, s, &toot, viewer)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		descendants, err := threadDescendants(ctx, s, &toot, viewer)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		filters, err := LoadFilters(ctx, s.DB(), viewer, "thread")
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		result := map[string]any{}
+		result["ancestors"], err = tootMaps(s, ancestors, viewer, filters)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		result["descendants"], err = tootMaps(s, descendants, viewer, filters)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		httpJson(w, result)
+	}
+}
+
+// tootMaps renders the toots, leaving out any hidden by the filters.
+func tootMaps(s sparq.Server, toots []model.Toot, viewer string, filters Filters) ([]map[string]any, error) {
+	results := []map[string]any{}
+	for idx := range toots {
+		attrs, err := TootMapFor(s.DB(), toots[idx].Sid, viewer)
+		if err != nil {
+			return nil, err
+		}
+		if filters.Apply(attrs) {
+			continue
+		}
+		results = append(results, attrs)
+	}
+	return results, nil
+}
+
+// threadAncestors returns the visible toots which the toot replies
+// to, oldest first. The walk stops at the first toot we haven't seen.
+func threadAncestors(ctx context.Context, s sparq.Server, toot *model.Toot, viewer string) ([]model.Toot, error) {
+	ancestors := []model.Toot{}
+	uri := toot.InReplyTo
+	for len(ancestors) < MaxAncestors && uri != nil {
+		var parent model.Toot
+		err := s.DB().GetContext(ctx, &parent, "select * from toots where Uri = ?", *uri)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				break
+			}
+			return nil, errors.Wrap(err, "toots")
+		}
+		uri = parent.InReplyTo
+		if parent.DeletedAt != nil {
+			continue
+		}
+		ok, err := canView(ctx, s, &parent, viewer)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			ancestors = append(ancestors, parent)
+		}
+	}
+	for i, j := 0, len(ancestors)-1; i < j; i, j = i+1, j-1 {
+		ancestors[i], ancestors[j] = ancestors[j], ancestors[i]
+	}
+	return ancestors, nil
+}
+
+// threadDescendants returns the visible replies to the toot, depth
+// first so each reply is followed by its own replies. Replies to
+// toots the viewer can't see are hidden too.
+func threadDescendants(ctx context.Context, s sparq.Server, toot *model.Toot, viewer string) ([]model.Toot, error) {
+	descendants := []model.Toot{}
+	stack := []string{toot.Uri}
+	for len(stack) > 0 && len(descendants) < MaxDescendants {
+		uri := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if uri != toot.Uri {
+			var reply model.Toot
+			err := s.DB().GetContext(ctx, &reply, "select * from toots where Uri = ?", uri)
+			if err != nil {
+				return nil, errors.Wrap(err, "toots")
+			}
+			ok, err := canView(ctx, s, &reply, viewer)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+			descendants = append(descendants, reply)
+		}
+		replies := []string{}
+		err := s.DB().SelectContext(ctx, &replies, `
+			select Uri from toots where InReplyTo = ? and DeletedAt is null
+			order by CreatedAt desc, Sid desc`, uri)
+		if err != nil {
+			return nil, errors.Wrap(err, "toots")
+		}
+		// newest first so the oldest reply is popped first
+		stack = append(stack, replies...)
+	}
+	return descendants, nil
+}
+
+// canView is true if the viewer, an actor IRI or the empty string
+// for anonymous requests, may see the toot.
+func canView(ctx context.Context, s sparq.Server, toot *model.Toot, viewer string) (bool, error) {
+	public := toot.Visibility == model.VisPublic || toot.Visibility == model.VisUnlisted
+	if viewer == "" {
+		return public, nil
+	}
+	author, err := activitypub.AuthorIRI(ctx, s, toot)
+	if err != nil {
+		return false, err
+	}
+	if author == viewer {
+		return true, nil
+	}
+	// a block hides toots both ways
+	var blocked int
+	err = s.DB().GetContext(ctx, &blocked,
+		"select count(*) from ("+model.BlockedActors+") where TargetActorId = ?", viewer, viewer, author)
+	if err != nil {
+		return false, errors.Wrap(err, "actor_blocks")
+	}
+	if blocked > 0 {
+		return false, nil
+	}
+	if public {
+		return true, nil
+	}
+	var count int
+	switch toot.Visibility {
+	case model.VisPrivate:
+		err = s.DB().GetContext(ctx, &count, `
+			select count(*) from actor_following
+			where ActorId = ? and TargetActorId = ? and State = ?`, viewer, author, activitypub.FollowAccepted)
+	case model.VisDirect:
+		err = s.DB().GetContext(ctx, &count, `
+			select count(*) from toot_mentions where Sid = ? and ActorId = ?`, toot.Sid, viewer)
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "visibility")
+	}
+	return count > 0, nil
+}
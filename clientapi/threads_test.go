@@ -0,0 +1,118 @@
+package clientapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/web"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThreads(t *testing.T) {
+	ts, stopper := web.NewTestServer(t, "threads")
+	defer stopper()
+	token, err := registerToken(t, ts)
+	assert.NoError(t, err)
+	root := rootRouter(ts)
+	AddPublicEndpoints(ts, root.PathPrefix("/api/v1").Subrouter())
+
+	_, err = ts.DB().Exec(`insert into accounts (Id, Sid, Nick, Email, FullName) values (2, 'AAAC', 'alice', 'alice@localhost.dev', 'Alice')`)
+	assert.NoError(t, err)
+	_, err = ts.DB().Exec(`insert into account_profiles (AccountId) values (2)`)
+	assert.NoError(t, err)
+
+	count := 0
+	call := func(method, path string, values url.Values, bearer string) (int, map[string]any) {
+		count++
+		req := httptest.NewRequest(method, "http://localhost.dev:9494/api/v1"+path, strings.NewReader(values.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		req.Header.Set("Idempotency-Key", fmt.Sprintf("threads-%d", count))
+		w := httptest.NewRecorder()
+		root.ServeHTTP(w, req)
+		result := map[string]any{}
+		if w.Code == 200 {
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		}
+		return w.Code, result
+	}
+	ids := func(toots any) []string {
+		result := []string{}
+		for _, toot := range toots.([]any) {
+			result = append(result, toot.(map[string]any)["id"].(string))
+		}
+		return result
+	}
+
+	code, _ := call("POST", "/statuses", url.Values{"status": {"Nobody home"}, "in_reply_to_id": {"NOPE"}}, token)
+	assert.Equal(t, 404, code)
+
+	code, first := call("POST", "/statuses", url.Values{"status": {"Thread start"}}, token)
+	assert.Equal(t, 200, code)
+	code, reply := call("POST", "/statuses", url.Values{"status": {"First reply"}, "in_reply_to_id": {first["id"].(string)}}, token)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, first["id"], reply["in_reply_to_id"])
+	assert.Equal(t, "1", reply["in_reply_to_account_id"])
+	code, nested := call("POST", "/statuses", url.Values{"status": {"Nested reply"}, "in_reply_to_id": {reply["id"].(string)}}, token)
+	assert.Equal(t, 200, code)
+
+	var parent model.Toot
+	err = ts.DB().Get(&parent, "select * from toots where Sid = ?", nested["id"])
+	assert.NoError(t, err)
+	assert.Equal(t, reply["uri"], *parent.InReplyTo)
+
+	// alice's followers-only reply
+	_, err = ts.DB().Exec(`
+		insert into toots (Sid, Uri, ActorId, AuthorId, InReplyTo, Visibility, Summary, Content) values
+		('ALI1', 'https://localhost.dev/@alice/ALI1', 2, 2, ?, ?, '', 'Secret reply')`, first["uri"], model.VisPrivate)
+	assert.NoError(t, err)
+
+	code, attrs := call("GET", "/statuses/"+first["id"].(string), nil, token)
+	assert.Equal(t, 200, code)
+	assert.EqualValues(t, 2, attrs["replies_count"])
+
+	t.Run("Context", func(t *testing.T) {
+		code, thread := call("GET", "/statuses/"+reply["id"].(string)+"/context", nil, token)
+		assert.Equal(t, 200, code)
+		assert.Equal(t, []string{first["id"].(string)}, ids(thread["ancestors"]))
+		assert.Equal(t, []string{nested["id"].(string)}, ids(thread["descendants"]))
+
+		code, thread = call("GET", "/statuses/"+first["id"].(string)+"/context", nil, "")
+		assert.Equal(t, 200, code)
+		assert.Empty(t, thread["ancestors"])
+		assert.Equal(t, []string{reply["id"].(string), nested["id"].(string)}, ids(thread["descendants"]))
+
+		code, _ = call("GET", "/statuses/ALI1/context", nil, "")
+		assert.Equal(t, 404, code)
+	})
+
+	t.Run("Visibility", func(t *testing.T) {
+		_, err := ts.DB().Exec(`insert into actor_following (Id, ActorId, TargetActorId, TargetActorAccount, State)
+			values ('f1', ?, ?, 'alice@localhost.dev', 'accepted')`, model.LocalIRI("admin"), model.LocalIRI("alice"))
+		assert.NoError(t, err)
+
+		code, thread := call("GET", "/statuses/"+first["id"].(string)+"/context", nil, token)
+		assert.Equal(t, 200, code)
+		assert.Equal(t, 3, len(ids(thread["descendants"])))
+
+		code, thread = call("GET", "/statuses/ALI1/context", nil, token)
+		assert.Equal(t, 200, code)
+		assert.Equal(t, []string{first["id"].(string)}, ids(thread["ancestors"]))
+	})
+
+	t.Run("Deleted", func(t *testing.T) {
+		code, _ := call("DELETE", "/statuses/"+reply["id"].(string), nil, token)
+		assert.Equal(t, 200, code)
+
+		code, thread := call("GET", "/statuses/"+nested["id"].(string)+"/context", nil, token)
+		assert.Equal(t, 200, code)
+		assert.Equal(t, []string{first["id"].(string)}, ids(thread["ancestors"]))
+	})
+}
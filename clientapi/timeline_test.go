@@ -1,9 +1,15 @@
 package clientapi
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/sparq/activitypub"
 	"github.com/contribsys/sparq/model"
 	"github.com/contribsys/sparq/web"
 	"github.com/stretchr/testify/assert"
@@ -32,3 +38,147 @@ func TestLocal(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, 0, len(res.Toots))
 }
+
+func TestTimelines(t *testing.T) {
+	ts, stopper := web.NewTestServer(t, "timelines")
+	defer stopper()
+	root := rootRouter(ts)
+	AddPublicEndpoints(ts, root.PathPrefix("/api/v1").Subrouter())
+
+	token, err := registerToken(t, ts)
+	assert.NoError(t, err)
+
+	// a followed remote actor, an unfollowed remote actor and a local account
+	_, err = ts.DB().Exec(`
+		insert into actors (Id, MastodonId, Type, Email, PublicKey, Properties, FetchedAt) values
+		('https://remote.example/users/bob', 1001, 'Person', '', '', '{"preferredUsername":"bob"}', current_timestamp),
+		('https://remote.example/users/eve', 1002, 'Person', '', '', '{"preferredUsername":"eve"}', current_timestamp)`)
+	assert.NoError(t, err)
+	_, err = ts.DB().Exec(`insert into accounts (Id, Sid, Nick, Email, FullName) values (2, 'AAAC', 'alice', 'alice@localhost.dev', 'Alice')`)
+	assert.NoError(t, err)
+	_, err = ts.DB().Exec(`insert into account_profiles (AccountId) values (2)`)
+	assert.NoError(t, err)
+	_, err = ts.DB().Exec(`
+		insert into actor_following (Id, ActorId, TargetActorId, TargetActorAccount, State)
+		values ('https://localhost.dev/users/admin#follows/1', 'https://localhost.dev/users/admin',
+			'https://remote.example/users/bob', 'bob@remote.example', 'accepted')`)
+	assert.NoError(t, err)
+	_, err = ts.DB().Exec(`
+		insert into toots (Sid, Uri, ActorId, AuthorId, Summary, Content, Visibility, CreatedAt) values
+		('BOB1', 'https://remote.example/notes/1', 1001, null, '', 'From bob', 0, '2030-01-01 00:00:01'),
+		('BOB2', 'https://remote.example/notes/2', 1001, null, '', 'Bob, followers only', 2, '2030-01-01 00:00:02'),
+		('EVE1', 'https://remote.example/notes/3', 1002, null, '', 'From eve', 0, '2030-01-01 00:00:03'),
+		('ALI1', 'https://localhost.dev/@alice/ALI1', 2, 2, '', 'From alice', 0, '2030-01-01 00:00:04')`)
+	assert.NoError(t, err)
+
+	call := func(path string) (*httptest.ResponseRecorder, []string) {
+		req := httptest.NewRequest("GET", "http://localhost.dev:9494/api/v1"+path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		root.ServeHTTP(w, req)
+		sids := []string{}
+		if w.Code == 200 {
+			var toots []map[string]any
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &toots))
+			for _, toot := range toots {
+				sids = append(sids, toot["id"].(string))
+			}
+		}
+		return w, sids
+	}
+
+	t.Run("Home", func(t *testing.T) {
+		w, sids := call("/timelines/home")
+		assert.Equal(t, 200, w.Code, w.Body.String())
+		assert.Equal(t, []string{"BOB2", "BOB1", "AABB", "AABA"}, sids)
+		if len(sids) != 4 {
+			return
+		}
+
+		var toots []map[string]any
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &toots))
+		assert.Equal(t, "bob@remote.example", toots[0]["account"].(map[string]any)["acct"])
+		assert.Equal(t, "private", toots[0]["visibility"])
+		assert.Equal(t, "admin", toots[3]["account"].(map[string]any)["acct"])
+
+		req := httptest.NewRequest("GET", "http://localhost.dev:9494/api/v1/timelines/home", nil)
+		w = httptest.NewRecorder()
+		root.ServeHTTP(w, req)
+		assert.Equal(t, 401, w.Code)
+	})
+
+	t.Run("Public", func(t *testing.T) {
+		w, sids := call("/timelines/public")
+		assert.Equal(t, 200, w.Code, w.Body.String())
+		assert.Equal(t, []string{"ALI1", "EVE1", "BOB1", "AABB", "AABA"}, sids)
+
+		_, sids = call("/timelines/public?local=true")
+		assert.Equal(t, []string{"ALI1", "AABB", "AABA"}, sids)
+		_, sids = call("/timelines/public?remote=true")
+		assert.Equal(t, []string{"EVE1", "BOB1"}, sids)
+		_, sids = call("/timelines/public?only_media=true")
+		assert.Equal(t, []string{}, sids)
+	})
+
+	t.Run("Pagination", func(t *testing.T) {
+		w, sids := call("/timelines/public?limit=2&local=true")
+		assert.Equal(t, []string{"ALI1", "AABB"}, sids)
+		link := w.Header().Get("Link")
+		assert.Contains(t, link, `<https://localhost.dev/api/v1/timelines/public?limit=2&local=true&max_id=AABB>; rel="next"`)
+		assert.Contains(t, link, `<https://localhost.dev/api/v1/timelines/public?limit=2&local=true&min_id=ALI1>; rel="prev"`)
+
+		_, sids = call("/timelines/public?limit=2&local=true&max_id=AABB")
+		assert.Equal(t, []string{"AABA"}, sids)
+		_, sids = call("/timelines/public?limit=2&min_id=AABA")
+		assert.Equal(t, []string{"BOB1", "AABB"}, sids)
+		_, sids = call("/timelines/public?limit=2&since_id=AABA")
+		assert.Equal(t, []string{"ALI1", "EVE1"}, sids)
+
+		w, sids = call("/timelines/public?max_id=AABA")
+		assert.Equal(t, []string{}, sids)
+		assert.Equal(t, "", w.Header().Get("Link"))
+	})
+
+	t.Run("Pruned", func(t *testing.T) {
+		// an actor whose server says they're gone
+		gone := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusGone)
+		}))
+		defer gone.Close()
+		dan := gone.URL + "/users/dan"
+		_, err := ts.DB().Exec(`
+			insert into actors (Id, MastodonId, Type, Email, PublicKey, Properties, FetchedAt) values
+			(?, 1003, 'Person', '', '', '{"preferredUsername":"dan"}', '2000-01-01 00:00:00')`, dan)
+		assert.NoError(t, err)
+		_, err = ts.DB().Exec(`
+			insert into toots (Sid, Uri, ActorId, AuthorId, Summary, Content, Visibility, CreatedAt) values
+			('DAN1', ?, 1003, null, '', 'From dan', 0, '2030-01-01 00:00:05')`, gone.URL+"/notes/1")
+		assert.NoError(t, err)
+		_, err = ts.DB().Exec(`
+			insert into actor_notifications (Type, ActorId, FromActorId, ObjectId) values ('mention', ?, ?, ?)`,
+			model.LocalIRI("admin"), dan, gone.URL+"/notes/1")
+		assert.NoError(t, err)
+		_, err = ts.DB().Exec(`insert into lists (Id, AccountId, Title) values (9, 1, 'Gone')`)
+		assert.NoError(t, err)
+		_, err = ts.DB().Exec(`insert into list_accounts (ListId, ActorId) values (9, ?)`, dan)
+		assert.NoError(t, err)
+		_, sids := call("/timelines/public?remote=true")
+		assert.Equal(t, []string{"DAN1", "EVE1", "BOB1"}, sids)
+
+		activitypub.Register(ts)
+		jobs := ts.Jobs().(*web.TestJobs)
+		assert.NoError(t, jobs.Push(context.Background(), client.NewJob("RefreshActor", dan)))
+		assert.NoError(t, jobs.Drain(context.Background()))
+
+		w, sids := call("/timelines/public?remote=true")
+		assert.Equal(t, 200, w.Code, w.Body.String())
+		assert.Equal(t, []string{"EVE1", "BOB1"}, sids)
+		w, _ = call("/notifications")
+		assert.Equal(t, 200, w.Code, w.Body.String())
+		var count int
+		assert.NoError(t, ts.DB().Get(&count, "select count(*) from list_accounts where ActorId = ?", dan))
+		assert.Equal(t, 0, count)
+		assert.NoError(t, ts.DB().Get(&count, "select count(*) from actors where Id = ?", dan))
+		assert.Equal(t, 0, count)
+	})
+}
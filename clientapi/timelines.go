@@ -1,59 +1,174 @@
 package clientapi
 
 import (
+	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
 
 	"github.com/contribsys/sparq"
 	"github.com/contribsys/sparq/model"
-	"github.com/jmoiron/sqlx"
+	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
 )
 
-func listHandler(svr sparq.Server) http.HandlerFunc {
+// GET https://mastodon.example/api/v1/timelines/home
+// GET https://mastodon.example/api/v1/timelines/public
+// GET https://mastodon.example/api/v1/timelines/list/:list_id
+// GET https://mastodon.example/api/v1/timelines/tag/:hashtag
+
+func listTimelineHandler(svr sparq.Server) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// listname := mux.Vars(r)["name"]
-		w.Header().Add("Content-Type", "application/json")
-		_, _ = w.Write([]byte("[]"))
+		acct, err := currentAccount(svr, r)
+		if err != nil {
+			httpError(w, err, http.StatusUnauthorized)
+			return
+		}
+		list, err := ownedList(r.Context(), svr, acct, mux.Vars(r)["id"])
+		if err != nil {
+			listError(w, err)
+			return
+		}
+		tq, err := timelineQuery(svr, r)
+		if err != nil {
+			httpError(w, err, http.StatusBadRequest)
+			return
+		}
+		tq.ListId = list.Id
+		tq.Visibilities = []model.PostVisibility{model.VisPublic, model.VisUnlisted, model.VisPrivate}
+		renderTimeline(w, r, svr, tq, "home")
 	}
 }
 
 func homeHandler(svr sparq.Server) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Add("Content-Type", "application/json")
-		_, _ = w.Write([]byte("[]"))
+		acct, err := currentAccount(svr, r)
+		if err != nil {
+			httpError(w, err, http.StatusUnauthorized)
+			return
+		}
+		tq, err := timelineQuery(svr, r)
+		if err != nil {
+			httpError(w, err, http.StatusBadRequest)
+			return
+		}
+		tq.HomeFor = acct
+		tq.Visibilities = []model.PostVisibility{model.VisPublic, model.VisUnlisted, model.VisPrivate}
+		renderTimeline(w, r, svr, tq, "home")
 	}
 }
 
 func publicHandler(svr sparq.Server) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Add("Content-Type", "application/json")
-		_, _ = w.Write([]byte("[]"))
-	}
-}
-
-func TootsForHome(db *sqlx.DB) (map[string]interface{}, error) {
-	attrs := map[string]interface{}{}
-	base := `select t.sid as id, t.CreatedAt as created_at, t.Summary as spoiler_text, t.Visibility as viz, t.Lang as language,
-	        t.URI as uri, t.URI as url, 0 as replies_count, 0 as reblogs_count, 0 as favourites_count, false as favourited,
-					false as reblogged, false as muted, false as bookmarked, t.Content as content, null as reblog,
-					null as media_attachments, null as mentions, null as tags, null as emojis, null as card, null as poll,
-					oc.name as app_name, oc.website as app_website
-					from toots t
-					left outer join oauth_clients oc on t.appid = oc.id
-					where t.sid = ?`
-	err := db.QueryRowx(base, nil).MapScan(attrs)
+		tq, err := timelineQuery(svr, r)
+		if err != nil {
+			httpError(w, err, http.StatusBadRequest)
+			return
+		}
+		tq.Visibility = model.VisPublic
+		tq.ExcludeBoosts = true
+		tq.Local = isTrue(r.Form.Get("local"))
+		tq.Remote = isTrue(r.Form.Get("remote"))
+		tq.OnlyMedia = isTrue(r.Form.Get("only_media"))
+		renderTimeline(w, r, svr, tq, "public")
+	}
+}
+
+// tagTimelineHandler lists public toots with the hashtag or any of
+// the any[] tags, which also have all of the all[] tags and none of
+// the none[] tags.
+func tagTimelineHandler(svr sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tq, err := timelineQuery(svr, r)
+		if err != nil {
+			httpError(w, err, http.StatusBadRequest)
+			return
+		}
+		tq.Tags = append([]string{mux.Vars(r)["hashtag"]}, r.Form["any[]"]...)
+		tq.AllTags = r.Form["all[]"]
+		tq.NoneTags = r.Form["none[]"]
+		tq.Visibility = model.VisPublic
+		tq.Local = isTrue(r.Form.Get("local"))
+		tq.Remote = isTrue(r.Form.Get("remote"))
+		tq.OnlyMedia = isTrue(r.Form.Get("only_media"))
+		renderTimeline(w, r, svr, tq, "public")
+	}
+}
+
+// timelineQuery parses the pagination parameters which are
+// common to all timelines.
+func timelineQuery(svr sparq.Server, r *http.Request) (*model.TimelineQuery, error) {
+	err := r.ParseForm()
 	if err != nil {
-		return nil, errors.Wrap(err, "Error with toot "+"")
+		return nil, err
+	}
+	tq := model.TQ(svr.DB())
+	tq.MaxId = r.Form.Get("max_id")
+	tq.SinceId = r.Form.Get("since_id")
+	tq.MinId = r.Form.Get("min_id")
+	if limit := r.Form.Get("limit"); limit != "" {
+		tq.Limit, err = strconv.ParseUint(limit, 10, 64)
+		if err != nil {
+			return nil, errors.Wrap(err, "Invalid limit")
+		}
 	}
+	return tq, nil
+}
 
-	attrs["visibility"] = model.FromVis(model.PostVisibility(attrs["viz"].(int64)))
-	delete(attrs, "viz")
+func isTrue(value string) bool {
+	return value == "true" || value == "1"
+}
+
+// renderTimeline renders the page of toots, applying the viewer's
+// filters for the timeline's filter context.
+func renderTimeline(w http.ResponseWriter, r *http.Request, svr sparq.Server, tq *model.TimelineQuery, fctx string) {
+	viewer := viewerIRI(svr, r)
+	tq.Viewer = viewer
+	result, err := tq.Execute()
+	if err != nil {
+		httpError(w, err, http.StatusInternalServerError)
+		return
+	}
+	filters, err := LoadFilters(r.Context(), svr.DB(), viewer, fctx)
+	if err != nil {
+		httpError(w, err, http.StatusInternalServerError)
+		return
+	}
+	toots := []map[string]any{}
+	for _, entry := range result.Toots {
+		attrs, err := TootMapFor(svr.DB(), entry.Sid, viewer)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		if filters.Apply(attrs) {
+			continue
+		}
+		toots = append(toots, attrs)
+	}
+	if !result.IsEmpty() {
+		linkHeader(w, r, result.Toots[len(result.Toots)-1].Sid, result.Toots[0].Sid)
+	}
+	httpJson(w, toots)
+}
+
+// linkHeader sets the Link header pointing to the pages before the
+// last item and after the first item of this page.
+func linkHeader(w http.ResponseWriter, r *http.Request, last, first string) {
+	w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next", <%s>; rel="prev"`,
+		pageUrl(r, "max_id", last), pageUrl(r, "min_id", first)))
+}
 
-	attrs["application"] = map[string]any{
-		"name":    attrs["app_name"],
-		"website": attrs["app_website"],
+// pageUrl links to the adjacent page of the timeline, keeping
+// any other parameters of the current request.
+func pageUrl(r *http.Request, param, sid string) string {
+	values := url.Values{}
+	for key, vals := range r.URL.Query() {
+		values[key] = vals
 	}
-	delete(attrs, "app_name")
-	delete(attrs, "app_website")
-	return attrs, nil
+	values.Del("max_id")
+	values.Del("since_id")
+	values.Del("min_id")
+	values.Set(param, sid)
+	return absoluteUrl(r.URL.Path + "?" + values.Encode())
 }
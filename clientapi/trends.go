@@ -0,0 +1,279 @@
+package clientapi
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/contribsys/sparq"
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/util"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+// GET https://mastodon.example/api/v1/trends/tags
+// GET https://mastodon.example/api/v1/admin/trends/tags
+// POST https://mastodon.example/api/v1/admin/trends/tags/:id/approve
+// POST https://mastodon.example/api/v1/admin/trends/tags/:id/reject
+
+var (
+	// TrendsInterval is how often, in seconds, trending tags
+	// are recalculated.
+	TrendsInterval int64 = 600
+	// TrendWindow is the recent period in which a tag's usage is
+	// compared with its usage over the week before.
+	TrendWindow = 24 * time.Hour
+	// TrendMinAccounts is how many accounts must use a tag within
+	// the window before it can trend.
+	TrendMinAccounts int64 = 2
+
+	ErrForbidden = errors.New("Forbidden")
+)
+
+// computeTrends scores the tags used in public toots by how many
+// more accounts used them within the window than would be expected
+// from the week before. Tags which haven't been reviewed yet are
+// flagged for review and won't be shown until they are approved.
+func computeTrends(ctx context.Context, svr sparq.Server) error {
+	now := time.Now().UTC()
+	recent := now.Add(-TrendWindow).Format("2006-01-02 15:04:05")
+	since := now.Add(-TrendWindow - 7*24*time.Hour).Format("2006-01-02 15:04:05")
+	usages := []struct {
+		Tag      string
+		Uses     int64
+		Accounts int64
+		Expected float64
+	}{}
+	err := svr.DB().SelectContext(ctx, &usages, `
+		select tt.Tag as Tag,
+			sum(t.CreatedAt >= ?) as Uses,
+			count(distinct case when t.CreatedAt >= ? then t.ActorId end) as Accounts,
+			count(distinct case when t.CreatedAt < ? then t.ActorId end) / 7.0 as Expected
+		from toot_tags tt
+		join toots t on t.Sid = tt.Sid
+		where t.DeletedAt is null and t.Visibility in (?, ?) and t.CreatedAt >= ?
+		group by tt.Tag`,
+		recent, recent, recent, model.VisPublic, model.VisUnlisted, since)
+	if err != nil {
+		return errors.Wrap(err, "tag usage")
+	}
+
+	tx, err := svr.DB().BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	_, err = tx.ExecContext(ctx, "delete from tag_trends")
+	if err != nil {
+		return errors.Wrap(err, "tag_trends")
+	}
+	count := 0
+	for _, usage := range usages {
+		if usage.Accounts < TrendMinAccounts || float64(usage.Accounts) <= usage.Expected {
+			continue
+		}
+		score := math.Pow(float64(usage.Accounts)-usage.Expected, 2) / (usage.Expected + 1)
+		_, err = tx.ExecContext(ctx, "insert into tags (Name) values (?) on conflict do nothing", usage.Tag)
+		if err != nil {
+			return errors.Wrap(err, "tags")
+		}
+		_, err = tx.ExecContext(ctx, `
+			update tags set RequestedReviewAt = ?
+			where Name = ? and ReviewedAt is null and RequestedReviewAt is null`,
+			now.Format("2006-01-02 15:04:05"), usage.Tag)
+		if err != nil {
+			return errors.Wrap(err, "tags")
+		}
+		_, err = tx.ExecContext(ctx, `
+			insert into tag_trends (TagId, Score, Uses, Accounts)
+			select Id, ?, ?, ? from tags where Name = ?`, score, usage.Uses, usage.Accounts, usage.Tag)
+		if err != nil {
+			return errors.Wrap(err, "tag_trends")
+		}
+		count++
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	util.Debugf("Found %d trending tags", count)
+	return nil
+}
+
+// trendingTagsHandler lists the approved trending tags, highest
+// scoring first.
+func trendingTagsHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := r.ParseForm()
+		if err != nil {
+			httpError(w, err, http.StatusBadRequest)
+			return
+		}
+		limit, offset := 10, 0
+		if value := r.Form.Get("limit"); value != "" {
+			limit, err = strconv.Atoi(value)
+			if err != nil {
+				httpError(w, errors.Wrap(err, "Invalid limit"), http.StatusBadRequest)
+				return
+			}
+		}
+		if limit <= 0 || limit > 20 {
+			limit = 20
+		}
+		if value := r.Form.Get("offset"); value != "" {
+			offset, err = strconv.Atoi(value)
+			if err != nil {
+				httpError(w, errors.Wrap(err, "Invalid offset"), http.StatusBadRequest)
+				return
+			}
+		}
+		names := []string{}
+		err = s.DB().SelectContext(r.Context(), &names, `
+			select g.Name from tag_trends tr
+			join tags g on g.Id = tr.TagId
+			where g.Trendable
+			order by tr.Score desc, g.Name
+			limit ? offset ?`, limit, offset)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		acct, _ := currentAccount(s, r)
+		results := []map[string]any{}
+		for _, name := range names {
+			attrs, err := TagMap(r.Context(), s.DB(), name, acct)
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+			results = append(results, attrs)
+		}
+		httpJson(w, results)
+	}
+}
+
+// adminTrendingTagsHandler lists every trending tag, including
+// those awaiting review, for moderators.
+func adminTrendingTagsHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, err := currentModerator(s, r)
+		if err != nil {
+			moderatorError(w, err)
+			return
+		}
+		tags := []model.Tag{}
+		err = s.DB().SelectContext(r.Context(), &tags, `
+			select g.* from tag_trends tr
+			join tags g on g.Id = tr.TagId
+			order by tr.Score desc, g.Name`)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		results := []map[string]any{}
+		for idx := range tags {
+			attrs, err := adminTagMap(r.Context(), s, &tags[idx])
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+			results = append(results, attrs)
+		}
+		httpJson(w, results)
+	}
+}
+
+func approveTagHandler(s sparq.Server) http.HandlerFunc {
+	return reviewTagHandler(s, true)
+}
+
+func rejectTagHandler(s sparq.Server) http.HandlerFunc {
+	return reviewTagHandler(s, false)
+}
+
+// reviewTagHandler records a moderator's decision whether the tag
+// in the URL may trend.
+func reviewTagHandler(s sparq.Server, trendable bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			httpError(w, errors.New("POST only"), http.StatusBadRequest)
+			return
+		}
+		_, err := currentModerator(s, r)
+		if err != nil {
+			moderatorError(w, err)
+			return
+		}
+		res, err := s.DB().ExecContext(r.Context(), `
+			update tags set Trendable = ?, ReviewedAt = ? where Id = ?`,
+			trendable, time.Now().UTC().Format("2006-01-02 15:04:05"), mux.Vars(r)["id"])
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		if count, _ := res.RowsAffected(); count == 0 {
+			listError(w, sql.ErrNoRows)
+			return
+		}
+		var tag model.Tag
+		err = s.DB().GetContext(r.Context(), &tag, "select * from tags where Id = ?", mux.Vars(r)["id"])
+		if err != nil {
+			listError(w, err)
+			return
+		}
+		attrs, err := adminTagMap(r.Context(), s, &tag)
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		httpJson(w, attrs)
+	}
+}
+
+// currentModerator returns the current account if it may moderate
+// the instance.
+func currentModerator(s sparq.Server, r *http.Request) (*model.Account, error) {
+	acct, err := currentAccount(s, r)
+	if err != nil {
+		return nil, err
+	}
+	if !acct.HasRole(model.RoleModerator | model.RoleAdmin) {
+		return nil, ErrForbidden
+	}
+	return acct, nil
+}
+
+func currentAdmin(s sparq.Server, r *http.Request) (*model.Account, error) {
+	acct, err := currentAccount(s, r)
+	if err != nil {
+		return nil, err
+	}
+	if !acct.HasRole(model.RoleAdmin) {
+		return nil, ErrForbidden
+	}
+	return acct, nil
+}
+
+func moderatorError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrForbidden) {
+		httpError(w, err, http.StatusForbidden)
+		return
+	}
+	httpError(w, err, http.StatusUnauthorized)
+}
+
+func adminTagMap(ctx context.Context, s sparq.Server, tag *model.Tag) (map[string]any, error) {
+	attrs, err := TagMap(ctx, s.DB(), tag.Name, nil)
+	if err != nil {
+		return nil, err
+	}
+	attrs["id"] = strconv.FormatUint(tag.Id, 10)
+	attrs["trendable"] = tag.Trendable
+	attrs["usable"] = true
+	attrs["requires_review"] = tag.RequiresReview()
+	return attrs, nil
+}
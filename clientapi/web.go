@@ -4,6 +4,7 @@ import (
 	"os"
 
 	"github.com/contribsys/sparq"
+	"github.com/contribsys/sparq/activitypub"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/sessions"
 )
@@ -18,25 +19,111 @@ func AddPublicEndpoints(s sparq.Server, mux *mux.Router) {
 	mux.HandleFunc("/media", postMediaHandler(s))
 	mux.HandleFunc("/media/{id:[0-9]+}", getMediaAttachmentHandler(s))
 	mux.HandleFunc("/statuses", PostTootHandler(s))
-	mux.HandleFunc("/statuses/{id}", getTootHandler(s))
-	mux.HandleFunc("/custom_emojis", emptyHandler(s))
-	mux.HandleFunc("/lists", emptyHandler(s))
-	mux.HandleFunc("/filters", emptyHandler(s))
-	mux.HandleFunc("/notifications", emptyHandler(s))
+	mux.HandleFunc("/statuses/{id}", statusHandler(s))
+	mux.HandleFunc("/statuses/{id}/context", contextHandler(s))
+	mux.HandleFunc("/statuses/{id}/history", tootHistoryHandler(s))
+	mux.HandleFunc("/statuses/{id}/source", tootSourceHandler(s))
+	mux.HandleFunc("/statuses/{id}/favourite", favouriteHandler(s))
+	mux.HandleFunc("/statuses/{id}/unfavourite", unfavouriteHandler(s))
+	mux.HandleFunc("/statuses/{id}/reblog", reblogHandler(s))
+	mux.HandleFunc("/statuses/{id}/unreblog", unreblogHandler(s))
+	mux.HandleFunc("/statuses/{id}/bookmark", bookmarkHandler(s))
+	mux.HandleFunc("/statuses/{id}/unbookmark", unbookmarkHandler(s))
+	mux.HandleFunc("/statuses/{id}/favourited_by", favouritedByHandler(s))
+	mux.HandleFunc("/statuses/{id}/reblogged_by", rebloggedByHandler(s))
+	mux.HandleFunc("/scheduled_statuses", scheduledTootsHandler(s))
+	mux.HandleFunc("/scheduled_statuses/{id:[0-9]+}", scheduledTootHandler(s))
+	mux.HandleFunc("/polls/{id:[0-9]+}", pollHandler(s))
+	mux.HandleFunc("/polls/{id:[0-9]+}/votes", voteHandler(s))
+	mux.HandleFunc("/favourites", favouritesHandler(s))
+	mux.HandleFunc("/bookmarks", bookmarksHandler(s))
+	mux.HandleFunc("/custom_emojis", customEmojisHandler(s))
+	mux.HandleFunc("/lists", listsHandler(s))
+	mux.HandleFunc("/lists/{id:[0-9]+}", listHandler(s))
+	mux.HandleFunc("/lists/{id:[0-9]+}/accounts", listAccountsHandler(s))
+	mux.HandleFunc("/filters", v1FiltersHandler(s))
+	mux.HandleFunc("/filters/{id:[0-9]+}", v1FilterHandler(s))
+	mux.HandleFunc("/conversations", conversationsHandler(s))
+	mux.HandleFunc("/conversations/{id:[0-9]+}", conversationHandler(s))
+	mux.HandleFunc("/conversations/{id:[0-9]+}/read", readConversationHandler(s))
+	mux.HandleFunc("/conversations/{id:[0-9]+}/mute", muteConversationHandler(s))
+	mux.HandleFunc("/conversations/{id:[0-9]+}/unmute", unmuteConversationHandler(s))
+	mux.HandleFunc("/notifications", notificationsHandler(s))
+	mux.HandleFunc("/notifications/clear", dismissNotificationHandler(s))
+	mux.HandleFunc("/notifications/{id:[0-9]+}", notificationHandler(s))
+	mux.HandleFunc("/notifications/{id:[0-9]+}/dismiss", dismissNotificationHandler(s))
+	mux.HandleFunc("/push/subscription", pushSubscriptionHandler(s))
 	mux.HandleFunc("/instance", instanceHandler(s))
 	mux.HandleFunc("/timelines/public", publicHandler(s))
 	mux.HandleFunc("/timelines/home", homeHandler(s))
-	mux.HandleFunc("/timelines/{name}", listHandler(s))
+	mux.HandleFunc("/timelines/list/{id:[0-9]+}", listTimelineHandler(s))
+	mux.HandleFunc("/timelines/tag/{hashtag}", tagTimelineHandler(s))
+	mux.HandleFunc("/tags/{name}", tagHandler(s))
+	mux.HandleFunc("/tags/{name}/follow", followTagHandler(s))
+	mux.HandleFunc("/tags/{name}/unfollow", unfollowTagHandler(s))
+	mux.HandleFunc("/followed_tags", followedTagsHandler(s))
+	mux.HandleFunc("/trends", trendingTagsHandler(s))
+	mux.HandleFunc("/trends/tags", trendingTagsHandler(s))
+	mux.HandleFunc("/admin/trends/tags", adminTrendingTagsHandler(s))
+	mux.HandleFunc("/admin/trends/tags/{id:[0-9]+}/approve", approveTagHandler(s))
+	mux.HandleFunc("/admin/trends/tags/{id:[0-9]+}/reject", rejectTagHandler(s))
+	mux.HandleFunc("/admin/custom_emojis", adminEmojisHandler(s))
+	mux.HandleFunc("/admin/custom_emojis/{id:[0-9]+}", adminEmojiHandler(s))
+	mux.HandleFunc("/admin/accounts", adminAccountsHandler(s))
+	mux.HandleFunc("/admin/accounts/{id:[0-9]+}", adminAccountHandler(s))
+	mux.HandleFunc("/admin/accounts/{id:[0-9]+}/approve", approveAccountHandler(s))
+	mux.HandleFunc("/admin/accounts/{id:[0-9]+}/reject", rejectAccountHandler(s))
+	mux.HandleFunc("/admin/invites", adminInvitesHandler(s))
+	mux.HandleFunc("/admin/invites/{id:[0-9]+}", adminInviteHandler(s))
+	mux.HandleFunc("/admin/registration_mode", registrationModeHandler(s))
 	mux.HandleFunc("/apps/verify_credentials", appsVerifyHandler(s))
 	mux.HandleFunc("/apps", appsHandler(s))
+	mux.HandleFunc("/accounts", registerHandler(s))
 	mux.HandleFunc("/accounts/verify_credentials", verifyCredentialsHandler(s))
-	mux.HandleFunc("/accounts/{sfid:[0-9]+}", getAccount(s))
+	mux.HandleFunc("/accounts/update_credentials", updateCredentialsHandler(s))
+	mux.HandleFunc("/accounts/relationships", relationshipsHandler(s))
+	mux.HandleFunc("/accounts/familiar_followers", familiarFollowersHandler(s))
+	mux.HandleFunc("/accounts/lookup", lookupHandler(s))
+	mux.HandleFunc("/accounts/search", accountSearchHandler(s))
+	mux.HandleFunc("/accounts/{id:[0-9]+}", accountHandler(s))
 	mux.HandleFunc("/accounts/{sfid:[0-9]+}/statuses", getAccountToots(s))
+	mux.HandleFunc("/accounts/{id:[0-9]+}/followers", followersHandler(s))
+	mux.HandleFunc("/accounts/{id:[0-9]+}/following", followingHandler(s))
+	mux.HandleFunc("/accounts/{id:[0-9]+}/follow", followHandler(s))
+	mux.HandleFunc("/accounts/{id:[0-9]+}/unfollow", unfollowHandler(s))
+	mux.HandleFunc("/accounts/{id:[0-9]+}/block", blockHandler(s))
+	mux.HandleFunc("/accounts/{id:[0-9]+}/unblock", unblockHandler(s))
+	mux.HandleFunc("/accounts/{id:[0-9]+}/mute", muteHandler(s))
+	mux.HandleFunc("/accounts/{id:[0-9]+}/unmute", unmuteHandler(s))
+	mux.HandleFunc("/accounts/{id:[0-9]+}/lists", accountListsHandler(s))
+	mux.HandleFunc("/blocks", blocksHandler(s))
+	mux.HandleFunc("/mutes", mutesHandler(s))
+	mux.HandleFunc("/domain_blocks", domainBlocksHandler(s))
+	mux.HandleFunc("/follows", remoteFollowHandler(s))
+	mux.HandleFunc("/follow_requests", followRequestsHandler(s))
+	mux.HandleFunc("/follow_requests/{id:[0-9]+}/authorize", authorizeFollowHandler(s))
+	mux.HandleFunc("/follow_requests/{id:[0-9]+}/reject", rejectFollowHandler(s))
 
+	// the listeners are global so they go when this server does
 	st := NewStreamer(s)
+	activitypub.Until(s.Context(),
+		activitypub.OnNotify(st.notify),
+		activitypub.OnConversation(st.conversation),
+		activitypub.OnToot(st.update))
+	mux.HandleFunc("/streaming", st.SocketHandler(s))
 	r := mux.PathPrefix("/streaming").Subrouter()
 	r.HandleFunc("/{key}", st.Handler(s))
+	r.HandleFunc("/{key}/local", st.Handler(s))
+}
 
-	// mux.HandleFunc("/accounts/{sfid:[0-9]+}/followers", getAccountFollowers)
-	// mux.HandleFunc("/accounts/{sfid:[0-9]+}/following", getAccountFollowing)
+// AddV2Endpoints adds the endpoints which only exist in version 2
+// of the API.
+func AddV2Endpoints(s sparq.Server, mux *mux.Router) {
+	mux.HandleFunc("/search", searchHandler(s))
+	mux.HandleFunc("/filters", filtersHandler(s))
+	mux.HandleFunc("/filters/{id:[0-9]+}", filterHandler(s))
+	mux.HandleFunc("/filters/{id:[0-9]+}/keywords", filterKeywordsHandler(s))
+	mux.HandleFunc("/filters/{id:[0-9]+}/statuses", filterStatusesHandler(s))
+	mux.HandleFunc("/filters/keywords/{id:[0-9]+}", filterKeywordHandler(s))
+	mux.HandleFunc("/filters/statuses/{id:[0-9]+}", filterStatusHandler(s))
 }
@@ -0,0 +1,207 @@
+package clientapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/contribsys/sparq"
+	"github.com/contribsys/sparq/model"
+	"github.com/contribsys/sparq/util"
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+)
+
+// wss://mastodon.example/api/v1/streaming?stream=user&access_token=...
+
+var (
+	// SocketPingInterval is how often we ping idle WebSockets so
+	// proxies don't close them.
+	SocketPingInterval = 25 * time.Second
+
+	upgrader = websocket.Upgrader{
+		// apps connect from any origin and authenticate with a token
+		CheckOrigin: func(r *http.Request) bool { return true },
+	}
+)
+
+// socketMessage is a request from the client to change the streams
+// it receives, e.g. {"type": "subscribe", "stream": "hashtag", "tag": "sparq"}.
+type socketMessage struct {
+	Type   string `json:"type"`
+	Stream string `json:"stream"`
+	Tag    string `json:"tag"`
+	List   string `json:"list"`
+}
+
+// socket is one WebSocket connection and the streams it carries.
+type socket struct {
+	conn     *websocket.Conn
+	streamer *Streamer
+	svr      sparq.Server
+	acct     *model.Account
+	// the streams write concurrently so writes take the lock
+	mu   sync.Mutex
+	subs map[any]func()
+	done chan struct{}
+}
+
+// SocketHandler serves the multiplexed streaming API over a
+// WebSocket. The client may name a first stream in the URL and
+// subscribes to or unsubscribes from streams at any time. Each
+// event names its stream and carries its payload as a string:
+// {"stream": ["hashtag", "sparq"], "event": "update", "payload": "{...}"}.
+func (s *Streamer) SocketHandler(sp sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		acct, err := currentAccount(sp, r)
+		if err != nil && !errors.Is(err, ErrUnauthorized) {
+			httpError(w, err, http.StatusUnauthorized)
+			return
+		}
+		var header http.Header
+		if token := r.Header.Get("Sec-WebSocket-Protocol"); token != "" {
+			// the web UI sends its token as the subprotocol, which
+			// we must accept
+			header = http.Header{"Sec-Websocket-Protocol": {token}}
+		}
+		conn, err := upgrader.Upgrade(w, r, header)
+		if err != nil {
+			// the upgrader has already replied
+			util.Debugf("Unable to open WebSocket: %v", err)
+			return
+		}
+
+		sock := &socket{
+			conn:     conn,
+			streamer: s,
+			svr:      sp,
+			acct:     acct,
+			subs:     map[any]func(){},
+			done:     make(chan struct{}),
+		}
+		defer sock.close()
+		go sock.ping()
+
+		query := r.URL.Query()
+		if name := query.Get("stream"); name != "" {
+			sock.subscribe(name, query.Get("tag"), query.Get("list"))
+		}
+		sock.read()
+	}
+}
+
+// read handles the client's messages until the connection closes.
+func (sock *socket) read() {
+	for {
+		_, data, err := sock.conn.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				util.Debugf("WebSocket closed: %v", err)
+			}
+			return
+		}
+		var msg socketMessage
+		err = json.Unmarshal(data, &msg)
+		if err != nil {
+			sock.fail(errors.Wrap(err, "Invalid message"), http.StatusBadRequest)
+			continue
+		}
+		switch msg.Type {
+		case "subscribe":
+			sock.subscribe(msg.Stream, msg.Tag, msg.List)
+		case "unsubscribe":
+			sock.unsubscribe(msg.Stream, msg.Tag, msg.List)
+		default:
+			sock.fail(errors.New("Unknown message type: "+msg.Type), http.StatusBadRequest)
+		}
+	}
+}
+
+func (sock *socket) subscribe(name, tag, list string) {
+	key, err := streamKey(sock.svr.Context(), sock.svr, sock.acct, name, tag, list)
+	if err != nil {
+		sock.fail(err, streamStatus(err))
+		return
+	}
+	if _, ok := sock.subs[key]; ok {
+		return
+	}
+	events, dereg := sock.streamer.registerStreamerFor(key)
+	sock.subs[key] = dereg
+
+	stream := []string{name}
+	if ts, ok := key.(timelineStream); ok && ts.Param != "" {
+		stream = append(stream, ts.Param)
+	}
+	go func() {
+		// the channel closes when the stream is unsubscribed
+		for e := range events {
+			if strings.HasPrefix(e.Name, ":") {
+				// SSE comments, we ping the socket ourselves
+				continue
+			}
+			sock.send(map[string]any{"stream": stream, "event": e.Name, "payload": e.Data})
+		}
+	}()
+}
+
+func (sock *socket) unsubscribe(name, tag, list string) {
+	key, err := streamKey(sock.svr.Context(), sock.svr, sock.acct, name, tag, list)
+	if err != nil {
+		sock.fail(err, streamStatus(err))
+		return
+	}
+	if dereg, ok := sock.subs[key]; ok {
+		dereg()
+		delete(sock.subs, key)
+	}
+}
+
+// fail tells the client why its request failed without closing
+// the connection.
+func (sock *socket) fail(err error, status int) {
+	sock.send(map[string]any{"error": err.Error(), "status": status})
+}
+
+func (sock *socket) send(msg map[string]any) {
+	sock.mu.Lock()
+	defer sock.mu.Unlock()
+	_ = sock.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	err := sock.conn.WriteJSON(msg)
+	if err != nil {
+		// the reader will notice the connection is gone
+		util.Debugf("Unable to write to WebSocket: %v", err)
+	}
+}
+
+// ping keeps the connection alive and closes it when the server
+// shuts down.
+func (sock *socket) ping() {
+	ticker := time.NewTicker(SocketPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-sock.done:
+			return
+		case <-sock.svr.Context().Done():
+			_ = sock.conn.Close()
+			return
+		case <-ticker.C:
+			err := sock.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second))
+			if err != nil {
+				_ = sock.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+func (sock *socket) close() {
+	for _, dereg := range sock.subs {
+		dereg()
+	}
+	close(sock.done)
+	_ = sock.conn.Close()
+}
@@ -7,6 +7,9 @@ import (
 	"sync"
 	"time"
 
+	"github.com/contribsys/sparq"
+	"github.com/contribsys/sparq/activitypub"
+	"github.com/contribsys/sparq/clientapi"
 	"github.com/contribsys/sparq/faktory"
 	"github.com/contribsys/sparq/jobrunner"
 	"github.com/contribsys/sparq/util"
@@ -56,6 +59,10 @@ func (s *Service) Context() context.Context {
 	return s.ctx
 }
 
+func (s *Service) Jobs() sparq.JobService {
+	return s.JobRunner
+}
+
 func (s *Service) MediaRoot() string {
 	return s.StorageDirectory + "/media"
 }
@@ -83,11 +90,13 @@ func NewService(db *sqlx.DB, opts Options) (*Service, error) {
 	s.JobServer = js
 	s.FaktoryUI = faktoryui.NewWeb(js, opts.Binding)
 	s.AdminUI = adminui.NewWeb(js.Manager(), opts.Binding)
-	s.JobRunner = jobrunner.NewJobRunner(js.Manager(), jobrunner.Options{
+	s.JobRunner = jobrunner.NewJobRunner(js, jobrunner.Options{
 		Concurrency: 1,
 		Queues:      []string{"high", "default", "low"},
 	})
 	adminui.Register(s.JobRunner)
+	activitypub.Register(s)
+	clientapi.Register(s)
 	return s, nil
 }
 
@@ -22,6 +22,8 @@ func BuildWeb(s *Service) *http.Server {
 	web.IntegrateOauth(s, root)
 	apiv1 := root.PathPrefix("/api/v1").Subrouter()
 	clientapi.AddPublicEndpoints(s, apiv1)
+	apiv2 := root.PathPrefix("/api/v2").Subrouter()
+	clientapi.AddV2Endpoints(s, apiv2)
 	public.AddPublicEndpoints(s, root)
 	// s.FaktoryUI.Embed(root, "/faktory")
 	// s.AdminUI.Embed(root, "/admin")
@@ -0,0 +1,11 @@
+-- +goose Up
+
+-- Mastodon clients expect numeric account IDs, remote actors
+-- get a snowflake so they can't collide with local accounts.
+ALTER TABLE actors ADD COLUMN MastodonId integer;
+UPDATE actors SET MastodonId = rowid << 22 WHERE MastodonId IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS actors_mastodon_id ON actors(MastodonId);
+
+-- +goose Down
+DROP INDEX actors_mastodon_id;
+ALTER TABLE actors DROP COLUMN MastodonId;
@@ -0,0 +1,10 @@
+-- +goose Up
+
+-- When we last fetched the remote actor, so stale actors can be refreshed.
+ALTER TABLE actors ADD COLUMN FetchedAt DATETIME;
+UPDATE actors SET FetchedAt = CreatedAt WHERE FetchedAt IS NULL;
+CREATE INDEX IF NOT EXISTS actors_fetched_at ON actors(FetchedAt);
+
+-- +goose Down
+DROP INDEX actors_fetched_at;
+ALTER TABLE actors DROP COLUMN FetchedAt;
@@ -0,0 +1,27 @@
+-- +goose Up
+
+-- Lists let an account split the actors it follows into separate timelines.
+create table if not exists `lists` (
+  Id integer not null primary key autoincrement,
+  AccountId integer not null,
+  Title string not null,
+  RepliesPolicy string not null default 'list', -- followed, list or none
+  Exclusive boolean not null default false,     -- hide members from the home timeline
+  CreatedAt timestamp not null default current_timestamp,
+  UpdatedAt timestamp not null default current_timestamp,
+  foreign key (AccountId) references accounts(Id) on delete cascade
+);
+create index idx_lists_account on lists(AccountId);
+
+create table if not exists `list_accounts` (
+  ListId integer not null,
+  ActorId string not null,
+  CreatedAt timestamp not null default current_timestamp,
+  primary key (ListId, ActorId),
+  foreign key (ListId) references lists(Id) on delete cascade
+);
+create index idx_list_accounts_actor on list_accounts(ActorId);
+
+-- +goose Down
+drop table list_accounts;
+drop table lists;
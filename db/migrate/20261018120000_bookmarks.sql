@@ -0,0 +1,18 @@
+-- +goose Up
+
+-- Bookmarks are private to the account so they aren't federated.
+create table if not exists `actor_bookmarks` (
+  ActorId string not null,
+  ObjectId string not null,
+  CreatedAt DATETIME NOT NULL DEFAULT (STRFTIME('%Y-%m-%d %H:%M:%f', 'NOW')),
+  primary key (ActorId, ObjectId)
+);
+
+-- An actor can favourite or reblog a toot only once.
+create unique index if not exists actor_favorites_actor_object on actor_favorites(ActorId, ObjectId);
+create unique index if not exists actor_reblogs_actor_object on actor_reblogs(ActorId, ObjectId);
+
+-- +goose Down
+drop index actor_reblogs_actor_object;
+drop index actor_favorites_actor_object;
+drop table actor_bookmarks;
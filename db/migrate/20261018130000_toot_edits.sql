@@ -0,0 +1,17 @@
+-- +goose Up
+
+-- Each revision of an edited toot, oldest first. The original
+-- version is recorded when the toot is first edited.
+create table if not exists `toot_edits` (
+  Id integer not null primary key autoincrement,
+  Sid string not null,
+  Summary string not null default '',
+  Content string not null,
+  Lang string default 'en',
+  CreatedAt timestamp not null default current_timestamp,
+  foreign key (Sid) references toots(Sid) on delete cascade
+);
+create index if not exists idx_toot_edits_sid on toot_edits(Sid);
+
+-- +goose Down
+drop table toot_edits;
@@ -0,0 +1,40 @@
+-- +goose Up
+
+-- Polls attached to local and remote toots. Vote counts are kept
+-- on the poll so we can mirror the counts of remote polls.
+create table if not exists `polls` (
+  Id integer not null primary key autoincrement,
+  Sid string not null,
+  ExpiresAt timestamp,
+  Multiple boolean not null default 0,
+  HideTotals boolean not null default 0,
+  VotersCount integer not null default 0,
+  ClosedAt timestamp,
+  CreatedAt timestamp not null default current_timestamp,
+  unique (Sid),
+  foreign key (Sid) references toots(Sid) on delete cascade
+);
+create table if not exists `poll_options` (
+  PollId integer not null,
+  Position integer not null,
+  Title string not null,
+  VotesCount integer not null default 0,
+  primary key (PollId, Position),
+  foreign key (PollId) references polls(Id) on delete cascade
+);
+-- Uri is the id of the remote Note which cast the vote.
+create table if not exists `poll_votes` (
+  Id integer not null primary key autoincrement,
+  PollId integer not null,
+  Position integer not null,
+  ActorId string not null,
+  Uri string,
+  CreatedAt timestamp not null default current_timestamp,
+  unique (PollId, Position, ActorId),
+  foreign key (PollId) references polls(Id) on delete cascade
+);
+
+-- +goose Down
+drop table poll_votes;
+drop table poll_options;
+drop table polls;
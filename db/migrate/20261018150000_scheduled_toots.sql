@@ -0,0 +1,20 @@
+-- +goose Up
+
+-- Toots waiting to be published. Params holds the toot as it was
+-- submitted so it can be published unchanged at ScheduledAt.
+create table if not exists `scheduled_toots` (
+  Id integer not null primary key autoincrement,
+  AccountId integer not null,
+  ScheduledAt timestamp not null,
+  Params string not null default '{}',
+  CreatedAt timestamp not null default current_timestamp,
+  foreign key (AccountId) references accounts(Id) on delete cascade
+);
+create index if not exists idx_scheduled_toots_account on scheduled_toots(AccountId);
+
+-- Media attached to a scheduled toot must not be purged as orphaned.
+alter table toot_medias add column ScheduledId integer;
+
+-- +goose Down
+alter table toot_medias drop column ScheduledId;
+drop table scheduled_toots;
@@ -0,0 +1,10 @@
+-- +goose Up
+
+-- Replies are looked up by their parent's URI to build threads.
+create index if not exists idx_toots_in_reply_to on toots(InReplyTo);
+-- When we last fetched the replies of a remote toot.
+alter table toots add column RepliesFetchedAt timestamp;
+
+-- +goose Down
+alter table toots drop column RepliesFetchedAt;
+drop index idx_toots_in_reply_to;
@@ -0,0 +1,20 @@
+-- +goose Up
+
+-- The actors mentioned in each toot, local and remote.
+create table if not exists `toot_mentions` (
+  Sid string not null,
+  ActorId string not null,
+  primary key (Sid, ActorId),
+  foreign key (Sid) references toots(Sid) on delete cascade
+);
+create index if not exists idx_toot_mentions_actor on toot_mentions(ActorId);
+
+-- Content is rendered HTML, Text keeps what the author wrote
+-- so local toots can be edited.
+alter table toots add column Text string not null default '';
+alter table toot_edits add column Text string not null default '';
+
+-- +goose Down
+alter table toot_edits drop column Text;
+alter table toots drop column Text;
+drop table toot_mentions;
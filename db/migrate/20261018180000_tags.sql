@@ -0,0 +1,39 @@
+-- +goose Up
+
+-- Hashtags which have been followed or have trended. Toots refer
+-- to tags by name in toot_tags, so a tag only gets a row here once
+-- it is needed.
+create table if not exists `tags` (
+  Id integer primary key,
+  Name string not null,
+  Trendable boolean not null default false, -- approved by a moderator
+  ReviewedAt timestamp,
+  RequestedReviewAt timestamp,
+  CreatedAt timestamp not null default current_timestamp,
+  unique (Name)
+);
+
+create table if not exists `account_tags` (
+  AccountId integer not null,
+  TagId integer not null,
+  CreatedAt timestamp not null default current_timestamp,
+  primary key (AccountId, TagId),
+  foreign key (AccountId) references accounts(Id) on delete cascade,
+  foreign key (TagId) references tags(Id) on delete cascade
+);
+create index idx_account_tags_tag on account_tags(TagId);
+
+-- The currently trending tags, rebuilt periodically.
+create table if not exists `tag_trends` (
+  TagId integer primary key,
+  Score real not null,
+  Uses integer not null,
+  Accounts integer not null,
+  CreatedAt timestamp not null default current_timestamp,
+  foreign key (TagId) references tags(Id) on delete cascade
+);
+
+-- +goose Down
+drop table tag_trends;
+drop table account_tags;
+drop table tags;
@@ -0,0 +1,46 @@
+-- +goose Up
+
+-- The full text index of toots, kept up to date by triggers like
+-- search_fts. Toots from before Text was kept are indexed by
+-- their content.
+CREATE VIRTUAL TABLE IF NOT EXISTS toot_fts USING fts5 (
+    Summary,
+    Text
+);
+
+INSERT INTO toot_fts (rowid, Summary, Text)
+SELECT rowid, coalesce(Summary, ''), CASE WHEN Text = '' THEN Content ELSE Text END FROM toots;
+
+-- +goose StatementBegin
+CREATE TRIGGER IF NOT EXISTS toots_fts_insert AFTER INSERT ON toots
+BEGIN
+    INSERT INTO toot_fts (rowid, Summary, Text)
+    VALUES (new.rowid,
+            coalesce(new.Summary, ''),
+            CASE WHEN new.Text = '' THEN new.Content ELSE new.Text END);
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER IF NOT EXISTS toots_fts_delete AFTER DELETE ON toots
+BEGIN
+    DELETE FROM toot_fts WHERE rowid=old.rowid;
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER IF NOT EXISTS toots_fts_update AFTER UPDATE OF Summary, Content, Text ON toots
+BEGIN
+    DELETE FROM toot_fts WHERE rowid=old.rowid;
+    INSERT INTO toot_fts (rowid, Summary, Text)
+    VALUES (new.rowid,
+            coalesce(new.Summary, ''),
+            CASE WHEN new.Text = '' THEN new.Content ELSE new.Text END);
+END;
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TRIGGER toots_fts_update;
+DROP TRIGGER toots_fts_delete;
+DROP TRIGGER toots_fts_insert;
+DROP TABLE toot_fts;
@@ -0,0 +1,40 @@
+-- +goose Up
+
+-- Filters hide or warn about toots matching any of their keywords
+-- or statuses, in the timelines listed in Context.
+create table if not exists `filters` (
+  Id integer primary key,
+  AccountId integer not null,
+  Title string not null,
+  Context string not null, -- comma separated: home,notifications,public,thread,account
+  Action string not null default 'warn', -- warn or hide
+  ExpiresAt timestamp,
+  CreatedAt timestamp not null default current_timestamp,
+  UpdatedAt timestamp not null default current_timestamp,
+  foreign key (AccountId) references accounts(Id) on delete cascade
+);
+create index idx_filters_account on filters(AccountId);
+
+create table if not exists `filter_keywords` (
+  Id integer primary key,
+  FilterId integer not null,
+  Keyword string not null,
+  WholeWord boolean not null default true,
+  CreatedAt timestamp not null default current_timestamp,
+  foreign key (FilterId) references filters(Id) on delete cascade
+);
+create index idx_filter_keywords_filter on filter_keywords(FilterId);
+
+create table if not exists `filter_statuses` (
+  Id integer primary key,
+  FilterId integer not null,
+  Sid string not null,
+  CreatedAt timestamp not null default current_timestamp,
+  unique (FilterId, Sid),
+  foreign key (FilterId) references filters(Id) on delete cascade
+);
+
+-- +goose Down
+drop table filter_statuses;
+drop table filter_keywords;
+drop table filters;
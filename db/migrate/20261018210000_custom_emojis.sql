@@ -0,0 +1,23 @@
+-- +goose Up
+
+-- Custom emoji uploaded by an admin, with Domain '', or cached from
+-- remote servers. Images are stored under the media root at Path,
+-- which is empty until a remote emoji has been downloaded.
+create table if not exists `custom_emojis` (
+  Id integer primary key,
+  Shortcode string not null,
+  Domain string not null default '',
+  Uri string not null,
+  ImageUrl string not null default '', -- the remote image
+  Path string not null default '',
+  MimeType string not null default '',
+  Category string not null default '',
+  VisibleInPicker boolean not null default true,
+  Disabled boolean not null default false,
+  CreatedAt timestamp not null default current_timestamp,
+  UpdatedAt timestamp not null default current_timestamp,
+  unique (Shortcode, Domain)
+);
+
+-- +goose Down
+drop table custom_emojis;
@@ -0,0 +1,34 @@
+-- +goose Up
+
+-- Blocks are made by our accounts or delivered to us by remote
+-- actors blocking one of our accounts. Id is the Block activity.
+create table if not exists `actor_blocks` (
+  Id string primary key,
+  ActorId string not null,
+  TargetActorId string not null,
+  CreatedAt timestamp not null default current_timestamp,
+  unique (ActorId, TargetActorId)
+);
+create index idx_actor_blocks_target on actor_blocks(TargetActorId);
+
+-- Mutes are private to our accounts and never federated.
+create table if not exists `actor_mutes` (
+  ActorId string not null,
+  TargetActorId string not null,
+  HideNotifications boolean not null default true,
+  ExpiresAt timestamp,
+  CreatedAt timestamp not null default current_timestamp,
+  primary key (ActorId, TargetActorId)
+);
+
+create table if not exists `actor_domain_blocks` (
+  ActorId string not null,
+  Domain string not null,
+  CreatedAt timestamp not null default current_timestamp,
+  primary key (ActorId, Domain)
+);
+
+-- +goose Down
+drop table actor_domain_blocks;
+drop table actor_mutes;
+drop table actor_blocks;
@@ -0,0 +1,11 @@
+-- +goose Up
+
+-- Note holds the rendered HTML of the plain text NoteSource.
+alter table account_profiles add column NoteSource string not null default '';
+alter table account_profiles add column Bot boolean not null default false;
+alter table account_profiles add column Discoverable boolean not null default true;
+
+-- +goose Down
+alter table account_profiles drop column Discoverable;
+alter table account_profiles drop column Bot;
+alter table account_profiles drop column NoteSource;
@@ -0,0 +1,41 @@
+-- +goose Up
+
+-- Instance-wide settings which can be changed while running.
+create table if not exists `instance_settings` (
+  Name string primary key,
+  Value string not null
+);
+insert into instance_settings (Name, Value) values ('RegistrationMode', 'approval');
+
+-- Invite codes let people sign up when registrations are
+-- invite-only and skip the approval queue otherwise.
+create table if not exists `invites` (
+  Id integer primary key,
+  Code string not null,
+  AccountId integer not null,
+  MaxUses integer,
+  Uses integer not null default 0,
+  ExpiresAt timestamp,
+  CreatedAt timestamp not null default current_timestamp,
+  unique (Code),
+  foreign key (AccountId) references accounts(Id) on delete cascade
+);
+
+-- Accounts which signed up rather than being created by an admin.
+-- They can't be used until confirmed and approved.
+create table if not exists `account_registrations` (
+  AccountId integer primary key,
+  Reason string not null default "",
+  InviteId integer,
+  ConfirmationToken string,
+  ConfirmedAt timestamp,
+  ApprovedAt timestamp,
+  CreatedAt timestamp not null default current_timestamp,
+  foreign key (AccountId) references accounts(Id) on delete cascade
+);
+create unique index idx_account_registrations_token on account_registrations(ConfirmationToken);
+
+-- +goose Down
+drop table account_registrations;
+drop table invites;
+drop table instance_settings;
@@ -0,0 +1,98 @@
+-- +goose Up
+
+-- Accepted follows of and by each actor, keyed by IRI and kept up
+-- to date by triggers on actor_following.
+create table if not exists `follow_counts` (
+  ActorId string primary key,
+  FollowersCount integer not null default 0,
+  FollowingCount integer not null default 0
+);
+
+-- Undeleted toots and boosts by each local account or remote
+-- actor, keyed by toots.ActorId and kept up to date by triggers
+-- on toots.
+create table if not exists `status_counts` (
+  ActorId integer primary key,
+  StatusesCount integer not null default 0,
+  LastStatusAt timestamp
+);
+
+insert into follow_counts (ActorId, FollowersCount)
+select TargetActorId, count(*) from actor_following
+where State = 'accepted' group by TargetActorId;
+
+insert into follow_counts (ActorId, FollowingCount)
+select ActorId, count(*) from actor_following
+where State = 'accepted' group by ActorId
+on conflict (ActorId) do update set FollowingCount = excluded.FollowingCount;
+
+insert into status_counts (ActorId, StatusesCount, LastStatusAt)
+select ActorId, count(*), max(CreatedAt) from toots
+where DeletedAt is null group by ActorId;
+
+-- +goose StatementBegin
+CREATE TRIGGER IF NOT EXISTS follow_counts_insert AFTER INSERT ON actor_following
+WHEN new.State = 'accepted'
+BEGIN
+    INSERT INTO follow_counts (ActorId, FollowersCount) VALUES (new.TargetActorId, 1)
+    ON CONFLICT (ActorId) DO UPDATE SET FollowersCount = FollowersCount + 1;
+    INSERT INTO follow_counts (ActorId, FollowingCount) VALUES (new.ActorId, 1)
+    ON CONFLICT (ActorId) DO UPDATE SET FollowingCount = FollowingCount + 1;
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER IF NOT EXISTS follow_counts_accept AFTER UPDATE OF State ON actor_following
+WHEN old.State != 'accepted' AND new.State = 'accepted'
+BEGIN
+    INSERT INTO follow_counts (ActorId, FollowersCount) VALUES (new.TargetActorId, 1)
+    ON CONFLICT (ActorId) DO UPDATE SET FollowersCount = FollowersCount + 1;
+    INSERT INTO follow_counts (ActorId, FollowingCount) VALUES (new.ActorId, 1)
+    ON CONFLICT (ActorId) DO UPDATE SET FollowingCount = FollowingCount + 1;
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER IF NOT EXISTS follow_counts_delete AFTER DELETE ON actor_following
+WHEN old.State = 'accepted'
+BEGIN
+    UPDATE follow_counts SET FollowersCount = max(FollowersCount - 1, 0) WHERE ActorId = old.TargetActorId;
+    UPDATE follow_counts SET FollowingCount = max(FollowingCount - 1, 0) WHERE ActorId = old.ActorId;
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER IF NOT EXISTS status_counts_insert AFTER INSERT ON toots
+WHEN new.DeletedAt IS NULL
+BEGIN
+    INSERT INTO status_counts (ActorId, StatusesCount, LastStatusAt) VALUES (new.ActorId, 1, new.CreatedAt)
+    ON CONFLICT (ActorId) DO UPDATE SET StatusesCount = StatusesCount + 1,
+        LastStatusAt = max(coalesce(LastStatusAt, ''), excluded.LastStatusAt);
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER IF NOT EXISTS status_counts_delete AFTER DELETE ON toots
+WHEN old.DeletedAt IS NULL
+BEGIN
+    UPDATE status_counts SET StatusesCount = max(StatusesCount - 1, 0) WHERE ActorId = old.ActorId;
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER IF NOT EXISTS status_counts_tombstone AFTER UPDATE OF DeletedAt ON toots
+WHEN old.DeletedAt IS NULL AND new.DeletedAt IS NOT NULL
+BEGIN
+    UPDATE status_counts SET StatusesCount = max(StatusesCount - 1, 0) WHERE ActorId = old.ActorId;
+END;
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TRIGGER status_counts_tombstone;
+DROP TRIGGER status_counts_delete;
+DROP TRIGGER status_counts_insert;
+DROP TRIGGER follow_counts_delete;
+DROP TRIGGER follow_counts_accept;
+DROP TRIGGER follow_counts_insert;
+DROP TABLE status_counts;
+DROP TABLE follow_counts;
@@ -0,0 +1,35 @@
+-- +goose Up
+
+-- Threads of direct toots, named by the URI of the thread's first
+-- toot. Direct toots from before conversations were kept aren't
+-- part of one.
+create table if not exists `conversations` (
+  Id integer primary key,
+  Uri string not null,
+  CreatedAt timestamp not null default current_timestamp,
+  unique (Uri)
+);
+alter table toots add column ConversationId integer;
+create index if not exists idx_toots_conversation on toots(ConversationId);
+
+-- Each local participant's view of a conversation. LastTootId is
+-- the rowid of the newest toot so conversations are listed by
+-- activity. Removed conversations come back with the next toot.
+create table if not exists `account_conversations` (
+  ConversationId integer not null,
+  ActorId string not null,
+  LastTootId integer not null default 0,
+  Unread boolean not null default false,
+  Removed boolean not null default false,
+  Muted boolean not null default false,
+  UpdatedAt timestamp not null default current_timestamp,
+  primary key (ConversationId, ActorId),
+  foreign key (ConversationId) references conversations(Id) on delete cascade
+);
+create index if not exists idx_account_conversations_actor on account_conversations(ActorId, LastTootId);
+
+-- +goose Down
+drop table account_conversations;
+drop index idx_toots_conversation;
+alter table toots drop column ConversationId;
+drop table conversations;
@@ -125,3 +125,9 @@ func (s *Server) startTasks(ctx context.Context) {
 	ts.Run(ctx)
 	s.taskRunner = ts
 }
+
+// Periodic pushes a job of the given type every N seconds
+// so application code can run scheduled work.
+func (s *Server) Periodic(jobtype string, every int64) {
+	s.taskRunner.AddTask(every, &periodicJob{jobtype: jobtype, m: s.mgr})
+}
@@ -5,6 +5,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/contribsys/faktory/client"
 	"github.com/contribsys/faktory/manager"
 )
 
@@ -33,3 +34,29 @@ func (r *reservationReaper) Stats(context.Context) map[string]interface{} {
 		"reaped": atomic.LoadInt64(&r.count),
 	}
 }
+
+// periodicJob pushes a new job to Faktory each time it runs.
+type periodicJob struct {
+	jobtype string
+	m       manager.Manager
+	count   int64
+}
+
+func (p *periodicJob) Name() string {
+	return p.jobtype
+}
+
+func (p *periodicJob) Execute(ctx context.Context) error {
+	err := p.m.Push(ctx, client.NewJob(p.jobtype))
+	if err != nil {
+		return err
+	}
+	atomic.AddInt64(&p.count, 1)
+	return nil
+}
+
+func (p *periodicJob) Stats(context.Context) map[string]interface{} {
+	return map[string]interface{}{
+		"pushed": atomic.LoadInt64(&p.count),
+	}
+}
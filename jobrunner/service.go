@@ -6,11 +6,13 @@ import (
 	"github.com/contribsys/faktory/client"
 	"github.com/contribsys/faktory/manager"
 	"github.com/contribsys/sparq"
+	"github.com/contribsys/sparq/faktory"
 	"github.com/contribsys/sparq/util"
 )
 
 type JobRunner struct {
 	mgr  manager.Manager
+	svr  *faktory.Server
 	exec *Runner
 }
 
@@ -19,11 +21,12 @@ type Options struct {
 	Queues      []string
 }
 
-func NewJobRunner(mgr manager.Manager, opts Options) *JobRunner {
+func NewJobRunner(svr *faktory.Server, opts Options) *JobRunner {
+	mgr := svr.Manager()
 	exec := NewRunner(mgr)
 	exec.Concurrency = opts.Concurrency
 	exec.Queues = opts.Queues
-	return &JobRunner{mgr, exec}
+	return &JobRunner{mgr, svr, exec}
 }
 
 func (jr *JobRunner) Run(ctx context.Context) error {
@@ -43,3 +46,7 @@ func (jr *JobRunner) Push(ctx context.Context, job *client.Job) error {
 func (jr *JobRunner) Register(jobtype string, fn sparq.PerformFunc) {
 	jr.exec.Register(jobtype, fn)
 }
+
+func (jr *JobRunner) Periodic(jobtype string, every int64) {
+	jr.svr.Periodic(jobtype, every)
+}
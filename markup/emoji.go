@@ -0,0 +1,44 @@
+package markup
+
+import (
+	"regexp"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	shortcodeRegexp = regexp.MustCompile(`:([a-zA-Z0-9_]{2,}):`)
+	nameRegexp      = regexp.MustCompile(`^[a-zA-Z0-9_]{2,}$`)
+)
+
+// IsShortcode is true if the name, without the colons, is a valid
+// custom emoji shortcode.
+func IsShortcode(name string) bool {
+	return nameRegexp.MatchString(name)
+}
+
+// Shortcodes returns the unique custom emoji shortcodes in the text,
+// without the colons. A shortcode can't touch a letter or digit so
+// times like 12:30:45 are ignored, but emoji may be next to each
+// other as in :blobcat::blobfox:.
+func Shortcodes(text string) []string {
+	seen := map[string]bool{}
+	codes := []string{}
+	for _, match := range shortcodeRegexp.FindAllStringSubmatchIndex(text, -1) {
+		before, _ := utf8.DecodeLastRuneInString(text[:match[0]])
+		after, _ := utf8.DecodeRuneInString(text[match[1]:])
+		if isAlnum(before) || isAlnum(after) {
+			continue
+		}
+		code := text[match[2]:match[3]]
+		if !seen[code] {
+			seen[code] = true
+			codes = append(codes, code)
+		}
+	}
+	return codes
+}
+
+func isAlnum(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
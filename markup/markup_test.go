@@ -0,0 +1,73 @@
+package markup
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRender(t *testing.T) {
+	mentions := map[string]*Mention{
+		"bob@remote.example": {Handle: "bob@remote.example", Nick: "bob", IRI: "https://remote.example/users/bob", URL: "https://remote.example/@bob"},
+		"admin":              {Handle: "admin", Nick: "admin", IRI: "https://localhost.dev/users/admin", URL: "https://localhost.dev/@admin"},
+	}
+	tests := map[string]string{
+		"Hello world":         "<p>Hello world</p>",
+		"<b>bold</b> & brave": "<p>&lt;b&gt;bold&lt;/b&gt; &amp; brave</p>",
+		"One\nTwo\n\nThree":   "<p>One<br />Two</p><p>Three</p>",
+		"Loving #Sparq!":      `<p>Loving <a href="https://localhost.dev/tags/sparq" class="mention hashtag" rel="tag">#<span>Sparq</span></a>!</p>`,
+		"Hi @bob@remote.example, meet @admin": `<p>Hi <span class="h-card"><a href="https://remote.example/@bob" class="u-url mention">@<span>bob</span></a></span>, ` +
+			`meet <span class="h-card"><a href="https://localhost.dev/@admin" class="u-url mention">@<span>admin</span></a></span></p>`,
+		"Hi @nobody":                         "<p>Hi @nobody</p>",
+		"See https://example.com/a?b=1&c=2.": `<p>See <a href="https://example.com/a?b=1&amp;c=2" target="_blank" rel="nofollow noopener noreferrer">https://example.com/a?b=1&amp;c=2</a>.</p>`,
+	}
+	for text, expected := range tests {
+		assert.Equal(t, expected, Render(text, "localhost.dev", mentions), text)
+	}
+
+	assert.Equal(t, `<a href="https://example.com" target="_blank" rel="nofollow noopener noreferrer">https://example.com</a>`,
+		RenderField("https://example.com", "localhost.dev"))
+	assert.Equal(t, "Fish &amp; &lt;b&gt;chips&lt;/b&gt;", RenderField("Fish &\n<b>chips</b>", "localhost.dev"))
+	assert.Equal(t, []string{"bob@remote.example", "admin"}, Mentions("@bob@remote.example @admin: cc @admin, not admin@localhost.dev"))
+	assert.Equal(t, []string{"foo", "bar"}, Tags("#foo, #bar. #1 #foo-bar"))
+	assert.True(t, IsTag("Sparq"))
+	assert.False(t, IsTag("foo-bar"))
+}
+
+func TestSanitize(t *testing.T) {
+	tests := map[string]string{
+		"<p>Hello <b>world</b></p>":                                      "<p>Hello <b>world</b></p>",
+		"<p onclick=\"alert(1)\">Hi</p>":                                 "<p>Hi</p>",
+		"<script>alert(1)</script><p>Hi</p>":                             "<p>Hi</p>",
+		"<div><img src=x onerror=alert(1)>Text</div>":                    "Text",
+		`<a href="javascript:alert(1)">x</a>`:                            `<a target="_blank" rel="nofollow noopener noreferrer">x</a>`,
+		`<a href="https://a.example/" class="u-url mention evil">@a</a>`: `<a href="https://a.example/" class="u-url mention" target="_blank" rel="nofollow noopener noreferrer">@a</a>`,
+		"<p>Unclosed <em>tags":                                           "<p>Unclosed <em>tags</em></p>",
+		"Stray </p> close":                                               "Stray  close",
+		"1 < 2 &amp; 3 > 2":                                              "1 &lt; 2 &amp; 3 &gt; 2",
+		`<p title="a > b">quoted</p>`:                                    "<p>quoted</p>",
+		"<p>Hi<!-- <script>alert(1)</script> --></p>":                    "<p>Hi</p>",
+		"line<br>break<BR/>":                                             "line<br />break<br />",
+		"<scr<script>ipt>alert(1)</script>":                              "ipt&gt;alert(1)",
+	}
+	for input, expected := range tests {
+		assert.Equal(t, expected, Sanitize(input), input)
+	}
+}
+
+func TestText(t *testing.T) {
+	html := Render("Hi @admin\nsee https://example.com/?a&b\n\n#Bye", "localhost.dev", map[string]*Mention{
+		"admin": {Handle: "admin", Nick: "admin", IRI: "https://localhost.dev/users/admin", URL: "https://localhost.dev/@admin"},
+	})
+	assert.Equal(t, "Hi @admin\nsee https://example.com/?a&b\n\n#Bye", Text(html))
+	assert.Equal(t, "1 < 2", Text(Sanitize("<p>1 &lt; 2</p>")))
+}
+
+func TestShortcodes(t *testing.T) {
+	assert.Equal(t, []string{"blobcat", "blob_fox"}, Shortcodes(":blobcat: hi :blobcat::blob_fox:!"))
+	assert.Equal(t, []string{}, Shortcodes("at 12:30:45 or a:bc: or :x:"))
+	assert.Equal(t, []string{"wave"}, Shortcodes("Bob :wave:\n"))
+	assert.True(t, IsShortcode("blob_cat2"))
+	assert.False(t, IsShortcode("x"))
+	assert.False(t, IsShortcode("blob-cat"))
+}
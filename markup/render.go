@@ -0,0 +1,145 @@
+// Package markup turns the plain text of a toot into the HTML
+// which Mastodon clients expect and sanitizes HTML from remote
+// servers.
+package markup
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+)
+
+// Mention is an account mentioned in a toot.
+type Mention struct {
+	// The handle as written, without the leading @
+	Handle string
+	Nick   string
+	IRI    string
+	URL    string
+}
+
+var (
+	tagRegexp     = regexp.MustCompile(`^#([[:alpha:]][[:word:]]{1,20})$`)
+	mentionRegexp = regexp.MustCompile(`^@([[:word:]]+(?:[.-][[:word:]]+)*)(?:@([[:alnum:]-]+(?:\.[[:alnum:]-]+)+(?::[0-9]+)?))?$`)
+	urlRegexp     = regexp.MustCompile(`^https?://[^\s<>"]+$`)
+	tokenRegexp   = regexp.MustCompile(`\S+`)
+	paraRegexp    = regexp.MustCompile(`\n\s*\n`)
+)
+
+// trailing punctuation which ends a sentence rather than a link
+const trailing = ".,;:!?)'\""
+
+type token struct {
+	kind  string // tag, mention, url or text
+	value string
+}
+
+// tokenize splits a word into its link, if any, and the trailing
+// punctuation after it.
+func tokenize(word string) (token, string) {
+	core := strings.TrimRight(word, trailing)
+	rest := word[len(core):]
+	switch {
+	case tagRegexp.MatchString(core):
+		return token{"tag", core[1:]}, rest
+	case mentionRegexp.MatchString(core):
+		return token{"mention", core[1:]}, rest
+	case urlRegexp.MatchString(core):
+		return token{"url", core}, rest
+	}
+	return token{"text", word}, ""
+}
+
+func scan(text string, fn func(tok token, rest string)) {
+	for _, word := range tokenRegexp.FindAllString(text, -1) {
+		fn(tokenize(word))
+	}
+}
+
+// Tags returns the hashtags in the text, without the #.
+func Tags(text string) []string {
+	tags := []string{}
+	scan(text, func(tok token, rest string) {
+		if tok.kind == "tag" {
+			tags = append(tags, tok.value)
+		}
+	})
+	return tags
+}
+
+// IsTag is true if the name, without the #, is a valid hashtag.
+func IsTag(name string) bool {
+	return tagRegexp.MatchString("#" + name)
+}
+
+// Mentions returns the unique handles mentioned in the text,
+// without the leading @, e.g. "nick" or "nick@remote.example".
+func Mentions(text string) []string {
+	seen := map[string]bool{}
+	handles := []string{}
+	scan(text, func(tok token, rest string) {
+		if tok.kind == "mention" && !seen[tok.value] {
+			seen[tok.value] = true
+			handles = append(handles, tok.value)
+		}
+	})
+	return handles
+}
+
+// Render converts plain text to HTML. URLs, hashtags and the given
+// mentions, keyed by handle, become links. Blank lines separate
+// paragraphs, other line breaks are kept.
+func Render(text string, hostname string, mentions map[string]*Mention) string {
+	var sb strings.Builder
+	text = strings.ReplaceAll(strings.TrimSpace(text), "\r\n", "\n")
+	for _, para := range paraRegexp.Split(text, -1) {
+		sb.WriteString("<p>")
+		for idx, line := range strings.Split(para, "\n") {
+			if idx > 0 {
+				sb.WriteString("<br />")
+			}
+			renderLine(&sb, line, hostname, mentions)
+		}
+		sb.WriteString("</p>")
+	}
+	return sb.String()
+}
+
+// RenderField converts the plain text of a profile field's value to
+// HTML. It links URLs and hashtags like Render but is a single line
+// without a paragraph.
+func RenderField(text string, hostname string) string {
+	var sb strings.Builder
+	renderLine(&sb, strings.Join(strings.Fields(text), " "), hostname, nil)
+	return sb.String()
+}
+
+func renderLine(sb *strings.Builder, line string, hostname string, mentions map[string]*Mention) {
+	last := 0
+	for _, loc := range tokenRegexp.FindAllStringIndex(line, -1) {
+		sb.WriteString(html.EscapeString(line[last:loc[0]]))
+		last = loc[1]
+		tok, rest := tokenize(line[loc[0]:loc[1]])
+		switch tok.kind {
+		case "tag":
+			fmt.Fprintf(sb, `<a href="https://%s/tags/%s" class="mention hashtag" rel="tag">#<span>%s</span></a>`,
+				hostname, strings.ToLower(tok.value), html.EscapeString(tok.value))
+		case "mention":
+			m, ok := mentions[tok.value]
+			if !ok {
+				sb.WriteString(html.EscapeString("@" + tok.value))
+				break
+			}
+			fmt.Fprintf(sb, `<span class="h-card"><a href="%s" class="u-url mention">@<span>%s</span></a></span>`,
+				html.EscapeString(m.URL), html.EscapeString(m.Nick))
+		case "url":
+			fmt.Fprintf(sb, `<a href="%s" target="_blank" rel="nofollow noopener noreferrer">%s</a>`,
+				html.EscapeString(tok.value), html.EscapeString(tok.value))
+		default:
+			sb.WriteString(html.EscapeString(tok.value))
+		}
+		sb.WriteString(html.EscapeString(rest))
+	}
+	sb.WriteString(html.EscapeString(line[last:]))
+}
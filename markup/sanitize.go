@@ -0,0 +1,204 @@
+package markup
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+var (
+	// the elements and attributes which Mastodon allows in toots,
+	// everything else is stripped
+	allowedTags = map[string]map[string]bool{
+		"p":          {},
+		"br":         {},
+		"span":       {"class": true},
+		"a":          {"href": true, "class": true},
+		"del":        {},
+		"s":          {},
+		"pre":        {},
+		"code":       {},
+		"blockquote": {},
+		"b":          {},
+		"strong":     {},
+		"i":          {},
+		"em":         {},
+		"u":          {},
+		"ul":         {},
+		"ol":         {"start": true, "reversed": true},
+		"li":         {"value": true},
+	}
+	voidTags = map[string]bool{"br": true}
+	// elements which are dropped along with their content
+	droppedTags = map[string]bool{"script": true, "style": true, "template": true, "iframe": true, "object": true, "textarea": true, "title": true}
+
+	tagNameRegexp = regexp.MustCompile(`^</?([a-zA-Z][a-zA-Z0-9]*)`)
+	attrRegexp    = regexp.MustCompile(`([^\s"'<>/=]+)(?:\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'=<>` + "`" + `]+)))?`)
+	// microformats and Mastodon's own classes, used by clients
+	// to style mentions and hashtags
+	classRegexp = regexp.MustCompile(`^(h-\S+|p-\S+|u-\S+|dt-\S+|e-\S+|mention|hashtag|ellipsis|invisible)$`)
+)
+
+// Sanitize returns the HTML with only allowed elements and
+// attributes. Links may only use http and https and always open
+// in a new window without a referrer.
+func Sanitize(input string) string {
+	var sb strings.Builder
+	open := []string{}
+	dropping := ""
+
+	for len(input) > 0 {
+		lt := strings.IndexByte(input, '<')
+		if lt < 0 {
+			if dropping == "" {
+				writeText(&sb, input)
+			}
+			break
+		}
+		if dropping == "" {
+			writeText(&sb, input[:lt])
+		}
+		input = input[lt:]
+
+		if strings.HasPrefix(input, "<!--") {
+			end := strings.Index(input, "-->")
+			if end < 0 {
+				break
+			}
+			input = input[end+3:]
+			continue
+		}
+		m := tagNameRegexp.FindStringSubmatch(input)
+		if m == nil {
+			// a bare < is text
+			if dropping == "" {
+				sb.WriteString("&lt;")
+			}
+			input = input[1:]
+			continue
+		}
+		end := tagEnd(input)
+		if end < 0 {
+			break
+		}
+		raw := input[:end+1]
+		input = input[end+1:]
+		name := strings.ToLower(m[1])
+		closing := strings.HasPrefix(raw, "</")
+
+		if dropping != "" {
+			if closing && name == dropping {
+				dropping = ""
+			}
+			continue
+		}
+		if droppedTags[name] {
+			if !closing && !strings.HasSuffix(raw, "/>") {
+				dropping = name
+			}
+			continue
+		}
+		attrs, ok := allowedTags[name]
+		if !ok {
+			continue
+		}
+		if closing {
+			// close the element and any unclosed elements within it
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i] == name {
+					for j := len(open) - 1; j >= i; j-- {
+						sb.WriteString("</" + open[j] + ">")
+					}
+					open = open[:i]
+					break
+				}
+			}
+			continue
+		}
+		sb.WriteString("<" + name)
+		writeAttrs(&sb, name, raw[len(m[0]):], attrs)
+		if voidTags[name] {
+			sb.WriteString(" />")
+			continue
+		}
+		sb.WriteString(">")
+		open = append(open, name)
+	}
+	for i := len(open) - 1; i >= 0; i-- {
+		sb.WriteString("</" + open[i] + ">")
+	}
+	return sb.String()
+}
+
+// writeText normalizes the text's entities so it is safe to output.
+func writeText(sb *strings.Builder, text string) {
+	sb.WriteString(html.EscapeString(html.UnescapeString(text)))
+}
+
+// tagEnd returns the index of the > which closes the tag at the
+// start of the input, skipping any > within quoted attributes.
+func tagEnd(input string) int {
+	var quote byte
+	for i := 1; i < len(input); i++ {
+		c := input[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '>':
+			return i
+		}
+	}
+	return -1
+}
+
+func writeAttrs(sb *strings.Builder, name string, raw string, allowed map[string]bool) {
+	raw = strings.TrimSuffix(strings.TrimSuffix(raw, ">"), "/")
+	for _, m := range attrRegexp.FindAllStringSubmatch(raw, -1) {
+		key := strings.ToLower(m[1])
+		if !allowed[key] {
+			continue
+		}
+		value := html.UnescapeString(m[2] + m[3] + m[4])
+		switch key {
+		case "href":
+			lower := strings.ToLower(strings.TrimSpace(value))
+			if !strings.HasPrefix(lower, "https://") && !strings.HasPrefix(lower, "http://") {
+				continue
+			}
+		case "class":
+			classes := []string{}
+			for _, class := range strings.Fields(value) {
+				if classRegexp.MatchString(class) {
+					classes = append(classes, class)
+				}
+			}
+			if len(classes) == 0 {
+				continue
+			}
+			value = strings.Join(classes, " ")
+		}
+		sb.WriteString(" " + key + `="` + html.EscapeString(value) + `"`)
+	}
+	if name == "a" {
+		sb.WriteString(` target="_blank" rel="nofollow noopener noreferrer"`)
+	}
+}
+
+var (
+	breakRegexp = regexp.MustCompile(`(?i)<br\s*/?>`)
+	paraBreak   = regexp.MustCompile(`(?i)</p>\s*<p[^>]*>`)
+	anyTag      = regexp.MustCompile(`<[^>]*>`)
+)
+
+// Text returns the plain text of sanitized HTML, keeping line and
+// paragraph breaks.
+func Text(input string) string {
+	input = breakRegexp.ReplaceAllString(input, "\n")
+	input = paraBreak.ReplaceAllString(input, "\n\n")
+	input = anyTag.ReplaceAllString(input, "")
+	return strings.TrimSpace(html.UnescapeString(input))
+}
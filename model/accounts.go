@@ -2,6 +2,7 @@ package model
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/contribsys/sparq/db"
@@ -40,20 +41,51 @@ type Account struct {
 	*AccountSecurity
 }
 
+// HasRole is true if the account has any of the given roles.
+func (a *Account) HasRole(role RoleMask) bool {
+	return a.RoleMask&role != 0
+}
+
 func (a *Account) URI() string {
 	return fmt.Sprintf("https://%s/@%s", db.InstanceHostname, a.Nick)
 }
 
+// The ActivityPub actor ID for this account
+func (a *Account) IRI() string {
+	return LocalIRI(a.Nick)
+}
+
+func LocalIRI(nick string) string {
+	return fmt.Sprintf("https://%s/users/%s", db.InstanceHostname, nick)
+}
+
+// LocalNick returns the nick for the given actor IRI if the
+// actor is an account on this instance.
+func LocalNick(iri string) (string, bool) {
+	prefix := fmt.Sprintf("https://%s/users/", db.InstanceHostname)
+	if !strings.HasPrefix(iri, prefix) {
+		return "", false
+	}
+	nick := iri[len(prefix):]
+	if nick == "" || strings.ContainsAny(nick, "/#?") {
+		return "", false
+	}
+	return nick, true
+}
+
 func (a *Account) Created() string {
 	return util.Thens(*a.CreatedAt)
 }
 
 type AccountProfile struct {
-	AccountID uint
-	Note      string
-	Avatar    string
-	Header    string
-	Fields    []AccountField
+	AccountID    uint
+	Note         string
+	NoteSource   string
+	Avatar       string
+	Header       string
+	Bot          bool
+	Discoverable bool
+	Fields       []AccountField
 }
 
 type AccountField struct {
@@ -11,6 +11,8 @@ type Actor struct {
 	PublicKey      string
 	CreatedAt      time.Time
 	Properties     string
+	MastodonId     int64
+	FetchedAt      time.Time
 }
 
 type ActorFollowing struct {
@@ -51,11 +53,11 @@ type OutboxObjects struct {
 }
 
 type ActorNotification struct {
-	Id          int
+	Id          int64
 	Type        string
 	ActorId     string
 	FromActorId string
-	ObjectId    string
+	ObjectId    *string
 	CreatedAt   time.Time
 }
 
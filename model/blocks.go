@@ -0,0 +1,40 @@
+package model
+
+import "time"
+
+type ActorBlock struct {
+	Id            string
+	ActorId       string
+	TargetActorId string
+	CreatedAt     time.Time
+}
+
+type ActorMute struct {
+	ActorId           string
+	TargetActorId     string
+	HideNotifications bool
+	ExpiresAt         *time.Time
+	CreatedAt         time.Time
+}
+
+func (m *ActorMute) IsExpired() bool {
+	return m.ExpiresAt != nil && !m.ExpiresAt.After(time.Now())
+}
+
+type ActorDomainBlock struct {
+	ActorId   string
+	Domain    string
+	CreatedAt time.Time
+}
+
+// BlockedActors selects the IRIs of the actors an actor blocks and
+// those which block it. The actor's IRI must be given twice.
+const BlockedActors = `select TargetActorId from actor_blocks where ActorId = ?
+	union select ActorId from actor_blocks where TargetActorId = ?`
+
+// HiddenActors selects the IRIs of the actors hidden from an actor:
+// those it blocks or mutes and those which block it. The actor's IRI
+// must be given three times.
+const HiddenActors = BlockedActors + `
+	union select TargetActorId from actor_mutes where ActorId = ?
+		and (ExpiresAt is null or ExpiresAt > current_timestamp)`
@@ -0,0 +1,19 @@
+package model
+
+import "time"
+
+type Conversation struct {
+	Id        int64
+	Uri       string
+	CreatedAt time.Time
+}
+
+type AccountConversation struct {
+	ConversationId int64
+	ActorId        string
+	LastTootId     int64
+	Unread         bool
+	Removed        bool
+	Muted          bool
+	UpdatedAt      time.Time
+}
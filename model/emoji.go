@@ -0,0 +1,38 @@
+package model
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/contribsys/sparq/db"
+)
+
+type CustomEmoji struct {
+	Id              uint64
+	Shortcode       string
+	Domain          string
+	Uri             string
+	ImageUrl        string
+	Path            string
+	MimeType        string
+	Category        string
+	VisibleInPicker bool
+	Disabled        bool
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
+
+func (ce *CustomEmoji) IsLocal() bool {
+	return ce.Domain == ""
+}
+
+// IsCached is true once we have a copy of the image to serve.
+func (ce *CustomEmoji) IsCached() bool {
+	return ce.Path != ""
+}
+
+// PublicUri is where we serve the emoji's image, for both local
+// and remote emoji.
+func (ce *CustomEmoji) PublicUri() string {
+	return fmt.Sprintf("https://%s/media/%s", db.InstanceHostname, ce.Path)
+}
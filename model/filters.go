@@ -0,0 +1,74 @@
+package model
+
+import (
+	"strings"
+	"time"
+)
+
+// FilterAction is what happens to a toot which matches a filter.
+type FilterAction string
+
+var (
+	// Show the toot behind a warning naming the filter
+	FilterWarn FilterAction = "warn"
+	// Don't show the toot at all
+	FilterHide FilterAction = "hide"
+)
+
+// FilterContexts are the places where a filter may apply.
+var FilterContexts = []string{"home", "notifications", "public", "thread", "account"}
+
+func ValidFilterContext(value string) bool {
+	for _, fc := range FilterContexts {
+		if fc == value {
+			return true
+		}
+	}
+	return false
+}
+
+type Filter struct {
+	Id        uint64
+	AccountId int64
+	Title     string
+	Context   string
+	Action    FilterAction
+	ExpiresAt *time.Time
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (f *Filter) Contexts() []string {
+	if f.Context == "" {
+		return []string{}
+	}
+	return strings.Split(f.Context, ",")
+}
+
+func (f *Filter) AppliesTo(context string) bool {
+	for _, fc := range f.Contexts() {
+		if fc == context {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Filter) IsExpired() bool {
+	return f.ExpiresAt != nil && !f.ExpiresAt.After(time.Now())
+}
+
+type FilterKeyword struct {
+	Id        uint64
+	FilterId  uint64
+	Keyword   string
+	WholeWord bool
+	CreatedAt time.Time
+}
+
+type FilterStatus struct {
+	Id        uint64
+	FilterId  uint64
+	Sid       string
+	CreatedAt time.Time
+}
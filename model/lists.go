@@ -0,0 +1,39 @@
+package model
+
+import "time"
+
+// RepliesPolicy controls which replies appear in a list timeline.
+type RepliesPolicy string
+
+var (
+	// Show replies to any followed account
+	RepliesFollowed RepliesPolicy = "followed"
+	// Show replies to other members of the list
+	RepliesList RepliesPolicy = "list"
+	// Don't show any replies
+	RepliesNone RepliesPolicy = "none"
+)
+
+func ValidRepliesPolicy(value string) bool {
+	switch RepliesPolicy(value) {
+	case RepliesFollowed, RepliesList, RepliesNone:
+		return true
+	}
+	return false
+}
+
+type List struct {
+	Id            uint64
+	AccountId     int64
+	Title         string
+	RepliesPolicy RepliesPolicy
+	Exclusive     bool
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+type ListAccount struct {
+	ListId    uint64
+	ActorId   string
+	CreatedAt time.Time
+}
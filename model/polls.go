@@ -0,0 +1,35 @@
+package model
+
+import "time"
+
+type Poll struct {
+	Id          uint64
+	Sid         string
+	ExpiresAt   *time.Time
+	Multiple    bool
+	HideTotals  bool
+	VotersCount int64
+	ClosedAt    *time.Time
+	CreatedAt   time.Time
+}
+
+// Expired is true once the poll has closed or passed its expiry.
+func (p *Poll) Expired() bool {
+	return p.ClosedAt != nil || (p.ExpiresAt != nil && p.ExpiresAt.Before(time.Now()))
+}
+
+type PollOption struct {
+	PollId     uint64
+	Position   int
+	Title      string
+	VotesCount int64
+}
+
+type PollVote struct {
+	Id        int64
+	PollId    uint64
+	Position  int
+	ActorId   string
+	Uri       *string
+	CreatedAt time.Time
+}
@@ -0,0 +1,60 @@
+package model
+
+import "time"
+
+type RegistrationMode string
+
+const (
+	// anyone may sign up and use their account once confirmed
+	RegistrationsOpen RegistrationMode = "open"
+	// new accounts must also be approved by a moderator
+	RegistrationsApproval RegistrationMode = "approval"
+	// only people with an invite code may sign up
+	RegistrationsInvite RegistrationMode = "invite"
+)
+
+func ValidRegistrationMode(value string) bool {
+	switch RegistrationMode(value) {
+	case RegistrationsOpen, RegistrationsApproval, RegistrationsInvite:
+		return true
+	}
+	return false
+}
+
+type Invite struct {
+	Id        int64
+	Code      string
+	AccountId int64
+	MaxUses   *int64
+	Uses      int64
+	ExpiresAt *time.Time
+	CreatedAt time.Time
+}
+
+func (i *Invite) IsExpired() bool {
+	return i.ExpiresAt != nil && !i.ExpiresAt.After(time.Now())
+}
+
+// IsUsable is true if someone can still sign up with the invite.
+func (i *Invite) IsUsable() bool {
+	return !i.IsExpired() && (i.MaxUses == nil || i.Uses < *i.MaxUses)
+}
+
+type AccountRegistration struct {
+	AccountId         int64
+	Reason            string
+	InviteId          *int64
+	ConfirmationToken *string
+	ConfirmedAt       *time.Time
+	ApprovedAt        *time.Time
+	CreatedAt         time.Time
+}
+
+func (ar *AccountRegistration) IsPending() bool {
+	return ar.ConfirmedAt == nil || ar.ApprovedAt == nil
+}
+
+// PendingAccount matches the account aliased as "a" if it signed up
+// and hasn't been confirmed and approved yet.
+const PendingAccount = `exists (select 1 from account_registrations ar
+	where ar.AccountId = a.Id and (ar.ConfirmedAt is null or ar.ApprovedAt is null))`
@@ -0,0 +1,32 @@
+package model
+
+import "time"
+
+type Tag struct {
+	Id                uint64
+	Name              string
+	Trendable         bool
+	ReviewedAt        *time.Time
+	RequestedReviewAt *time.Time
+	CreatedAt         time.Time
+}
+
+// RequiresReview is true if the tag has trended but a moderator
+// hasn't yet decided whether it may appear in trends.
+func (t *Tag) RequiresReview() bool {
+	return t.ReviewedAt == nil && t.RequestedReviewAt != nil
+}
+
+type AccountTag struct {
+	AccountId int64
+	TagId     uint64
+	CreatedAt time.Time
+}
+
+type TagTrend struct {
+	TagId     uint64
+	Score     float64
+	Uses      int64
+	Accounts  int64
+	CreatedAt time.Time
+}
@@ -2,7 +2,7 @@ package model
 
 import (
 	"database/sql"
-	"fmt"
+	"strings"
 
 	"github.com/Masterminds/squirrel"
 	"github.com/jmoiron/sqlx"
@@ -19,8 +19,33 @@ type TimelineQuery struct {
 	Local     bool
 	Remote    bool
 	OnlyMedia bool
+	// Leave out boosts, as public timelines do
+	ExcludeBoosts bool
 
-	Visibility PostVisibility
+	// Only toots with this visibility are returned, unless
+	// Visibilities is set.
+	Visibility   PostVisibility
+	Visibilities []PostVisibility
+
+	// HomeFor limits the timeline to the toots of the given local
+	// account, the actors it follows and public toots with the
+	// tags it follows.
+	HomeFor *Account
+
+	// Viewer is the IRI of the account reading the timeline. Toots by
+	// actors it blocks, mutes or whose domain it blocks are left out,
+	// as are toots by actors which block it and boosts of any of them.
+	Viewer string
+
+	// Toots must have any of Tags, all of AllTags and none of
+	// NoneTags. Tags are matched case insensitively.
+	Tags     []string
+	AllTags  []string
+	NoneTags []string
+
+	// Sid limits the timeline to the one toot, to check whether
+	// a new toot belongs in it.
+	Sid string
 
 	db *sqlx.DB
 }
@@ -74,31 +99,111 @@ func TQ(db *sqlx.DB) *TimelineQuery {
 	}
 }
 
+// The actors followed by the given actor IRI.
+const followed = "select TargetActorId from actor_following where ActorId = ? and State = 'accepted'"
+
+// tootActors maps a subquery of actor IRIs to the ActorId used by
+// their toots: the account ID for local accounts or the MastodonId
+// for remote actors. The subquery's arguments must be given twice,
+// after the local IRI prefix.
+func tootActors(iris string) string {
+	return `select a.Id from accounts a where ? || a.Nick in (` + iris + `)
+		union select r.MastodonId from actors r where r.Id in (` + iris + `)`
+}
+
 func (tq *TimelineQuery) Execute() (*QueryResult, error) {
-	if tq.Limit > 50 {
-		tq.Limit = 50
+	if tq.Limit == 0 {
+		tq.Limit = 20
+	}
+	if tq.Limit > 40 {
+		tq.Limit = 40
+	}
+	vis := tq.Visibilities
+	if len(vis) == 0 {
+		vis = []PostVisibility{tq.Visibility}
 	}
 	base := squirrel.Select(`t.*`).From("toots t").
-		JoinClause("LEFT OUTER JOIN oauth_clients oc on t.appid = oc.id").
-		Where("t.visibility = ?", tq.Visibility).
+		Where(squirrel.Eq{"t.visibility": vis}).
+		Where("t.DeletedAt is null").
 		Limit(tq.Limit)
 
-	if tq.MinId != "" && tq.MaxId != "" {
-		base = base.Where("t.sid between ? and ?", tq.MinId)
-	} else if tq.MinId != "" {
-		base = base.Where("t.sid > ?", tq.MinId)
-	} else if tq.MaxId != "" {
-		base = base.Where("t.sid <= ?", tq.MaxId)
-	} else if tq.SinceId != "" {
-		base = base.Where("t.sid > ?", tq.SinceId)
+	// Toot IDs aren't ordered so we page using the timestamp
+	// of the given toot, with the ID to break ties.
+	cursor := "(select CreatedAt, Sid from toots where Sid = ?)"
+	if tq.MaxId != "" {
+		base = base.Where("(t.CreatedAt, t.Sid) < "+cursor, tq.MaxId)
+	}
+	if tq.SinceId != "" {
+		base = base.Where("(t.CreatedAt, t.Sid) > "+cursor, tq.SinceId)
+	}
+	if tq.MinId != "" {
+		base = base.Where("(t.CreatedAt, t.Sid) > "+cursor, tq.MinId)
+	}
+	if tq.Sid != "" {
+		base = base.Where("t.Sid = ?", tq.Sid)
+	}
+	if tq.ExcludeBoosts {
+		base = base.Where("t.BoostOfId is null")
 	}
 	if tq.OnlyMedia {
-		base = base.Join("toot_medias tm on t.sid = tm.sid")
+		base = base.Where("exists (select 1 from toot_medias tm where tm.sid = t.sid)")
 	}
 	if tq.ListId != 0 {
-		// TODO
+		var list List
+		err := tq.db.Get(&list, "select * from lists where Id = ?", tq.ListId)
+		if err != nil {
+			return nil, errors.Wrap(err, "lists")
+		}
+		members := "select ActorId from list_accounts where ListId = ?"
+		base = base.Where("t.ActorId in ("+tootActors(members)+")", LocalIRI(""), list.Id, list.Id)
+		switch list.RepliesPolicy {
+		case RepliesNone:
+			base = base.Where("t.InReplyTo is null")
+		case RepliesList:
+			base = base.Where(`(t.InReplyTo is null or exists (
+				select 1 from toots p where p.Uri = t.InReplyTo and p.ActorId in (`+tootActors(members)+`)))`,
+				LocalIRI(""), list.Id, list.Id)
+		case RepliesFollowed:
+			var nick string
+			err := tq.db.Get(&nick, "select Nick from accounts where Id = ?", list.AccountId)
+			if err != nil {
+				return nil, errors.Wrap(err, "accounts")
+			}
+			base = base.Where(`(t.InReplyTo is null or exists (
+				select 1 from toots p where p.Uri = t.InReplyTo and (p.AuthorId = ? or p.ActorId in (`+tootActors(followed)+`))))`,
+				list.AccountId, LocalIRI(""), LocalIRI(nick), LocalIRI(nick))
+		}
 	}
-	if tq.Local || tq.Remote {
+	if tq.HomeFor != nil {
+		iri := tq.HomeFor.IRI()
+		followedTags := `select 1 from toot_tags tt
+			join tags g on g.Name = tt.Tag
+			join account_tags at on at.TagId = g.Id
+			where tt.Sid = t.Sid and at.AccountId = ?`
+		base = base.Where("(t.AuthorId = ? or t.ActorId in ("+tootActors(followed)+") or (t.Visibility = ? and exists ("+followedTags+")))",
+			tq.HomeFor.Id, LocalIRI(""), iri, iri, VisPublic, tq.HomeFor.Id)
+		// members of exclusive lists only appear in those lists
+		exclusive := `select la.ActorId from list_accounts la
+			join lists l on l.Id = la.ListId
+			where l.AccountId = ? and l.Exclusive`
+		base = base.Where("t.ActorId not in ("+tootActors(exclusive)+")",
+			LocalIRI(""), tq.HomeFor.Id, tq.HomeFor.Id)
+	}
+	if tq.Viewer != "" {
+		base = base.Where(hiddenFrom("t", tq.Viewer))
+		sqlq, args, _ := hiddenFrom("o", tq.Viewer).ToSql()
+		base = base.Where("(t.BoostOfId is null or exists (select 1 from toots o where o.Sid = t.BoostOfId and "+sqlq+"))", args...)
+	}
+	if len(tq.Tags) > 0 {
+		base = base.Where(hasTags("exists", tq.Tags))
+	}
+	for _, tag := range tq.AllTags {
+		base = base.Where(hasTags("exists", []string{tag}))
+	}
+	if len(tq.NoneTags) > 0 {
+		base = base.Where(hasTags("not exists", tq.NoneTags))
+	}
+	if tq.Local != tq.Remote {
 		if tq.Local {
 			base = base.Where("t.authorId is not null")
 		} else {
@@ -106,9 +211,14 @@ func (tq *TimelineQuery) Execute() (*QueryResult, error) {
 		}
 	}
 
-	base = base.OrderBy("t.CreatedAt DESC")
+	// min_id asks for the toots immediately after the given toot
+	ascending := tq.MinId != "" && tq.MaxId == ""
+	if ascending {
+		base = base.OrderBy("t.CreatedAt ASC", "t.Sid ASC")
+	} else {
+		base = base.OrderBy("t.CreatedAt DESC", "t.Sid DESC")
+	}
 	sqlq, args, err := base.ToSql()
-	fmt.Println(sqlq)
 	if err != nil {
 		return nil, errors.Wrap(err, "Invalid timeline query")
 	}
@@ -120,6 +230,7 @@ func (tq *TimelineQuery) Execute() (*QueryResult, error) {
 		}
 		return nil, errors.Wrap(err, "Bad timeline query")
 	}
+	defer rows.Close()
 	for rows.Next() {
 		toot := Toot{}
 		err := rows.StructScan(&toot)
@@ -128,5 +239,38 @@ func (tq *TimelineQuery) Execute() (*QueryResult, error) {
 		}
 		entries = append(entries, &Entry{Toot: &toot, db: tq.db})
 	}
+	if ascending {
+		for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+			entries[i], entries[j] = entries[j], entries[i]
+		}
+	}
 	return &QueryResult{Toots: entries}, nil
 }
+
+// hasTags matches toots with any of the given tags, or with none
+// of them for "not exists".
+func hasTags(exists string, tags []string) squirrel.Sqlizer {
+	names := []string{}
+	for _, tag := range tags {
+		names = append(names, strings.ToLower(tag))
+	}
+	sqlq, args, _ := squirrel.Eq{"tt.Tag": names}.ToSql()
+	return squirrel.Expr(exists+" (select 1 from toot_tags tt where tt.Sid = t.Sid and "+sqlq+")", args...)
+}
+
+// BlockedFrom leaves out the toots, with the given alias, whose
+// authors block or are blocked by the viewer IRI.
+func BlockedFrom(alias, viewer string) squirrel.Sqlizer {
+	return squirrel.Expr(alias+".ActorId not in ("+tootActors(BlockedActors)+")",
+		LocalIRI(""), viewer, viewer, viewer, viewer)
+}
+
+// hiddenFrom leaves out the toots, with the given alias, which are
+// hidden from the viewer IRI.
+func hiddenFrom(alias, viewer string) squirrel.Sqlizer {
+	domains := `select 1 from actors r
+		join actor_domain_blocks d on r.Id like '%://' || d.Domain || '/%'
+		where r.MastodonId = ` + alias + `.ActorId and d.ActorId = ?`
+	return squirrel.Expr("("+alias+".ActorId not in ("+tootActors(HiddenActors)+") and ("+alias+".AuthorId is not null or not exists ("+domains+")))",
+		LocalIRI(""), viewer, viewer, viewer, viewer, viewer, viewer, viewer)
+}
@@ -17,16 +17,19 @@ type Toot struct {
 	InReplyToAccountId *uint64 `json:"in_reply_to_account_id,omitempty"`
 	Summary            string  `json:"spoiler_text"`
 	Content            string  `json:"content"`
+	Text               string  `json:"text"`
 	Lang               string  `json:"language"`
 	Visibility         PostVisibility
 	CreatedAt          time.Time `json:"created_at"`
-	AuthorId           string
+	AuthorId           *uint64
 	CollectionId       *uint64
 	PollId             *uint64
 	AppId              *uint64
 	LastEditAt         *time.Time
 	UpdatedAt          time.Time
 	DeletedAt          *time.Time
+	RepliesFetchedAt   *time.Time
+	ConversationId     *int64
 }
 
 type PostVisibility int
@@ -110,6 +113,7 @@ type TootMedia struct {
 	Meta          string
 	Description   string
 	Blurhash      string
+	ScheduledId   *int64
 	CreatedAt     time.Time
 }
 
@@ -136,3 +140,30 @@ type TootTag struct {
 	Tag       string
 	CreatedAt time.Time
 }
+
+// TootMention is an actor mentioned in a toot.
+type TootMention struct {
+	Sid     string
+	ActorId string
+}
+
+// TootEdit is one revision of an edited toot.
+type TootEdit struct {
+	Id        int64
+	Sid       string
+	Summary   string
+	Content   string
+	Text      string
+	Lang      string
+	CreatedAt time.Time
+}
+
+// ScheduledToot is a toot waiting to be published at ScheduledAt.
+// Params is the submitted toot as JSON.
+type ScheduledToot struct {
+	Id          int64
+	AccountId   uint64
+	ScheduledAt time.Time
+	Params      string
+	CreatedAt   time.Time
+}
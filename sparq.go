@@ -25,4 +25,6 @@ type Pusher interface {
 type JobService interface {
 	Pusher
 	Register(jobtype string, fn PerformFunc)
+	// Periodic pushes a job of the given type every N seconds.
+	Periodic(jobtype string, every int64)
 }
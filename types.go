@@ -13,4 +13,5 @@ type Server interface {
 	MediaRoot() string
 	Root() string
 	Context() context.Context
+	Jobs() JobService
 }
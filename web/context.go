@@ -84,6 +84,9 @@ func Ctx(r *http.Request) *WebCtx {
 	return &WebCtx{}
 }
 
+// bearerCode returns the request's access token. Browsers can't set
+// headers for EventSource or WebSocket so streaming clients pass it
+// as the access_token parameter or the WebSocket subprotocol.
 func bearerCode(r *http.Request) string {
 	auth := r.Header.Get("Authorization")
 	prefix := "Bearer "
@@ -91,5 +94,11 @@ func bearerCode(r *http.Request) string {
 	if auth != "" && strings.HasPrefix(auth, prefix) {
 		return auth[len(prefix):]
 	}
+	if token := r.URL.Query().Get("access_token"); token != "" {
+		return token
+	}
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return r.Header.Get("Sec-WebSocket-Protocol")
+	}
 	return ""
 }
@@ -52,13 +52,18 @@ func (scs *SqliteOauthStore) Delete(ctx context.Context, id string) error {
 
 func (scs *SqliteOauthStore) Create(ctx context.Context, info oauth2.TokenInfo) error {
 	// fmt.Printf("Created OAuth token: %+v\n", info)
+	uid := info.GetUserID()
+	if uid == "" {
+		// client credentials tokens belong to the app, not an account
+		uid = "0"
+	}
 	_, err := scs.DB.ExecContext(ctx, `INSERT INTO oauth_tokens (
 			ClientId, AccountId, RedirectUri, Scope, CodeChallenge,
 			Code, CodeCreatedAt, CodeExpiresIn,
 			Access, AccessCreatedAt, AccessExpiresIn,
 			Refresh, RefreshCreatedAt, RefreshExpiresIn)
 			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		info.GetClientID(), info.GetUserID(), info.GetRedirectURI(), info.GetScope(), info.GetCodeChallenge(),
+		info.GetClientID(), uid, info.GetRedirectURI(), info.GetScope(), info.GetCodeChallenge(),
 		info.GetCode(), info.GetCodeCreateAt(), info.GetCodeExpiresIn(),
 		info.GetAccess(), info.GetAccessCreateAt(), info.GetAccessExpiresIn(),
 		info.GetRefresh(), info.GetRefreshCreateAt(), info.GetRefreshExpiresIn())
@@ -119,6 +124,7 @@ func IntegrateOauth(s sparq.Server, root *mux.Router) *SqliteOauthStore {
 		AllowedResponseTypes:  []oauth2.ResponseType{oauth2.CodeType},
 		AllowedGrantTypes: []oauth2.GrantType{
 			oauth2.AuthorizationCode,
+			oauth2.ClientCredentials,
 		},
 		AllowedCodeChallengeMethods: []oauth2.CodeChallengeMethod{
 			oauth2.CodeChallengePlain, oauth2.CodeChallengeS256},
@@ -253,7 +259,9 @@ func Auth(store oauth2.TokenStore) func(http.Handler) http.Handler {
 					_, _ = w.Write([]byte(`{ "error": "invalid_token", "error_description": "The access token expired" }`))
 					return
 				}
-				webctx.CurrentUserID = ti.GetUserID()
+				if uid := ti.GetUserID(); uid != "0" {
+					webctx.CurrentUserID = uid
+				}
 			}
 
 			pass.ServeHTTP(w, r)
@@ -2,8 +2,11 @@ package web
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 
 	"github.com/gorilla/mux"
@@ -111,6 +114,40 @@ func TestPublicOauth(t *testing.T) {
 		assert.Equal(t, w.Header().Get("Location"), "")
 		assert.Contains(t, w.Body.String(), "Your authorization code is")
 	})
+
+	t.Run("with client credentials", func(t *testing.T) {
+		_, err := ts.DB().Exec(`insert into oauth_clients
+		(ClientId, Name, Secret, RedirectUris, Website, Scopes) values
+		("93e60c83-3c57-42ac-abaf-be6bc7ad2e70", "Tusky", "abcdef123456789", "urn:ietf:wg:oauth:2.0:oob", "https://tusky.app", "read write")`)
+		assert.NoError(t, err)
+
+		form := url.Values{
+			"grant_type":    {"client_credentials"},
+			"client_id":     {"93e60c83-3c57-42ac-abaf-be6bc7ad2e70"},
+			"client_secret": {"abcdef123456789"},
+			"scope":         {"read write"},
+		}
+		req := httptest.NewRequest("POST", "http://localhost.dev:9494/oauth/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code, w.Body.String())
+		result := map[string]any{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		access := result["access_token"].(string)
+		assert.NotEmpty(t, access)
+
+		// the token belongs to the app rather than an account
+		sos := &SqliteOauthStore{DB: ts.DB()}
+		ti, err := sos.GetByAccess(context.Background(), access)
+		assert.NoError(t, err)
+		assert.Equal(t, "0", ti.GetUserID())
+		req = httptest.NewRequest("GET", "http://localhost.dev:9494/nosuch", nil)
+		req.Header.Set("Authorization", "Bearer "+access)
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, 404, w.Code)
+	})
 }
 
 func route(r *mux.Router, query string, fn func(w *httptest.ResponseRecorder, req *http.Request)) {
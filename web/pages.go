@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/contribsys/sparq/db"
+	"github.com/contribsys/sparq/markup"
 	"github.com/contribsys/sparq/model"
 	"github.com/contribsys/sparq/util"
 	"github.com/gorilla/sessions"
@@ -69,6 +70,9 @@ func RegisterPages(pages ...string) {
 			"now":      util.Nows,
 			"hostname": func() string { return db.InstanceHostname },
 			"relative": func(when time.Time) string { return time.Since(when).String() },
+			// toots are stored as HTML, sanitize again in case
+			// they predate sanitization
+			"content": func(html string) template.HTML { return template.HTML(markup.Sanitize(html)) },
 		})
 		ts, err := ts.ParseFS(templateFiles, files...)
 		if err != nil {
@@ -4,5 +4,5 @@ import "github.com/contribsys/sparq/web"
 
 func init() {
 	// these are the pages which can be rendered
-	web.RegisterPages("public/index", "public/profile", "public/home", "public/login", "public/status", "public/local")
+	web.RegisterPages("public/index", "public/profile", "public/home", "public/login", "public/status", "public/local", "public/signup")
 }
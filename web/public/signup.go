@@ -0,0 +1,95 @@
+package public
+
+import (
+	"net/http"
+
+	"github.com/contribsys/sparq"
+	"github.com/contribsys/sparq/clientapi"
+	"github.com/contribsys/sparq/web"
+	"github.com/pkg/errors"
+)
+
+type signupPage struct {
+	Mode       string
+	Username   string
+	Email      string
+	Reason     string
+	InviteCode string
+}
+
+func signupHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if web.IsLoggedIn(r) != web.Anonymous {
+			http.Redirect(w, r, "/home", http.StatusFound)
+			return
+		}
+		mode, err := clientapi.RegistrationMode(r.Context(), s.DB())
+		if err != nil {
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		err = r.ParseForm()
+		if err != nil {
+			httpError(w, err, http.StatusBadRequest)
+			return
+		}
+		page := &signupPage{
+			Mode:       string(mode),
+			Username:   r.Form.Get("username"),
+			Email:      r.Form.Get("email"),
+			Reason:     r.Form.Get("reason"),
+			InviteCode: r.Form.Get("invite_code"),
+		}
+		if r.Method == "POST" {
+			session, _ := web.SessionStore.Get(r, "sparq-session")
+			reg := &clientapi.Registration{
+				Username:   page.Username,
+				Email:      page.Email,
+				Password:   r.Form.Get("password"),
+				Agreement:  r.Form.Get("agreement") != "",
+				Reason:     page.Reason,
+				InviteCode: page.InviteCode,
+			}
+			err = reg.Validate(r.Context(), s)
+			if err != nil {
+				session.AddFlash(err.Error())
+				web.Render(w, r, "public/signup", page)
+				return
+			}
+			_, err = clientapi.SignUp(r.Context(), s, reg)
+			if err != nil {
+				httpError(w, err, http.StatusInternalServerError)
+				return
+			}
+			session.AddFlash("Welcome! Please follow the link we sent to your email address to confirm it.")
+			_ = session.Save(r, w)
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
+		}
+		web.Render(w, r, "public/signup", page)
+	}
+}
+
+// confirmationHandler confirms the email address of a new account
+// with the token from the link we sent.
+func confirmationHandler(s sparq.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		reg, err := clientapi.ConfirmEmail(r.Context(), s, r.FormValue("confirmation_token"))
+		if err != nil {
+			if errors.Is(err, clientapi.ErrInvalidConfirmation) {
+				httpError(w, err, http.StatusNotFound)
+				return
+			}
+			httpError(w, err, http.StatusInternalServerError)
+			return
+		}
+		session, _ := web.SessionStore.Get(r, "sparq-session")
+		if reg.IsPending() {
+			session.AddFlash("Your email address has been confirmed. A moderator will review your account soon.")
+		} else {
+			session.AddFlash("Your email address has been confirmed, please sign in.")
+		}
+		_ = session.Save(r, w)
+		http.Redirect(w, r, "/login", http.StatusFound)
+	}
+}
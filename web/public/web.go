@@ -2,11 +2,13 @@ package public
 
 import (
 	"database/sql"
+	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
 
 	"github.com/contribsys/sparq"
+	"github.com/contribsys/sparq/activitypub"
 	"github.com/contribsys/sparq/clientapi"
 	"github.com/contribsys/sparq/model"
 	"github.com/contribsys/sparq/util"
@@ -31,6 +33,12 @@ func cacheControl(pass http.Handler) http.Handler {
 func AddPublicEndpoints(s sparq.Server, root *mux.Router) {
 	root.PathPrefix("/static").Handler(staticHandler)
 	root.HandleFunc("/users/{nick:[a-z0-9]{4,20}}", getUser(s))
+	root.HandleFunc("/users/{nick:[a-z0-9]{4,20}}/inbox", activitypub.InboxHandler(s))
+	root.HandleFunc("/inbox", activitypub.InboxHandler(s))
+	root.HandleFunc("/actor", activitypub.InstanceActorHandler(s))
+	root.HandleFunc("/users/{nick:[a-z0-9]{4,20}}/outbox", activitypub.OutboxHandler(s))
+	root.HandleFunc("/users/{nick:[a-z0-9]{4,20}}/followers", activitypub.FollowersHandler(s))
+	root.HandleFunc("/users/{nick:[a-z0-9]{4,20}}/following", activitypub.FollowingHandler(s))
 	root.HandleFunc("/@{nick:[a-z0-9]{4,20}}/{id:[A-Z0-9]+}", showStatusHandler(s))
 	root.HandleFunc("/@{nick:[a-z0-9]{4,20}}", getUser(s))
 	root.Methods("POST").Path("/home").Handler(clientapi.PostTootHandler(s))
@@ -39,8 +47,9 @@ func AddPublicEndpoints(s sparq.Server, root *mux.Router) {
 	root.HandleFunc("/login", loginHandler(s))
 	root.HandleFunc("/logout", logoutHandler(s))
 	root.HandleFunc("/public/local", localHandler(s))
+	root.HandleFunc("/signup", signupHandler(s))
+	root.HandleFunc("/auth/confirmation", confirmationHandler(s))
 	// mux.HandleFunc("/public", publicHandler)
-	// mux.HandleFunc("/auth/sign_up", signupHandler)
 	// mux.HandleFunc("/auth/sign_in", signinHandler)
 }
 
@@ -59,11 +68,23 @@ func localHandler(svr sparq.Server) http.HandlerFunc {
 }
 
 func showStatusHandler(svr sparq.Server) http.HandlerFunc {
+	notes := activitypub.NoteHandler(svr)
 	return func(w http.ResponseWriter, r *http.Request) {
+		if activitypub.WantsActivity(r) {
+			notes(w, r)
+			return
+		}
 		sid := mux.Vars(r)["id"]
 		attrs, err := clientapi.TootMap(svr.DB(), sid)
 		if err != nil {
 			if errors.Is(err, sql.ErrNoRows) {
+				var count int
+				_ = svr.DB().GetContext(r.Context(), &count,
+					"select count(*) from toots where Sid = ? and DeletedAt is not null", sid)
+				if count > 0 {
+					httpError(w, errors.New("This toot has been deleted"), http.StatusGone)
+					return
+				}
 				httpError(w, err, http.StatusNotFound)
 				return
 			}
@@ -71,7 +92,7 @@ func showStatusHandler(svr sparq.Server) http.HandlerFunc {
 			return
 		}
 		if attrs["visibility"] == "public" ||
-			attrs["authorId"] == web.IsLoggedIn(r) {
+			fmt.Sprint(attrs["authorId"]) == web.IsLoggedIn(r) {
 			web.Render(w, r, "public/status", attrs)
 		} else {
 			httpError(w, err, http.StatusNotFound)
@@ -122,6 +143,18 @@ func loginHandler(s sparq.Server) http.HandlerFunc {
 			util.Debugf("Login %s (uid %d)", username, uid)
 			err = bcrypt.CompareHashAndPassword(hash, []byte(password))
 			if err == nil {
+				var pending bool
+				err = s.DB().GetContext(r.Context(), &pending,
+					"select count(*) > 0 from accounts a where a.Id = ? and "+model.PendingAccount, uid)
+				if err != nil {
+					httpError(w, err, http.StatusInternalServerError)
+					return
+				}
+				if pending {
+					session.AddFlash("Your account hasn't been confirmed and approved yet")
+					web.Render(w, r, "public/login", nil)
+					return
+				}
 				session.Values["uid"] = strconv.FormatUint(uid, 10)
 				session.Values["username"] = username
 				redir, ok := session.Values["redirectTo"].(string)
@@ -76,6 +76,62 @@ func TestPublicLogin(t *testing.T) {
 	})
 }
 
+func TestPublicSignup(t *testing.T) {
+	ts, stopper := web.NewTestServer(t, "public")
+	defer stopper()
+	root := rootRouter(ts)
+	AddPublicEndpoints(ts, root)
+
+	post := func(path string, payload url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "http://localhost.dev:9494"+path, strings.NewReader(payload.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		root.ServeHTTP(w, req)
+		return w
+	}
+
+	req := httptest.NewRequest("GET", "http://localhost.dev:9494/signup", nil)
+	w := httptest.NewRecorder()
+	root.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), "reviewed by a moderator")
+
+	signup := url.Values{
+		"username":  {"alice"},
+		"email":     {"alice@example.com"},
+		"password":  {"secret123"},
+		"agreement": {"on"},
+	}
+	w = post("/signup", url.Values{"username": {"alice"}, "email": {"nope"}, "password": {"secret123"}})
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), "Invalid email address")
+	w = post("/signup", signup)
+	assert.Equal(t, 302, w.Code)
+	assert.Equal(t, "/login", w.Header().Get("Location"))
+
+	login := url.Values{"username": {"alice"}, "password": {"secret123"}}
+	w = post("/login", login)
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), "confirmed and approved")
+
+	var token string
+	assert.NoError(t, ts.DB().Get(&token, "select ConfirmationToken from account_registrations"))
+	req = httptest.NewRequest("GET", "http://localhost.dev:9494/auth/confirmation?confirmation_token=nosuch", nil)
+	w = httptest.NewRecorder()
+	root.ServeHTTP(w, req)
+	assert.Equal(t, 404, w.Code)
+	req = httptest.NewRequest("GET", "http://localhost.dev:9494/auth/confirmation?confirmation_token="+token, nil)
+	w = httptest.NewRecorder()
+	root.ServeHTTP(w, req)
+	assert.Equal(t, 302, w.Code)
+
+	_, err := ts.DB().Exec("update account_registrations set ApprovedAt = current_timestamp")
+	assert.NoError(t, err)
+	w = post("/login", login)
+	assert.Equal(t, 302, w.Code)
+	assert.Contains(t, w.Header().Get("Location"), "/home")
+}
+
 func rootRouter(s sparq.Server) *mux.Router {
 	root := mux.NewRouter()
 	store := &web.SqliteOauthStore{DB: s.DB()}
@@ -2,10 +2,13 @@ package web
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
+	"sync"
 	"testing"
 
+	"github.com/contribsys/faktory/client"
 	"github.com/contribsys/sparq"
 	"github.com/contribsys/sparq/db"
 	"github.com/jmoiron/sqlx"
@@ -20,11 +23,15 @@ func NewTestServer(t *testing.T, name string) (sparq.Server, func()) {
 	if err != nil {
 		panic(err)
 	}
+	ctx, cancel := context.WithCancel(context.Background())
 	svr := &testSvr{
 		db:   dbx,
 		root: dir,
+		jobs: NewTestJobs(),
+		ctx:  ctx,
 	}
 	return svr, func() {
+		cancel()
 		os.RemoveAll(svr.root)
 		stopper()
 	}
@@ -33,6 +40,8 @@ func NewTestServer(t *testing.T, name string) (sparq.Server, func()) {
 type testSvr struct {
 	db   *sqlx.DB
 	root string
+	jobs *TestJobs
+	ctx  context.Context
 }
 
 func (ts *testSvr) DB() *sqlx.DB {
@@ -56,5 +65,93 @@ func (ts *testSvr) MediaRoot() string {
 }
 
 func (ts *testSvr) Context() context.Context {
-	return context.Background()
+	return ts.ctx
+}
+
+func (ts *testSvr) Jobs() sparq.JobService {
+	return ts.jobs
+}
+
+// TestJobs is an in-memory JobService for tests. Pushed jobs
+// are collected rather than executed so tests can inspect them
+// and run them explicitly with Drain.
+type TestJobs struct {
+	Pushed   []*client.Job
+	Schedule map[string]int64
+	handlers map[string]sparq.PerformFunc
+	mu       sync.Mutex
+}
+
+func NewTestJobs() *TestJobs {
+	return &TestJobs{
+		Pushed:   []*client.Job{},
+		Schedule: map[string]int64{},
+		handlers: map[string]sparq.PerformFunc{},
+	}
+}
+
+func (tj *TestJobs) Push(ctx context.Context, job *client.Job) error {
+	tj.mu.Lock()
+	defer tj.mu.Unlock()
+	tj.Pushed = append(tj.Pushed, job)
+	return nil
+}
+
+func (tj *TestJobs) Register(jobtype string, fn sparq.PerformFunc) {
+	tj.mu.Lock()
+	defer tj.mu.Unlock()
+	tj.handlers[jobtype] = fn
+}
+
+func (tj *TestJobs) Periodic(jobtype string, every int64) {
+	tj.mu.Lock()
+	defer tj.mu.Unlock()
+	tj.Schedule[jobtype] = every
+}
+
+// Find returns the pushed jobs of the given type.
+func (tj *TestJobs) Find(jobtype string) []*client.Job {
+	tj.mu.Lock()
+	defer tj.mu.Unlock()
+	result := []*client.Job{}
+	for _, job := range tj.Pushed {
+		if job.Type == jobtype {
+			result = append(result, job)
+		}
+	}
+	return result
+}
+
+// Drain executes all pushed jobs, including any jobs pushed
+// by those jobs, and returns the first error.
+func (tj *TestJobs) Drain(ctx context.Context) error {
+	for {
+		tj.mu.Lock()
+		if len(tj.Pushed) == 0 {
+			tj.mu.Unlock()
+			return nil
+		}
+		job := tj.Pushed[0]
+		tj.Pushed = tj.Pushed[1:]
+		fn := tj.handlers[job.Type]
+		tj.mu.Unlock()
+
+		if fn == nil {
+			return fmt.Errorf("No handler registered for job type %s", job.Type)
+		}
+		// round-trip the arguments through JSON like Faktory does
+		data, err := json.Marshal(job.Args)
+		if err != nil {
+			return err
+		}
+		args := []interface{}{}
+		err = json.Unmarshal(data, &args)
+		if err != nil {
+			return err
+		}
+		err = fn(ctx, args...)
+		if err != nil {
+			return err
+		}
+	}
 }
@@ -0,0 +1,292 @@
+// Package webpush encrypts and delivers Web Push messages as
+// described by RFC 8030, RFC 8291 and RFC 8292.
+package webpush
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/hkdf"
+)
+
+var (
+	// ErrGone means the push service no longer knows the
+	// subscription and it should be forgotten.
+	ErrGone = errors.New("Push subscription has expired")
+
+	// Client is used to deliver messages to push services.
+	Client = &http.Client{Timeout: 10 * time.Second}
+)
+
+// RecordSize is the size of the single aes128gcm record we send.
+const RecordSize = 4096
+
+// Keys is the VAPID key pair which identifies this server to
+// push services, encoded as unpadded base64url like browsers
+// expect.
+type Keys struct {
+	Private string
+	Public  string
+}
+
+// GenerateKeys creates a new P-256 VAPID key pair.
+func GenerateKeys() (*Keys, error) {
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "vapid")
+	}
+	return &Keys{
+		Private: base64.RawURLEncoding.EncodeToString(key.Bytes()),
+		Public:  base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes()),
+	}, nil
+}
+
+// Decode accepts base64 in any of the variants clients send,
+// padded or not, standard or URL safe.
+func Decode(value string) ([]byte, error) {
+	value = strings.TrimRight(value, "=")
+	value = strings.NewReplacer("+", "-", "/", "_").Replace(value)
+	return base64.RawURLEncoding.DecodeString(value)
+}
+
+// Encrypt encodes the plaintext for the subscriber with the given
+// p256dh public key and auth secret using the aes128gcm content
+// encoding.
+func Encrypt(p256dh, auth string, plaintext []byte) ([]byte, error) {
+	salt := make([]byte, 16)
+	_, err := io.ReadFull(rand.Reader, salt)
+	if err != nil {
+		return nil, errors.Wrap(err, "salt")
+	}
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "ephemeral key")
+	}
+	return encrypt(p256dh, auth, plaintext, salt, key)
+}
+
+func encrypt(p256dh, auth string, plaintext, salt []byte, key *ecdh.PrivateKey) ([]byte, error) {
+	uaPublic, err := Decode(p256dh)
+	if err != nil {
+		return nil, errors.Wrap(err, "p256dh")
+	}
+	secret, err := Decode(auth)
+	if err != nil {
+		return nil, errors.Wrap(err, "auth")
+	}
+	if len(plaintext)+1+16 > RecordSize {
+		return nil, errors.New("Push message is too large")
+	}
+	remote, err := ecdh.P256().NewPublicKey(uaPublic)
+	if err != nil {
+		return nil, errors.Wrap(err, "p256dh")
+	}
+	shared, err := key.ECDH(remote)
+	if err != nil {
+		return nil, errors.Wrap(err, "ecdh")
+	}
+	asPublic := key.PublicKey().Bytes()
+	gcm, nonce, err := contentKeys(shared, secret, salt, uaPublic, asPublic)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.Write(salt)
+	_ = binary.Write(&buf, binary.BigEndian, uint32(RecordSize))
+	buf.WriteByte(byte(len(asPublic)))
+	buf.Write(asPublic)
+	// a single, final record: the plaintext and its delimiter
+	record := append(append([]byte{}, plaintext...), 2)
+	return gcm.Seal(buf.Bytes(), nonce, record, nil), nil
+}
+
+// Decrypt reverses Encrypt for the subscriber holding the private
+// key and auth secret, as a browser would.
+func Decrypt(key *ecdh.PrivateKey, secret, body []byte) ([]byte, error) {
+	if len(body) < 21 {
+		return nil, errors.New("Truncated header")
+	}
+	salt := body[:16]
+	idlen := int(body[20])
+	if len(body) < 21+idlen {
+		return nil, errors.New("Truncated header")
+	}
+	asPublic := body[21 : 21+idlen]
+	remote, err := ecdh.P256().NewPublicKey(asPublic)
+	if err != nil {
+		return nil, errors.Wrap(err, "keyid")
+	}
+	shared, err := key.ECDH(remote)
+	if err != nil {
+		return nil, errors.Wrap(err, "ecdh")
+	}
+	gcm, nonce, err := contentKeys(shared, secret, salt, key.PublicKey().Bytes(), asPublic)
+	if err != nil {
+		return nil, err
+	}
+	record, err := gcm.Open(nil, nonce, body[21+idlen:], nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "decrypt")
+	}
+	record = bytes.TrimRight(record, "\x00")
+	if len(record) == 0 || record[len(record)-1] != 2 {
+		return nil, errors.New("Missing record delimiter")
+	}
+	return record[:len(record)-1], nil
+}
+
+// contentKeys derives the AES-GCM cipher and nonce from the ECDH
+// shared secret, auth secret and salt per RFC 8291 section 3.4.
+func contentKeys(shared, secret, salt, uaPublic, asPublic []byte) (cipher.AEAD, []byte, error) {
+	info := append([]byte("WebPush: info\x00"), uaPublic...)
+	info = append(info, asPublic...)
+	ikm := make([]byte, 32)
+	_, err := io.ReadFull(hkdf.New(sha256.New, shared, secret, info), ikm)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "hkdf")
+	}
+	prk := hkdf.Extract(sha256.New, ikm, salt)
+	cek := make([]byte, 16)
+	_, err = io.ReadFull(hkdf.Expand(sha256.New, prk, []byte("Content-Encoding: aes128gcm\x00")), cek)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "hkdf")
+	}
+	nonce := make([]byte, 12)
+	_, err = io.ReadFull(hkdf.Expand(sha256.New, prk, []byte("Content-Encoding: nonce\x00")), nonce)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "hkdf")
+	}
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "aes")
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "gcm")
+	}
+	return gcm, nonce, nil
+}
+
+// Authorization returns the VAPID header value for a message to
+// the endpoint, signed by the keys and naming the subject, a
+// mailto: or https: URL where the push service may reach us.
+func Authorization(endpoint, subject string, keys *Keys) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", errors.Wrap(err, "endpoint")
+	}
+	claims, err := json.Marshal(map[string]any{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": time.Now().Add(12 * time.Hour).Unix(),
+		"sub": subject,
+	})
+	if err != nil {
+		return "", err
+	}
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"typ":"JWT","alg":"ES256"}`))
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+
+	key, err := signingKey(keys)
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256([]byte(unsigned))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		return "", errors.Wrap(err, "sign")
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	token := unsigned + "." + base64.RawURLEncoding.EncodeToString(sig)
+	return "vapid t=" + token + ", k=" + keys.Public, nil
+}
+
+func signingKey(keys *Keys) (*ecdsa.PrivateKey, error) {
+	priv, err := Decode(keys.Private)
+	if err != nil {
+		return nil, errors.Wrap(err, "vapid")
+	}
+	pub, err := Decode(keys.Public)
+	if err != nil || len(pub) != 65 {
+		return nil, errors.New("Invalid VAPID public key")
+	}
+	return &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(pub[1:33]),
+			Y:     new(big.Int).SetBytes(pub[33:]),
+		},
+		D: new(big.Int).SetBytes(priv),
+	}, nil
+}
+
+// Message is a push message for one subscription.
+type Message struct {
+	Endpoint string
+	P256dh   string
+	Auth     string
+	Payload  []byte
+	// TTL is how long the push service should hold the message
+	// while the browser is offline.
+	TTL time.Duration
+}
+
+// Send encrypts and delivers the message. It returns ErrGone if
+// the push service says the subscription no longer exists and an
+// error for any other failure so the caller can retry.
+func Send(ctx context.Context, msg *Message, subject string, keys *Keys) error {
+	body, err := Encrypt(msg.P256dh, msg.Auth, msg.Payload)
+	if err != nil {
+		return err
+	}
+	auth, err := Authorization(msg.Endpoint, subject, keys)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", msg.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "endpoint")
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("TTL", strconv.Itoa(int(msg.TTL.Seconds())))
+	req.Header.Set("Urgency", "normal")
+	req.Header.Set("Authorization", auth)
+
+	resp, err := Client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "Unable to push to "+msg.Endpoint)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	code := resp.StatusCode
+	switch {
+	case code >= 200 && code < 300:
+		return nil
+	case code == http.StatusNotFound || code == http.StatusGone:
+		return ErrGone
+	}
+	return fmt.Errorf("Push to %s failed: %d", msg.Endpoint, code)
+}
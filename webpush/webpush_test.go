@@ -0,0 +1,112 @@
+package webpush
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// The example from RFC 8291 Appendix A.
+func TestEncryptExample(t *testing.T) {
+	asPrivate, _ := Decode("yfWPiYE-n46HLnH0KqZOF1fJJU3MYrct3AELtAQ-oRw")
+	uaPrivate, _ := Decode("q1dXpw3UpT5VOmu_cf_v6ih07Aems3njxI-JWgLcM94")
+	salt, _ := Decode("DGv6ra1nlYgDCS1FRnbzlw")
+	auth := "BTBZMqHH6r4Tts7J_aSIgg"
+	p256dh := "BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4"
+	expected := "DGv6ra1nlYgDCS1FRnbzlwAAEABBBP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27mlmlMoZIIgDll6e3vCYLocInmYWAmS6TlzAC8wEqKK6PBru3jl7A_yl95bQpu6cVPTpK4Mqgkf1CXztLVBSt2Ks3oZwbuwXPXLWyouBWLVWGNWQexSgSxsj_Qulcy4a-fN"
+
+	key, err := ecdh.P256().NewPrivateKey(asPrivate)
+	assert.NoError(t, err)
+	body, err := encrypt(p256dh, auth, []byte("When I grow up, I want to be a watermelon"), salt, key)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, base64.RawURLEncoding.EncodeToString(body))
+
+	ua, err := ecdh.P256().NewPrivateKey(uaPrivate)
+	assert.NoError(t, err)
+	secret, _ := Decode(auth)
+	plain, err := Decrypt(ua, secret, body)
+	assert.NoError(t, err)
+	assert.Equal(t, "When I grow up, I want to be a watermelon", string(plain))
+
+	_, err = Decrypt(ua, []byte("wrongwrongwrong!"), body)
+	assert.Error(t, err)
+}
+
+func TestSend(t *testing.T) {
+	keys, err := GenerateKeys()
+	assert.NoError(t, err)
+	ua, err := ecdh.P256().GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	secret := []byte("0123456789abcdef")
+
+	var received []byte
+	var auth string
+	push := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/gone" {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+		if r.URL.Path == "/busy" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		assert.Equal(t, "aes128gcm", r.Header.Get("Content-Encoding"))
+		assert.Equal(t, "60", r.Header.Get("TTL"))
+		auth = r.Header.Get("Authorization")
+		body, _ := io.ReadAll(r.Body)
+		received, err = Decrypt(ua, secret, body)
+		assert.NoError(t, err)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer push.Close()
+
+	msg := &Message{
+		Endpoint: push.URL + "/push/abc",
+		// browsers hand out padded, standard base64 too
+		P256dh:  base64.StdEncoding.EncodeToString(ua.PublicKey().Bytes()),
+		Auth:    base64.URLEncoding.EncodeToString(secret),
+		Payload: []byte(`{"title":"Hello"}`),
+		TTL:     time.Minute,
+	}
+	ctx := context.Background()
+	assert.NoError(t, Send(ctx, msg, "mailto:admin@localhost.dev", keys))
+	assert.Equal(t, `{"title":"Hello"}`, string(received))
+
+	// the VAPID token is signed by our key for the push service
+	assert.True(t, strings.HasPrefix(auth, "vapid t="))
+	assert.True(t, strings.HasSuffix(auth, ", k="+keys.Public))
+	token := strings.TrimSuffix(strings.TrimPrefix(auth, "vapid t="), ", k="+keys.Public)
+	parts := strings.Split(token, ".")
+	assert.Equal(t, 3, len(parts))
+	claims := map[string]any{}
+	data, _ := Decode(parts[1])
+	assert.NoError(t, json.Unmarshal(data, &claims))
+	assert.Equal(t, push.URL, claims["aud"])
+	assert.Equal(t, "mailto:admin@localhost.dev", claims["sub"])
+	key, err := signingKey(keys)
+	assert.NoError(t, err)
+	sig, _ := Decode(parts[2])
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+	assert.True(t, ecdsa.Verify(&key.PublicKey, digest[:], r, s))
+
+	msg.Endpoint = push.URL + "/gone"
+	assert.ErrorIs(t, Send(ctx, msg, "mailto:admin@localhost.dev", keys), ErrGone)
+	msg.Endpoint = push.URL + "/busy"
+	err = Send(ctx, msg, "mailto:admin@localhost.dev", keys)
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrGone)
+}
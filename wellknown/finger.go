@@ -10,6 +10,7 @@ import (
 
 	"github.com/contribsys/sparq"
 	"github.com/contribsys/sparq/db"
+	"github.com/contribsys/sparq/model"
 	"github.com/gorilla/mux"
 	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
@@ -30,7 +31,7 @@ func (r *result) URI() string {
 
 func fingerLookup(ctx context.Context, db *sqlx.DB, username, host string) (*result, error) {
 	var r result
-	err := db.Get(&r, `select nick from accounts where lower(nick) = ?`,
+	err := db.Get(&r, `select a.nick from accounts a where lower(a.nick) = ? and not `+model.PendingAccount,
 		strings.ToLower(username))
 	if err == sql.ErrNoRows {
 		return nil, ErrNotFound
@@ -41,6 +41,9 @@ func defaultResolver(url string) ([]byte, error) {
 func RemoteLookup(handle string, fn resolverFn) (data string, err error) {
 	handle = strings.TrimLeft(handle, "@")
 	parts := strings.Split(handle, "@")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", fmt.Errorf("Invalid handle: %q", handle)
+	}
 
 	if fn == nil {
 		fn = defaultResolver
@@ -67,12 +70,15 @@ func RemoteLookup(handle string, fn resolverFn) (data string, err error) {
 
 	// if we didn't find it with the above then
 	// try using aliases
-	if href == "" {
+	if href == "" && len(result.Aliases) > 0 {
 		// take the last alias because mastodon has the
 		// https://instance.tld/@user first which
 		// doesn't work as an href
 		href = result.Aliases[len(result.Aliases)-1]
 	}
 
+	if href == "" {
+		return "", fmt.Errorf("No actor found for %s", handle)
+	}
 	return href, nil
 }